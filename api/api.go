@@ -0,0 +1,268 @@
+// Package api serves the routes of a RIB and the state of BGP sessions as JSON
+// over HTTP
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/protocols/bgp/server"
+	"github.com/bio-routing/bio-rd/rt"
+	log "github.com/sirupsen/logrus"
+	"github.com/taktv6/tflow2/convert"
+)
+
+// NeighborSource provides the state of BGP sessions. BGPServer implements it.
+type NeighborSource interface {
+	Neighbors() []server.NeighborInfo
+}
+
+// Server is an http.Handler serving
+//
+//	/rib        all routes of the RIB with their active and inactive paths
+//	/neighbors  the state of all BGP sessions
+//
+// Both are read through methods of the RIB and the sessions which are safe for
+// concurrent use, so requests can be served while routes change.
+type Server struct {
+	rib       *rt.RIB
+	neighbors NeighborSource
+	mux       *http.ServeMux
+}
+
+// New creates a server reporting rib and neighbors
+func New(rib *rt.RIB, neighbors NeighborSource) *Server {
+	s := &Server{
+		rib:       rib,
+		neighbors: neighbors,
+		mux:       http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/rib", s.handleRIB)
+	s.mux.HandleFunc("/neighbors", s.handleNeighbors)
+
+	return s
+}
+
+// ServeHTTP dispatches a request to its handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Route is the JSON representation of a route
+type Route struct {
+	Prefix string `json:"prefix"`
+	Paths  []Path `json:"paths"`
+}
+
+// Path is the JSON representation of a path. BGP attributes are only set for
+// BGP paths.
+type Path struct {
+	Type        string   `json:"type"`
+	Active      bool     `json:"active"`
+	NextHop     string   `json:"next_hop"`
+	LocalPref   uint32   `json:"local_pref,omitempty"`
+	MED         uint32   `json:"med,omitempty"`
+	Origin      string   `json:"origin,omitempty"`
+	ASPath      string   `json:"as_path,omitempty"`
+	Communities []string `json:"communities,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	EBGP        bool     `json:"ebgp,omitempty"`
+	Priority    uint8    `json:"priority,omitempty"`
+}
+
+// Neighbor is the JSON representation of a BGP session
+type Neighbor struct {
+	PeerAddress        string        `json:"peer_address"`
+	LocalAddress       string        `json:"local_address"`
+	PeerASN            uint32        `json:"peer_asn"`
+	LocalASN           uint32        `json:"local_asn"`
+	State              string        `json:"state"`
+	UptimeSeconds      float64       `json:"uptime_seconds"`
+	HoldTimeSeconds    float64       `json:"hold_time_seconds"`
+	KeepaliveSeconds   float64       `json:"keepalive_seconds"`
+	RouterID           string        `json:"router_id"`
+	NeighborID         string        `json:"neighbor_id"`
+	Capabilities       []string      `json:"capabilities"`
+	PrefixesReceived   uint64        `json:"prefixes_received"`
+	PrefixesAccepted   uint64        `json:"prefixes_accepted"`
+	PrefixesAdvertised uint64        `json:"prefixes_advertised"`
+	LastError          string        `json:"last_error,omitempty"`
+	LastNotification   *Notification `json:"last_notification,omitempty"`
+	AdminDown          bool          `json:"admin_down"`
+	AdminDownReason    string        `json:"admin_down_reason,omitempty"`
+}
+
+// Notification is the JSON representation of a NOTIFICATION message
+type Notification struct {
+	Code    uint8 `json:"code"`
+	Subcode uint8 `json:"subcode"`
+}
+
+func (s *Server) handleRIB(w http.ResponseWriter, r *http.Request) {
+	if !allowed(w, r) {
+		return
+	}
+
+	routes := s.rib.Dump()
+	res := make([]Route, 0, len(routes))
+	for _, route := range routes {
+		res = append(res, newRoute(route))
+	}
+
+	writeJSON(w, res)
+}
+
+func (s *Server) handleNeighbors(w http.ResponseWriter, r *http.Request) {
+	if !allowed(w, r) {
+		return
+	}
+
+	infos := s.neighbors.Neighbors()
+	res := make([]Neighbor, 0, len(infos))
+	for _, info := range infos {
+		res = append(res, newNeighbor(info))
+	}
+
+	writeJSON(w, res)
+}
+
+// allowed checks if r is a GET request and answers it with an error otherwise
+func allowed(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet {
+		return true
+	}
+
+	w.Header().Set("Allow", http.MethodGet)
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.WithError(err).Warning("Unable to write API response")
+	}
+}
+
+func newRoute(r *rt.Route) Route {
+	res := Route{
+		Prefix: r.Prefix().String(),
+		Paths:  make([]Path, 0, len(r.Paths())),
+	}
+
+	for _, p := range r.Paths() {
+		res.Paths = append(res.Paths, newPath(p, isActive(r, p)))
+	}
+
+	return res
+}
+
+func isActive(r *rt.Route, p *rt.Path) bool {
+	for _, x := range r.ActivePaths() {
+		if x == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newPath(p *rt.Path, active bool) Path {
+	res := Path{
+		Active: active,
+	}
+
+	switch p.Type {
+	case rt.StaticPathType:
+		res.Type = "static"
+		res.NextHop = ipv4String(p.StaticPath.NextHop)
+		res.Priority = p.StaticPath.Priority
+	case rt.LocalPathType:
+		res.Type = "local"
+		res.NextHop = ipv4String(p.LocalPath.NextHop)
+		res.Origin = originString(p.LocalPath.Origin)
+	case rt.BGPPathType:
+		b := p.BGPPath
+		res.Type = "bgp"
+		res.NextHop = b.NextHopIP().String()
+		res.LocalPref = b.LocalPref
+		res.MED = b.MED
+		res.Origin = originString(b.Origin)
+		res.ASPath = b.ASPath
+		res.Source = ipv4String(b.Source)
+		res.EBGP = b.EBGP
+		for _, c := range b.Communities {
+			res.Communities = append(res.Communities, packet.CommunityString(c))
+		}
+	default:
+		res.Type = "unknown"
+	}
+
+	return res
+}
+
+func newNeighbor(info server.NeighborInfo) Neighbor {
+	res := Neighbor{
+		PeerAddress:        ipString(info.PeerAddress),
+		LocalAddress:       ipString(info.LocalAddress),
+		PeerASN:            info.PeerASN,
+		LocalASN:           info.LocalASN,
+		State:              info.State,
+		UptimeSeconds:      info.Uptime.Seconds(),
+		HoldTimeSeconds:    info.HoldTime.Seconds(),
+		KeepaliveSeconds:   info.KeepaliveTime.Seconds(),
+		RouterID:           ipv4String(info.RouterID),
+		NeighborID:         ipv4String(info.NeighborID),
+		Capabilities:       make([]string, 0, len(info.Capabilities)),
+		PrefixesReceived:   info.PrefixesReceived,
+		PrefixesAccepted:   info.PrefixesAccepted,
+		PrefixesAdvertised: info.PrefixesAdvertised,
+		LastError:          info.LastError,
+		AdminDown:          info.AdminDown,
+		AdminDownReason:    info.AdminDownReason,
+	}
+
+	for _, c := range info.Capabilities {
+		res.Capabilities = append(res.Capabilities, c.String())
+	}
+
+	if n := info.LastNotification; n != nil {
+		res.LastNotification = &Notification{
+			Code:    n.ErrorCode,
+			Subcode: n.ErrorSubcode,
+		}
+	}
+
+	return res
+}
+
+func originString(origin uint8) string {
+	switch origin {
+	case packet.IGP:
+		return "IGP"
+	case packet.EGP:
+		return "EGP"
+	case packet.INCOMPLETE:
+		return "INCOMPLETE"
+	}
+
+	return "unknown"
+}
+
+func ipv4String(addr uint32) string {
+	return net.IP(convert.Uint32Byte(addr)).String()
+}
+
+// ipString formats addr leaving unset addresses empty
+func ipString(addr net.IP) string {
+	if addr == nil {
+		return ""
+	}
+
+	return addr.String()
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/protocols/bgp/server"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+type neighbors []server.NeighborInfo
+
+func (n neighbors) Neighbors() []server.NeighborInfo {
+	return n
+}
+
+func testServer() *Server {
+	rib := rt.NewRIB(nil)
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	b := &rt.BGPPath{
+		NextHop:     3325256705, // 198.51.100.1
+		LocalPref:   200,
+		Origin:      packet.IGP,
+		Communities: []uint32{65200<<16 | 100},
+		Source:      3325256705,
+		EBGP:        true,
+	}
+	b.SetASPath(packet.ASPath{
+		{Type: packet.ASSequence, Count: 2, ASNs: []uint32{65201, 64496}},
+	})
+	rib.AddPath(pfx, &rt.Path{Type: rt.BGPPathType, BGPPath: b})
+
+	worse := b.Copy()
+	worse.LocalPref = 100
+	worse.NextHop = 3405803777 // 203.0.113.1
+	worse.Source = 3405803777
+	rib.AddPath(pfx, &rt.Path{Type: rt.BGPPathType, BGPPath: worse})
+
+	return New(rib, neighbors{
+		{
+			PeerAddress:  net.IP{198, 51, 100, 1},
+			LocalAddress: net.IP{198, 51, 100, 254},
+			PeerASN:      65201,
+			LocalASN:     65200,
+			State:        "Established",
+			Uptime:       time.Minute,
+			HoldTime:     90 * time.Second,
+			Capabilities: packet.Capabilities{
+				{
+					Code:  packet.ASN4CapabilityCode,
+					Value: packet.ASN4Capability{ASN4: 65201},
+				},
+			},
+			RouterID:         100,
+			NeighborID:       3325256705,
+			PrefixesReceived: 1,
+			LastNotification: &packet.BGPNotification{
+				ErrorCode:    packet.Cease,
+				ErrorSubcode: packet.AdminShut,
+			},
+		},
+	})
+}
+
+func get(t *testing.T, s *Server, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	return w
+}
+
+func TestRIB(t *testing.T) {
+	w := get(t, testServer(), "/rib")
+
+	var shape []map[string]interface{}
+	if !assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &shape)) || !assert.Len(t, shape, 1) {
+		return
+	}
+	assert.Equal(t, "192.0.2.0/24", shape[0]["prefix"])
+	assert.Len(t, shape[0]["paths"], 2)
+
+	var routes []Route
+	if !assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &routes)) {
+		return
+	}
+
+	expected := []Route{
+		{
+			Prefix: "192.0.2.0/24",
+			Paths: []Path{
+				{
+					Type:        "bgp",
+					Active:      true,
+					NextHop:     "198.51.100.1",
+					LocalPref:   200,
+					Origin:      "IGP",
+					ASPath:      "65201 64496",
+					Communities: []string{"65200:100"},
+					Source:      "198.51.100.1",
+					EBGP:        true,
+				},
+				{
+					Type:        "bgp",
+					NextHop:     "203.0.113.1",
+					LocalPref:   100,
+					Origin:      "IGP",
+					ASPath:      "65201 64496",
+					Communities: []string{"65200:100"},
+					Source:      "203.0.113.1",
+					EBGP:        true,
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, routes)
+}
+
+func TestNeighbors(t *testing.T) {
+	w := get(t, testServer(), "/neighbors")
+
+	var shape []map[string]interface{}
+	if !assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &shape)) || !assert.Len(t, shape, 1) {
+		return
+	}
+	for _, key := range []string{"peer_address", "state", "uptime_seconds", "capabilities", "prefixes_received", "last_notification"} {
+		assert.Contains(t, shape[0], key)
+	}
+
+	var res []Neighbor
+	if !assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res)) {
+		return
+	}
+
+	n := res[0]
+	assert.Equal(t, "198.51.100.1", n.PeerAddress)
+	assert.Equal(t, "Established", n.State)
+	assert.Equal(t, float64(60), n.UptimeSeconds)
+	assert.Equal(t, float64(90), n.HoldTimeSeconds)
+	assert.Equal(t, "0.0.0.100", n.RouterID)
+	assert.Equal(t, "198.51.100.1", n.NeighborID)
+	assert.Equal(t, []string{"4-octet-asn (65201)"}, n.Capabilities)
+	assert.Equal(t, uint64(1), n.PrefixesReceived)
+	assert.Equal(t, &Notification{Code: packet.Cease, Subcode: packet.AdminShut}, n.LastNotification)
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	w := httptest.NewRecorder()
+	testServer().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rib", nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, http.MethodGet, w.Header().Get("Allow"))
+}
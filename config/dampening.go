@@ -0,0 +1,21 @@
+package config
+
+// Dampening configures route flap dampening (RFC 2439). Zero values select the
+// defaults given.
+type Dampening struct {
+	// HalfLife is the time in seconds after which a penalty has decayed to
+	// half its value. Zero selects 900 seconds.
+	HalfLife uint16
+
+	// SuppressThreshold is the penalty above which a path is suppressed. Zero
+	// selects 2000.
+	SuppressThreshold uint32
+
+	// ReuseThreshold is the penalty below which a suppressed path is used
+	// again. Zero selects 750.
+	ReuseThreshold uint32
+
+	// MaxSuppressTime is the time in seconds a path is suppressed at most
+	// after its last flap. Zero selects 3600 seconds.
+	MaxSuppressTime uint16
+}
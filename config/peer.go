@@ -1,9 +1,17 @@
 package config
 
 import (
+	"math"
 	"net"
+
+	bnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/rt"
 )
 
+// AdvertiseAllActivePaths advertises all active paths of a prefix to a peer
+// supporting ADD-PATH
+const AdvertiseAllActivePaths = math.MaxUint8
+
 type Peer struct {
 	AdminEnabled bool
 	KeepAlive    uint16
@@ -12,6 +20,196 @@ type Peer struct {
 	PeerAddress  net.IP
 	LocalAS      uint32
 	PeerAS       uint32
-	Passive      bool
 	RouterID     uint32
+
+	// Passive only accepts connections from the peer and never connects to
+	// it. Active only connects to the peer and refuses its connections.
+	// Setting neither does both, Passive takes precedence over Active.
+	Passive bool
+	Active  bool
+
+	// SendBufferSize and ReceiveBufferSize set SO_SNDBUF and SO_RCVBUF on the
+	// session's TCP connection. Zero keeps the operating system default. Linux
+	// doubles the requested value for bookkeeping overhead and caps it at
+	// net.core.wmem_max/rmem_max, BSDs cap it at kern.ipc.maxsockbuf.
+	SendBufferSize    int
+	ReceiveBufferSize int
+
+	// Nagle enables Nagle's algorithm by clearing TCP_NODELAY which is set by
+	// default on all connections
+	Nagle bool
+
+	// GracefulRestartTime is the restart time in seconds advertised in the
+	// graceful restart capability. Zero does not advertise the capability.
+	GracefulRestartTime uint16
+
+	// PrefixLimit is the maximum number of prefixes accepted from the peer. The
+	// session is torn down once it is exceeded. Zero disables the limit.
+	PrefixLimit uint64
+
+	// PrefixLimitWarning is the percentage of PrefixLimit at which a warning
+	// is logged. Zero disables the warning.
+	PrefixLimitWarning uint8
+
+	// MaxCommunities and MaxExtendedCommunities cap the number of COMMUNITIES
+	// and EXTENDED_COMMUNITIES accepted per route. Routes exceeding them are
+	// treated as withdrawn. Zero selects 1024 and 512.
+	MaxCommunities         int
+	MaxExtendedCommunities int
+
+	// AllowASIn is the number of times the local AS may occur in the AS_PATH
+	// of routes received from the peer. Routes exceeding it are rejected.
+	AllowASIn uint8
+
+	// NextHopSelf sets the NEXT_HOP of routes advertised to an iBGP peer to
+	// the local address of the session. Routes advertised to eBGP peers
+	// always carry the local address.
+	NextHopSelf bool
+
+	// ImportPolicy is applied to routes received from the peer before they
+	// are stored in the Adj-RIB-In. Unless SoftReconfigInbound is set routes
+	// are not stored as received, so the peer is asked to advertise them
+	// again with a ROUTE-REFRESH once the policy is changed with
+	// Peer.SetImportPolicy. If it does not support route refresh the new
+	// policy only applies to routes received later.
+	ImportPolicy rt.Policy
+
+	// SoftReconfigInbound keeps the routes received from the peer before the
+	// import policy is applied, so a changed policy is applied to them
+	// without asking the peer to advertise them again. This takes about as
+	// much memory as the Adj-RIB-In itself.
+	SoftReconfigInbound bool
+
+	// Dampening suppresses flapping routes received from the peer (RFC 2439)
+	// before they are passed on to the clients of the Adj-RIB-In. Nil
+	// disables it.
+	Dampening *Dampening
+
+	// MED is the MULTI_EXIT_DISC locally originated routes are advertised to
+	// the peer with. Zero advertises them without MED.
+	MED uint32
+
+	// MEDResolver derives the MED of locally originated routes advertised to
+	// the peer from the IGP metric towards their next hop, e.g. by resolving
+	// it in the IGP RIB or the Loc-RIB. It takes precedence over MED. Routes
+	// whose next hop can not be resolved or that have no next hop get MED.
+	// Routes received from other peers keep their MED, which is only
+	// advertised to external peers if the import policy set it. Nil disables
+	// it.
+	MEDResolver rt.NextHopResolver
+
+	// ExportPolicy is applied to routes advertised to the peer after the next
+	// hop was set, so a next hop set by the policy takes precedence
+	ExportPolicy rt.Policy
+
+	// Aggregates are advertised to the peer as long as the Loc-RIB contains
+	// more specific routes contributing to them. IPv4 only.
+	Aggregates []Aggregate
+
+	// RouteReflectorClient makes the peer a route reflector client (RFC 4456).
+	// Routes received from clients are reflected to all iBGP peers, routes
+	// received from other iBGP peers are only reflected to clients.
+	RouteReflectorClient bool
+
+	// RouteServerClient makes the peer a client of a transparent route server
+	// (RFC 7947). Routes advertised to it keep their NEXT_HOP and AS_PATH,
+	// the local AS is not prepended. NextHopSelf is ignored. The AS_PATH of
+	// routes received from it is kept as received, so it is passed on to
+	// other clients byte for byte.
+	RouteServerClient bool
+
+	// ClusterID identifies the cluster of the route reflector. Zero uses the
+	// router ID.
+	ClusterID uint32
+
+	// ConfederationID is the AS number of the confederation LocalAS is a
+	// member AS of (RFC 5065). It is presented to peers outside of the
+	// confederation. Zero disables confederations.
+	ConfederationID uint32
+
+	// ConfederationPeer marks a peer of another member AS of the confederation.
+	// Its routes are treated like iBGP routes in path selection.
+	ConfederationPeer bool
+
+	// MD5Password enables TCP-MD5 signatures (RFC 2385) on the session. The
+	// kernel signs and verifies all segments, so it must be supported by the
+	// operating system. Empty disables it.
+	MD5Password string
+
+	// UpdateRateLimit is the number of UPDATEs per second processed from the
+	// peer. UPDATEs exceeding it are not dropped but delayed, which stops
+	// reading from the connection. Other messages are not limited. Zero
+	// disables the limit. UPDATEs sent to the peer are not limited.
+	UpdateRateLimit float64
+
+	// UpdateRateBurst is the number of UPDATEs processed in a burst before
+	// UpdateRateLimit applies
+	UpdateRateBurst uint
+
+	// AdvertisementInterval is the minimum route advertisement interval in
+	// seconds. Changes of a prefix within the interval are advertised with a
+	// single UPDATE. Zero selects 30 seconds for eBGP and no delay for iBGP
+	// peers.
+	AdvertisementInterval uint16
+
+	// CoalesceInterval is the time in milliseconds changes of the Loc-RIB are
+	// collected before they are advertised to the peer. Changes of a prefix
+	// within the interval are advertised as its final state, so a prefix
+	// withdrawn and added again is announced with a single UPDATE. Unlike
+	// AdvertisementInterval it also delays withdrawals. Zero advertises
+	// changes as they happen.
+	CoalesceInterval uint16
+
+	// AdvertisePaths is the number of active paths per prefix advertised to
+	// the peer, starting with the best path. Zero or one advertises the best
+	// path only, AdvertiseAllActivePaths all active paths. More than one path
+	// is only advertised if the peer announced to receive multiple paths for
+	// IPv4 unicast with the ADD-PATH capability (RFC 7911).
+	AdvertisePaths uint8
+
+	// IdleHoldTime is the time in seconds the session stays Idle after it was
+	// closed by a NOTIFICATION of the peer before it is restarted. It doubles
+	// with every NOTIFICATION received within the idle hold time after the
+	// session was established. Zero disables the automatic restart.
+	IdleHoldTime uint16
+
+	// ConnectRetryTime is the initial ConnectRetry time in seconds. It doubles
+	// with every failed connection attempt up to ConnectRetryTimeMax and is
+	// reset once the session is established. Zero selects 5 seconds.
+	ConnectRetryTime uint16
+
+	// ConnectRetryTimeMax caps the ConnectRetry time in seconds. Zero selects
+	// 120 seconds.
+	ConnectRetryTimeMax uint16
+
+	// ConnectRetryJitter is the fraction of the ConnectRetry time it is
+	// randomly reduced by, so peers dropped by a shared outage do not all
+	// reconnect at once. Zero selects 0.25, negative values disable jitter.
+	ConnectRetryJitter float64
+}
+
+// Aggregate is a route formed from all more specific routes of Prefix (RFC
+// 4271, 9.2.2.2). The AS_PATHs of the contributing routes are merged into a
+// leading AS_SEQUENCE of the ASes they share followed by an AS_SET of all
+// others. ATOMIC_AGGREGATE is set if ASes were dropped.
+type Aggregate struct {
+	Prefix *bnet.Prefix
+
+	// DisableASSet drops the ASes the contributing routes do not share
+	// instead of adding them as an AS_SET
+	DisableASSet bool
+
+	// SummaryOnly suppresses the advertisement of the contributing routes
+	SummaryOnly bool
+
+	// Aggregator adds an AGGREGATOR attribute carrying the local AS and the
+	// router ID
+	Aggregator bool
+}
+
+// DynamicPeerRange accepts BGP sessions from any address within Range. Sessions
+// are created from Template with the peer address set to the connecting source.
+type DynamicPeerRange struct {
+	Range    net.IPNet
+	Template Peer
 }
@@ -14,10 +14,19 @@ type Global struct {
 	Port             uint16
 	LocalAddressList []net.IP
 	Listen           bool
+	ConnRateLimit    float64
+	ConnRateBurst    uint
 }
 
 const BGPPORT = uint16(179)
 
+const (
+	// DefaultConnRateLimit is the default number of incoming connections per second accepted from one source
+	DefaultConnRateLimit = 5
+	// DefaultConnRateBurst is the default number of incoming connections from one source accepted in a burst
+	DefaultConnRateBurst = 20
+)
+
 func (g *Global) SetDefaultGlobalConfigValues() error {
 	if g.LocalAddressList == nil {
 		g.LocalAddressList = make([]net.IP, 0)
@@ -37,6 +46,14 @@ func (g *Global) SetDefaultGlobalConfigValues() error {
 		g.Port = BGPPORT
 	}
 
+	if g.ConnRateLimit == 0 {
+		g.ConnRateLimit = DefaultConnRateLimit
+	}
+
+	if g.ConnRateBurst == 0 {
+		g.ConnRateBurst = DefaultConnRateBurst
+	}
+
 	return nil
 }
 
@@ -1,6 +1,8 @@
 package net
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"math"
 	"net"
@@ -10,13 +12,21 @@ import (
 	"github.com/taktv6/tflow2/convert"
 )
 
-// Prefix represents an IPv4 prefix
+// Address family identifiers as of IANA Address Family Numbers
+const (
+	IPv4AFI = 1
+	IPv6AFI = 2
+)
+
+// Prefix represents an IPv4 or IPv6 prefix
 type Prefix struct {
 	addr   uint32
+	addr6  [net.IPv6len]byte
+	ipv6   bool
 	pfxlen uint8
 }
 
-// NewPfx creates a new Prefix
+// NewPfx creates a new IPv4 Prefix
 func NewPfx(addr uint32, pfxlen uint8) *Prefix {
 	return &Prefix{
 		addr:   addr,
@@ -24,6 +34,33 @@ func NewPfx(addr uint32, pfxlen uint8) *Prefix {
 	}
 }
 
+// NewPfx6 creates a new IPv6 Prefix
+func NewPfx6(addr [net.IPv6len]byte, pfxlen uint8) *Prefix {
+	return &Prefix{
+		addr6:  addr,
+		ipv6:   true,
+		pfxlen: pfxlen,
+	}
+}
+
+// ParsePrefix parses a prefix in CIDR notation, e.g. "10.0.0.0/8" or
+// "2001:db8::/32". Host bits set in the address are cleared.
+func ParsePrefix(s string) (*Prefix, error) {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse prefix %q: %v", s, err)
+	}
+
+	pfxlen, _ := n.Mask.Size()
+	if len(n.IP) == net.IPv4len {
+		return NewPfx(binary.BigEndian.Uint32(n.IP), uint8(pfxlen)), nil
+	}
+
+	var addr [net.IPv6len]byte
+	copy(addr[:], n.IP)
+	return NewPfx6(addr, uint8(pfxlen)), nil
+}
+
 // StrToAddr converts an IP address string to it's uint32 representation
 func StrToAddr(x string) (uint32, error) {
 	parts := strings.Split(x, ".")
@@ -48,11 +85,25 @@ func StrToAddr(x string) (uint32, error) {
 	return ret, nil
 }
 
-// Addr returns the address of the prefix
+// AFI returns the address family of the prefix
+func (pfx *Prefix) AFI() uint16 {
+	if pfx.ipv6 {
+		return IPv6AFI
+	}
+
+	return IPv4AFI
+}
+
+// Addr returns the address of an IPv4 prefix
 func (pfx *Prefix) Addr() uint32 {
 	return pfx.addr
 }
 
+// Addr6 returns the address of an IPv6 prefix
+func (pfx *Prefix) Addr6() [net.IPv6len]byte {
+	return pfx.addr6
+}
+
 // Pfxlen returns the length of the prefix
 func (pfx *Prefix) Pfxlen() uint8 {
 	return pfx.pfxlen
@@ -60,26 +111,55 @@ func (pfx *Prefix) Pfxlen() uint8 {
 
 // String returns a string representation of pfx
 func (pfx *Prefix) String() string {
+	if pfx.ipv6 {
+		return fmt.Sprintf("%s/%d", net.IP(pfx.addr6[:]), pfx.pfxlen)
+	}
+
 	return fmt.Sprintf("%s/%d", net.IP(convert.Uint32Byte(pfx.addr)), pfx.pfxlen)
 }
 
-// Contains checks if x is a subnet of or equal to pfx
+// MarshalJSON encodes pfx as a JSON string in CIDR notation
+func (pfx *Prefix) MarshalJSON() ([]byte, error) {
+	return json.Marshal(pfx.String())
+}
+
+// Contains checks if x is a more specific of pfx. Host bits of both prefixes
+// are ignored.
 func (pfx *Prefix) Contains(x *Prefix) bool {
-	if x.pfxlen <= pfx.pfxlen {
+	if x.pfxlen <= pfx.pfxlen || x.ipv6 != pfx.ipv6 {
 		return false
 	}
 
-	mask := (uint32(1) << (32 - pfx.pfxlen))
-	return (pfx.addr & mask) == (x.addr & mask)
+	return pfx.equalNetwork(x, pfx.pfxlen)
 }
 
-// Equal checks if pfx and x are equal
+// Equal checks if pfx and x are of the same address family and length and
+// share the same network address. Host bits are ignored.
 func (pfx *Prefix) Equal(x *Prefix) bool {
-	return *pfx == *x
+	if x.pfxlen != pfx.pfxlen || x.ipv6 != pfx.ipv6 {
+		return false
+	}
+
+	return pfx.equalNetwork(x, pfx.pfxlen)
+}
+
+// equalNetwork checks if the first n bits of the addresses of pfx and x are
+// equal
+func (pfx *Prefix) equalNetwork(x *Prefix, n uint8) bool {
+	if pfx.ipv6 {
+		return equalBits(pfx.addr6, x.addr6, n)
+	}
+
+	mask := ^uint32(0) << (32 - n)
+	return (pfx.addr & mask) == (x.addr & mask)
 }
 
 // GetSupernet gets the next common supernet of pfx and x
 func (pfx *Prefix) GetSupernet(x *Prefix) *Prefix {
+	if pfx.ipv6 {
+		return pfx.getSupernet6(x)
+	}
+
 	maxPfxLen := min(pfx.pfxlen, x.pfxlen) - 1
 	a := pfx.addr >> (32 - maxPfxLen)
 	b := x.addr >> (32 - maxPfxLen)
@@ -102,3 +182,32 @@ func min(a uint8, b uint8) uint8 {
 	}
 	return b
 }
+
+func (pfx *Prefix) getSupernet6(x *Prefix) *Prefix {
+	maxPfxLen := min(pfx.pfxlen, x.pfxlen) - 1
+	for maxPfxLen > 0 && !equalBits(pfx.addr6, x.addr6, maxPfxLen) {
+		maxPfxLen--
+	}
+
+	return NewPfx6(maskBits(pfx.addr6, maxPfxLen), maxPfxLen)
+}
+
+// equalBits checks if the first n bits of a and b are equal
+func equalBits(a, b [net.IPv6len]byte, n uint8) bool {
+	return maskBits(a, n) == maskBits(b, n)
+}
+
+// maskBits returns addr with all but the first n bits cleared
+func maskBits(addr [net.IPv6len]byte, n uint8) [net.IPv6len]byte {
+	for i := range addr {
+		bits := int(n) - i*8
+		switch {
+		case bits <= 0:
+			addr[i] = 0
+		case bits < 8:
+			addr[i] &= ^byte(0) << uint(8-bits)
+		}
+	}
+
+	return addr
+}
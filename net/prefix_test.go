@@ -1,6 +1,8 @@
 package net
 
 import (
+	"encoding/json"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -92,6 +94,18 @@ func TestGetSupernet(t *testing.T) {
 				pfxlen: 0,
 			},
 		},
+		{
+			name:     "IPv6",
+			a:        NewPfx6(addr6("2001:db8::"), 32),
+			b:        NewPfx6(addr6("2001:db9:100::"), 48),
+			expected: NewPfx6(addr6("2001:db8::"), 31),
+		},
+		{
+			name:     "IPv6 default",
+			a:        NewPfx6(addr6("2001:db8::"), 32),
+			b:        NewPfx6(addr6("fd00::"), 8),
+			expected: NewPfx6(addr6("::"), 0),
+		},
 	}
 
 	for _, test := range tests {
@@ -179,6 +193,48 @@ func TestContains(t *testing.T) {
 			},
 			expected: false,
 		},
+		{
+			name: "Test 7",
+			a: &Prefix{
+				addr:   167772160, // 10.0.0.0
+				pfxlen: 8,
+			},
+			b: &Prefix{
+				addr:   201326592, // 12.0.0.0
+				pfxlen: 16,
+			},
+			expected: false,
+		},
+		{
+			name:     "10.0.0.0/8 contains 10.1.0.0/16",
+			a:        NewPfx(167772160, 8),
+			b:        NewPfx(167837696, 16),
+			expected: true,
+		},
+		{
+			name:     "10.0.0.0/8 does not contain 11.0.0.0/8",
+			a:        NewPfx(167772160, 8),
+			b:        NewPfx(184549376, 8),
+			expected: false,
+		},
+		{
+			name:     "Equal prefix",
+			a:        NewPfx(167772160, 8),
+			b:        NewPfx(167772160, 8),
+			expected: false,
+		},
+		{
+			name:     "Host bits set",
+			a:        NewPfx(167772161, 8),  // 10.0.0.1/8
+			b:        NewPfx(167837697, 16), // 10.1.0.1/16
+			expected: true,
+		},
+		{
+			name:     "Default route contains host route",
+			a:        NewPfx(0, 0),
+			b:        NewPfx(4294967295, 32), // 255.255.255.255/32
+			expected: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -239,8 +295,44 @@ func TestEqual(t *testing.T) {
 		},
 		{
 			name:     "Unequal PFXs",
-			a:        NewPfx(100, 8),
-			b:        NewPfx(200, 8),
+			a:        NewPfx(167772160, 8), // 10.0.0.0/8
+			b:        NewPfx(184549376, 8), // 11.0.0.0/8
+			expected: false,
+		},
+		{
+			name:     "Different length",
+			a:        NewPfx(167772160, 8),
+			b:        NewPfx(167772160, 16),
+			expected: false,
+		},
+		{
+			name:     "Host bits set",
+			a:        NewPfx(167772160, 8),
+			b:        NewPfx(167772161, 8), // 10.0.0.1/8
+			expected: true,
+		},
+		{
+			name:     "Default routes",
+			a:        NewPfx(0, 0),
+			b:        NewPfx(167772160, 0),
+			expected: true,
+		},
+		{
+			name:     "Different address family",
+			a:        NewPfx(0, 0),
+			b:        NewPfx6(addr6("::"), 0),
+			expected: false,
+		},
+		{
+			name:     "Equal IPv6 PFXs",
+			a:        NewPfx6(addr6("2001:db8::"), 32),
+			b:        NewPfx6(addr6("2001:db8::1"), 32),
+			expected: true,
+		},
+		{
+			name:     "Unequal IPv6 PFXs",
+			a:        NewPfx6(addr6("2001:db8::"), 32),
+			b:        NewPfx6(addr6("2001:db9::"), 32),
 			expected: false,
 		},
 	}
@@ -269,6 +361,11 @@ func TestString(t *testing.T) {
 			pfx:      NewPfx(167772160, 16), // 10.0.0.0/8
 			expected: "10.0.0.0/16",
 		},
+		{
+			name:     "IPv6",
+			pfx:      NewPfx6(addr6("2001:db8::"), 32),
+			expected: "2001:db8::/32",
+		},
 	}
 
 	for _, test := range tests {
@@ -279,6 +376,123 @@ func TestString(t *testing.T) {
 	}
 }
 
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantFail bool
+		expected *Prefix
+	}{
+		{
+			name:     "IPv4",
+			input:    "10.0.0.0/8",
+			expected: NewPfx(167772160, 8),
+		},
+		{
+			name:     "Default route",
+			input:    "0.0.0.0/0",
+			expected: NewPfx(0, 0),
+		},
+		{
+			name:     "Host route",
+			input:    "192.0.2.1/32",
+			expected: NewPfx(3221225985, 32),
+		},
+		{
+			name:     "Host bits set",
+			input:    "192.0.2.1/24",
+			expected: NewPfx(3221225984, 24),
+		},
+		{
+			name:     "IPv6",
+			input:    "2001:db8::/32",
+			expected: NewPfx6(addr6("2001:db8::"), 32),
+		},
+		{
+			name:     "IPv6 default route",
+			input:    "::/0",
+			expected: NewPfx6(addr6("::"), 0),
+		},
+		{
+			name:     "Invalid mask length",
+			input:    "10.0.0.0/33",
+			wantFail: true,
+		},
+		{
+			name:     "Invalid IPv6 mask length",
+			input:    "2001:db8::/129",
+			wantFail: true,
+		},
+		{
+			name:     "Missing mask length",
+			input:    "10.0.0.0",
+			wantFail: true,
+		},
+		{
+			name:     "Invalid address",
+			input:    "10.256.0.0/16",
+			wantFail: true,
+		},
+		{
+			name:     "Empty",
+			input:    "",
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		res, err := ParsePrefix(test.input)
+		if err != nil {
+			if test.wantFail {
+				continue
+			}
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+			continue
+		}
+
+		if test.wantFail {
+			t.Errorf("Unexpected success for test %q", test.name)
+			continue
+		}
+
+		assert.Equal(t, test.expected, res, test.name)
+	}
+}
+
+func TestParsePrefixRoundTrip(t *testing.T) {
+	tests := []*Prefix{
+		NewPfx(0, 0),
+		NewPfx(167772160, 8),
+		NewPfx(3221225985, 32),
+		NewPfx6(addr6("::"), 0),
+		NewPfx6(addr6("2001:db8:100::"), 48),
+		NewPfx6(addr6("2001:db8::1"), 128),
+	}
+
+	for _, pfx := range tests {
+		res, err := ParsePrefix(pfx.String())
+		if !assert.NoError(t, err, pfx.String()) {
+			continue
+		}
+
+		assert.Equal(t, pfx, res, pfx.String())
+		assert.Equal(t, pfx.String(), res.String())
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	res, err := json.Marshal(struct {
+		Prefix  *Prefix
+		Prefix6 *Prefix
+	}{
+		Prefix:  NewPfx(167772160, 8),
+		Prefix6: NewPfx6(addr6("2001:db8::"), 32),
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, `{"Prefix":"10.0.0.0/8","Prefix6":"2001:db8::/32"}`, string(res))
+	}
+}
+
 func TestStrToAddr(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -332,3 +546,57 @@ func TestStrToAddr(t *testing.T) {
 		assert.Equal(t, test.expected, res)
 	}
 }
+
+func TestContains6(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        *Prefix
+		b        *Prefix
+		expected bool
+	}{
+		{
+			name:     "More specific",
+			a:        NewPfx6(addr6("2001:db8::"), 32),
+			b:        NewPfx6(addr6("2001:db8:100::"), 48),
+			expected: true,
+		},
+		{
+			name:     "Unaligned prefix length",
+			a:        NewPfx6(addr6("2001:db8::"), 31),
+			b:        NewPfx6(addr6("2001:db9:100::"), 48),
+			expected: true,
+		},
+		{
+			name:     "Different prefix",
+			a:        NewPfx6(addr6("2001:db8::"), 32),
+			b:        NewPfx6(addr6("2001:db9::"), 48),
+			expected: false,
+		},
+		{
+			name:     "Default route",
+			a:        NewPfx6(addr6("::"), 0),
+			b:        NewPfx6(addr6("2001:db8::1"), 128),
+			expected: true,
+		},
+		{
+			name:     "Different address family",
+			a:        NewPfx6(addr6("::"), 0),
+			b:        NewPfx(167772160, 8),
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.a.Contains(test.b), test.name)
+	}
+}
+
+func TestAFI(t *testing.T) {
+	assert.Equal(t, uint16(IPv4AFI), NewPfx(167772160, 8).AFI())
+	assert.Equal(t, uint16(IPv6AFI), NewPfx6(addr6("2001:db8::"), 32).AFI())
+}
+
+func addr6(s string) (addr [net.IPv6len]byte) {
+	copy(addr[:], net.ParseIP(s))
+	return addr
+}
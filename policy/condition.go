@@ -0,0 +1,101 @@
+package policy
+
+import (
+	gonet "net"
+	"regexp"
+
+	"github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+)
+
+// Condition matches paths of a prefix
+type Condition interface {
+	Matches(pfx *net.Prefix, p *rt.Path) bool
+}
+
+// PrefixRange matches Prefix and its more specifics with a prefix length
+// between GE and LE. Without GE and LE only Prefix itself matches. With only LE
+// set lengths from the length of Prefix up to LE match, with only GE set
+// lengths from GE up to the maximum of the address family.
+type PrefixRange struct {
+	Prefix *net.Prefix
+	GE     uint8
+	LE     uint8
+}
+
+// Matches checks if pfx is within r
+func (r PrefixRange) Matches(pfx *net.Prefix) bool {
+	if !r.Prefix.Equal(pfx) && !r.Prefix.Contains(pfx) {
+		return false
+	}
+
+	min, max := r.Prefix.Pfxlen(), r.Prefix.Pfxlen()
+	if r.GE != 0 {
+		min = r.GE
+		max = maxPfxlen(pfx)
+	}
+	if r.LE != 0 {
+		max = r.LE
+	}
+
+	return pfx.Pfxlen() >= min && pfx.Pfxlen() <= max
+}
+
+func maxPfxlen(pfx *net.Prefix) uint8 {
+	if pfx.AFI() == net.IPv6AFI {
+		return 128
+	}
+
+	return 32
+}
+
+// PrefixList matches prefixes within any of its ranges
+type PrefixList []PrefixRange
+
+// Matches checks if pfx is within any range of l
+func (l PrefixList) Matches(pfx *net.Prefix, p *rt.Path) bool {
+	for _, r := range l {
+		if r.Matches(pfx) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ASPathRegexp matches BGP paths with an AS path matching a regular expression.
+// AS paths are formatted as space separated AS numbers with AS sets in parentheses.
+type ASPathRegexp struct {
+	Regexp *regexp.Regexp
+}
+
+// Matches checks if the AS path of p matches c
+func (c ASPathRegexp) Matches(pfx *net.Prefix, p *rt.Path) bool {
+	return p.Type == rt.BGPPathType && c.Regexp.MatchString(p.BGPPath.ASPath)
+}
+
+// HasCommunity matches BGP paths carrying a community
+type HasCommunity uint32
+
+// Matches checks if p carries the community c
+func (c HasCommunity) Matches(pfx *net.Prefix, p *rt.Path) bool {
+	return p.Type == rt.BGPPathType && p.BGPPath.HasCommunity(uint32(c))
+}
+
+// HasExtendedCommunity matches BGP paths carrying an extended community, e.g.
+// a route target
+type HasExtendedCommunity packet.ExtendedCommunity
+
+// Matches checks if p carries the extended community c
+func (c HasExtendedCommunity) Matches(pfx *net.Prefix, p *rt.Path) bool {
+	return p.Type == rt.BGPPathType && p.BGPPath.HasExtendedCommunity(packet.ExtendedCommunity(c))
+}
+
+// NextHop matches BGP paths with a next hop
+type NextHop gonet.IP
+
+// Matches checks if the next hop of p is n
+func (n NextHop) Matches(pfx *net.Prefix, p *rt.Path) bool {
+	return p.Type == rt.BGPPathType && p.BGPPath.NextHopIP().Equal(gonet.IP(n))
+}
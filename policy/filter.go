@@ -0,0 +1,114 @@
+// Package policy implements route filters applied when paths are imported into
+// or exported from a RIB
+package policy
+
+import (
+	"github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/rt"
+)
+
+// Verdict is the decision a term takes for a matching path
+type Verdict uint8
+
+const (
+	// Next applies the modifiers of a term and continues with the next term
+	Next Verdict = iota
+
+	// Accept applies the modifiers of a term and accepts the path
+	Accept
+
+	// Reject rejects the path
+	Reject
+)
+
+// Term modifies and decides on paths matching all of its conditions. A term
+// without conditions matches all paths.
+type Term struct {
+	Name       string
+	Conditions []Condition
+	Modifiers  []Modifier
+	Verdict    Verdict
+}
+
+// Filter runs paths through an ordered list of terms. The first term with an
+// Accept or Reject verdict decides. Paths not decided by any term get the
+// default verdict.
+type Filter struct {
+	Terms   []*Term
+	Default Verdict
+}
+
+var _ rt.Policy = &Filter{}
+
+// Process runs p through f. The returned path carries all modifications of
+// matching terms. p itself is never modified.
+func (f *Filter) Process(pfx *net.Prefix, p *rt.Path) (res *rt.Path, accept bool) {
+	res = p
+	for _, t := range f.Terms {
+		if !t.matches(pfx, res) {
+			continue
+		}
+
+		if t.Verdict == Reject {
+			return nil, false
+		}
+
+		if len(t.Modifiers) > 0 {
+			if res == p {
+				res = copyPath(p)
+			}
+
+			for _, m := range t.Modifiers {
+				m.Modify(res)
+			}
+		}
+
+		if t.Verdict == Accept {
+			return res, true
+		}
+	}
+
+	if f.Default != Accept {
+		return nil, false
+	}
+
+	return res, true
+}
+
+// TagPrefixes returns a term adding communities to the paths of prefixes within
+// l, e.g. to tag routes by region on export. The term continues with the next
+// term, so it can be combined with any other terms of a filter.
+func TagPrefixes(name string, l PrefixList, communities ...uint32) *Term {
+	t := &Term{
+		Name:       name,
+		Conditions: []Condition{l},
+		Verdict:    Next,
+	}
+	for _, c := range communities {
+		t.Modifiers = append(t.Modifiers, AddCommunity(c))
+	}
+
+	return t
+}
+
+func (t *Term) matches(pfx *net.Prefix, p *rt.Path) bool {
+	for _, c := range t.Conditions {
+		if !c.Matches(pfx, p) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// copyPath returns a copy of p whose BGP attributes can be modified. The
+// received attributes are shared with p.
+func copyPath(p *rt.Path) *rt.Path {
+	c := *p
+	if p.BGPPath != nil {
+		c.BGPPath = p.BGPPath.Copy()
+		c.BGPPath.Received = p.BGPPath.Received
+	}
+
+	return &c
+}
@@ -0,0 +1,236 @@
+package policy
+
+import (
+	gonet "net"
+	"regexp"
+	"testing"
+
+	"github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixRangeMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		r        PrefixRange
+		pfx      *net.Prefix
+		expected bool
+	}{
+		{
+			name:     "Exact match",
+			r:        PrefixRange{Prefix: net.NewPfx(167772160, 8)}, // 10.0.0.0/8
+			pfx:      net.NewPfx(167772160, 8),
+			expected: true,
+		},
+		{
+			name:     "More specific without le",
+			r:        PrefixRange{Prefix: net.NewPfx(167772160, 8)},
+			pfx:      net.NewPfx(167837696, 16), // 10.1.0.0/16
+			expected: false,
+		},
+		{
+			name:     "le 24 matches /8",
+			r:        PrefixRange{Prefix: net.NewPfx(167772160, 8), LE: 24},
+			pfx:      net.NewPfx(167772160, 8),
+			expected: true,
+		},
+		{
+			name:     "le 24 matches /24",
+			r:        PrefixRange{Prefix: net.NewPfx(167772160, 8), LE: 24},
+			pfx:      net.NewPfx(167837952, 24), // 10.1.1.0/24
+			expected: true,
+		},
+		{
+			name:     "le 24 does not match /25",
+			r:        PrefixRange{Prefix: net.NewPfx(167772160, 8), LE: 24},
+			pfx:      net.NewPfx(167837952, 25),
+			expected: false,
+		},
+		{
+			name:     "ge 16 does not match /8",
+			r:        PrefixRange{Prefix: net.NewPfx(167772160, 8), GE: 16},
+			pfx:      net.NewPfx(167772160, 8),
+			expected: false,
+		},
+		{
+			name:     "ge 16 matches /32",
+			r:        PrefixRange{Prefix: net.NewPfx(167772160, 8), GE: 16},
+			pfx:      net.NewPfx(167837953, 32),
+			expected: true,
+		},
+		{
+			name:     "ge 16 le 24 matches /20",
+			r:        PrefixRange{Prefix: net.NewPfx(167772160, 8), GE: 16, LE: 24},
+			pfx:      net.NewPfx(167837696, 20),
+			expected: true,
+		},
+		{
+			name:     "Outside of prefix",
+			r:        PrefixRange{Prefix: net.NewPfx(167772160, 8), LE: 24},
+			pfx:      net.NewPfx(3221225984, 24), // 192.0.2.0/24
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.r.Matches(test.pfx), test.name)
+	}
+}
+
+func bgpPath(b rt.BGPPath) *rt.Path {
+	return &rt.Path{
+		Type:    rt.BGPPathType,
+		BGPPath: &b,
+	}
+}
+
+func TestFilterProcess(t *testing.T) {
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	tests := []struct {
+		name     string
+		filter   *Filter
+		path     *rt.Path
+		accept   bool
+		expected *rt.Path
+	}{
+		{
+			name: "Reject by community",
+			filter: &Filter{
+				Terms: []*Term{
+					{
+						Conditions: []Condition{HasCommunity(4259840100)}, // 65000:100
+						Verdict:    Reject,
+					},
+				},
+				Default: Accept,
+			},
+			path: bgpPath(rt.BGPPath{Communities: []uint32{4259840100}}),
+		},
+		{
+			name: "Community not present",
+			filter: &Filter{
+				Terms: []*Term{
+					{
+						Conditions: []Condition{HasCommunity(4259840100)},
+						Verdict:    Reject,
+					},
+				},
+				Default: Accept,
+			},
+			path:     bgpPath(rt.BGPPath{Communities: []uint32{4259840200}}),
+			accept:   true,
+			expected: bgpPath(rt.BGPPath{Communities: []uint32{4259840200}}),
+		},
+		{
+			name: "Accept setting LOCAL_PREF",
+			filter: &Filter{
+				Terms: []*Term{
+					{
+						Conditions: []Condition{
+							PrefixList{{Prefix: net.NewPfx(3221225984, 16), LE: 24}},
+						},
+						Modifiers: []Modifier{SetLocalPref(200)},
+						Verdict:   Accept,
+					},
+				},
+				Default: Reject,
+			},
+			path:     bgpPath(rt.BGPPath{LocalPref: 100}),
+			accept:   true,
+			expected: bgpPath(rt.BGPPath{LocalPref: 200}),
+		},
+		{
+			name: "Default reject",
+			filter: &Filter{
+				Terms: []*Term{
+					{
+						Conditions: []Condition{
+							PrefixList{{Prefix: net.NewPfx(167772160, 8), LE: 24}},
+						},
+						Verdict: Accept,
+					},
+				},
+				Default: Reject,
+			},
+			path: bgpPath(rt.BGPPath{LocalPref: 100}),
+		},
+		{
+			name: "Modifications of Next terms accumulate",
+			filter: &Filter{
+				Terms: []*Term{
+					{
+						Conditions: []Condition{ASPathRegexp{Regexp: regexp.MustCompile(`^65001( |$)`)}},
+						Modifiers: []Modifier{
+							SetMED(10),
+							Prepend(65000, 2),
+						},
+						Verdict: Next,
+					},
+					{
+						Conditions: []Condition{NextHop(gonet.IP{192, 0, 2, 1})},
+						Modifiers:  []Modifier{AddCommunity(4259840100)},
+						Verdict:    Accept,
+					},
+				},
+				Default: Reject,
+			},
+			path: bgpPath(rt.BGPPath{
+				ASPath:     "65001 65002",
+				ASPathLen:  2,
+				NeighborAS: 65001,
+				NextHop:    3221225985,
+				ASPathSegments: packet.ASPath{
+					{Type: packet.ASSequence, Count: 2, ASNs: []uint32{65001, 65002}},
+				},
+			}),
+			accept: true,
+			expected: bgpPath(rt.BGPPath{
+				ASPath:      "65000 65000 65001 65002",
+				ASPathLen:   4,
+				NeighborAS:  65000,
+				NextHop:     3221225985,
+				MED:         10,
+				HasMED:      true,
+				Communities: []uint32{4259840100},
+				ASPathSegments: packet.ASPath{
+					{Type: packet.ASSequence, Count: 4, ASNs: []uint32{65000, 65000, 65001, 65002}},
+				},
+			}),
+		},
+	}
+
+	for _, test := range tests {
+		orig := copyPath(test.path)
+
+		res, accept := test.filter.Process(pfx, test.path)
+		assert.Equal(t, test.accept, accept, test.name)
+		assert.Equal(t, test.expected, res, test.name)
+		assert.Equal(t, orig, test.path, "%s: input modified", test.name)
+	}
+}
+
+func TestTagPrefixes(t *testing.T) {
+	f := &Filter{
+		Terms: []*Term{
+			TagPrefixes("documentation", PrefixList{
+				{Prefix: net.NewPfx(3325256704, 24), LE: 32}, // 198.51.100.0/24
+			}, 4259840100, 4259840200), // 65000:100 65000:200
+			{
+				Modifiers: []Modifier{SetMED(10)},
+				Verdict:   Accept,
+			},
+		},
+		Default: Reject,
+	}
+
+	res, accept := f.Process(net.NewPfx(3325256704, 24), bgpPath(rt.BGPPath{}))
+	assert.True(t, accept)
+	assert.Equal(t, bgpPath(rt.BGPPath{MED: 10, HasMED: true, Communities: []uint32{4259840100, 4259840200}}), res)
+
+	res, accept = f.Process(net.NewPfx(3221225984, 24), bgpPath(rt.BGPPath{})) // 192.0.2.0/24
+	assert.True(t, accept)
+	assert.Equal(t, bgpPath(rt.BGPPath{MED: 10, HasMED: true}), res)
+}
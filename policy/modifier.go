@@ -0,0 +1,91 @@
+package policy
+
+import (
+	gonet "net"
+
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+)
+
+// Modifier changes attributes of a path. Modifiers for BGP attributes leave
+// other paths alone.
+type Modifier interface {
+	Modify(p *rt.Path)
+}
+
+// SetLocalPref sets the LOCAL_PREF of BGP paths
+type SetLocalPref uint32
+
+// Modify sets the LOCAL_PREF of p
+func (m SetLocalPref) Modify(p *rt.Path) {
+	if p.Type == rt.BGPPathType {
+		p.BGPPath.LocalPref = uint32(m)
+	}
+}
+
+// SetMED sets the MED of BGP paths
+type SetMED uint32
+
+// Modify sets the MED of p
+func (m SetMED) Modify(p *rt.Path) {
+	if p.Type == rt.BGPPathType {
+		p.BGPPath.MED = uint32(m)
+		p.BGPPath.HasMED = true
+	}
+}
+
+// PrependASPath prepends ASN Times times to the AS path of BGP paths
+type PrependASPath struct {
+	ASN   uint32
+	Times uint16
+}
+
+// Prepend returns a modifier prepending asn count times to the AS path
+func Prepend(asn uint32, count uint16) Modifier {
+	return PrependASPath{ASN: asn, Times: count}
+}
+
+// Modify prepends the AS path of p
+func (m PrependASPath) Modify(p *rt.Path) {
+	if p.Type != rt.BGPPathType || m.Times == 0 {
+		return
+	}
+
+	p.BGPPath.SetASPath(p.BGPPath.ASPathSegments.Prepend(m.ASN, m.Times))
+}
+
+// SetNextHop sets the next hop of BGP paths
+type SetNextHop gonet.IP
+
+// Modify sets the next hop of p
+func (m SetNextHop) Modify(p *rt.Path) {
+	if p.Type == rt.BGPPathType {
+		p.BGPPath.SetNextHop(gonet.IP(m))
+	}
+}
+
+// AddCommunity adds a community to BGP paths not carrying it yet
+type AddCommunity uint32
+
+// Modify adds the community to p
+func (m AddCommunity) Modify(p *rt.Path) {
+	if p.Type != rt.BGPPathType || p.BGPPath.HasCommunity(uint32(m)) {
+		return
+	}
+
+	p.BGPPath.Communities = append(p.BGPPath.Communities, uint32(m))
+}
+
+// AddExtendedCommunity adds an extended community, e.g. a route target, to BGP
+// paths not carrying it yet
+type AddExtendedCommunity packet.ExtendedCommunity
+
+// Modify adds the extended community to p
+func (m AddExtendedCommunity) Modify(p *rt.Path) {
+	c := packet.ExtendedCommunity(m)
+	if p.Type != rt.BGPPathType || p.BGPPath.HasExtendedCommunity(c) {
+		return
+	}
+
+	p.BGPPath.ExtendedCommunities = append(p.BGPPath.ExtendedCommunities, c)
+}
@@ -0,0 +1,301 @@
+package packet
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Prepend returns a copy of path with asn prepended count times. The ASNs are
+// merged into a leading AS_SEQUENCE. New AS_SEQUENCE segments are put in front
+// if there is none or it would exceed 255 ASNs, the maximum a segment can hold.
+func (path ASPath) Prepend(asn uint32, count uint16) ASPath {
+	return path.prepend(ASSequence, asn, count)
+}
+
+// PrependConfederation returns a copy of path with the member AS asn prepended
+// to a leading AS_CONFED_SEQUENCE as required when advertising to a peer of
+// another member AS of a confederation (RFC 5065, 4)
+func (path ASPath) PrependConfederation(asn uint32) ASPath {
+	return path.prepend(ASConfedSequence, asn, 1)
+}
+
+func (path ASPath) prepend(typ uint8, asn uint32, count uint16) ASPath {
+	res := path.copy()
+
+	n := int(count)
+	if len(res) > 0 && res[0].Type == typ && len(res[0].ASNs) < math.MaxUint8 {
+		merge := math.MaxUint8 - len(res[0].ASNs)
+		if merge > n {
+			merge = n
+		}
+
+		res[0].ASNs = append(repeatASN(asn, merge), res[0].ASNs...)
+		res[0].Count = uint8(len(res[0].ASNs))
+		n -= merge
+	}
+
+	for n > 0 {
+		l := n
+		if l > math.MaxUint8 {
+			l = math.MaxUint8
+		}
+
+		res = append(ASPath{
+			{
+				Type:  typ,
+				Count: uint8(l),
+				ASNs:  repeatASN(asn, l),
+			},
+		}, res...)
+		n -= l
+	}
+
+	return res
+}
+
+// StripConfederation returns a copy of path without AS_CONFED_SEQUENCE and
+// AS_CONFED_SET segments as required when advertising to a peer outside of
+// the confederation (RFC 5065, 4)
+func (path ASPath) StripConfederation() ASPath {
+	res := make(ASPath, 0, len(path))
+	for _, s := range path.copy() {
+		if !s.isConfederation() {
+			res = append(res, s)
+		}
+	}
+
+	return res
+}
+
+// AggregateASPaths returns the AS_PATH of a route aggregating routes with the
+// given AS_PATHs (RFC 4271, 9.2.2.2). The ASNs all paths start with form a
+// leading AS_SEQUENCE. All other ASNs are added as an AS_SET if asSet is set
+// and dropped otherwise, in which case lost is true. Confederation segments
+// are ignored.
+func AggregateASPaths(paths []ASPath, asSet bool) (res ASPath, lost bool) {
+	var common []uint32
+	for i, path := range paths {
+		seq := path.StripConfederation().leadingSequence()
+		if i == 0 {
+			common = seq
+			continue
+		}
+
+		n := 0
+		for n < len(common) && n < len(seq) && common[n] == seq[n] {
+			n++
+		}
+		common = common[:n]
+	}
+
+	var set []uint32
+	seen := make(map[uint32]struct{})
+	for _, path := range paths {
+		skip := len(common)
+		for _, s := range path.StripConfederation() {
+			for _, asn := range s.ASNs {
+				if skip > 0 {
+					skip--
+					continue
+				}
+
+				if _, ok := seen[asn]; !ok {
+					seen[asn] = struct{}{}
+					set = append(set, asn)
+				}
+			}
+		}
+	}
+	sort.Slice(set, func(i, j int) bool { return set[i] < set[j] })
+
+	res = appendSegments(ASPath{}, ASSequence, common)
+	if !asSet {
+		return res, len(set) > 0
+	}
+
+	return appendSegments(res, ASSet, set), false
+}
+
+// leadingSequence returns the ASNs of the AS_SEQUENCE segments path starts with
+func (path ASPath) leadingSequence() []uint32 {
+	var res []uint32
+	for _, s := range path {
+		if s.Type != ASSequence {
+			break
+		}
+		res = append(res, s.ASNs...)
+	}
+
+	return res
+}
+
+// appendSegments appends asns to path as segments of type typ holding at most
+// 255 ASNs each
+func appendSegments(path ASPath, typ uint8, asns []uint32) ASPath {
+	for len(asns) > 0 {
+		l := len(asns)
+		if l > math.MaxUint8 {
+			l = math.MaxUint8
+		}
+
+		path = append(path, ASPathSegment{
+			Type:  typ,
+			Count: uint8(l),
+			ASNs:  append([]uint32(nil), asns[:l]...),
+		})
+		asns = asns[l:]
+	}
+
+	return path
+}
+
+func (path ASPath) copy() ASPath {
+	res := make(ASPath, 0, len(path)+1)
+	for _, s := range path {
+		asns := make([]uint32, len(s.ASNs))
+		copy(asns, s.ASNs)
+		res = append(res, ASPathSegment{
+			Type:  s.Type,
+			Count: s.Count,
+			ASNs:  asns,
+		})
+	}
+
+	return res
+}
+
+func (s ASPathSegment) isConfederation() bool {
+	return s.Type == ASConfedSequence || s.Type == ASConfedSet
+}
+
+// String returns path in human readable form. AS_SETs are enclosed in
+// parentheses, AS_CONFED_SEQUENCEs in brackets and AS_CONFED_SETs in braces.
+func (path ASPath) String() string {
+	segments := make([]string, 0, len(path))
+	for _, s := range path {
+		asns := make([]string, len(s.ASNs))
+		for i, asn := range s.ASNs {
+			asns[i] = fmt.Sprintf("%d", asn)
+		}
+
+		str := strings.Join(asns, " ")
+		switch s.Type {
+		case ASSet:
+			str = "(" + str + ")"
+		case ASConfedSequence:
+			str = "[" + str + "]"
+		case ASConfedSet:
+			str = "{" + str + "}"
+		}
+
+		segments = append(segments, str)
+	}
+
+	return strings.Join(segments, " ")
+}
+
+// Length returns the length of path used in path selection. An AS_SET counts
+// as one AS, confederation segments are not counted (RFC 5065, 5.3).
+func (path ASPath) Length() (ret uint16) {
+	for _, s := range path {
+		switch s.Type {
+		case ASSet:
+			ret++
+		case ASSequence:
+			ret += uint16(len(s.ASNs))
+		}
+	}
+
+	return
+}
+
+// NeighborAS returns the leftmost AS of path ignoring leading confederation
+// segments. ok is false if there is no AS_SEQUENCE following them.
+func (path ASPath) NeighborAS() (asn uint32, ok bool) {
+	for _, s := range path {
+		if s.isConfederation() {
+			continue
+		}
+
+		if s.Type != ASSequence || len(s.ASNs) == 0 {
+			return 0, false
+		}
+
+		return s.ASNs[0], true
+	}
+
+	return 0, false
+}
+
+// Occurrences counts how often asn occurs in path
+func (path ASPath) Occurrences(asn uint32) int {
+	n := 0
+	for _, s := range path {
+		for _, x := range s.ASNs {
+			if x == asn {
+				n++
+			}
+		}
+	}
+
+	return n
+}
+
+// has32BitASN checks if path contains an AS number that does not fit into 2
+// octets
+func (path ASPath) has32BitASN() bool {
+	for _, s := range path {
+		for _, asn := range s.ASNs {
+			if asn > math.MaxUint16 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// MergeAS4Path reconstructs the AS path of a route received from a 2-octet AS
+// speaker from its AS_PATH and AS4_PATH (RFC 6793, 4.2.3). as4 is ignored if it
+// is longer than path.
+func MergeAS4Path(path ASPath, as4 ASPath) ASPath {
+	if len(as4) == 0 || as4.Length() > path.Length() {
+		return path
+	}
+
+	n := path.Length() - as4.Length()
+	res := make(ASPath, 0, len(path)+len(as4))
+	for _, s := range path {
+		if n == 0 {
+			break
+		}
+
+		switch s.Type {
+		case ASSequence:
+			asns := s.ASNs
+			if uint16(len(asns)) > n {
+				asns = asns[:n]
+			}
+			res = appendSegments(res, ASSequence, asns)
+			n -= uint16(len(asns))
+		case ASSet:
+			res = append(res, s)
+			n--
+		default:
+			res = append(res, s)
+		}
+	}
+
+	return append(res, as4.copy()...)
+}
+
+func repeatASN(asn uint32, n int) []uint32 {
+	res := make([]uint32, n)
+	for i := range res {
+		res[i] = asn
+	}
+
+	return res
+}
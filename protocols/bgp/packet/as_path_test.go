@@ -0,0 +1,263 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestASPathPrepend(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     ASPath
+		asn      uint32
+		count    uint16
+		expected ASPath
+	}{
+		{
+			name:  "Empty path",
+			path:  ASPath{},
+			asn:   65000,
+			count: 3,
+			expected: ASPath{
+				{Type: ASSequence, Count: 3, ASNs: []uint32{65000, 65000, 65000}},
+			},
+		},
+		{
+			name: "Existing sequence",
+			path: ASPath{
+				{Type: ASSequence, Count: 2, ASNs: []uint32{65001, 65002}},
+				{Type: ASSet, Count: 2, ASNs: []uint32{65003, 65004}},
+			},
+			asn:   65000,
+			count: 2,
+			expected: ASPath{
+				{Type: ASSequence, Count: 4, ASNs: []uint32{65000, 65000, 65001, 65002}},
+				{Type: ASSet, Count: 2, ASNs: []uint32{65003, 65004}},
+			},
+		},
+		{
+			name: "Leading set",
+			path: ASPath{
+				{Type: ASSet, Count: 2, ASNs: []uint32{65003, 65004}},
+			},
+			asn:   65000,
+			count: 1,
+			expected: ASPath{
+				{Type: ASSequence, Count: 1, ASNs: []uint32{65000}},
+				{Type: ASSet, Count: 2, ASNs: []uint32{65003, 65004}},
+			},
+		},
+		{
+			name: "Across the 255 ASN boundary",
+			path: ASPath{
+				{Type: ASSequence, Count: 250, ASNs: repeatASN(65001, 250)},
+			},
+			asn:   65000,
+			count: 10,
+			expected: ASPath{
+				{Type: ASSequence, Count: 5, ASNs: repeatASN(65000, 5)},
+				{Type: ASSequence, Count: 255, ASNs: append(repeatASN(65000, 5), repeatASN(65001, 250)...)},
+			},
+		},
+		{
+			name:  "More than 255 ASNs onto an empty path",
+			path:  ASPath{},
+			asn:   65000,
+			count: 300,
+			expected: ASPath{
+				{Type: ASSequence, Count: 45, ASNs: repeatASN(65000, 45)},
+				{Type: ASSequence, Count: 255, ASNs: repeatASN(65000, 255)},
+			},
+		},
+		{
+			name: "Zero count",
+			path: ASPath{
+				{Type: ASSequence, Count: 1, ASNs: []uint32{65001}},
+			},
+			asn:   65000,
+			count: 0,
+			expected: ASPath{
+				{Type: ASSequence, Count: 1, ASNs: []uint32{65001}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		orig := test.path.Prepend(0, 0)
+		res := test.path.Prepend(test.asn, test.count)
+		assert.Equal(t, test.expected, res, test.name)
+		assert.Equal(t, orig, test.path, "%s: input modified", test.name)
+
+		_, err := serializeASPath(res, 4)
+		assert.Nil(t, err, test.name)
+	}
+}
+
+func TestASPathConfederation(t *testing.T) {
+	path := ASPath{
+		{Type: ASConfedSequence, Count: 2, ASNs: []uint32{65101, 65102}},
+		{Type: ASConfedSet, Count: 2, ASNs: []uint32{65103, 65104}},
+		{Type: ASSequence, Count: 2, ASNs: []uint32{64496, 64497}},
+		{Type: ASSet, Count: 2, ASNs: []uint32{64498, 64499}},
+	}
+
+	assert.Equal(t, "[65101 65102] {65103 65104} 64496 64497 (64498 64499)", path.String())
+	assert.Equal(t, uint16(3), path.Length())
+
+	asn, ok := path.NeighborAS()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(64496), asn)
+
+	assert.Equal(t, ASPath{
+		{Type: ASSequence, Count: 2, ASNs: []uint32{64496, 64497}},
+		{Type: ASSet, Count: 2, ASNs: []uint32{64498, 64499}},
+	}, path.StripConfederation())
+
+	assert.Equal(t, ASPath{
+		{Type: ASConfedSequence, Count: 3, ASNs: []uint32{65100, 65101, 65102}},
+		{Type: ASConfedSet, Count: 2, ASNs: []uint32{65103, 65104}},
+		{Type: ASSequence, Count: 2, ASNs: []uint32{64496, 64497}},
+		{Type: ASSet, Count: 2, ASNs: []uint32{64498, 64499}},
+	}, path.PrependConfederation(65100))
+
+	assert.Equal(t, ASPath{
+		{Type: ASConfedSequence, Count: 1, ASNs: []uint32{65100}},
+		{Type: ASSequence, Count: 1, ASNs: []uint32{64496}},
+	}, ASPath{{Type: ASSequence, Count: 1, ASNs: []uint32{64496}}}.PrependConfederation(65100))
+}
+
+func TestAggregateASPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		paths    []ASPath
+		asSet    bool
+		expected ASPath
+		lost     bool
+	}{
+		{
+			name: "Common neighbor AS with AS_SET",
+			paths: []ASPath{
+				{{Type: ASSequence, Count: 2, ASNs: []uint32{65201, 65300}}},
+				{{Type: ASSequence, Count: 3, ASNs: []uint32{65201, 65400, 65300}}},
+			},
+			asSet: true,
+			expected: ASPath{
+				{Type: ASSequence, Count: 1, ASNs: []uint32{65201}},
+				{Type: ASSet, Count: 2, ASNs: []uint32{65300, 65400}},
+			},
+		},
+		{
+			name: "Common neighbor AS without AS_SET",
+			paths: []ASPath{
+				{{Type: ASSequence, Count: 2, ASNs: []uint32{65201, 65300}}},
+				{{Type: ASSequence, Count: 3, ASNs: []uint32{65201, 65400, 65300}}},
+			},
+			expected: ASPath{
+				{Type: ASSequence, Count: 1, ASNs: []uint32{65201}},
+			},
+			lost: true,
+		},
+		{
+			name: "Equal paths",
+			paths: []ASPath{
+				{{Type: ASSequence, Count: 2, ASNs: []uint32{65201, 65300}}},
+				{{Type: ASSequence, Count: 2, ASNs: []uint32{65201, 65300}}},
+			},
+			expected: ASPath{
+				{Type: ASSequence, Count: 2, ASNs: []uint32{65201, 65300}},
+			},
+		},
+		{
+			name: "Nothing in common",
+			paths: []ASPath{
+				{{Type: ASSequence, Count: 1, ASNs: []uint32{65202}}},
+				{
+					{Type: ASSequence, Count: 1, ASNs: []uint32{65201}},
+					{Type: ASSet, Count: 2, ASNs: []uint32{65300, 65202}},
+				},
+			},
+			asSet: true,
+			expected: ASPath{
+				{Type: ASSet, Count: 3, ASNs: []uint32{65201, 65202, 65300}},
+			},
+		},
+		{
+			name: "Locally originated contributor",
+			paths: []ASPath{
+				{},
+				{{Type: ASSequence, Count: 1, ASNs: []uint32{65201}}},
+			},
+			expected: ASPath{},
+			lost:     true,
+		},
+		{
+			name: "Confederation segments",
+			paths: []ASPath{
+				{
+					{Type: ASConfedSequence, Count: 1, ASNs: []uint32{65101}},
+					{Type: ASSequence, Count: 1, ASNs: []uint32{65201}},
+				},
+				{{Type: ASSequence, Count: 1, ASNs: []uint32{65201}}},
+			},
+			asSet: true,
+			expected: ASPath{
+				{Type: ASSequence, Count: 1, ASNs: []uint32{65201}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		res, lost := AggregateASPaths(test.paths, test.asSet)
+		assert.Equal(t, test.expected, res, test.name)
+		assert.Equal(t, test.lost, lost, test.name)
+	}
+}
+
+func TestMergeAS4Path(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     ASPath
+		as4      ASPath
+		expected ASPath
+	}{
+		{
+			name: "No AS4_PATH",
+			path: ASPath{
+				{Type: ASSequence, Count: 2, ASNs: []uint32{65201, 65300}},
+			},
+			expected: ASPath{
+				{Type: ASSequence, Count: 2, ASNs: []uint32{65201, 65300}},
+			},
+		},
+		{
+			name: "2-octet AS speakers in front",
+			path: ASPath{
+				{Type: ASSequence, Count: 3, ASNs: []uint32{65201, ASTrans, 65300}},
+			},
+			as4: ASPath{
+				{Type: ASSequence, Count: 2, ASNs: []uint32{4200000000, 65300}},
+			},
+			expected: ASPath{
+				{Type: ASSequence, Count: 1, ASNs: []uint32{65201}},
+				{Type: ASSequence, Count: 2, ASNs: []uint32{4200000000, 65300}},
+			},
+		},
+		{
+			name: "AS4_PATH longer than AS_PATH",
+			path: ASPath{
+				{Type: ASSequence, Count: 1, ASNs: []uint32{ASTrans}},
+			},
+			as4: ASPath{
+				{Type: ASSequence, Count: 2, ASNs: []uint32{4200000000, 65300}},
+			},
+			expected: ASPath{
+				{Type: ASSequence, Count: 1, ASNs: []uint32{ASTrans}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, MergeAS4Path(test.path, test.as4), test.name)
+	}
+}
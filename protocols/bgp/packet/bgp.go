@@ -1,5 +1,7 @@
 package packet
 
+import "net"
+
 const (
 	OctetLen    = 8
 	BGP4Version = 4
@@ -13,6 +15,8 @@ const (
 	UpdateMsg       = 2
 	NotificationMsg = 3
 	KeepaliveMsg    = 4
+	RouteRefreshMsg = 5 // RFC 2918
+	CapabilityMsg   = 6 // draft-ietf-idr-dynamic-cap
 
 	MessageHeaderError      = 1
 	OpenMessageError        = 2
@@ -48,13 +52,29 @@ const (
 	MalformedASPath           = 11
 
 	// Attribute Type Codes
-	OriginAttr     = 1
-	ASPathAttr     = 2
-	NextHopAttr    = 3
-	MEDAttr        = 4
-	LocalPrefAttr  = 5
-	AtomicAggrAttr = 6
-	AggregatorAttr = 7
+	OriginAttr                   = 1
+	ASPathAttr                   = 2
+	NextHopAttr                  = 3
+	MEDAttr                      = 4
+	LocalPrefAttr                = 5
+	AtomicAggrAttr               = 6
+	AggregatorAttr               = 7
+	CommunitiesAttr              = 8
+	OriginatorIDAttr             = 9
+	ClusterListAttr              = 10
+	MultiProtocolReachNLRIAttr   = 14
+	MultiProtocolUnreachNLRIAttr = 15
+	ExtendedCommunitiesAttr      = 16
+	AS4PathAttr                  = 17
+	AS4AggregatorAttr            = 18
+	BGPsecPathAttr               = 33
+
+	// Address Family Identifiers
+	IPv4AFI = 1
+	IPv6AFI = 2
+
+	// Subsequent Address Family Identifiers
+	UnicastSAFI = 1
 
 	// ORIGIN values
 	IGP        = 0
@@ -62,8 +82,22 @@ const (
 	INCOMPLETE = 2
 
 	// ASPath Segment Types
-	ASSet      = 1
-	ASSequence = 2
+	ASSet            = 1
+	ASSequence       = 2
+	ASConfedSequence = 3
+	ASConfedSet      = 4
+
+	// MaxASPathSegments is the maximum number of segments accepted in an AS_PATH
+	MaxASPathSegments = 128
+	// MaxASPathASNs is the maximum number of ASNs accepted in an AS_PATH
+	MaxASPathASNs = 1024
+
+	// DefaultMaxCommunities is the default maximum number of COMMUNITIES
+	// accepted per route
+	DefaultMaxCommunities = 1024
+	// DefaultMaxExtendedCommunities is the default maximum number of
+	// EXTENDED_COMMUNITIES accepted per route
+	DefaultMaxExtendedCommunities = 512
 
 	// NOTIFICATION Cease error SubCodes (RFC4486)
 	MaxPrefReached                = 1
@@ -71,20 +105,28 @@ const (
 	PeerDeconfigured              = 3
 	AdminReset                    = 4
 	ConnectionRejected            = 5
-	OtherConfigChange             = 8
+	OtherConfigChange             = 6
 	ConnectionCollisionResolution = 7
 	OutOfResoutces                = 8
-)
+	HardReset                     = 9 // RFC 8538
 
-type BGPError struct {
-	ErrorCode    uint8
-	ErrorSubCode uint8
-	ErrorStr     string
-}
+	// Optional Parameter Types
+	CapabilitiesParamType = 2
 
-func (b BGPError) Error() string {
-	return b.ErrorStr
-}
+	// ExtendedOptParamMarker marks the extended optional parameters encoding (RFC 9072)
+	ExtendedOptParamMarker = 255
+
+	// Capability Codes
+	MultiProtocolCapabilityCode   = 1
+	RouteRefreshCapabilityCode    = 2
+	GracefulRestartCapabilityCode = 64
+	ASN4CapabilityCode            = 65
+	DynamicCapabilityCode         = 67
+	AddPathCapabilityCode         = 69
+
+	// ASTrans is sent in the AS field of an OPEN by speakers with a 4-octet AS (RFC 6793)
+	ASTrans = 23456
+)
 
 type BGPMessage struct {
 	Header *BGPHeader
@@ -101,12 +143,104 @@ type BGPOpen struct {
 	AS            uint16
 	HoldTime      uint16
 	BGPIdentifier uint32
-	OptParmLen    uint8
+	OptParmLen    uint16
+	OptParams     []OptParam
+}
+
+// OptParam is an optional parameter of an OPEN message
+type OptParam struct {
+	Type   uint8
+	Length uint16
+	Value  interface{}
+}
+
+// Capabilities is a list of capabilities
+type Capabilities []Capability
+
+// Capability is a capability advertised in an OPEN message. Value holds the
+// decoded capability or the raw bytes in case the capability is unknown.
+type Capability struct {
+	Code   uint8
+	Length uint8
+	Value  interface{}
+}
+
+// MultiProtocolCapability is the multiprotocol extensions capability (RFC4760)
+type MultiProtocolCapability struct {
+	AFI  uint16
+	SAFI uint8
+}
+
+// ASN4Capability is the 4 octet AS number capability (RFC6793)
+type ASN4Capability struct {
+	ASN4 uint32
+}
+
+// GracefulRestartCapability is the graceful restart capability (RFC4724)
+type GracefulRestartCapability struct {
+	// RestartState is set by a speaker that has restarted
+	RestartState bool
+
+	// RestartTime is the time in seconds it takes the sender to re-establish
+	// the session after a restart. It is limited to 12 bits.
+	RestartTime uint16
+
+	AddressFamilies []GracefulRestartAddressFamily
+}
+
+// GracefulRestartAddressFamily is an address family supported by graceful restart
+type GracefulRestartAddressFamily struct {
+	AFI  uint16
+	SAFI uint8
+
+	// ForwardingStatePreserved is set if the sender kept its forwarding state
+	// for the address family across the restart
+	ForwardingStatePreserved bool
+}
+
+// AddPathCapability is the ADD-PATH capability (RFC 7911)
+type AddPathCapability struct {
+	AddressFamilies []AddPathAddressFamily
+}
+
+// Send/Receive field values of the ADD-PATH capability
+const (
+	AddPathReceive     = 1
+	AddPathSend        = 2
+	AddPathSendReceive = AddPathReceive | AddPathSend
+)
+
+// AddPathAddressFamily is an address family the sender is able to send and/or
+// receive multiple paths for
+type AddPathAddressFamily struct {
+	AFI         uint16
+	SAFI        uint8
+	SendReceive uint8
+}
+
+// DynamicCapability is the dynamic capability capability (draft-ietf-idr-dynamic-cap)
+// listing the capabilities that can be changed during a session
+type DynamicCapability struct {
+	CapabilityCodes []uint8
+}
+
+// BGPCapabilityMsg is a CAPABILITY message (draft-ietf-idr-dynamic-cap). It is not
+// supported but recognized so it can be ignored.
+type BGPCapabilityMsg struct {
+	Data []byte
+}
+
+// BGPRouteRefresh is a ROUTE-REFRESH message (RFC 2918) requesting the peer to
+// advertise its Adj-RIB-Out of an address family again
+type BGPRouteRefresh struct {
+	AFI  uint16
+	SAFI uint8
 }
 
 type BGPNotification struct {
 	ErrorCode    uint8
 	ErrorSubcode uint8
+	Data         []byte
 }
 
 type BGPUpdate struct {
@@ -115,6 +249,11 @@ type BGPUpdate struct {
 	TotalPathAttrLen   uint16
 	PathAttributes     *PathAttribute
 	NLRI               *NLRI
+
+	// TreatAsWithdraw is set if a path attribute was discarded as malformed
+	// in a way that only affects the routes of the UPDATE. The routes of NLRI
+	// and MP_REACH_NLRI have to be withdrawn instead (RFC 7606, 2).
+	TreatAsWithdraw bool
 }
 
 type PathAttribute struct {
@@ -126,12 +265,29 @@ type PathAttribute struct {
 	TypeCode       uint8
 	Value          interface{}
 	Next           *PathAttribute
+
+	// RawASPath is the value of an AS_PATH attribute as received. It is
+	// serialized instead of Value if the ASNs are encoded with the same
+	// length, so the AS_PATH is passed on byte for byte. Nil for other
+	// attributes.
+	RawASPath *RawASPath
+}
+
+// RawASPath is the value of an AS_PATH attribute as received with ASNs of
+// ASNLength octets
+type RawASPath struct {
+	Value     []byte
+	ASNLength uint8
 }
 
+// UnknownAttribute is the raw value of an unrecognized optional transitive attribute
+type UnknownAttribute []byte
+
 type NLRI struct {
-	IP     interface{}
-	Pfxlen uint8
-	Next   *NLRI
+	PathIdentifier uint32
+	IP             interface{}
+	Pfxlen         uint8
+	Next           *NLRI
 }
 
 type ASPath []ASPathSegment
@@ -141,7 +297,28 @@ type ASPathSegment struct {
 	ASNs  []uint32
 }
 
+// MultiProtocolReachNLRI is the value of a MP_REACH_NLRI attribute (RFC 4760)
+type MultiProtocolReachNLRI struct {
+	AFI     uint16
+	SAFI    uint8
+	NextHop net.IP
+
+	// LinkLocalNextHop is the link-local next hop of an IPv6 route (RFC 2545).
+	// It is nil if only a global next hop is given.
+	LinkLocalNextHop net.IP
+	NLRI             *NLRI
+}
+
+// MultiProtocolUnreachNLRI is the value of a MP_UNREACH_NLRI attribute (RFC 4760)
+type MultiProtocolUnreachNLRI struct {
+	AFI  uint16
+	SAFI uint8
+	NLRI *NLRI
+}
+
+// Aggretator is the value of an AGGREGATOR attribute naming the speaker that
+// formed an aggregate route
 type Aggretator struct {
 	Addr [4]byte
-	ASN  uint16
+	ASN  uint32
 }
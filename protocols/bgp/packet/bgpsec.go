@@ -0,0 +1,157 @@
+package packet
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	securePathSegmentLen = 6
+	skiLen               = 20
+)
+
+// BGPsec validation states
+const (
+	BGPsecNotValidated BGPsecValidationState = iota
+	BGPsecValid
+	BGPsecInvalid
+)
+
+// BGPsecValidationState is the result of validating a BGPsec_Path
+type BGPsecValidationState uint8
+
+// BGPSecValidator validates the signatures of a BGPsec_Path (RFC 8205)
+type BGPSecValidator interface {
+	Validate(p *BGPsecPath) BGPsecValidationState
+}
+
+var bgpsecValidator BGPSecValidator
+
+// SetBGPSecValidator sets the validator applied to every decoded BGPsec_Path.
+// Without a validator paths are left in state BGPsecNotValidated.
+func SetBGPSecValidator(v BGPSecValidator) {
+	bgpsecValidator = v
+}
+
+// BGPsecPath is a decoded BGPsec_Path attribute. It must not be modified.
+type BGPsecPath struct {
+	SecurePath      []SecurePathSegment
+	SignatureBlocks []SignatureBlock
+	ValidationState BGPsecValidationState
+}
+
+// SecurePathSegment is one AS hop of a BGPsec Secure_Path
+type SecurePathSegment struct {
+	PCount uint8
+	Flags  uint8
+	ASN    uint32
+}
+
+// SignatureBlock holds the signatures of one algorithm suite
+type SignatureBlock struct {
+	AlgorithmSuite uint8
+	Segments       []SignatureSegment
+}
+
+// SignatureSegment is the signature of one AS hop
+type SignatureSegment struct {
+	SKI       [skiLen]byte
+	Signature []byte
+}
+
+func (pa *PathAttribute) decodeBGPsecPath(buf *bytes.Buffer) error {
+	data := buf.Next(int(pa.Length))
+	if len(data) != int(pa.Length) {
+		return fmt.Errorf("Unable to read %d bytes of BGPsec_Path: got %d", pa.Length, len(data))
+	}
+
+	path, err := decodeBGPsecPath(data)
+	if err != nil {
+		return err
+	}
+
+	if bgpsecValidator != nil {
+		path.ValidationState = bgpsecValidator.Validate(path)
+	}
+
+	pa.Value = path
+	return nil
+}
+
+func decodeBGPsecPath(data []byte) (*BGPsecPath, error) {
+	buf := bytes.NewBuffer(data)
+	path := &BGPsecPath{}
+
+	l := uint16(0)
+	err := decode(buf, []interface{}{&l})
+	if err != nil {
+		return nil, err
+	}
+
+	if l < 2 || (l-2)%securePathSegmentLen != 0 || int(l) > len(data) {
+		return nil, fmt.Errorf("Invalid Secure_Path length: %d", l)
+	}
+
+	for i := 0; i < int(l-2)/securePathSegmentLen; i++ {
+		s := SecurePathSegment{}
+		err := decode(buf, []interface{}{&s.PCount, &s.Flags, &s.ASN})
+		if err != nil {
+			return nil, err
+		}
+
+		path.SecurePath = append(path.SecurePath, s)
+	}
+
+	for buf.Len() > 0 {
+		b, err := decodeSignatureBlock(buf)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode Signature_Block: %w", err)
+		}
+
+		path.SignatureBlocks = append(path.SignatureBlocks, b)
+	}
+
+	if len(path.SignatureBlocks) == 0 || len(path.SignatureBlocks) > 2 {
+		return nil, fmt.Errorf("Invalid number of Signature_Blocks: %d", len(path.SignatureBlocks))
+	}
+
+	return path, nil
+}
+
+func decodeSignatureBlock(buf *bytes.Buffer) (SignatureBlock, error) {
+	b := SignatureBlock{}
+
+	l := uint16(0)
+	err := decode(buf, []interface{}{&l})
+	if err != nil {
+		return b, err
+	}
+
+	if l < 3 || int(l-2) > buf.Len() {
+		return b, fmt.Errorf("Invalid length: %d", l)
+	}
+
+	blockBuf := bytes.NewBuffer(buf.Next(int(l - 2)))
+	err = decode(blockBuf, []interface{}{&b.AlgorithmSuite})
+	if err != nil {
+		return b, err
+	}
+
+	for blockBuf.Len() > 0 {
+		s := SignatureSegment{}
+		sigLen := uint16(0)
+		err := decode(blockBuf, []interface{}{&s.SKI, &sigLen})
+		if err != nil {
+			return b, err
+		}
+
+		if int(sigLen) > blockBuf.Len() {
+			return b, fmt.Errorf("Invalid signature length: %d", sigLen)
+		}
+
+		s.Signature = blockBuf.Next(int(sigLen))
+		b.Segments = append(b.Segments, s)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,156 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticValidator struct {
+	state BGPsecValidationState
+}
+
+func (v staticValidator) Validate(p *BGPsecPath) BGPsecValidationState {
+	return v.state
+}
+
+func bgpsecPathInput() []byte {
+	input := []byte{
+		0, 14, // Secure_Path Length
+		1, 0, 0, 0, 253, 232, // pCount 1, Flags 0, AS65000
+		1, 128, 0, 0, 253, 233, // pCount 1, Confed_Segment, AS65001
+		0, 52, // Signature_Block Length
+		1, // Algorithm Suite Identifier
+	}
+	input = append(input, bytes.Repeat([]byte{1}, 20)...) // SKI
+	input = append(input, 0, 3, 170, 187, 204)            // Signature
+	input = append(input, bytes.Repeat([]byte{2}, 20)...) // SKI
+	input = append(input, 0, 2, 221, 238)                 // Signature
+
+	return input
+}
+
+func TestDecodeBGPsecPath(t *testing.T) {
+	ski1 := [20]byte{}
+	copy(ski1[:], bytes.Repeat([]byte{1}, 20))
+	ski2 := [20]byte{}
+	copy(ski2[:], bytes.Repeat([]byte{2}, 20))
+
+	tests := []struct {
+		name      string
+		input     []byte
+		validator BGPSecValidator
+		wantFail  bool
+		expected  *BGPsecPath
+	}{
+		{
+			name:  "Valid BGPsec_Path",
+			input: bgpsecPathInput(),
+			expected: &BGPsecPath{
+				SecurePath: []SecurePathSegment{
+					{
+						PCount: 1,
+						ASN:    65000,
+					},
+					{
+						PCount: 1,
+						Flags:  128,
+						ASN:    65001,
+					},
+				},
+				SignatureBlocks: []SignatureBlock{
+					{
+						AlgorithmSuite: 1,
+						Segments: []SignatureSegment{
+							{
+								SKI:       ski1,
+								Signature: []byte{170, 187, 204},
+							},
+							{
+								SKI:       ski2,
+								Signature: []byte{221, 238},
+							},
+						},
+					},
+				},
+				ValidationState: BGPsecNotValidated,
+			},
+		},
+		{
+			name:      "Valid BGPsec_Path with validator",
+			input:     bgpsecPathInput(),
+			validator: staticValidator{state: BGPsecInvalid},
+			expected: &BGPsecPath{
+				SecurePath: []SecurePathSegment{
+					{
+						PCount: 1,
+						ASN:    65000,
+					},
+					{
+						PCount: 1,
+						Flags:  128,
+						ASN:    65001,
+					},
+				},
+				SignatureBlocks: []SignatureBlock{
+					{
+						AlgorithmSuite: 1,
+						Segments: []SignatureSegment{
+							{
+								SKI:       ski1,
+								Signature: []byte{170, 187, 204},
+							},
+							{
+								SKI:       ski2,
+								Signature: []byte{221, 238},
+							},
+						},
+					},
+				},
+				ValidationState: BGPsecInvalid,
+			},
+		},
+		{
+			name:     "Secure_Path length not a multiple of the segment size",
+			input:    []byte{0, 9, 1, 0, 0, 0, 253, 232, 1},
+			wantFail: true,
+		},
+		{
+			name:     "Missing Signature_Block",
+			input:    []byte{0, 8, 1, 0, 0, 0, 253, 232},
+			wantFail: true,
+		},
+		{
+			name:     "Truncated signature",
+			input:    bgpsecPathInput()[:len(bgpsecPathInput())-1],
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		SetBGPSecValidator(test.validator)
+
+		pa := &PathAttribute{
+			Length: uint16(len(test.input)),
+		}
+		err := pa.decodeBGPsecPath(bytes.NewBuffer(test.input))
+
+		if test.wantFail {
+			if err != nil {
+				continue
+			}
+			t.Errorf("Expected error did not happen for test %q", test.name)
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+			continue
+		}
+
+		assert.Equal(t, test.expected, pa.Value, test.name)
+	}
+
+	SetBGPSecValidator(nil)
+}
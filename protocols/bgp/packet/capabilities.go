@@ -0,0 +1,129 @@
+package packet
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	gracefulRestartStateFlag      = 0x8000
+	gracefulRestartTimeMask       = 0x0fff
+	gracefulRestartForwardingFlag = 0x80
+)
+
+// Capabilities returns all capabilities advertised in the optional parameters of o
+func (o *BGPOpen) Capabilities() Capabilities {
+	var caps Capabilities
+	for _, p := range o.OptParams {
+		if p.Type != CapabilitiesParamType {
+			continue
+		}
+
+		caps = append(caps, p.Value.(Capabilities)...)
+	}
+
+	return caps
+}
+
+// AddCapability adds c to the first capabilities optional parameter of o.
+// The parameter is created if o has none.
+func (o *BGPOpen) AddCapability(c Capability) {
+	for i, p := range o.OptParams {
+		if p.Type != CapabilitiesParamType {
+			continue
+		}
+
+		o.OptParams[i].Value = append(p.Value.(Capabilities), c)
+		return
+	}
+
+	o.OptParams = append(o.OptParams, OptParam{
+		Type:  CapabilitiesParamType,
+		Value: Capabilities{c},
+	})
+}
+
+// ASN returns the AS of the sender of o. The 4-octet AS capability takes
+// precedence over the 2-octet AS field.
+func (o *BGPOpen) ASN() uint32 {
+	for _, c := range o.Capabilities() {
+		if c.Code == ASN4CapabilityCode {
+			return c.Value.(ASN4Capability).ASN4
+		}
+	}
+
+	return uint32(o.AS)
+}
+
+// String returns a human readable representation of c
+func (c Capability) String() string {
+	switch c.Code {
+	case MultiProtocolCapabilityCode:
+		mpCap := c.Value.(MultiProtocolCapability)
+		return fmt.Sprintf("multiprotocol (AFI %d, SAFI %d)", mpCap.AFI, mpCap.SAFI)
+	case RouteRefreshCapabilityCode:
+		return "route-refresh"
+	case GracefulRestartCapabilityCode:
+		grCap := c.Value.(GracefulRestartCapability)
+		return fmt.Sprintf("graceful-restart (restart time %d, restarting %v)", grCap.RestartTime, grCap.RestartState)
+	case ASN4CapabilityCode:
+		return fmt.Sprintf("4-octet-asn (%d)", c.Value.(ASN4Capability).ASN4)
+	case AddPathCapabilityCode:
+		return fmt.Sprintf("add-path %v", c.Value.(AddPathCapability).AddressFamilies)
+	case DynamicCapabilityCode:
+		return fmt.Sprintf("dynamic (codes %v)", c.Value.(DynamicCapability).CapabilityCodes)
+	}
+
+	return fmt.Sprintf("unknown (code %d, value %x)", c.Code, c.Value)
+}
+
+// Get returns the first capability with the given code. ok is false if c has none.
+func (c Capabilities) Get(code uint8) (x Capability, ok bool) {
+	for _, x := range c {
+		if x.Code == code {
+			return x, true
+		}
+	}
+
+	return Capability{}, false
+}
+
+// Has checks if c contains a capability with the given code
+func (c Capabilities) Has(code uint8) bool {
+	for _, x := range c {
+		if x.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddPath checks if c contains an ADD-PATH capability with any of the
+// directions in sendReceive set for the address family afi/safi
+func (c Capabilities) AddPath(afi uint16, safi uint8, sendReceive uint8) bool {
+	for _, x := range c {
+		apCap, ok := x.Value.(AddPathCapability)
+		if x.Code != AddPathCapabilityCode || !ok {
+			continue
+		}
+
+		for _, af := range apCap.AddressFamilies {
+			if af.AFI == afi && af.SAFI == safi && af.SendReceive&sendReceive != 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// String returns a human readable representation of all capabilities in c
+func (c Capabilities) String() string {
+	ret := make([]string, 0, len(c))
+	for _, x := range c {
+		ret = append(ret, x.String())
+	}
+
+	return strings.Join(ret, ", ")
+}
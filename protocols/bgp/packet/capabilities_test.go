@@ -0,0 +1,244 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenCapabilities(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    *BGPOpen
+		expected Capabilities
+	}{
+		{
+			name:     "No optional parameters",
+			input:    &BGPOpen{},
+			expected: nil,
+		},
+		{
+			name: "Capabilities spread over multiple parameters",
+			input: &BGPOpen{
+				OptParams: []OptParam{
+					{
+						Type: CapabilitiesParamType,
+						Value: Capabilities{
+							{
+								Code:  MultiProtocolCapabilityCode,
+								Value: MultiProtocolCapability{AFI: 1, SAFI: 1},
+							},
+						},
+					},
+					{
+						Type: CapabilitiesParamType,
+						Value: Capabilities{
+							{
+								Code: RouteRefreshCapabilityCode,
+							},
+							{
+								Code:  ASN4CapabilityCode,
+								Value: ASN4Capability{ASN4: 65000},
+							},
+						},
+					},
+				},
+			},
+			expected: Capabilities{
+				{
+					Code:  MultiProtocolCapabilityCode,
+					Value: MultiProtocolCapability{AFI: 1, SAFI: 1},
+				},
+				{
+					Code: RouteRefreshCapabilityCode,
+				},
+				{
+					Code:  ASN4CapabilityCode,
+					Value: ASN4Capability{ASN4: 65000},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		res := test.input.Capabilities()
+		assert.Equal(t, test.expected, res, test.name)
+	}
+}
+
+func TestCapabilitiesString(t *testing.T) {
+	caps := Capabilities{
+		{
+			Code:  MultiProtocolCapabilityCode,
+			Value: MultiProtocolCapability{AFI: 1, SAFI: 1},
+		},
+		{
+			Code: RouteRefreshCapabilityCode,
+		},
+		{
+			Code:  ASN4CapabilityCode,
+			Value: ASN4Capability{ASN4: 65000},
+		},
+		{
+			Code:  70,
+			Value: []byte{1, 2},
+		},
+	}
+
+	expected := "multiprotocol (AFI 1, SAFI 1), route-refresh, 4-octet-asn (65000), unknown (code 70, value 0102)"
+	assert.Equal(t, expected, caps.String())
+}
+
+func TestOpenASN(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    *BGPOpen
+		expected uint32
+	}{
+		{
+			name: "2-octet AS",
+			input: &BGPOpen{
+				AS: 65000,
+			},
+			expected: 65000,
+		},
+		{
+			name: "4-octet AS capability",
+			input: &BGPOpen{
+				AS: 23456,
+				OptParams: []OptParam{
+					{
+						Type: CapabilitiesParamType,
+						Value: Capabilities{
+							{
+								Code:  ASN4CapabilityCode,
+								Value: ASN4Capability{ASN4: 4200000000},
+							},
+						},
+					},
+				},
+			},
+			expected: 4200000000,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.input.ASN(), test.name)
+	}
+}
+
+func TestGracefulRestartCapability(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    GracefulRestartCapability
+		wire     []byte
+		wantFail bool
+	}{
+		{
+			name: "Restarting with preserved IPv4 unicast forwarding state",
+			input: GracefulRestartCapability{
+				RestartState: true,
+				RestartTime:  120,
+				AddressFamilies: []GracefulRestartAddressFamily{
+					{AFI: IPv4AFI, SAFI: UnicastSAFI, ForwardingStatePreserved: true},
+					{AFI: IPv6AFI, SAFI: UnicastSAFI},
+				},
+			},
+			wire: []byte{
+				GracefulRestartCapabilityCode, 10,
+				0x80, 120, // Restart flags and time
+				0, 1, 1, 0x80, // IPv4 unicast, forwarding state preserved
+				0, 2, 1, 0, // IPv6 unicast
+			},
+		},
+		{
+			name: "Maximum restart time without address families",
+			input: GracefulRestartCapability{
+				RestartTime: 4095,
+			},
+			wire: []byte{
+				GracefulRestartCapabilityCode, 2,
+				0x0f, 0xff,
+			},
+		},
+		{
+			name: "Restart time too long",
+			input: GracefulRestartCapability{
+				RestartTime: 4096,
+			},
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		wire, err := serializeCapabilities(Capabilities{
+			{
+				Code:  GracefulRestartCapabilityCode,
+				Value: test.input,
+			},
+		})
+		if test.wantFail {
+			assert.Error(t, err, test.name)
+			continue
+		}
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+		assert.Equal(t, test.wire, wire, test.name)
+
+		c, n, err := decodeCapability(bytes.NewBuffer(wire))
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+		assert.Equal(t, uint16(len(wire)), n, test.name)
+		assert.Equal(t, test.input, c.Value, test.name)
+	}
+}
+
+func TestDecodeGracefulRestartCapabilityInvalidLength(t *testing.T) {
+	_, _, err := decodeCapability(bytes.NewBuffer([]byte{
+		GracefulRestartCapabilityCode, 5,
+		0x80, 120,
+		0, 1, 1,
+	}))
+	assert.Error(t, err)
+}
+
+func TestAddPathCapability(t *testing.T) {
+	apCap := AddPathCapability{
+		AddressFamilies: []AddPathAddressFamily{
+			{AFI: IPv4AFI, SAFI: UnicastSAFI, SendReceive: AddPathSend},
+			{AFI: IPv6AFI, SAFI: UnicastSAFI, SendReceive: AddPathSendReceive},
+		},
+	}
+	wire := []byte{
+		AddPathCapabilityCode, 8,
+		0, 1, 1, 2, // IPv4 unicast, send
+		0, 2, 1, 3, // IPv6 unicast, send and receive
+	}
+
+	res, err := serializeCapabilities(Capabilities{
+		{
+			Code:  AddPathCapabilityCode,
+			Value: apCap,
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, wire, res)
+
+	c, n, err := decodeCapability(bytes.NewBuffer(wire))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, uint16(len(wire)), n)
+	assert.Equal(t, apCap, c.Value)
+
+	caps := Capabilities{c}
+	assert.True(t, caps.AddPath(IPv4AFI, UnicastSAFI, AddPathSend))
+	assert.False(t, caps.AddPath(IPv4AFI, UnicastSAFI, AddPathReceive))
+	assert.True(t, caps.AddPath(IPv6AFI, UnicastSAFI, AddPathReceive))
+
+	_, _, err = decodeCapability(bytes.NewBuffer([]byte{AddPathCapabilityCode, 3, 0, 1, 1}))
+	assert.Error(t, err)
+}
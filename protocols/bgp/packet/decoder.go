@@ -9,16 +9,85 @@ import (
 	"github.com/taktv6/tflow2/convert"
 )
 
+// DecodeOptions holds the session parameters negotiated with a peer which
+// affect how its messages are decoded
+type DecodeOptions struct {
+	// Use32BitASN is set if both speakers announced the 4-octet AS capability
+	Use32BitASN bool
+
+	// LocalAddress is the address of the receiving speaker. A NEXT_HOP pointing
+	// to it is rejected.
+	LocalAddress net.IP
+
+	// AddPath holds the address families for which the ADD-PATH capability
+	// (RFC 7911) was negotiated in receive direction. NLRIs of these families
+	// are preceded by a path identifier.
+	AddPath map[AddressFamily]bool
+
+	// MaxCommunities and MaxExtendedCommunities cap the number of communities
+	// accepted per route. UPDATEs exceeding them are treated as withdraw.
+	// Zero selects DefaultMaxCommunities and DefaultMaxExtendedCommunities.
+	MaxCommunities         int
+	MaxExtendedCommunities int
+
+	// KeepRawASPath retains the AS_PATH as received in PathAttribute.RawASPath,
+	// so it can be passed on unchanged
+	KeepRawASPath bool
+}
+
+// AddressFamily identifies an address family by AFI and SAFI
+type AddressFamily struct {
+	AFI  uint16
+	SAFI uint8
+}
+
+// asnLength returns the length of AS numbers in AS_PATH and AGGREGATOR
+func (opt *DecodeOptions) asnLength() uint8 {
+	if opt != nil && opt.Use32BitASN {
+		return 4
+	}
+
+	return 2
+}
+
+// maxCommunities returns the maximum number of COMMUNITIES per route
+func (opt *DecodeOptions) maxCommunities() int {
+	if opt == nil || opt.MaxCommunities == 0 {
+		return DefaultMaxCommunities
+	}
+
+	return opt.MaxCommunities
+}
+
+// maxExtendedCommunities returns the maximum number of EXTENDED_COMMUNITIES per
+// route
+func (opt *DecodeOptions) maxExtendedCommunities() int {
+	if opt == nil || opt.MaxExtendedCommunities == 0 {
+		return DefaultMaxExtendedCommunities
+	}
+
+	return opt.MaxExtendedCommunities
+}
+
+// addPath checks if NLRIs of the given address family carry path identifiers
+func (opt *DecodeOptions) addPath(afi uint16, safi uint8) bool {
+	if opt == nil {
+		return false
+	}
+
+	return opt.AddPath[AddressFamily{AFI: afi, SAFI: safi}]
+}
+
 // Decode decodes a BGP message
-func Decode(buf *bytes.Buffer) (*BGPMessage, error) {
+func Decode(buf *bytes.Buffer, opt *DecodeOptions) (*BGPMessage, error) {
 	hdr, err := decodeHeader(buf)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to decode header: %v", err)
+		return nil, fmt.Errorf("Failed to decode header: %w", err)
 	}
 
-	body, err := decodeMsgBody(buf, hdr.Type, hdr.Length-MinLen)
+	body, err := decodeMsgBody(buf, hdr.Type, hdr.Length-MinLen, opt)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to decode message: %v", err)
+		return nil, fmt.Errorf("Failed to decode message: %w", err)
 	}
 
 	return &BGPMessage{
@@ -27,29 +96,42 @@ func Decode(buf *bytes.Buffer) (*BGPMessage, error) {
 	}, nil
 }
 
-func decodeMsgBody(buf *bytes.Buffer, msgType uint8, l uint16) (interface{}, error) {
+func decodeMsgBody(buf *bytes.Buffer, msgType uint8, l uint16, opt *DecodeOptions) (interface{}, error) {
 	switch msgType {
 	case OpenMsg:
 		return decodeOpenMsg(buf)
 	case UpdateMsg:
-		return decodeUpdateMsg(buf, l)
+		return decodeUpdateMsg(buf, l, opt)
 	case KeepaliveMsg:
 		return nil, nil // Nothing to decode in Keepalive message
 	case NotificationMsg:
-		return decodeNotificationMsg(buf)
+		return decodeNotificationMsg(buf, l)
+	case RouteRefreshMsg:
+		return decodeRouteRefreshMsg(buf, l)
+	case CapabilityMsg:
+		return decodeCapabilityMsg(buf, l)
+	}
+	return nil, BGPError{
+		ErrorCode:    MessageHeaderError,
+		ErrorSubCode: BadMessageType,
+		ErrorStr:     fmt.Sprintf("Unknown message type: %d", msgType),
 	}
-	return nil, fmt.Errorf("Unknown message type: %d", msgType)
 }
 
-func decodeUpdateMsg(buf *bytes.Buffer, l uint16) (*BGPUpdate, error) {
+func decodeUpdateMsg(buf *bytes.Buffer, l uint16, opt *DecodeOptions) (*BGPUpdate, error) {
 	msg := &BGPUpdate{}
+	addPath := opt.addPath(IPv4AFI, UnicastSAFI)
 
 	err := decode(buf, []interface{}{&msg.WithdrawnRoutesLen})
 	if err != nil {
 		return msg, err
 	}
 
-	msg.WithdrawnRoutes, err = decodeNLRIs(buf, uint16(msg.WithdrawnRoutesLen))
+	if uint32(msg.WithdrawnRoutesLen)+4 > uint32(l) {
+		return msg, malformedAttrListErr(fmt.Sprintf("Withdrawn routes length %d exceeds message length %d", msg.WithdrawnRoutesLen, l))
+	}
+
+	msg.WithdrawnRoutes, err = decodeNLRIs(buf, uint16(msg.WithdrawnRoutesLen), IPv4AFI, addPath)
 	if err != nil {
 		return msg, err
 	}
@@ -59,14 +141,29 @@ func decodeUpdateMsg(buf *bytes.Buffer, l uint16) (*BGPUpdate, error) {
 		return msg, err
 	}
 
-	msg.PathAttributes, err = decodePathAttrs(buf, msg.TotalPathAttrLen)
+	if uint32(msg.WithdrawnRoutesLen)+uint32(msg.TotalPathAttrLen)+4 > uint32(l) {
+		return msg, malformedAttrListErr(fmt.Sprintf("Total path attribute length %d exceeds message length %d", msg.TotalPathAttrLen, l))
+	}
+
+	msg.PathAttributes, err = decodePathAttrs(buf, msg.TotalPathAttrLen, opt)
 	if err != nil {
-		return msg, err
+		if !isTreatAsWithdraw(err) {
+			return msg, err
+		}
+		msg.TreatAsWithdraw = true
 	}
 
 	nlriLen := uint16(l) - 4 - uint16(msg.TotalPathAttrLen) - uint16(msg.WithdrawnRoutesLen)
+	if nlriLen > 0 && msg.TotalPathAttrLen == 0 {
+		return msg, BGPError{
+			ErrorCode:    UpdateMessageError,
+			ErrorSubCode: MissingWellKnonAttr,
+			ErrorStr:     "UPDATE contains NLRI but no path attributes",
+		}
+	}
+
 	if nlriLen > 0 {
-		msg.NLRI, err = decodeNLRIs(buf, nlriLen)
+		msg.NLRI, err = decodeNLRIs(buf, nlriLen, IPv4AFI, addPath)
 		if err != nil {
 			return msg, err
 		}
@@ -75,7 +172,51 @@ func decodeUpdateMsg(buf *bytes.Buffer, l uint16) (*BGPUpdate, error) {
 	return msg, nil
 }
 
-func decodeNotificationMsg(buf *bytes.Buffer) (*BGPNotification, error) {
+func malformedAttrListErr(msg string) error {
+	return BGPError{
+		ErrorCode:    UpdateMessageError,
+		ErrorSubCode: MalformedAttributeList,
+		ErrorStr:     msg,
+	}
+}
+
+func decodeCapabilityMsg(buf *bytes.Buffer, l uint16) (*BGPCapabilityMsg, error) {
+	msg := &BGPCapabilityMsg{
+		Data: make([]byte, l),
+	}
+
+	err := decode(buf, []interface{}{&msg.Data})
+	if err != nil {
+		return msg, err
+	}
+
+	return msg, nil
+}
+
+// routeRefreshLen is the length of the body of a ROUTE-REFRESH message
+const routeRefreshLen = 4
+
+func decodeRouteRefreshMsg(buf *bytes.Buffer, l uint16) (*BGPRouteRefresh, error) {
+	msg := &BGPRouteRefresh{}
+
+	if l != routeRefreshLen {
+		return msg, BGPError{
+			ErrorCode:    MessageHeaderError,
+			ErrorSubCode: BadMessageLength,
+			ErrorStr:     fmt.Sprintf("Invalid ROUTE-REFRESH length: %d", l),
+		}
+	}
+
+	reserved := uint8(0)
+	err := decode(buf, []interface{}{&msg.AFI, &reserved, &msg.SAFI})
+	if err != nil {
+		return msg, err
+	}
+
+	return msg, nil
+}
+
+func decodeNotificationMsg(buf *bytes.Buffer, l uint16) (*BGPNotification, error) {
 	msg := &BGPNotification{}
 
 	fields := []interface{}{
@@ -88,8 +229,20 @@ func decodeNotificationMsg(buf *bytes.Buffer) (*BGPNotification, error) {
 		return msg, err
 	}
 
+	if l > 2 {
+		msg.Data = make([]byte, l-2)
+		err = decode(buf, []interface{}{&msg.Data})
+		if err != nil {
+			return msg, err
+		}
+	}
+
+	return msg, validateNotification(msg)
+}
+
+func validateNotification(msg *BGPNotification) error {
 	if msg.ErrorCode > Cease {
-		return msg, fmt.Errorf("Invalid error code: %d", msg.ErrorSubcode)
+		return fmt.Errorf("Invalid error code: %d", msg.ErrorCode)
 	}
 
 	switch msg.ErrorCode {
@@ -114,40 +267,62 @@ func decodeNotificationMsg(buf *bytes.Buffer) (*BGPNotification, error) {
 			return invalidErrCode(msg)
 		}
 	case Cease:
-		if msg.ErrorSubcode != 0 {
-			return invalidErrCode(msg)
-		}
+		// Cease subcodes are still being allocated (e.g. Hard Reset, RFC
+		// 8538), so unknown ones are accepted
 	default:
 		return invalidErrCode(msg)
 	}
 
-	return msg, nil
+	return nil
 }
 
-func invalidErrCode(n *BGPNotification) (*BGPNotification, error) {
-	return n, fmt.Errorf("Invalid error sub code: %d/%d", n.ErrorCode, n.ErrorSubcode)
+func invalidErrCode(n *BGPNotification) error {
+	return fmt.Errorf("Invalid error sub code: %d/%d", n.ErrorCode, n.ErrorSubcode)
 }
 
 func decodeOpenMsg(buf *bytes.Buffer) (*BGPOpen, error) {
 	msg, err := _decodeOpenMsg(buf)
-	return msg.(*BGPOpen), err
+	if err != nil {
+		return nil, err
+	}
+
+	return msg.(*BGPOpen), nil
 }
 
 func _decodeOpenMsg(buf *bytes.Buffer) (interface{}, error) {
 	msg := &BGPOpen{}
+	optParmLen := uint8(0)
 
 	fields := []interface{}{
 		&msg.Version,
 		&msg.AS,
 		&msg.HoldTime,
 		&msg.BGPIdentifier,
-		&msg.OptParmLen,
+		&optParmLen,
 	}
 
 	err := decode(buf, fields)
 	if err != nil {
 		return msg, err
 	}
+	msg.OptParmLen = uint16(optParmLen)
+
+	// RFC9072: A non-extended optional parameter can not start with type 255,
+	// so 255 followed by 255 marks the extended encoding with 2 octet lengths
+	extended := false
+	if optParmLen == ExtendedOptParamMarker && buf.Len() > 0 && buf.Bytes()[0] == ExtendedOptParamMarker {
+		buf.Next(1)
+		err = decode(buf, []interface{}{&msg.OptParmLen})
+		if err != nil {
+			return msg, err
+		}
+		extended = true
+	}
+
+	msg.OptParams, err = decodeOptParams(buf, msg.OptParmLen, extended)
+	if err != nil {
+		return msg, err
+	}
 
 	err = validateOpen(msg)
 	if err != nil {
@@ -157,6 +332,172 @@ func _decodeOpenMsg(buf *bytes.Buffer) (interface{}, error) {
 	return msg, nil
 }
 
+func decodeOptParams(buf *bytes.Buffer, optParmLen uint16, extended bool) ([]OptParam, error) {
+	if optParmLen == 0 {
+		return nil, nil
+	}
+
+	optParams := make([]OptParam, 0)
+	read := uint16(0)
+	for read < optParmLen {
+		o := OptParam{}
+		err := decode(buf, []interface{}{&o.Type})
+		if err != nil {
+			return nil, err
+		}
+
+		if extended {
+			err = decode(buf, []interface{}{&o.Length})
+			read += 3
+		} else {
+			l := uint8(0)
+			err = decode(buf, []interface{}{&l})
+			o.Length = uint16(l)
+			read += 2
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch o.Type {
+		case CapabilitiesParamType:
+			caps, err := decodeCapabilities(buf, o.Length)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to decode capabilities: %w", err)
+			}
+			o.Value = caps
+		default:
+			return nil, BGPError{
+				ErrorCode:    OpenMessageError,
+				ErrorSubCode: UnsupportedOptionalParameter,
+				ErrorStr:     fmt.Sprintf("Unsupported optional parameter: %d", o.Type),
+			}
+		}
+
+		read += o.Length
+		optParams = append(optParams, o)
+	}
+
+	return optParams, nil
+}
+
+func decodeCapabilities(buf *bytes.Buffer, length uint16) (Capabilities, error) {
+	caps := make(Capabilities, 0)
+	read := uint16(0)
+	for read < length {
+		c, n, err := decodeCapability(buf)
+		if err != nil {
+			return nil, err
+		}
+		read += n
+
+		caps = append(caps, c)
+	}
+
+	return caps, nil
+}
+
+func decodeCapability(buf *bytes.Buffer) (Capability, uint16, error) {
+	c := Capability{}
+	err := decode(buf, []interface{}{&c.Code, &c.Length})
+	if err != nil {
+		return c, 0, err
+	}
+
+	raw := make([]byte, c.Length)
+	err = decode(buf, []interface{}{&raw})
+	if err != nil {
+		return c, 0, err
+	}
+	capBuf := bytes.NewBuffer(raw)
+
+	switch c.Code {
+	case MultiProtocolCapabilityCode:
+		mpCap := MultiProtocolCapability{}
+		reserved := uint8(0)
+		err = decode(capBuf, []interface{}{&mpCap.AFI, &reserved, &mpCap.SAFI})
+		if err != nil {
+			return c, 0, fmt.Errorf("Unable to decode multi protocol capability: %w", err)
+		}
+		c.Value = mpCap
+	case RouteRefreshCapabilityCode:
+		// Route refresh capability has no value
+	case ASN4CapabilityCode:
+		asn4Cap := ASN4Capability{}
+		err = decode(capBuf, []interface{}{&asn4Cap.ASN4})
+		if err != nil {
+			return c, 0, fmt.Errorf("Unable to decode 4 octet ASN capability: %w", err)
+		}
+		c.Value = asn4Cap
+	case GracefulRestartCapabilityCode:
+		grCap, err := decodeGracefulRestartCapability(capBuf, c.Length)
+		if err != nil {
+			return c, 0, fmt.Errorf("Unable to decode graceful restart capability: %w", err)
+		}
+		c.Value = grCap
+	case AddPathCapabilityCode:
+		apCap, err := decodeAddPathCapability(capBuf, c.Length)
+		if err != nil {
+			return c, 0, fmt.Errorf("Unable to decode ADD-PATH capability: %w", err)
+		}
+		c.Value = apCap
+	case DynamicCapabilityCode:
+		c.Value = DynamicCapability{
+			CapabilityCodes: raw,
+		}
+	default:
+		c.Value = raw
+	}
+
+	return c, uint16(c.Length) + 2, nil
+}
+
+func decodeGracefulRestartCapability(buf *bytes.Buffer, length uint8) (GracefulRestartCapability, error) {
+	grCap := GracefulRestartCapability{}
+	if length < 2 || (length-2)%4 != 0 {
+		return grCap, fmt.Errorf("Invalid length: %d", length)
+	}
+
+	flagsAndTime := uint16(0)
+	err := decode(buf, []interface{}{&flagsAndTime})
+	if err != nil {
+		return grCap, err
+	}
+	grCap.RestartState = flagsAndTime&gracefulRestartStateFlag != 0
+	grCap.RestartTime = flagsAndTime & gracefulRestartTimeMask
+
+	for i := uint8(0); i < (length-2)/4; i++ {
+		af := GracefulRestartAddressFamily{}
+		flags := uint8(0)
+		err := decode(buf, []interface{}{&af.AFI, &af.SAFI, &flags})
+		if err != nil {
+			return grCap, err
+		}
+		af.ForwardingStatePreserved = flags&gracefulRestartForwardingFlag != 0
+		grCap.AddressFamilies = append(grCap.AddressFamilies, af)
+	}
+
+	return grCap, nil
+}
+
+func decodeAddPathCapability(buf *bytes.Buffer, length uint8) (AddPathCapability, error) {
+	apCap := AddPathCapability{}
+	if length%4 != 0 {
+		return apCap, fmt.Errorf("Invalid length: %d", length)
+	}
+
+	for i := uint8(0); i < length/4; i++ {
+		af := AddPathAddressFamily{}
+		err := decode(buf, []interface{}{&af.AFI, &af.SAFI, &af.SendReceive})
+		if err != nil {
+			return apCap, err
+		}
+		apCap.AddressFamilies = append(apCap.AddressFamilies, af)
+	}
+
+	return apCap, nil
+}
+
 func validateOpen(msg *BGPOpen) error {
 	if msg.Version != BGP4Version {
 		return BGPError{
@@ -250,7 +591,7 @@ func decodeHeader(buf *bytes.Buffer) (*BGPHeader, error) {
 		}
 	}
 
-	if hdr.Type > KeepaliveMsg || hdr.Type == 0 {
+	if !isValidMsgType(hdr.Type) {
 		return hdr, BGPError{
 			ErrorCode:    MessageHeaderError,
 			ErrorSubCode: BadMessageType,
@@ -261,12 +602,16 @@ func decodeHeader(buf *bytes.Buffer) (*BGPHeader, error) {
 	return hdr, nil
 }
 
+func isValidMsgType(t uint8) bool {
+	return t >= OpenMsg && t <= CapabilityMsg
+}
+
 func decode(buf *bytes.Buffer, fields []interface{}) error {
 	var err error
 	for _, field := range fields {
 		err = binary.Read(buf, binary.BigEndian, field)
 		if err != nil {
-			return fmt.Errorf("Unable to read from buffer: %v", err)
+			return fmt.Errorf("Unable to read from buffer: %w", err)
 		}
 	}
 	return nil
@@ -2,7 +2,9 @@ package packet
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -22,7 +24,7 @@ func BenchmarkDecodeUpdateMsg(b *testing.B) {
 	input := []byte{0, 5, 8, 10, 16, 192, 168,
 		0, 53, // Total Path Attribute Length
 
-		255,  // Attribute flags
+		80,   // Attribute flags
 		1,    // Attribute Type code (ORIGIN)
 		0, 1, // Length
 		2, // INCOMPLETE
@@ -44,12 +46,12 @@ func BenchmarkDecodeUpdateMsg(b *testing.B) {
 		4,              // Length
 		10, 11, 12, 13, // Next Hop
 
-		0,          // Attribute flags
+		128,        // Attribute flags
 		4,          // Attribute Type code (MED)
 		4,          // Length
 		0, 0, 1, 0, // MED 256
 
-		0,          // Attribute flags
+		64,         // Attribute flags
 		5,          // Attribute Type code (Local Pref)
 		4,          // Length
 		0, 0, 1, 0, // Local Pref 256
@@ -69,7 +71,7 @@ func BenchmarkDecodeUpdateMsg(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		buf := bytes.NewBuffer(input)
-		_, err := decodeUpdateMsg(buf, uint16(len(input)))
+		_, err := decodeUpdateMsg(buf, uint16(len(input)), &DecodeOptions{})
 		if err != nil {
 			fmt.Printf("decodeUpdateMsg failed: %v\n", err)
 		}
@@ -220,16 +222,70 @@ func TestDecode(t *testing.T) {
 			input: []byte{
 				255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, // Marker
 				0, 28, // Length
-				5,                               // Type = Invalid
+				7,                               // Type = Invalid
 				0, 5, 8, 10, 16, 192, 168, 0, 0, // Some more stuff
 			},
 			wantFail: true,
+		}, {
+			// CAPABILITY message (draft-ietf-idr-dynamic-cap)
+			testNum: 8,
+			input: []byte{
+				255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, // Marker
+				0, 24, // Length
+				6,    // Type = Capability
+				0,    // Action = Advertise
+				2, 0, // Route Refresh capability
+				0, 0, // Padding
+			},
+			wantFail: false,
+			expected: &BGPMessage{
+				Header: &BGPHeader{
+					Length: 24,
+					Type:   CapabilityMsg,
+				},
+				Body: &BGPCapabilityMsg{
+					Data: []byte{0, 2, 0, 0, 0},
+				},
+			},
+		}, {
+			// ROUTE-REFRESH message for IPv4 unicast (RFC 2918)
+			testNum: 9,
+			input: []byte{
+				255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, // Marker
+				0, 23, // Length
+				5,    // Type = Route Refresh
+				0, 1, // AFI
+				0, // Reserved
+				1, // SAFI
+			},
+			wantFail: false,
+			expected: &BGPMessage{
+				Header: &BGPHeader{
+					Length: 23,
+					Type:   RouteRefreshMsg,
+				},
+				Body: &BGPRouteRefresh{
+					AFI:  IPv4AFI,
+					SAFI: UnicastSAFI,
+				},
+			},
+		}, {
+			// ROUTE-REFRESH message with invalid length
+			testNum: 10,
+			input: []byte{
+				255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, // Marker
+				0, 22, // Length
+				5,    // Type = Route Refresh
+				0, 1, // AFI
+				0, // Reserved
+			},
+			wantFail: true,
 		},
 	}
 
 	for _, test := range tests {
 		buf := bytes.NewBuffer(test.input)
-		msg, err := Decode(buf)
+		msg, err := Decode(buf, &DecodeOptions{})
 
 		if err != nil && !test.wantFail {
 			t.Errorf("Unexpected error in test %d: %v", test.testNum, err)
@@ -363,14 +419,42 @@ func TestDecodeNotificationMsg(t *testing.T) {
 			},
 		},
 		{
-			name:     "Cease (invalid subcode)",
-			input:    []byte{6, 1},
-			wantFail: true,
+			name:  "Cease (administrative shutdown)",
+			input: []byte{6, 2},
+			expected: &BGPNotification{
+				ErrorCode:    6,
+				ErrorSubcode: 2,
+			},
+		},
+		{
+			name:  "Cease (hard reset)",
+			input: []byte{6, 9},
+			expected: &BGPNotification{
+				ErrorCode:    6,
+				ErrorSubcode: 9,
+			},
+		},
+		{
+			name:  "Cease (unassigned subcode)",
+			input: []byte{6, 255},
+			expected: &BGPNotification{
+				ErrorCode:    6,
+				ErrorSubcode: 255,
+			},
+		},
+		{
+			name:  "Bad message length with data",
+			input: []byte{1, 2, 0, 18},
+			expected: &BGPNotification{
+				ErrorCode:    1,
+				ErrorSubcode: 2,
+				Data:         []byte{0, 18},
+			},
 		},
 	}
 
 	for _, test := range tests {
-		res, err := decodeNotificationMsg(bytes.NewBuffer(test.input))
+		res, err := decodeNotificationMsg(bytes.NewBuffer(test.input), uint16(len(test.input)))
 
 		if test.wantFail {
 			if err != nil {
@@ -422,7 +506,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 				8, 10, // 10.0.0.0/8
 				16, 192, 168, // 192.168.0.0/16
 				0, 5, // Total Path Attribute Length
-				255,  // Attribute flags
+				80,   // Attribute flags
 				1,    // Attribute Type code
 				0, 1, // Length
 				2, // INCOMPLETE
@@ -440,9 +524,9 @@ func TestDecodeUpdateMsg(t *testing.T) {
 				},
 				TotalPathAttrLen: 5,
 				PathAttributes: &PathAttribute{
-					Optional:       true,
+					Optional:       false,
 					Transitive:     true,
-					Partial:        true,
+					Partial:        false,
 					ExtendedLength: true,
 					Length:         1,
 					TypeCode:       1,
@@ -456,7 +540,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 			input: []byte{0, 5, 8, 10, 16, 192, 168,
 				0, 14, // Total Path Attribute Length
 
-				255,  // Attribute flags
+				80,   // Attribute flags
 				1,    // Attribute Type code (ORIGIN)
 				0, 1, // Length
 				2, // INCOMPLETE
@@ -482,9 +566,9 @@ func TestDecodeUpdateMsg(t *testing.T) {
 				},
 				TotalPathAttrLen: 14,
 				PathAttributes: &PathAttribute{
-					Optional:       true,
+					Optional:       false,
 					Transitive:     true,
-					Partial:        true,
+					Partial:        false,
 					ExtendedLength: true,
 					Length:         1,
 					TypeCode:       1,
@@ -516,7 +600,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 			input: []byte{0, 5, 8, 10, 16, 192, 168,
 				0, 13, // Total Path Attribute Length
 
-				255,  // Attribute flags
+				80,   // Attribute flags
 				1,    // Attribute Type code (ORIGIN)
 				0, 1, // Length
 				2, // INCOMPLETE
@@ -535,7 +619,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 			input: []byte{0, 5, 8, 10, 16, 192, 168,
 				0, 13, // Total Path Attribute Length
 
-				255,  // Attribute flags
+				80,   // Attribute flags
 				1,    // Attribute Type code (ORIGIN)
 				0, 1, // Length
 				2, // INCOMPLETE
@@ -556,7 +640,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 			input: []byte{0, 5, 8, 10, 16, 192, 168,
 				0, 20, // Total Path Attribute Length
 
-				255,  // Attribute flags
+				80,   // Attribute flags
 				1,    // Attribute Type code (ORIGIN)
 				0, 1, // Length
 				2, // INCOMPLETE
@@ -586,9 +670,9 @@ func TestDecodeUpdateMsg(t *testing.T) {
 				},
 				TotalPathAttrLen: 20,
 				PathAttributes: &PathAttribute{
-					Optional:       true,
+					Optional:       false,
 					Transitive:     true,
-					Partial:        true,
+					Partial:        false,
 					ExtendedLength: true,
 					Length:         1,
 					TypeCode:       1,
@@ -628,7 +712,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 			input: []byte{0, 5, 8, 10, 16, 192, 168,
 				0, 27, // Total Path Attribute Length
 
-				255,  // Attribute flags
+				80,   // Attribute flags
 				1,    // Attribute Type code (ORIGIN)
 				0, 1, // Length
 				2, // INCOMPLETE
@@ -665,9 +749,9 @@ func TestDecodeUpdateMsg(t *testing.T) {
 				TotalPathAttrLen: 27,
 				PathAttributes: &PathAttribute{
 
-					Optional:       true,
+					Optional:       false,
 					Transitive:     true,
-					Partial:        true,
+					Partial:        false,
 					ExtendedLength: true,
 					Length:         1,
 					TypeCode:       1,
@@ -705,7 +789,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 							ExtendedLength: false,
 							Length:         4,
 							TypeCode:       3,
-							Value:          [4]byte{10, 11, 12, 13},
+							Value:          net.IP{10, 11, 12, 13},
 						},
 					},
 				},
@@ -717,7 +801,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 			input: []byte{0, 5, 8, 10, 16, 192, 168,
 				0, 34, // Total Path Attribute Length
 
-				255,  // Attribute flags
+				80,   // Attribute flags
 				1,    // Attribute Type code (ORIGIN)
 				0, 1, // Length
 				2, // INCOMPLETE
@@ -739,8 +823,8 @@ func TestDecodeUpdateMsg(t *testing.T) {
 				4,              // Length
 				10, 11, 12, 13, // Next Hop
 
-				0,          // Attribute flags
-				4,          // Attribute Type code (Next Hop)
+				128,        // Attribute flags
+				4,          // Attribute Type code (MED)
 				4,          // Length
 				0, 0, 1, 0, // MED 256
 
@@ -759,9 +843,9 @@ func TestDecodeUpdateMsg(t *testing.T) {
 				TotalPathAttrLen: 34,
 				PathAttributes: &PathAttribute{
 
-					Optional:       true,
+					Optional:       false,
 					Transitive:     true,
-					Partial:        true,
+					Partial:        false,
 					ExtendedLength: true,
 					Length:         1,
 					TypeCode:       1,
@@ -798,9 +882,9 @@ func TestDecodeUpdateMsg(t *testing.T) {
 							ExtendedLength: false,
 							Length:         4,
 							TypeCode:       3,
-							Value:          [4]byte{10, 11, 12, 13},
+							Value:          net.IP{10, 11, 12, 13},
 							Next: &PathAttribute{
-								Optional:       false,
+								Optional:       true,
 								Transitive:     false,
 								Partial:        false,
 								ExtendedLength: false,
@@ -819,7 +903,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 			input: []byte{0, 5, 8, 10, 16, 192, 168,
 				0, 41, // Total Path Attribute Length
 
-				255,  // Attribute flags
+				80,   // Attribute flags
 				1,    // Attribute Type code (ORIGIN)
 				0, 1, // Length
 				2, // INCOMPLETE
@@ -841,12 +925,12 @@ func TestDecodeUpdateMsg(t *testing.T) {
 				4,              // Length
 				10, 11, 12, 13, // Next Hop
 
-				0,          // Attribute flags
+				128,        // Attribute flags
 				4,          // Attribute Type code (MED)
 				4,          // Length
 				0, 0, 1, 0, // MED 256
 
-				0,          // Attribute flags
+				64,         // Attribute flags
 				5,          // Attribute Type code (Local Pref)
 				4,          // Length
 				0, 0, 1, 0, // Local Pref 256
@@ -865,9 +949,9 @@ func TestDecodeUpdateMsg(t *testing.T) {
 				},
 				TotalPathAttrLen: 41,
 				PathAttributes: &PathAttribute{
-					Optional:       true,
+					Optional:       false,
 					Transitive:     true,
-					Partial:        true,
+					Partial:        false,
 					ExtendedLength: true,
 					Length:         1,
 					TypeCode:       1,
@@ -904,9 +988,9 @@ func TestDecodeUpdateMsg(t *testing.T) {
 							ExtendedLength: false,
 							Length:         4,
 							TypeCode:       3,
-							Value:          [4]byte{10, 11, 12, 13},
+							Value:          net.IP{10, 11, 12, 13},
 							Next: &PathAttribute{
-								Optional:       false,
+								Optional:       true,
 								Transitive:     false,
 								Partial:        false,
 								ExtendedLength: false,
@@ -915,7 +999,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 								Value:          uint32(256),
 								Next: &PathAttribute{
 									Optional:       false,
-									Transitive:     false,
+									Transitive:     true,
 									Partial:        false,
 									ExtendedLength: false,
 									Length:         4,
@@ -934,7 +1018,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 			input: []byte{0, 5, 8, 10, 16, 192, 168,
 				0, 44, // Total Path Attribute Length
 
-				255,  // Attribute flags
+				80,   // Attribute flags
 				1,    // Attribute Type code (ORIGIN)
 				0, 1, // Length
 				2, // INCOMPLETE
@@ -956,12 +1040,12 @@ func TestDecodeUpdateMsg(t *testing.T) {
 				4,              // Length
 				10, 11, 12, 13, // Next Hop
 
-				0,          // Attribute flags
+				128,        // Attribute flags
 				4,          // Attribute Type code (MED)
 				4,          // Length
 				0, 0, 1, 0, // MED 256
 
-				0,          // Attribute flags
+				64,         // Attribute flags
 				5,          // Attribute Type code (Local Pref)
 				4,          // Length
 				0, 0, 1, 0, // Local Pref 256
@@ -983,9 +1067,9 @@ func TestDecodeUpdateMsg(t *testing.T) {
 				},
 				TotalPathAttrLen: 44,
 				PathAttributes: &PathAttribute{
-					Optional:       true,
+					Optional:       false,
 					Transitive:     true,
-					Partial:        true,
+					Partial:        false,
 					ExtendedLength: true,
 					Length:         1,
 					TypeCode:       1,
@@ -1022,9 +1106,9 @@ func TestDecodeUpdateMsg(t *testing.T) {
 							ExtendedLength: false,
 							Length:         4,
 							TypeCode:       3,
-							Value:          [4]byte{10, 11, 12, 13},
+							Value:          net.IP{10, 11, 12, 13},
 							Next: &PathAttribute{
-								Optional:       false,
+								Optional:       true,
 								Transitive:     false,
 								Partial:        false,
 								ExtendedLength: false,
@@ -1033,7 +1117,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 								Value:          uint32(256),
 								Next: &PathAttribute{
 									Optional:       false,
-									Transitive:     false,
+									Transitive:     true,
 									Partial:        false,
 									ExtendedLength: false,
 									Length:         4,
@@ -1060,7 +1144,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 			input: []byte{0, 5, 8, 10, 16, 192, 168,
 				0, 53, // Total Path Attribute Length
 
-				255,  // Attribute flags
+				80,   // Attribute flags
 				1,    // Attribute Type code (ORIGIN)
 				0, 1, // Length
 				2, // INCOMPLETE
@@ -1082,12 +1166,12 @@ func TestDecodeUpdateMsg(t *testing.T) {
 				4,              // Length
 				10, 11, 12, 13, // Next Hop
 
-				0,          // Attribute flags
+				128,        // Attribute flags
 				4,          // Attribute Type code (MED)
 				4,          // Length
 				0, 0, 1, 0, // MED 256
 
-				0,          // Attribute flags
+				64,         // Attribute flags
 				5,          // Attribute Type code (Local Pref)
 				4,          // Length
 				0, 0, 1, 0, // Local Pref 256
@@ -1117,9 +1201,9 @@ func TestDecodeUpdateMsg(t *testing.T) {
 				},
 				TotalPathAttrLen: 53,
 				PathAttributes: &PathAttribute{
-					Optional:       true,
+					Optional:       false,
 					Transitive:     true,
-					Partial:        true,
+					Partial:        false,
 					ExtendedLength: true,
 					Length:         1,
 					TypeCode:       1,
@@ -1156,9 +1240,9 @@ func TestDecodeUpdateMsg(t *testing.T) {
 							ExtendedLength: false,
 							Length:         4,
 							TypeCode:       3,
-							Value:          [4]byte{10, 11, 12, 13},
+							Value:          net.IP{10, 11, 12, 13},
 							Next: &PathAttribute{
-								Optional:       false,
+								Optional:       true,
 								Transitive:     false,
 								Partial:        false,
 								ExtendedLength: false,
@@ -1167,7 +1251,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 								Value:          uint32(256),
 								Next: &PathAttribute{
 									Optional:       false,
-									Transitive:     false,
+									Transitive:     true,
 									Partial:        false,
 									ExtendedLength: false,
 									Length:         4,
@@ -1188,7 +1272,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 											Length:         6,
 											TypeCode:       7,
 											Value: Aggretator{
-												ASN:  uint16(258),
+												ASN:  258,
 												Addr: [4]byte{10, 11, 12, 13},
 											},
 										},
@@ -1241,6 +1325,19 @@ func TestDecodeUpdateMsg(t *testing.T) {
 			explicitLength: 5,
 			wantFail:       true,
 		},
+		{
+			// Invalid ORIGIN
+			testNum: 16,
+			input: []byte{
+				0, 0, // No Withdraws
+				0, 5, // Total Path Attribute Length
+				80,   // Attribute flags
+				1,    // Attribute Type code (ORIGIN)
+				0, 1, // Length
+				5, // Invalid
+			},
+			wantFail: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -1249,7 +1346,7 @@ func TestDecodeUpdateMsg(t *testing.T) {
 		if l == 0 {
 			l = uint16(len(test.input))
 		}
-		msg, err := decodeUpdateMsg(buf, l)
+		msg, err := decodeUpdateMsg(buf, l, &DecodeOptions{})
 
 		if err != nil && !test.wantFail {
 			t.Errorf("Unexpected error in test %d: %v", test.testNum, err)
@@ -1269,6 +1366,271 @@ func TestDecodeUpdateMsg(t *testing.T) {
 	}
 }
 
+func TestDecodeUpdateMsgLengths(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []byte
+		length uint16
+	}{
+		{
+			name: "Withdrawn routes length exceeds message",
+			input: []byte{
+				0, 10, // Withdrawn Routes Length
+				8, 10, // 10.0.0.0/8
+				0, 0, // Total Path Attribute Length
+			},
+		},
+		{
+			name: "Maximum withdrawn routes length",
+			input: []byte{
+				255, 255, // Withdrawn Routes Length
+				0, 0, // Total Path Attribute Length
+			},
+		},
+		{
+			name: "Total path attribute length exceeds message",
+			input: []byte{
+				0, 0, // Withdrawn Routes Length
+				0, 8, // Total Path Attribute Length
+				64, 1, 1, 0, // ORIGIN
+			},
+		},
+		{
+			name: "Maximum total path attribute length",
+			input: []byte{
+				0, 0, // Withdrawn Routes Length
+				255, 255, // Total Path Attribute Length
+				64, 1, 1, 0, // ORIGIN
+			},
+		},
+		{
+			name: "Sum of lengths exceeds message",
+			input: []byte{
+				0, 2, // Withdrawn Routes Length
+				8, 10, // 10.0.0.0/8
+				0, 4, // Total Path Attribute Length
+				64, 1, 1, 0, // ORIGIN
+			},
+			length: 8,
+		},
+		{
+			name: "Message shorter than length fields",
+			input: []byte{
+				0, 0, // Withdrawn Routes Length
+				0, 0, // Total Path Attribute Length
+			},
+			length: 2,
+		},
+	}
+
+	for _, test := range tests {
+		l := test.length
+		if l == 0 {
+			l = uint16(len(test.input))
+		}
+
+		_, err := decodeUpdateMsg(bytes.NewBuffer(test.input), l, &DecodeOptions{})
+
+		var bgperr BGPError
+		if !assert.True(t, errors.As(err, &bgperr), "%s: %v", test.name, err) {
+			continue
+		}
+		assert.Equal(t, uint8(UpdateMessageError), bgperr.ErrorCode, test.name)
+		assert.Equal(t, uint8(MalformedAttributeList), bgperr.ErrorSubCode, test.name)
+	}
+}
+
+func TestDecodeUpdateMsgASNLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		opt   *DecodeOptions
+		input []byte
+	}{
+		{
+			name: "2-octet AS session",
+			opt:  &DecodeOptions{},
+			input: []byte{
+				0, 0, // Withdrawn Routes Length
+				0, 9, // Total Path Attribute Length
+				64, 2, 6, // AS_PATH
+				2, 2, // AS_SEQUENCE, 2 ASNs
+				253, 232, // AS65000
+				253, 233, // AS65001
+				24, 192, 0, 2, // 192.0.2.0/24
+			},
+		},
+		{
+			name: "4-octet AS session",
+			opt: &DecodeOptions{
+				Use32BitASN: true,
+			},
+			input: []byte{
+				0, 0, // Withdrawn Routes Length
+				0, 13, // Total Path Attribute Length
+				64, 2, 10, // AS_PATH
+				2, 2, // AS_SEQUENCE, 2 ASNs
+				0, 0, 253, 232, // AS65000
+				0, 0, 253, 233, // AS65001
+				24, 192, 0, 2, // 192.0.2.0/24
+			},
+		},
+	}
+
+	expected := ASPath{
+		{
+			Type:  ASSequence,
+			Count: 2,
+			ASNs:  []uint32{65000, 65001},
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := decodeUpdateMsg(bytes.NewBuffer(test.input), uint16(len(test.input)), test.opt)
+		if err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+			continue
+		}
+
+		assert.Equal(t, expected, msg.PathAttributes.Value, test.name)
+	}
+}
+
+func TestDecodeUpdateMsgCommunityLimits(t *testing.T) {
+	opt := &DecodeOptions{
+		MaxCommunities:         2,
+		MaxExtendedCommunities: 1,
+	}
+
+	tests := []struct {
+		name            string
+		attr            []byte
+		treatAsWithdraw bool
+	}{
+		{
+			name: "COMMUNITIES at cap",
+			attr: []byte{
+				192, 8, 8, // COMMUNITIES
+				253, 232, 0, 100, // 65000:100
+				253, 232, 0, 200, // 65000:200
+			},
+		},
+		{
+			name: "COMMUNITIES over cap",
+			attr: []byte{
+				192, 8, 12, // COMMUNITIES
+				253, 232, 0, 100, // 65000:100
+				253, 232, 0, 200, // 65000:200
+				253, 232, 1, 44, // 65000:300
+			},
+			treatAsWithdraw: true,
+		},
+		{
+			name: "EXTENDED_COMMUNITIES at cap",
+			attr: []byte{
+				192, 16, 8, // EXTENDED_COMMUNITIES
+				0, 2, 253, 232, 0, 0, 0, 100, // target:65000:100
+			},
+		},
+		{
+			name: "EXTENDED_COMMUNITIES over cap",
+			attr: []byte{
+				192, 16, 16, // EXTENDED_COMMUNITIES
+				0, 2, 253, 232, 0, 0, 0, 100, // target:65000:100
+				0, 2, 253, 232, 0, 0, 0, 200, // target:65000:200
+			},
+			treatAsWithdraw: true,
+		},
+	}
+
+	for _, test := range tests {
+		origin := []byte{64, 1, 1, 0} // ORIGIN: IGP
+		attrs := append(append([]byte{}, test.attr...), origin...)
+		input := append([]byte{0, 0, 0, uint8(len(attrs))}, attrs...)
+		input = append(input, 24, 192, 0, 2) // 192.0.2.0/24
+
+		msg, err := decodeUpdateMsg(bytes.NewBuffer(input), uint16(len(input)), opt)
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+
+		assert.Equal(t, test.treatAsWithdraw, msg.TreatAsWithdraw, test.name)
+		assert.Equal(t, &NLRI{IP: [4]byte{192, 0, 2, 0}, Pfxlen: 24}, msg.NLRI, test.name)
+
+		// The attribute following the discarded one is unaffected
+		last := msg.PathAttributes
+		for last.Next != nil {
+			last = last.Next
+		}
+		assert.Equal(t, uint8(OriginAttr), last.TypeCode, test.name)
+		assert.Equal(t, uint8(IGP), last.Value, test.name)
+		if test.treatAsWithdraw {
+			assert.Equal(t, last, msg.PathAttributes, "%s: discarded attribute was kept", test.name)
+		}
+	}
+}
+
+func TestDecodeUpdateMsgWithoutPathAttrs(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		wantFail bool
+		expected *BGPUpdate
+	}{
+		{
+			name: "Withdraw only",
+			input: []byte{
+				0, 2, // Withdrawn Routes Length
+				8, 10, // 10.0.0.0/8
+				0, 0, // Total Path Attributes Length
+			},
+			wantFail: false,
+			expected: &BGPUpdate{
+				WithdrawnRoutesLen: 2,
+				WithdrawnRoutes: &NLRI{
+					IP:     [4]byte{10, 0, 0, 0},
+					Pfxlen: 8,
+				},
+			},
+		},
+		{
+			name: "NLRI without path attributes",
+			input: []byte{
+				0, 0, // Withdrawn Routes Length
+				0, 0, // Total Path Attributes Length
+				8, 10, // 10.0.0.0/8
+			},
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := decodeUpdateMsg(bytes.NewBuffer(test.input), uint16(len(test.input)), &DecodeOptions{})
+		if test.wantFail {
+			if err == nil {
+				t.Errorf("Expected error did not happen in test %q", test.name)
+				continue
+			}
+
+			bgpErr, ok := err.(BGPError)
+			if !ok {
+				t.Errorf("Unexpected error type in test %q: %v", test.name, err)
+				continue
+			}
+
+			assert.Equal(t, uint8(UpdateMessageError), bgpErr.ErrorCode, test.name)
+			assert.Equal(t, uint8(MissingWellKnonAttr), bgpErr.ErrorSubCode, test.name)
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error in test %q: %v", test.name, err)
+			continue
+		}
+
+		assert.Equal(t, test.expected, msg, test.name)
+	}
+}
+
 func TestDecodeMsgBody(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -1280,13 +1642,13 @@ func TestDecodeMsgBody(t *testing.T) {
 	}{
 		{
 			name:     "Unknown msgType",
-			msgType:  5,
+			msgType:  7,
 			wantFail: true,
 		},
 	}
 
 	for _, test := range tests {
-		res, err := decodeMsgBody(test.buffer, test.msgType, test.length)
+		res, err := decodeMsgBody(test.buffer, test.msgType, test.length, &DecodeOptions{})
 		if test.wantFail && err == nil {
 			t.Errorf("Expected error dit not happen in test %q", test.name)
 		}
@@ -1320,11 +1682,176 @@ func TestDecodeOpenMsg(t *testing.T) {
 			input:    []byte{3, 1, 1, 0, 15, 10, 10, 10, 11, 0},
 			wantFail: true,
 		},
+		{
+			// Valid message with capabilities
+			testNum: 3,
+			input: []byte{
+				4,    // Version
+				1, 1, // ASN
+				0, 15, // Hold Time
+				10, 20, 30, 40, // BGP Identifier
+				20,   // Opt Parm Len
+				2,    // Type = Capabilities
+				18,   // Length
+				1, 4, // Multi Protocol, Length
+				0, 1, 0, 1, // AFI IPv4, reserved, SAFI unicast
+				2, 0, // Route Refresh, Length
+				65, 4, // 4 octet ASN, Length
+				0, 0, 253, 232, // AS65000
+				70, 2, // Unknown capability, Length
+				1, 2, // Value
+			},
+			wantFail: false,
+			expected: &BGPOpen{
+				Version:       4,
+				AS:            257,
+				HoldTime:      15,
+				BGPIdentifier: 169090600,
+				OptParmLen:    20,
+				OptParams: []OptParam{
+					{
+						Type:   CapabilitiesParamType,
+						Length: 18,
+						Value: Capabilities{
+							{
+								Code:   MultiProtocolCapabilityCode,
+								Length: 4,
+								Value: MultiProtocolCapability{
+									AFI:  1,
+									SAFI: 1,
+								},
+							},
+							{
+								Code:   RouteRefreshCapabilityCode,
+								Length: 0,
+							},
+							{
+								Code:   ASN4CapabilityCode,
+								Length: 4,
+								Value: ASN4Capability{
+									ASN4: 65000,
+								},
+							},
+							{
+								Code:   70,
+								Length: 2,
+								Value:  []byte{1, 2},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			// Unsupported optional parameter
+			testNum: 4,
+			input: []byte{
+				4,    // Version
+				1, 1, // ASN
+				0, 15, // Hold Time
+				10, 20, 30, 40, // BGP Identifier
+				3, // Opt Parm Len
+				1, // Type = Authentication (deprecated)
+				1, // Length
+				0,
+			},
+			wantFail: true,
+		},
+		{
+			// Truncated capability
+			testNum: 5,
+			input: []byte{
+				4,    // Version
+				1, 1, // ASN
+				0, 15, // Hold Time
+				10, 20, 30, 40, // BGP Identifier
+				6,     // Opt Parm Len
+				2,     // Type = Capabilities
+				4,     // Length
+				65, 4, // 4 octet ASN, Length
+				0, 0,
+			},
+			wantFail: true,
+		},
+		{
+			// Dynamic capability
+			testNum: 6,
+			input: []byte{
+				4,    // Version
+				1, 1, // ASN
+				0, 15, // Hold Time
+				10, 20, 30, 40, // BGP Identifier
+				6,     // Opt Parm Len
+				2,     // Type = Capabilities
+				4,     // Length
+				67, 2, // Dynamic capability, Length
+				2, 65, // Route Refresh, 4 octet ASN
+			},
+			wantFail: false,
+			expected: &BGPOpen{
+				Version:       4,
+				AS:            257,
+				HoldTime:      15,
+				BGPIdentifier: 169090600,
+				OptParmLen:    6,
+				OptParams: []OptParam{
+					{
+						Type:   CapabilitiesParamType,
+						Length: 4,
+						Value: Capabilities{
+							{
+								Code:   DynamicCapabilityCode,
+								Length: 2,
+								Value: DynamicCapability{
+									CapabilityCodes: []uint8{2, 65},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	genericTest(_decodeOpenMsg, tests, t)
 }
 
+func TestDecodeOpenMsgExtendedOptParams(t *testing.T) {
+	caps := []byte{
+		1, 4, 0, 1, 0, 1, // Multi Protocol: IPv4 Unicast
+		2, 0, // Route Refresh
+		65, 4, 0, 0, 253, 232, // 4 octet ASN: 65000
+		70, 2, 1, 2, // Unknown capability
+	}
+	hdr := []byte{
+		4,    // Version
+		1, 1, // ASN
+		0, 15, // Hold Time
+		10, 20, 30, 40, // BGP Identifier
+	}
+
+	legacy := append(append([]byte{}, hdr...), 20, 2, 18)
+	legacy = append(legacy, caps...)
+
+	extended := append(append([]byte{}, hdr...), 255, 255, 0, 21, 2, 0, 18)
+	extended = append(extended, caps...)
+
+	legacyMsg, err := decodeOpenMsg(bytes.NewBuffer(legacy))
+	if err != nil {
+		t.Fatalf("Unable to decode legacy OPEN: %v", err)
+	}
+
+	extendedMsg, err := decodeOpenMsg(bytes.NewBuffer(extended))
+	if err != nil {
+		t.Fatalf("Unable to decode extended OPEN: %v", err)
+	}
+
+	assert.Equal(t, uint16(20), legacyMsg.OptParmLen)
+	assert.Equal(t, uint16(21), extendedMsg.OptParmLen)
+	assert.Equal(t, 4, len(extendedMsg.Capabilities()))
+	assert.Equal(t, legacyMsg.Capabilities(), extendedMsg.Capabilities())
+}
+
 func TestDecodeHeader(t *testing.T) {
 	tests := []test{
 		{
@@ -1358,9 +1885,9 @@ func TestDecodeHeader(t *testing.T) {
 			},
 		},
 		{
-			// Invalid message type 5
+			// Invalid message type 7
 			testNum:  4,
-			input:    []byte{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 0, 19, 5},
+			input:    []byte{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 0, 19, 7},
 			wantFail: true,
 			expected: &BGPHeader{
 				Length: 19,
@@ -1405,6 +1932,26 @@ func TestDecodeHeader(t *testing.T) {
 			input:    []byte{},
 			wantFail: true,
 		},
+		{
+			// Valid CAPABILITY message header
+			testNum:  10,
+			input:    []byte{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 0, 19, CapabilityMsg},
+			wantFail: false,
+			expected: &BGPHeader{
+				Length: 19,
+				Type:   CapabilityMsg,
+			},
+		},
+		{
+			// Valid ROUTE-REFRESH message header
+			testNum:  11,
+			input:    []byte{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 0, 23, RouteRefreshMsg},
+			wantFail: false,
+			expected: &BGPHeader{
+				Length: 23,
+				Type:   RouteRefreshMsg,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -1534,3 +2081,50 @@ func TestValidateOpenMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeUnknownMsgType(t *testing.T) {
+	input := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0, 19, // Length
+		7, // Type
+	}
+
+	_, err := Decode(bytes.NewBuffer(input), &DecodeOptions{})
+	var bgperr BGPError
+	if assert.True(t, errors.As(err, &bgperr), "Header: %v", err) {
+		assert.Equal(t, uint8(MessageHeaderError), bgperr.ErrorCode)
+		assert.Equal(t, uint8(BadMessageType), bgperr.ErrorSubCode)
+	}
+
+	_, err = decodeMsgBody(bytes.NewBuffer(nil), 7, 0, &DecodeOptions{})
+	if assert.True(t, errors.As(err, &bgperr), "Body: %v", err) {
+		assert.Equal(t, uint8(MessageHeaderError), bgperr.ErrorCode)
+		assert.Equal(t, uint8(BadMessageType), bgperr.ErrorSubCode)
+		assert.Contains(t, bgperr.ErrorStr, "7")
+	}
+}
+
+func TestDecodeWrapsBGPError(t *testing.T) {
+	input := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0, 34, // Length
+		2,    // UPDATE
+		0, 0, // Withdrawn Routes Length
+		0, 11, // Total Path Attribute Length
+		64, 1, 1, 0, // ORIGIN
+		64, 2, 4, // AS_PATH
+		2, 0, // AS_SEQUENCE, 0 ASNs
+		0, 0,
+	}
+
+	_, err := Decode(bytes.NewBuffer(input), &DecodeOptions{})
+	if !assert.Error(t, err) {
+		return
+	}
+
+	var bgperr BGPError
+	if assert.True(t, errors.As(err, &bgperr)) {
+		assert.Equal(t, uint8(UpdateMessageError), bgperr.ErrorCode)
+		assert.Equal(t, uint8(MalformedASPath), bgperr.ErrorSubCode)
+	}
+}
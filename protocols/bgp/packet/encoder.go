@@ -2,10 +2,47 @@ package packet
 
 import (
 	"bytes"
+	"fmt"
+	"math"
+	"net"
 
 	"github.com/taktv6/tflow2/convert"
 )
 
+// Serialize serializes a BGP message including its header
+func Serialize(msg *BGPMessage) ([]byte, error) {
+	switch msg.Header.Type {
+	case KeepaliveMsg:
+		return SerializeKeepaliveMsg(), nil
+	case NotificationMsg:
+		n, ok := msg.Body.(*BGPNotification)
+		if !ok {
+			return nil, fmt.Errorf("Invalid NOTIFICATION body: %T", msg.Body)
+		}
+		return SerializeNotificationMsg(n)
+	case OpenMsg:
+		o, ok := msg.Body.(*BGPOpen)
+		if !ok {
+			return nil, fmt.Errorf("Invalid OPEN body: %T", msg.Body)
+		}
+		return SerializeOpenMsg(o)
+	case UpdateMsg:
+		u, ok := msg.Body.(*BGPUpdate)
+		if !ok {
+			return nil, fmt.Errorf("Invalid UPDATE body: %T", msg.Body)
+		}
+		return SerializeUpdateMsg(u)
+	case RouteRefreshMsg:
+		r, ok := msg.Body.(*BGPRouteRefresh)
+		if !ok {
+			return nil, fmt.Errorf("Invalid ROUTE-REFRESH body: %T", msg.Body)
+		}
+		return SerializeRouteRefreshMsg(r), nil
+	}
+
+	return nil, fmt.Errorf("Unable to serialize message type %d", msg.Header.Type)
+}
+
 func SerializeKeepaliveMsg() []byte {
 	keepaliveLen := uint16(19)
 	buf := bytes.NewBuffer(make([]byte, 0, keepaliveLen))
@@ -14,30 +51,504 @@ func SerializeKeepaliveMsg() []byte {
 	return buf.Bytes()
 }
 
-func SerializeNotificationMsg(msg *BGPNotification) []byte {
-	notificationLen := uint16(21)
-	buf := bytes.NewBuffer(make([]byte, 0, notificationLen))
-	serializeHeader(buf, notificationLen, NotificationMsg)
-	buf.WriteByte(msg.ErrorCode)
-	buf.WriteByte(msg.ErrorSubcode)
+// SerializeRouteRefreshMsg serializes a ROUTE-REFRESH message including its header
+func SerializeRouteRefreshMsg(msg *BGPRouteRefresh) []byte {
+	l := uint16(HeaderLen + routeRefreshLen)
+	buf := bytes.NewBuffer(make([]byte, 0, l))
+	serializeHeader(buf, l, RouteRefreshMsg)
+	buf.Write(convert.Uint16Byte(msg.AFI))
+	buf.WriteByte(0) // Reserved
+	buf.WriteByte(msg.SAFI)
 
 	return buf.Bytes()
 }
 
-func SerializeOpenMsg(msg *BGPOpen) []byte {
-	openLen := uint16(29)
-	buf := bytes.NewBuffer(make([]byte, 0, openLen))
-	serializeHeader(buf, openLen, OpenMsg)
+// SerializeNotificationMsg serializes a NOTIFICATION message including its header
+func SerializeNotificationMsg(msg *BGPNotification) ([]byte, error) {
+	body := bytes.NewBuffer(nil)
+	n, err := msg.Serialize(body)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, int(n)+HeaderLen))
+	serializeHeader(buf, n+HeaderLen, NotificationMsg)
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// Serialize writes the body of the NOTIFICATION to buf and returns the number
+// of bytes written. Invalid error code/subcode combinations are rejected.
+func (n *BGPNotification) Serialize(buf *bytes.Buffer) (uint16, error) {
+	err := validateNotification(n)
+	if err != nil {
+		return 0, err
+	}
+
+	l := 2 + len(n.Data)
+	if l+HeaderLen > MaxLen {
+		return 0, fmt.Errorf("NOTIFICATION data too long: %d", len(n.Data))
+	}
+
+	buf.WriteByte(n.ErrorCode)
+	buf.WriteByte(n.ErrorSubcode)
+	buf.Write(n.Data)
+
+	return uint16(l), nil
+}
+
+// SerializeOpenMsg serializes an OPEN message including its header
+func SerializeOpenMsg(msg *BGPOpen) ([]byte, error) {
+	body := bytes.NewBuffer(nil)
+	n, err := msg.Serialize(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(n)+HeaderLen > MaxLen {
+		return nil, fmt.Errorf("OPEN exceeds maximum message length: %d", int(n)+HeaderLen)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, int(n)+HeaderLen))
+	serializeHeader(buf, n+HeaderLen, OpenMsg)
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// Serialize writes the body of the OPEN to buf and returns the number of bytes
+// written. OptParmLen and all parameter and capability lengths are calculated
+// from the content. The extended optional parameters encoding (RFC 9072) is used
+// if the parameters don't fit into the 1 octet lengths.
+func (o *BGPOpen) Serialize(buf *bytes.Buffer) (uint16, error) {
+	optParams, extended, err := serializeOptParams(o.OptParams)
+	if err != nil {
+		return 0, err
+	}
+
+	buf.WriteByte(o.Version)
+	buf.Write(convert.Uint16Byte(o.AS))
+	buf.Write(convert.Uint16Byte(o.HoldTime))
+	buf.Write(convert.Uint32Byte(o.BGPIdentifier))
+
+	l := 10 + len(optParams)
+	if extended {
+		buf.Write([]byte{ExtendedOptParamMarker, ExtendedOptParamMarker})
+		buf.Write(convert.Uint16Byte(uint16(len(optParams))))
+		l += 3
+	} else {
+		buf.WriteByte(uint8(len(optParams)))
+	}
+	buf.Write(optParams)
+
+	return uint16(l), nil
+}
+
+func serializeOptParams(params []OptParam) ([]byte, bool, error) {
+	values := make([][]byte, 0, len(params))
+	total := 0
+	extended := false
+	for _, p := range params {
+		var value []byte
+		switch v := p.Value.(type) {
+		case Capabilities:
+			caps, err := serializeCapabilities(v)
+			if err != nil {
+				return nil, false, err
+			}
+			value = caps
+		case []byte:
+			value = v
+		}
+
+		if len(value) > math.MaxUint8 {
+			extended = true
+		}
+		total += 2 + len(value)
+		values = append(values, value)
+	}
+
+	if total > math.MaxUint8 {
+		extended = true
+	}
+
+	buf := bytes.NewBuffer(nil)
+	for i, p := range params {
+		buf.WriteByte(p.Type)
+		if extended {
+			if len(values[i]) > math.MaxUint16 {
+				return nil, false, fmt.Errorf("Optional parameter %d too long: %d", p.Type, len(values[i]))
+			}
+			buf.Write(convert.Uint16Byte(uint16(len(values[i]))))
+		} else {
+			buf.WriteByte(uint8(len(values[i])))
+		}
+		buf.Write(values[i])
+	}
+
+	if buf.Len() > math.MaxUint16 {
+		return nil, false, fmt.Errorf("Optional parameters too long: %d", buf.Len())
+	}
+
+	return buf.Bytes(), extended, nil
+}
+
+func serializeCapabilities(caps Capabilities) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	for _, c := range caps {
+		var value []byte
+		switch v := c.Value.(type) {
+		case MultiProtocolCapability:
+			value = append(convert.Uint16Byte(v.AFI), 0, v.SAFI)
+		case ASN4Capability:
+			value = convert.Uint32Byte(v.ASN4)
+		case GracefulRestartCapability:
+			var err error
+			value, err = serializeGracefulRestartCapability(v)
+			if err != nil {
+				return nil, err
+			}
+		case AddPathCapability:
+			for _, af := range v.AddressFamilies {
+				value = append(value, convert.Uint16Byte(af.AFI)...)
+				value = append(value, af.SAFI, af.SendReceive)
+			}
+		case DynamicCapability:
+			value = v.CapabilityCodes
+		case []byte:
+			value = v
+		}
+
+		if len(value) > math.MaxUint8 {
+			return nil, fmt.Errorf("Capability %d too long: %d", c.Code, len(value))
+		}
+
+		buf.WriteByte(c.Code)
+		buf.WriteByte(uint8(len(value)))
+		buf.Write(value)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func serializeGracefulRestartCapability(c GracefulRestartCapability) ([]byte, error) {
+	if c.RestartTime > gracefulRestartTimeMask {
+		return nil, fmt.Errorf("Restart time too long: %d", c.RestartTime)
+	}
+
+	flagsAndTime := c.RestartTime
+	if c.RestartState {
+		flagsAndTime |= gracefulRestartStateFlag
+	}
+
+	value := convert.Uint16Byte(flagsAndTime)
+	for _, af := range c.AddressFamilies {
+		flags := uint8(0)
+		if af.ForwardingStatePreserved {
+			flags |= gracefulRestartForwardingFlag
+		}
+		value = append(value, convert.Uint16Byte(af.AFI)...)
+		value = append(value, af.SAFI, flags)
+	}
+
+	return value, nil
+}
+
+// EncodeOptions holds the session parameters negotiated with a peer which
+// affect how messages sent to it are encoded
+type EncodeOptions struct {
+	// Use32BitASN is set if both speakers announced the 4-octet AS capability.
+	// Otherwise AS numbers that do not fit into 2 octets are replaced by
+	// AS_TRANS and AS4_PATH and AS4_AGGREGATOR carry them instead (RFC 6793,
+	// 4.2.2).
+	Use32BitASN bool
+
+	// AddPath is set if the ADD-PATH capability (RFC 7911) was negotiated in
+	// send direction for IPv4 unicast. NLRI and withdrawn routes are preceded
+	// by their path identifier.
+	AddPath bool
+}
+
+// SerializeUpdateMsg serializes an UPDATE message including its header. AS
+// numbers are encoded with 2 octets.
+func SerializeUpdateMsg(m *BGPUpdate) ([]byte, error) {
+	return SerializeUpdate(m, EncodeOptions{})
+}
+
+// SerializeUpdate serializes an UPDATE message including its header as
+// negotiated with the receiving peer
+func SerializeUpdate(m *BGPUpdate, opt EncodeOptions) ([]byte, error) {
+	asnLength := uint8(2)
+	if opt.Use32BitASN {
+		asnLength = 4
+	}
+
+	body := bytes.NewBuffer(nil)
+	n, err := m.serialize(body, asnLength, opt.AddPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(n)+HeaderLen > MaxLen {
+		return nil, fmt.Errorf("UPDATE exceeds maximum message length: %d", int(n)+HeaderLen)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, int(n)+HeaderLen))
+	serializeHeader(buf, n+HeaderLen, UpdateMsg)
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// Serialize writes the body of the UPDATE to buf and returns the number of
+// bytes written. Lengths are calculated from the content, AS numbers are
+// encoded with 2 octets.
+func (m *BGPUpdate) Serialize(buf *bytes.Buffer) (uint16, error) {
+	return m.serialize(buf, 2, false)
+}
+
+func (m *BGPUpdate) serialize(buf *bytes.Buffer, asnLength uint8, addPath bool) (uint16, error) {
+	withdrawn := serializeNLRIs(m.WithdrawnRoutes, addPath)
+	if len(withdrawn) > math.MaxUint16 {
+		return 0, fmt.Errorf("Withdrawn routes too long: %d", len(withdrawn))
+	}
+
+	pathAttrs := m.PathAttributes
+	if asnLength == 2 {
+		pathAttrs = withAS4Attributes(pathAttrs)
+	}
+
+	attrs, err := serializePathAttrs(pathAttrs, asnLength)
+	if err != nil {
+		return 0, err
+	}
+	if len(attrs) > math.MaxUint16 {
+		return 0, fmt.Errorf("Path attributes too long: %d", len(attrs))
+	}
+
+	nlri := serializeNLRIs(m.NLRI, addPath)
+
+	l := 4 + len(withdrawn) + len(attrs) + len(nlri)
+	if l > math.MaxUint16 {
+		return 0, fmt.Errorf("UPDATE too long: %d", l)
+	}
+
+	buf.Write(convert.Uint16Byte(uint16(len(withdrawn))))
+	buf.Write(withdrawn)
+	buf.Write(convert.Uint16Byte(uint16(len(attrs))))
+	buf.Write(attrs)
+	buf.Write(nlri)
+
+	return uint16(l), nil
+}
+
+// serializeNLRIs serializes a list of prefixes. If addPath is set each prefix
+// is preceded by its path identifier.
+func serializeNLRIs(nlri *NLRI, addPath bool) []byte {
+	buf := bytes.NewBuffer(nil)
+	for n := nlri; n != nil; n = n.Next {
+		if addPath {
+			buf.Write(convert.Uint32Byte(n.PathIdentifier))
+		}
 
-	buf.WriteByte(msg.Version)
-	buf.Write(convert.Uint16Byte(msg.AS))
-	buf.Write(convert.Uint16Byte(msg.HoldTime))
-	buf.Write(convert.Uint32Byte(msg.BGPIdentifier))
-	buf.WriteByte(uint8(0))
+		var addr []byte
+		switch x := n.IP.(type) {
+		case [4]byte:
+			addr = x[:]
+		case [16]byte:
+			addr = x[:]
+		}
+		buf.WriteByte(n.Pfxlen)
+		buf.Write(addr[:int(math.Ceil(float64(n.Pfxlen)/float64(OctetLen)))])
+	}
 
 	return buf.Bytes()
 }
 
+// SerializePathAttributes serializes a list of path attributes. AS numbers
+// are encoded with 4 octets if use32BitASN is set, with 2 octets otherwise.
+func SerializePathAttributes(attrs *PathAttribute, use32BitASN bool) ([]byte, error) {
+	if use32BitASN {
+		return serializePathAttrs(attrs, 4)
+	}
+
+	return serializePathAttrs(attrs, 2)
+}
+
+// withAS4Attributes returns attrs with AS4_PATH and AS4_AGGREGATOR appended if
+// the AS_PATH or AGGREGATOR contain AS numbers that do not fit into 2 octets.
+// attrs is not modified.
+func withAS4Attributes(attrs *PathAttribute) *PathAttribute {
+	var as4 []*PathAttribute
+	for pa := attrs; pa != nil; pa = pa.Next {
+		switch v := pa.Value.(type) {
+		case ASPath:
+			if v.has32BitASN() {
+				as4 = append(as4, &PathAttribute{
+					TypeCode:   AS4PathAttr,
+					Optional:   true,
+					Transitive: true,
+					Value:      v.StripConfederation(),
+				})
+			}
+		case Aggretator:
+			if v.ASN > math.MaxUint16 {
+				as4 = append(as4, &PathAttribute{
+					TypeCode:   AS4AggregatorAttr,
+					Optional:   true,
+					Transitive: true,
+					Value:      v,
+				})
+			}
+		}
+	}
+
+	if len(as4) == 0 {
+		return attrs
+	}
+
+	var res []*PathAttribute
+	for pa := attrs; pa != nil; pa = pa.Next {
+		x := *pa
+		res = append(res, &x)
+	}
+	res = append(res, as4...)
+	for i := 0; i < len(res)-1; i++ {
+		res[i].Next = res[i+1]
+	}
+	res[len(res)-1].Next = nil
+
+	return res[0]
+}
+
+func serializePathAttrs(attrs *PathAttribute, asnLength uint8) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	for pa := attrs; pa != nil; pa = pa.Next {
+		l := asnLength
+		if pa.TypeCode == AS4PathAttr || pa.TypeCode == AS4AggregatorAttr {
+			l = 4
+		}
+
+		value, err := pa.serializeValue(l)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to serialize path attribute %d: %w", pa.TypeCode, err)
+		}
+		if len(value) > math.MaxUint16 {
+			return nil, fmt.Errorf("Path attribute %d too long: %d", pa.TypeCode, len(value))
+		}
+
+		extended := pa.ExtendedLength || len(value) > math.MaxUint8
+		buf.WriteByte(serializePathAttrFlags(pa, extended))
+		buf.WriteByte(pa.TypeCode)
+		if extended {
+			buf.Write(convert.Uint16Byte(uint16(len(value))))
+		} else {
+			buf.WriteByte(uint8(len(value)))
+		}
+		buf.Write(value)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func serializePathAttrFlags(pa *PathAttribute, extended bool) uint8 {
+	flags := uint8(0)
+	if pa.Optional {
+		flags |= 128
+	}
+	if pa.Transitive {
+		flags |= 64
+	}
+	if _, unknown := pa.Value.(UnknownAttribute); pa.Partial || unknown && pa.Transitive {
+		flags |= 32
+	}
+	if extended {
+		flags |= 16
+	}
+
+	return flags
+}
+
+func (pa *PathAttribute) serializeValue(asnLength uint8) ([]byte, error) {
+	if pa.RawASPath != nil && pa.RawASPath.ASNLength == asnLength {
+		return pa.RawASPath.Value, nil
+	}
+
+	switch v := pa.Value.(type) {
+	case nil:
+		return nil, nil
+	case uint8:
+		return []byte{v}, nil
+	case uint32:
+		return convert.Uint32Byte(v), nil
+	case net.IP:
+		addr := v.To4()
+		if addr == nil {
+			return nil, fmt.Errorf("Invalid IPv4 address: %s", v)
+		}
+		return addr, nil
+	case ASPath:
+		return serializeASPath(v, asnLength)
+	case []uint32:
+		buf := make([]byte, 0, len(v)*4)
+		for _, c := range v {
+			buf = append(buf, convert.Uint32Byte(c)...)
+		}
+		return buf, nil
+	case []ExtendedCommunity:
+		buf := make([]byte, 0, len(v)*extendedCommunityLen)
+		for _, c := range v {
+			buf = append(buf, c[:]...)
+		}
+		return buf, nil
+	case MultiProtocolUnreachNLRI:
+		buf := append(convert.Uint16Byte(v.AFI), v.SAFI)
+		return append(buf, serializeNLRIs(v.NLRI, false)...), nil
+	case Aggretator:
+		if asnLength == 4 {
+			return append(convert.Uint32Byte(v.ASN), v.Addr[:]...), nil
+		}
+
+		asn := v.ASN
+		if asn > math.MaxUint16 {
+			asn = ASTrans
+		}
+		return append(convert.Uint16Byte(uint16(asn)), v.Addr[:]...), nil
+	case []byte:
+		return v, nil
+	case UnknownAttribute:
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported value type %T", pa.Value)
+}
+
+func serializeASPath(path ASPath, asnLength uint8) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	for _, segment := range path {
+		if len(segment.ASNs) > math.MaxUint8 {
+			return nil, fmt.Errorf("AS Path segment too long: %d", len(segment.ASNs))
+		}
+
+		buf.WriteByte(segment.Type)
+		buf.WriteByte(uint8(len(segment.ASNs)))
+		for _, asn := range segment.ASNs {
+			if asnLength == 4 {
+				buf.Write(convert.Uint32Byte(asn))
+				continue
+			}
+
+			if asn > math.MaxUint16 {
+				asn = ASTrans
+			}
+			buf.Write(convert.Uint16Byte(uint16(asn)))
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
 func serializeHeader(buf *bytes.Buffer, length uint16, typ uint8) {
 	buf.Write([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
 	buf.Write(convert.Uint16Byte(length))
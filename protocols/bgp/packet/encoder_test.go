@@ -18,45 +18,93 @@ func TestSerializeKeepaliveMsg(t *testing.T) {
 	assert.Equal(t, expected, res)
 }
 
+func TestSerializeRouteRefreshMsg(t *testing.T) {
+	msg := &BGPRouteRefresh{
+		AFI:  IPv6AFI,
+		SAFI: UnicastSAFI,
+	}
+
+	res := SerializeRouteRefreshMsg(msg)
+	assert.Equal(t, []byte{0, 23, RouteRefreshMsg, 0, 2, 0, 1}, res[MarkerLen:])
+
+	decoded, err := Decode(bytes.NewBuffer(res), &DecodeOptions{})
+	if err != nil {
+		t.Fatalf("Unable to decode serialized message: %v", err)
+	}
+
+	assert.Equal(t, msg, decoded.Body)
+}
+
 func TestSerializeNotificationMsg(t *testing.T) {
 	tests := []struct {
 		name     string
 		input    *BGPNotification
+		wantFail bool
 		expected []byte
 	}{
 		{
-			name: "Valid #1",
+			name: "Cease",
 			input: &BGPNotification{
-				ErrorCode:    10,
-				ErrorSubcode: 5,
+				ErrorCode:    Cease,
+				ErrorSubcode: AdminShut,
 			},
 			expected: []byte{
 				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
 				0x00, 0x15, // Length
 				0x03, // Type
-				0x0a, // Error Code
-				0x05, // Error Subcode
+				0x06, // Error Code
+				0x02, // Error Subcode
 			},
 		},
 		{
-			name: "Valid #2",
+			name: "Bad message length",
 			input: &BGPNotification{
-				ErrorCode:    11,
-				ErrorSubcode: 6,
+				ErrorCode:    MessageHeaderError,
+				ErrorSubcode: BadMessageLength,
+				Data:         []byte{0x00, 0x12},
 			},
 			expected: []byte{
 				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
-				0x00, 0x15, // Length
-				0x03, // Type
-				0x0b, // Error Code
-				0x06, // Error Subcode
+				0x00, 0x17, // Length
+				0x03,       // Type
+				0x01,       // Error Code
+				0x02,       // Error Subcode
+				0x00, 0x12, // Data: Erroneous length
+			},
+		},
+		{
+			name: "Invalid error code",
+			input: &BGPNotification{
+				ErrorCode:    10,
+				ErrorSubcode: 5,
+			},
+			wantFail: true,
+		},
+		{
+			name: "Invalid error subcode",
+			input: &BGPNotification{
+				ErrorCode:    HoldTimeExpired,
+				ErrorSubcode: 1,
 			},
+			wantFail: true,
 		},
 	}
 
 	for _, test := range tests {
-		res := SerializeNotificationMsg(test.input)
-		assert.Equal(t, test.expected, res)
+		res, err := SerializeNotificationMsg(test.input)
+		if test.wantFail {
+			if err == nil {
+				t.Errorf("Expected error did not happen for test %q", test.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+			continue
+		}
+
+		assert.Equal(t, test.expected, res, test.name)
 	}
 }
 
@@ -86,14 +134,315 @@ func TestSerializeOpenMsg(t *testing.T) {
 				0x00, // Opt. Param Length
 			},
 		},
+		{
+			name: "With capabilities",
+			input: &BGPOpen{
+				Version:       4,
+				AS:            ASTrans,
+				HoldTime:      120,
+				BGPIdentifier: convert.Uint32([]byte{100, 111, 120, 130}),
+				OptParams: []OptParam{
+					{
+						Type: CapabilitiesParamType,
+						Value: Capabilities{
+							{
+								Code:  MultiProtocolCapabilityCode,
+								Value: MultiProtocolCapability{AFI: 1, SAFI: 1},
+							},
+							{
+								Code:  ASN4CapabilityCode,
+								Value: ASN4Capability{ASN4: 4200000000},
+							},
+						},
+					},
+				},
+			},
+			expected: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0x00, 0x2b, // Length
+				0x01,       // Type
+				0x04,       // Version
+				0x5b, 0xa0, // ASN
+				0x00, 0x78, // Holdtime
+				130, 120, 111, 100, // BGP Identifier
+				0x0e,       // Opt. Param Length
+				0x02, 0x0c, // Capabilities parameter
+				0x01, 0x04, 0x00, 0x01, 0x00, 0x01, // Multiprotocol IPv4 unicast
+				0x41, 0x04, 0xfa, 0x56, 0xea, 0x00, // 4-octet AS 4200000000
+			},
+		},
 	}
 
 	for _, test := range tests {
-		res := SerializeOpenMsg(test.input)
+		res, err := SerializeOpenMsg(test.input)
+		if err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+			continue
+		}
+
 		assert.Equal(t, test.expected, res)
 	}
 }
 
+func TestSerializeOpenMsgRoundTrip(t *testing.T) {
+	o := &BGPOpen{
+		Version:       4,
+		AS:            ASTrans,
+		HoldTime:      90,
+		BGPIdentifier: convert.Uint32([]byte{1, 2, 0, 192}),
+		OptParmLen:    42, // Ignored
+	}
+	o.AddCapability(Capability{
+		Code:  ASN4CapabilityCode,
+		Value: ASN4Capability{ASN4: 4200000000},
+	})
+	o.AddCapability(Capability{
+		Code:  MultiProtocolCapabilityCode,
+		Value: MultiProtocolCapability{AFI: 1, SAFI: 1},
+	})
+	o.AddCapability(Capability{
+		Code: RouteRefreshCapabilityCode,
+	})
+
+	buf := bytes.NewBuffer(nil)
+	n, err := o.Serialize(buf)
+	if err != nil {
+		t.Fatalf("Unexpected failure: %v", err)
+	}
+	assert.Equal(t, uint16(buf.Len()), n)
+
+	res, err := decodeOpenMsg(buf)
+	if err != nil {
+		t.Fatalf("Unable to decode serialized OPEN: %v", err)
+	}
+
+	assert.Equal(t, uint16(16), res.OptParmLen)
+	assert.Equal(t, uint32(4200000000), res.ASN())
+	assert.Equal(t, Capabilities{
+		{
+			Code:   ASN4CapabilityCode,
+			Length: 4,
+			Value:  ASN4Capability{ASN4: 4200000000},
+		},
+		{
+			Code:   MultiProtocolCapabilityCode,
+			Length: 4,
+			Value:  MultiProtocolCapability{AFI: 1, SAFI: 1},
+		},
+		{
+			Code:   RouteRefreshCapabilityCode,
+			Length: 0,
+		},
+	}, res.Capabilities())
+}
+
+func TestSerializeOpenMsgExtendedOptParams(t *testing.T) {
+	o := &BGPOpen{
+		Version:       4,
+		AS:            65000,
+		HoldTime:      90,
+		BGPIdentifier: convert.Uint32([]byte{1, 2, 0, 192}),
+	}
+	for i := 0; i < 50; i++ {
+		o.AddCapability(Capability{
+			Code:  ASN4CapabilityCode,
+			Value: ASN4Capability{ASN4: uint32(i)},
+		})
+	}
+
+	buf := bytes.NewBuffer(nil)
+	_, err := o.Serialize(buf)
+	if err != nil {
+		t.Fatalf("Unexpected failure: %v", err)
+	}
+
+	res, err := decodeOpenMsg(buf)
+	if err != nil {
+		t.Fatalf("Unable to decode serialized OPEN: %v", err)
+	}
+
+	assert.Equal(t, uint16(303), res.OptParmLen)
+	assert.Equal(t, 50, len(res.Capabilities()))
+}
+
+func TestSerializeUpdateMsg(t *testing.T) {
+	// AS_PATH of 2 segments with 100 ASNs each needs an extended length
+	longASPath := []byte{
+		80, 2, 1, 148, // Attr. Flags, Type Code, Length 404
+	}
+	for i := 0; i < 2; i++ {
+		longASPath = append(longASPath, ASSequence, 100)
+		for j := 0; j < 100; j++ {
+			longASPath = append(longASPath, 253, uint8(j))
+		}
+	}
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{
+			name: "Withdraws only",
+			input: []byte{
+				0, 5, // Withdrawn Routes Length
+				8, 10, // 10.0.0.0/8
+				16, 192, 168, // 192.168.0.0/16
+				0, 0, // Total Path Attribute Length
+			},
+		},
+		{
+			name: "All attributes without withdraws",
+			input: []byte{
+				0, 0, // Withdrawn Routes Length
+				0, 52, // Total Path Attribute Length
+				64, 1, 1, 2, // ORIGIN: INCOMPLETE
+				64, 2, 12, // AS_PATH
+				2, 2, 59, 65, 12, 248, // AS_SEQUENCE: 15169 3320
+				1, 2, 59, 65, 12, 248, // AS_SET: 15169 3320
+				64, 3, 4, 10, 11, 12, 13, // NEXT_HOP
+				128, 4, 4, 0, 0, 1, 0, // MED 256
+				64, 5, 4, 0, 0, 1, 0, // LOCAL_PREF 256
+				64, 6, 0, // ATOMIC_AGGREGATE
+				192, 7, 6, 1, 2, 10, 11, 12, 13, // AGGREGATOR
+				8, 11, // 11.0.0.0/8
+				22, 192, 0, 4, // 192.0.4.0/22
+			},
+		},
+		{
+			name: "Empty NLRI",
+			input: []byte{
+				0, 2, // Withdrawn Routes Length
+				8, 10, // 10.0.0.0/8
+				0, 5, // Total Path Attribute Length
+				80, 1, 0, 1, 0, // ORIGIN: IGP, extended length
+			},
+		},
+		{
+			name:  "Extended length attribute",
+			input: append(append([]byte{0, 0, 1, 156, 64, 1, 1, 0}, longASPath...), 24, 192, 0, 2),
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := decodeUpdateMsg(bytes.NewBuffer(test.input), uint16(len(test.input)), &DecodeOptions{})
+		if err != nil {
+			t.Errorf("Unable to decode input of test %q: %v", test.name, err)
+			continue
+		}
+
+		buf := bytes.NewBuffer(nil)
+		n, err := msg.Serialize(buf)
+		if err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+			continue
+		}
+
+		assert.Equal(t, uint16(len(test.input)), n, test.name)
+		assert.Equal(t, test.input, buf.Bytes(), test.name)
+
+		res, err := decodeUpdateMsg(buf, n, &DecodeOptions{})
+		if err != nil {
+			t.Errorf("Unable to decode serialized UPDATE of test %q: %v", test.name, err)
+			continue
+		}
+
+		assert.Equal(t, msg, res, test.name)
+	}
+}
+
+func TestSerializePathAttrsExtendedLength(t *testing.T) {
+	asns := make([]uint32, 200)
+	for i := range asns {
+		asns[i] = 65000
+	}
+
+	res, err := serializePathAttrs(&PathAttribute{
+		Transitive: true,
+		TypeCode:   ASPathAttr,
+		Value: ASPath{
+			{
+				Type:  ASSequence,
+				Count: 200,
+				ASNs:  asns,
+			},
+		},
+	}, 2)
+	if err != nil {
+		t.Fatalf("Unexpected failure: %v", err)
+	}
+
+	assert.Equal(t, []byte{80, ASPathAttr, 1, 146}, res[:4])
+	assert.Equal(t, 4+402, len(res))
+}
+
+func TestSerialize(t *testing.T) {
+	msg := &BGPMessage{
+		Header: &BGPHeader{
+			Length: 31,
+			Type:   UpdateMsg,
+		},
+		Body: &BGPUpdate{
+			TotalPathAttrLen: 4,
+			PathAttributes: &PathAttribute{
+				Transitive: true,
+				Length:     1,
+				TypeCode:   OriginAttr,
+				Value:      uint8(IGP),
+			},
+			NLRI: &NLRI{
+				IP:     [4]byte{192, 0, 2, 0},
+				Pfxlen: 24,
+			},
+		},
+	}
+
+	res, err := Serialize(msg)
+	if err != nil {
+		t.Fatalf("Unexpected failure: %v", err)
+	}
+
+	decoded, err := Decode(bytes.NewBuffer(res), &DecodeOptions{})
+	if err != nil {
+		t.Fatalf("Unable to decode serialized message: %v", err)
+	}
+
+	assert.Equal(t, msg, decoded)
+}
+
+func TestSerializeAggregator(t *testing.T) {
+	attrs := &PathAttribute{
+		Optional:   true,
+		Transitive: true,
+		TypeCode:   AggregatorAttr,
+		Value: Aggretator{
+			Addr: [4]byte{10, 0, 0, 1},
+			ASN:  4200000000,
+		},
+	}
+
+	tests := []struct {
+		name        string
+		use32BitASN bool
+		expected    []byte
+	}{
+		{
+			name:     "2 octet ASN",
+			expected: []byte{192, AggregatorAttr, 6, 91, 160, 10, 0, 0, 1},
+		},
+		{
+			name:        "4 octet ASN",
+			use32BitASN: true,
+			expected:    []byte{192, AggregatorAttr, 8, 250, 86, 234, 0, 10, 0, 0, 1},
+		},
+	}
+
+	for _, test := range tests {
+		res, err := SerializePathAttributes(attrs, test.use32BitASN)
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, res, test.name)
+	}
+}
+
 func TestSerializeHeader(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -128,3 +477,121 @@ func TestSerializeHeader(t *testing.T) {
 		assert.Equal(t, test.expected, buf.Bytes())
 	}
 }
+
+func TestSerializePathAttributes(t *testing.T) {
+	attrs := &PathAttribute{
+		Transitive: true,
+		TypeCode:   ASPathAttr,
+		Value: ASPath{
+			{
+				Type:  ASSequence,
+				Count: 2,
+				ASNs:  []uint32{65000, 4200000000},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		use32BitASN bool
+		expected    []byte
+	}{
+		{
+			name:     "2 octet ASNs",
+			expected: []byte{64, ASPathAttr, 6, ASSequence, 2, 253, 232, 91, 160},
+		},
+		{
+			name:        "4 octet ASNs",
+			use32BitASN: true,
+			expected:    []byte{64, ASPathAttr, 10, ASSequence, 2, 0, 0, 253, 232, 250, 86, 234, 0},
+		},
+	}
+
+	for _, test := range tests {
+		res, err := SerializePathAttributes(attrs, test.use32BitASN)
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, res, test.name)
+	}
+}
+
+func TestSerializeUpdate(t *testing.T) {
+	aggregator := &PathAttribute{
+		Optional:   true,
+		Transitive: true,
+		TypeCode:   AggregatorAttr,
+		Value:      Aggretator{ASN: 4200000000, Addr: [4]byte{10, 0, 0, 1}},
+	}
+	msg := &BGPUpdate{
+		PathAttributes: &PathAttribute{
+			Transitive: true,
+			TypeCode:   ASPathAttr,
+			Value: ASPath{
+				{
+					Type:  ASSequence,
+					Count: 2,
+					ASNs:  []uint32{65000, 4200000000},
+				},
+			},
+			Next: aggregator,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		opt      EncodeOptions
+		expected []byte
+	}{
+		{
+			name: "2 octet ASNs",
+			expected: []byte{
+				0, 0, // Withdrawn Routes Length
+				0, 42, // Total Path Attribute Length
+				64, ASPathAttr, 6, ASSequence, 2, 253, 232, 91, 160,
+				192, AggregatorAttr, 6, 91, 160, 10, 0, 0, 1,
+				192, AS4PathAttr, 10, ASSequence, 2, 0, 0, 253, 232, 250, 86, 234, 0,
+				192, AS4AggregatorAttr, 8, 250, 86, 234, 0, 10, 0, 0, 1,
+			},
+		},
+		{
+			name: "4 octet ASNs",
+			opt:  EncodeOptions{Use32BitASN: true},
+			expected: []byte{
+				0, 0, // Withdrawn Routes Length
+				0, 24, // Total Path Attribute Length
+				64, ASPathAttr, 10, ASSequence, 2, 0, 0, 253, 232, 250, 86, 234, 0,
+				192, AggregatorAttr, 8, 250, 86, 234, 0, 10, 0, 0, 1,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		res, err := SerializeUpdate(msg, test.opt)
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, res[MinLen:], test.name)
+		assert.Nil(t, aggregator.Next, test.name)
+	}
+}
+
+func TestSerializeUpdateAddPath(t *testing.T) {
+	msg := &BGPUpdate{
+		WithdrawnRoutes: &NLRI{
+			PathIdentifier: 2,
+			IP:             [4]byte{192, 0, 2, 0},
+			Pfxlen:         24,
+		},
+		NLRI: &NLRI{
+			PathIdentifier: 1,
+			IP:             [4]byte{198, 51, 100, 0},
+			Pfxlen:         24,
+		},
+	}
+
+	res, err := SerializeUpdate(msg, EncodeOptions{AddPath: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{
+		0, 8, // Withdrawn Routes Length
+		0, 0, 0, 2, 24, 192, 0, 2,
+		0, 0, // Total Path Attribute Length
+		0, 0, 0, 1, 24, 198, 51, 100,
+	}, res[MinLen:])
+}
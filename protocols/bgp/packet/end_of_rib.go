@@ -0,0 +1,64 @@
+package packet
+
+// mpUnreachEndOfRIBLen is the length of a MP_UNREACH_NLRI attribute carrying
+// only AFI and SAFI
+const mpUnreachEndOfRIBLen = 3
+
+// EndOfRIB returns the End-of-RIB marker for an address family (RFC 4724, 2).
+// For IPv4 unicast this is an UPDATE without withdrawn routes, path attributes
+// and NLRI. All other address families use an UPDATE carrying only an empty
+// MP_UNREACH_NLRI attribute.
+func EndOfRIB(afi uint16, safi uint8) *BGPUpdate {
+	if afi == IPv4AFI && safi == UnicastSAFI {
+		return &BGPUpdate{}
+	}
+
+	return &BGPUpdate{
+		TotalPathAttrLen: mpUnreachEndOfRIBLen + 3,
+		PathAttributes: &PathAttribute{
+			Length:   mpUnreachEndOfRIBLen,
+			Optional: true,
+			TypeCode: MultiProtocolUnreachNLRIAttr,
+			Value: MultiProtocolUnreachNLRI{
+				AFI:  afi,
+				SAFI: safi,
+			},
+		},
+	}
+}
+
+// IsEndOfRIB checks if u is an End-of-RIB marker
+func (u *BGPUpdate) IsEndOfRIB() bool {
+	_, ok := u.EndOfRIBAddressFamily()
+	return ok
+}
+
+// EndOfRIBAddressFamily returns the address family u is the End-of-RIB marker
+// for. ok is false if u is no End-of-RIB marker. A MP_UNREACH_NLRI attribute
+// carrying anything but AFI and SAFI or any other attribute disqualifies an
+// UPDATE as marker.
+func (u *BGPUpdate) EndOfRIBAddressFamily() (af AddressFamily, ok bool) {
+	if u.WithdrawnRoutesLen != 0 || u.WithdrawnRoutes != nil || u.NLRI != nil {
+		return AddressFamily{}, false
+	}
+
+	pa := u.PathAttributes
+	if pa == nil {
+		if u.TotalPathAttrLen != 0 {
+			return AddressFamily{}, false
+		}
+
+		return AddressFamily{AFI: IPv4AFI, SAFI: UnicastSAFI}, true
+	}
+
+	if pa.Next != nil || pa.TypeCode != MultiProtocolUnreachNLRIAttr || pa.Length != mpUnreachEndOfRIBLen {
+		return AddressFamily{}, false
+	}
+
+	mp, ok := pa.Value.(MultiProtocolUnreachNLRI)
+	if !ok || mp.NLRI != nil {
+		return AddressFamily{}, false
+	}
+
+	return AddressFamily{AFI: mp.AFI, SAFI: mp.SAFI}, true
+}
@@ -0,0 +1,132 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndOfRIBAddressFamily(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected bool
+		af       AddressFamily
+	}{
+		{
+			name: "IPv4 unicast",
+			input: []byte{
+				0, 0, // Withdrawn routes length
+				0, 0, // Total path attribute length
+			},
+			expected: true,
+			af:       AddressFamily{AFI: IPv4AFI, SAFI: UnicastSAFI},
+		},
+		{
+			name: "IPv6 unicast",
+			input: []byte{
+				0, 0, // Withdrawn routes length
+				0, 6, // Total path attribute length
+				128, 15, 3, // Attribute flags, type and length
+				0, 2, // AFI
+				1, // SAFI
+			},
+			expected: true,
+			af:       AddressFamily{AFI: IPv6AFI, SAFI: UnicastSAFI},
+		},
+		{
+			name: "Withdraw only",
+			input: []byte{
+				0, 4, // Withdrawn routes length
+				24, 192, 0, 2, // 192.0.2.0/24
+				0, 0, // Total path attribute length
+			},
+			expected: false,
+		},
+		{
+			name: "MP_UNREACH_NLRI withdrawing routes",
+			input: []byte{
+				0, 0, // Withdrawn routes length
+				0, 11, // Total path attribute length
+				128, 15, 8, // Attribute flags, type and length
+				0, 2, // AFI
+				1,                          // SAFI
+				32, 0x20, 0x01, 0x0d, 0xb8, // 2001:db8::/32
+			},
+			expected: false,
+		},
+		{
+			name: "MP_UNREACH_NLRI and ORIGIN",
+			input: []byte{
+				0, 0, // Withdrawn routes length
+				0, 10, // Total path attribute length
+				64, 1, 1, 0, // ORIGIN
+				128, 15, 3, // Attribute flags, type and length
+				0, 2, // AFI
+				1, // SAFI
+			},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		u, err := decodeUpdateMsg(bytes.NewBuffer(test.input), uint16(len(test.input)), &DecodeOptions{})
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+
+		af, ok := u.EndOfRIBAddressFamily()
+		assert.Equal(t, test.expected, ok, test.name)
+		assert.Equal(t, test.af, af, test.name)
+		assert.Equal(t, test.expected, u.IsEndOfRIB(), test.name)
+	}
+}
+
+func TestEndOfRIBRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		afi      uint16
+		safi     uint8
+		expected []byte
+	}{
+		{
+			name: "IPv4 unicast",
+			afi:  IPv4AFI,
+			safi: UnicastSAFI,
+			expected: []byte{
+				0, 0, // Withdrawn routes length
+				0, 0, // Total path attribute length
+			},
+		},
+		{
+			name: "IPv6 unicast",
+			afi:  IPv6AFI,
+			safi: UnicastSAFI,
+			expected: []byte{
+				0, 0, // Withdrawn routes length
+				0, 6, // Total path attribute length
+				128, 15, 3, // Attribute flags, type and length
+				0, 2, // AFI
+				1, // SAFI
+			},
+		},
+	}
+
+	for _, test := range tests {
+		eor := EndOfRIB(test.afi, test.safi)
+		assert.True(t, eor.IsEndOfRIB(), test.name)
+
+		msg, err := SerializeUpdateMsg(eor)
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+		assert.Equal(t, test.expected, msg[HeaderLen:], test.name)
+
+		decoded, err := Decode(bytes.NewBuffer(msg), &DecodeOptions{})
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+		assert.Equal(t, eor, decoded.Body.(*BGPUpdate), test.name)
+	}
+}
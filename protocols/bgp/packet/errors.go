@@ -0,0 +1,53 @@
+package packet
+
+import (
+	"errors"
+	"fmt"
+)
+
+// BGPError is a violation of the BGP protocol. ErrorCode and ErrorSubCode are
+// the codes of the NOTIFICATION message to send to the peer.
+type BGPError struct {
+	ErrorCode    uint8
+	ErrorSubCode uint8
+	ErrorStr     string
+}
+
+func (b BGPError) Error() string {
+	return fmt.Sprintf("BGP error %d/%d: %s", b.ErrorCode, b.ErrorSubCode, b.ErrorStr)
+}
+
+// Code returns the error code of the NOTIFICATION message
+func (b BGPError) Code() uint8 {
+	return b.ErrorCode
+}
+
+// SubCode returns the error subcode of the NOTIFICATION message
+func (b BGPError) SubCode() uint8 {
+	return b.ErrorSubCode
+}
+
+// treatAsWithdrawErr is an error in a path attribute that only affects the
+// routes of the UPDATE carrying it (RFC 7606, 2). The attribute is discarded
+// and the routes are withdrawn while the session stays up.
+type treatAsWithdrawErr struct {
+	msg string
+}
+
+func (e treatAsWithdrawErr) Error() string {
+	return e.msg
+}
+
+// isTreatAsWithdraw checks if err requires the routes of an UPDATE to be
+// treated as withdrawn
+func isTreatAsWithdraw(err error) bool {
+	var e treatAsWithdrawErr
+	return errors.As(err, &e)
+}
+
+// AsBGPError returns the first BGPError in the chain of wrapped errors of err.
+// ok is false if there is none.
+func AsBGPError(err error) (b BGPError, ok bool) {
+	ok = errors.As(err, &b)
+	return b, ok
+}
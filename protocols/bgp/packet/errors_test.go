@@ -0,0 +1,88 @@
+package packet
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBGPErrorString(t *testing.T) {
+	err := BGPError{
+		ErrorCode:    UpdateMessageError,
+		ErrorSubCode: MalformedASPath,
+		ErrorStr:     "Invalid AS Path segment type: 5",
+	}
+
+	assert.Equal(t, "BGP error 3/11: Invalid AS Path segment type: 5", err.Error())
+	assert.Equal(t, uint8(UpdateMessageError), err.Code())
+	assert.Equal(t, uint8(MalformedASPath), err.SubCode())
+}
+
+func TestAsBGPError(t *testing.T) {
+	bgperr := BGPError{
+		ErrorCode:    UpdateMessageError,
+		ErrorSubCode: AttrLengthError,
+		ErrorStr:     "Invalid ORIGIN length: 2",
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "Plain BGPError",
+			err:      bgperr,
+			expected: true,
+		},
+		{
+			name:     "Wrapped once",
+			err:      fmt.Errorf("Failed to decode Origin: %w", bgperr),
+			expected: true,
+		},
+		{
+			name:     "Wrapped twice",
+			err:      fmt.Errorf("Unable to decode path attr: %w", fmt.Errorf("Failed to decode Origin: %w", bgperr)),
+			expected: true,
+		},
+		{
+			name: "Formatted without wrapping",
+			err:  fmt.Errorf("Failed to decode Origin: %v", bgperr),
+		},
+		{
+			name: "Other error",
+			err:  fmt.Errorf("Unable to read"),
+		},
+		{
+			name: "nil",
+		},
+	}
+
+	for _, test := range tests {
+		res, ok := AsBGPError(test.err)
+		assert.Equal(t, test.expected, ok, test.name)
+		if test.expected {
+			assert.Equal(t, bgperr, res, test.name)
+		}
+	}
+}
+
+func TestAsBGPErrorFromDecoder(t *testing.T) {
+	input := []byte{
+		64, 1, 2, // ORIGIN with invalid length
+		0, 0,
+	}
+
+	_, err := decodePathAttrs(bytes.NewBuffer(input), uint16(len(input)), &DecodeOptions{})
+	if !assert.Error(t, err) {
+		return
+	}
+
+	bgperr, ok := AsBGPError(err)
+	if assert.True(t, ok) {
+		assert.Equal(t, uint8(UpdateMessageError), bgperr.Code())
+		assert.Equal(t, uint8(AttrLengthError), bgperr.SubCode())
+	}
+}
@@ -0,0 +1,95 @@
+package packet
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/taktv6/tflow2/convert"
+)
+
+const (
+	extendedCommunityLen = 8
+
+	// Extended community types (high-order octet) as of RFC 4360
+	TransitiveTwoOctetASSpecific = 0x00
+	TransitiveIPv4AddrSpecific   = 0x01
+	TransitiveOpaque             = 0x03
+
+	// Extended community sub-types as of RFC 4360
+	RouteTargetSubType = 0x02
+	RouteOriginSubType = 0x03
+)
+
+// ExtendedCommunity is an 8 octet extended community (RFC 4360)
+type ExtendedCommunity [extendedCommunityLen]byte
+
+// Type returns the type field of c with the IANA authority and transitive bits
+func (c ExtendedCommunity) Type() uint8 {
+	return c[0]
+}
+
+// SubType returns the sub-type field of c
+func (c ExtendedCommunity) SubType() uint8 {
+	return c[1]
+}
+
+// IsTransitive checks if c is to be propagated across Autonomous Systems
+func (c ExtendedCommunity) IsTransitive() bool {
+	return c[0]&0x40 == 0
+}
+
+// TwoOctetASRouteTarget returns the global administrator (ASN) and the local
+// administrator (assigned number) of a two-octet AS specific route target.
+// ok is false if c is not a two-octet AS specific route target.
+func (c ExtendedCommunity) TwoOctetASRouteTarget() (asn uint16, assigned uint32, ok bool) {
+	if c.Type() != TransitiveTwoOctetASSpecific || c.SubType() != RouteTargetSubType {
+		return 0, 0, false
+	}
+
+	return convert.Uint16b(c[2:4]), convert.Uint32b(c[4:8]), true
+}
+
+// TwoOctetASRouteTarget returns the two-octet AS specific route target with
+// the global administrator asn and the local administrator assigned
+func TwoOctetASRouteTarget(asn uint16, assigned uint32) ExtendedCommunity {
+	c := ExtendedCommunity{TransitiveTwoOctetASSpecific, RouteTargetSubType}
+	copy(c[2:4], convert.Uint16Byte(asn))
+	copy(c[4:8], convert.Uint32Byte(assigned))
+	return c
+}
+
+// String returns the human readable representation of c
+func (c ExtendedCommunity) String() string {
+	if asn, assigned, ok := c.TwoOctetASRouteTarget(); ok {
+		return fmt.Sprintf("target:%d:%d", asn, assigned)
+	}
+
+	return fmt.Sprintf("%#x", c[:])
+}
+
+// decodeExtendedCommunities decodes an EXTENDED_COMMUNITIES attribute. More
+// than max communities are discarded and the UPDATE is treated as withdraw.
+func (pa *PathAttribute) decodeExtendedCommunities(buf *bytes.Buffer, max int) error {
+	if pa.Length%extendedCommunityLen != 0 {
+		return attrLengthErr(fmt.Sprintf("Invalid EXTENDED_COMMUNITIES length: %d", pa.Length))
+	}
+
+	if int(pa.Length/extendedCommunityLen) > max {
+		buf.Next(int(pa.Length))
+		return treatAsWithdrawErr{msg: fmt.Sprintf("%d EXTENDED_COMMUNITIES exceed the maximum of %d", pa.Length/extendedCommunityLen, max)}
+	}
+
+	comms := make([]ExtendedCommunity, pa.Length/extendedCommunityLen)
+	for i := range comms {
+		n, err := buf.Read(comms[i][:])
+		if err != nil {
+			return err
+		}
+		if n != extendedCommunityLen {
+			return fmt.Errorf("Unable to read extended community: buf.Read read %d bytes", n)
+		}
+	}
+
+	pa.Value = comms
+	return nil
+}
@@ -0,0 +1,115 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeExtendedCommunities(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		wantFail bool
+		expected *PathAttribute
+	}{
+		{
+			name: "Route target 65000:1",
+			input: []byte{
+				192, 16, 8, // Attribute flags, type and length
+				0, 2, 253, 232, 0, 0, 0, 1, // target:65000:1
+			},
+			wantFail: false,
+			expected: &PathAttribute{
+				Length:     8,
+				Optional:   true,
+				Transitive: true,
+				TypeCode:   ExtendedCommunitiesAttr,
+				Value: []ExtendedCommunity{
+					{0, 2, 253, 232, 0, 0, 0, 1},
+				},
+			},
+		},
+		{
+			name: "Invalid length",
+			input: []byte{
+				192, 16, 7,
+				0, 2, 253, 232, 0, 0, 0,
+			},
+			wantFail: true,
+		},
+		{
+			name: "Invalid flags",
+			input: []byte{
+				128, 16, 8,
+				0, 2, 253, 232, 0, 0, 0, 1,
+			},
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		pa, _, err := decodePathAttr(bytes.NewBuffer(test.input), &DecodeOptions{})
+
+		if test.wantFail && err == nil {
+			t.Errorf("Expected error did not happen for test %q", test.name)
+		}
+
+		if !test.wantFail && err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		assert.Equal(t, test.expected, pa)
+	}
+}
+
+func TestExtendedCommunityRouteTarget(t *testing.T) {
+	tests := []struct {
+		name         string
+		community    ExtendedCommunity
+		wantOK       bool
+		wantType     uint8
+		wantASN      uint16
+		wantAssigned uint32
+		wantString   string
+	}{
+		{
+			name:         "target:65000:1",
+			community:    ExtendedCommunity{0, 2, 253, 232, 0, 0, 0, 1},
+			wantOK:       true,
+			wantType:     TransitiveTwoOctetASSpecific,
+			wantASN:      65000,
+			wantAssigned: 1,
+			wantString:   "target:65000:1",
+		},
+		{
+			name:       "Route origin",
+			community:  ExtendedCommunity{0, 3, 253, 232, 0, 0, 0, 1},
+			wantOK:     false,
+			wantType:   TransitiveTwoOctetASSpecific,
+			wantString: "0x0003fde800000001",
+		},
+		{
+			name:       "IPv4 address specific route target",
+			community:  ExtendedCommunity{1, 2, 192, 0, 2, 1, 0, 1},
+			wantOK:     false,
+			wantType:   TransitiveIPv4AddrSpecific,
+			wantString: "0x0102c00002010001",
+		},
+	}
+
+	for _, test := range tests {
+		asn, assigned, ok := test.community.TwoOctetASRouteTarget()
+		assert.Equal(t, test.wantOK, ok, test.name)
+		assert.Equal(t, test.wantType, test.community.Type(), test.name)
+		assert.Equal(t, test.wantASN, asn, test.name)
+		assert.Equal(t, test.wantAssigned, assigned, test.name)
+		assert.Equal(t, test.wantString, test.community.String(), test.name)
+		assert.True(t, test.community.IsTransitive(), test.name)
+	}
+}
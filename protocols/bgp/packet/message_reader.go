@@ -0,0 +1,118 @@
+package packet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// MessageReader decodes a stream of BGP messages, e.g. read from a TCP
+// connection. Data is buffered until a message is complete, so messages may
+// be split across reads arbitrarily.
+type MessageReader struct {
+	r   io.Reader
+	opt *DecodeOptions
+	buf []byte
+	err error
+}
+
+// NewMessageReader creates a MessageReader reading from r
+func NewMessageReader(r io.Reader, opt *DecodeOptions) *MessageReader {
+	return &MessageReader{
+		r:   r,
+		opt: opt,
+		buf: make([]byte, 0, MaxLen),
+	}
+}
+
+// Next returns the next message of the stream. It returns io.EOF if the
+// stream ended at a message boundary and io.ErrUnexpectedEOF if it ended
+// within a message.
+//
+// Errors in the message header or body are returned as BGPError. The bytes in
+// error are discarded, so the stream position stays consistent and Next may
+// be called again. An invalid marker discards data up to the next possible
+// start of a marker.
+func (m *MessageReader) Next() (*BGPMessage, error) {
+	for {
+		msg, ok, err := m.decode()
+		if ok || err != nil {
+			return msg, err
+		}
+
+		if m.err != nil {
+			if m.err == io.EOF && len(m.buf) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, m.err
+		}
+
+		m.fill()
+	}
+}
+
+// decode decodes the first message in the buffer. ok is false if the buffer
+// does not yet hold a complete message.
+func (m *MessageReader) decode() (msg *BGPMessage, ok bool, err error) {
+	if len(m.buf) < MinLen {
+		return nil, false, nil
+	}
+
+	for i := 0; i < MarkerLen; i++ {
+		if m.buf[i] != 255 {
+			err := BGPError{
+				ErrorCode:    MessageHeaderError,
+				ErrorSubCode: ConnectionNotSync,
+				ErrorStr:     fmt.Sprintf("Invalid marker: %v", m.buf[:MarkerLen]),
+			}
+			m.resync()
+			return nil, false, err
+		}
+	}
+
+	l := int(m.buf[MarkerLen])<<8 | int(m.buf[MarkerLen+1])
+	if l < MinLen || l > MaxLen {
+		m.consume(MinLen)
+		return nil, false, BGPError{
+			ErrorCode:    MessageHeaderError,
+			ErrorSubCode: BadMessageLength,
+			ErrorStr:     fmt.Sprintf("Invalid length in BGP header: %d", l),
+		}
+	}
+
+	if len(m.buf) < l {
+		return nil, false, nil
+	}
+
+	msg, err = Decode(bytes.NewBuffer(m.buf[:l]), m.opt)
+	m.consume(l)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return msg, true, nil
+}
+
+// fill reads from the underlying reader into the free space of the buffer
+func (m *MessageReader) fill() {
+	n, err := m.r.Read(m.buf[len(m.buf):cap(m.buf)])
+	m.buf = m.buf[:len(m.buf)+n]
+	if err != nil {
+		m.err = err
+	}
+}
+
+// resync drops the invalid marker up to the next byte which may start a marker
+func (m *MessageReader) resync() {
+	i := 1
+	for i < len(m.buf) && m.buf[i] != 255 {
+		i++
+	}
+
+	m.consume(i)
+}
+
+// consume removes the first n bytes from the buffer
+func (m *MessageReader) consume(n int) {
+	m.buf = m.buf[:copy(m.buf, m.buf[n:])]
+}
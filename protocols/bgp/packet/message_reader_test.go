@@ -0,0 +1,151 @@
+package packet
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// chunkReader returns one chunk per call to Read
+type chunkReader struct {
+	chunks [][]byte
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, c.chunks[0])
+	c.chunks[0] = c.chunks[0][n:]
+	if len(c.chunks[0]) == 0 {
+		c.chunks = c.chunks[1:]
+	}
+
+	return n, nil
+}
+
+func bytewise(b []byte) [][]byte {
+	chunks := make([][]byte, len(b))
+	for i := range b {
+		chunks[i] = b[i : i+1]
+	}
+
+	return chunks
+}
+
+func concat(b ...[]byte) []byte {
+	res := make([]byte, 0)
+	for _, x := range b {
+		res = append(res, x...)
+	}
+
+	return res
+}
+
+func TestMessageReader(t *testing.T) {
+	marker := []byte{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255}
+	keepalive := concat(marker, []byte{0, 19, KeepaliveMsg})
+	notification := concat(marker, []byte{0, 21, NotificationMsg, Cease, 2})
+
+	keepaliveMsg := &BGPMessage{
+		Header: &BGPHeader{
+			Length: 19,
+			Type:   KeepaliveMsg,
+		},
+	}
+	notificationMsg := &BGPMessage{
+		Header: &BGPHeader{
+			Length: 21,
+			Type:   NotificationMsg,
+		},
+		Body: &BGPNotification{
+			ErrorCode:    Cease,
+			ErrorSubcode: 2,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		chunks   [][]byte
+		expected []*BGPMessage
+		errors   []error
+		final    error
+	}{
+		{
+			name:     "Two messages in one read",
+			chunks:   [][]byte{concat(keepalive, notification)},
+			expected: []*BGPMessage{keepaliveMsg, notificationMsg},
+			errors:   []error{nil, nil},
+			final:    io.EOF,
+		},
+		{
+			name: "Two messages split across three reads",
+			chunks: [][]byte{
+				keepalive[:10],
+				concat(keepalive[10:], notification[:17]),
+				notification[17:],
+			},
+			expected: []*BGPMessage{keepaliveMsg, notificationMsg},
+			errors:   []error{nil, nil},
+			final:    io.EOF,
+		},
+		{
+			name:     "One byte at a time",
+			chunks:   bytewise(concat(notification, keepalive)),
+			expected: []*BGPMessage{notificationMsg, keepaliveMsg},
+			errors:   []error{nil, nil},
+			final:    io.EOF,
+		},
+		{
+			name:     "Stream ends within a message",
+			chunks:   [][]byte{keepalive, notification[:20]},
+			expected: []*BGPMessage{keepaliveMsg},
+			errors:   []error{nil},
+			final:    io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "Invalid marker",
+			chunks:   [][]byte{{1, 2, 3}, concat(keepalive, notification)},
+			expected: []*BGPMessage{nil, keepaliveMsg, notificationMsg},
+			errors: []error{
+				BGPError{
+					ErrorCode:    MessageHeaderError,
+					ErrorSubCode: ConnectionNotSync,
+					ErrorStr:     "Invalid marker: [1 2 3 255 255 255 255 255 255 255 255 255 255 255 255 255]",
+				},
+				nil,
+				nil,
+			},
+			final: io.EOF,
+		},
+		{
+			name:     "Invalid length",
+			chunks:   [][]byte{concat(marker, []byte{0, 18, KeepaliveMsg}), keepalive},
+			expected: []*BGPMessage{nil, keepaliveMsg},
+			errors: []error{
+				BGPError{
+					ErrorCode:    MessageHeaderError,
+					ErrorSubCode: BadMessageLength,
+					ErrorStr:     "Invalid length in BGP header: 18",
+				},
+				nil,
+			},
+			final: io.EOF,
+		},
+	}
+
+	for _, test := range tests {
+		r := NewMessageReader(&chunkReader{chunks: test.chunks}, nil)
+
+		for i := range test.expected {
+			msg, err := r.Next()
+			assert.Equal(t, test.errors[i], err, test.name)
+			assert.Equal(t, test.expected[i], msg, test.name)
+		}
+
+		_, err := r.Next()
+		assert.Equal(t, test.final, err, test.name)
+	}
+}
@@ -0,0 +1,110 @@
+package packet
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+)
+
+func (pa *PathAttribute) decodeMultiProtocolReachNLRI(buf *bytes.Buffer, opt *DecodeOptions) error {
+	mp := MultiProtocolReachNLRI{}
+	nextHopLen := uint8(0)
+
+	if pa.Length < 5 {
+		return attrLengthErr(fmt.Sprintf("Invalid MP_REACH_NLRI length: %d", pa.Length))
+	}
+
+	err := decode(buf, []interface{}{&mp.AFI, &mp.SAFI, &nextHopLen})
+	if err != nil {
+		return err
+	}
+	p := uint16(4)
+
+	if !supportedAddressFamily(mp.AFI, mp.SAFI) {
+		pa.Value = mp
+		return dumpNBytes(buf, pa.Length-p)
+	}
+
+	if uint16(nextHopLen)+1 > pa.Length-p {
+		return attrLengthErr(fmt.Sprintf("MP_REACH_NLRI next hop length %d exceeds attribute length", nextHopLen))
+	}
+
+	nextHop := make([]byte, nextHopLen)
+	err = decode(buf, []interface{}{&nextHop})
+	if err != nil {
+		return err
+	}
+	p += uint16(nextHopLen)
+
+	mp.NextHop, mp.LinkLocalNextHop, err = decodeMultiProtocolNextHop(mp.AFI, nextHop)
+	if err != nil {
+		return err
+	}
+
+	// Reserved octet
+	err = dumpNBytes(buf, 1)
+	if err != nil {
+		return err
+	}
+	p++
+
+	mp.NLRI, err = decodeNLRIs(buf, pa.Length-p, mp.AFI, opt.addPath(mp.AFI, mp.SAFI))
+	if err != nil {
+		return err
+	}
+
+	pa.Value = mp
+	return nil
+}
+
+// supportedAddressFamily checks if the NLRI of an address family can be
+// decoded. The NLRI of other address families are skipped, leaving only AFI
+// and SAFI of MP_REACH_NLRI and MP_UNREACH_NLRI set.
+func supportedAddressFamily(afi uint16, safi uint8) bool {
+	return safi == UnicastSAFI && (afi == IPv4AFI || afi == IPv6AFI)
+}
+
+// decodeMultiProtocolNextHop splits the next hop field of MP_REACH_NLRI. An IPv6 next hop
+// may carry a global and a link-local address (RFC 2545).
+func decodeMultiProtocolNextHop(afi uint16, nextHop []byte) (global net.IP, linkLocal net.IP, err error) {
+	switch {
+	case afi == IPv4AFI && len(nextHop) == net.IPv4len:
+		return net.IP(nextHop), nil, nil
+	case afi == IPv6AFI && len(nextHop) == net.IPv6len:
+		return net.IP(nextHop), nil, nil
+	case afi == IPv6AFI && len(nextHop) == 2*net.IPv6len:
+		return net.IP(nextHop[:net.IPv6len]), net.IP(nextHop[net.IPv6len:]), nil
+	}
+
+	return nil, nil, BGPError{
+		ErrorCode:    UpdateMessageError,
+		ErrorSubCode: OptionalAttrError,
+		ErrorStr:     fmt.Sprintf("Invalid next hop length %d for AFI %d", len(nextHop), afi),
+	}
+}
+
+func (pa *PathAttribute) decodeMultiProtocolUnreachNLRI(buf *bytes.Buffer, opt *DecodeOptions) error {
+	mp := MultiProtocolUnreachNLRI{}
+
+	if pa.Length < 3 {
+		return attrLengthErr(fmt.Sprintf("Invalid MP_UNREACH_NLRI length: %d", pa.Length))
+	}
+
+	err := decode(buf, []interface{}{&mp.AFI, &mp.SAFI})
+	if err != nil {
+		return err
+	}
+
+	if !supportedAddressFamily(mp.AFI, mp.SAFI) {
+		pa.Value = mp
+		return dumpNBytes(buf, pa.Length-3)
+	}
+
+	mp.NLRI, err = decodeNLRIs(buf, pa.Length-3, mp.AFI, opt.addPath(mp.AFI, mp.SAFI))
+	if err != nil {
+		return err
+	}
+
+	pa.Value = mp
+	return nil
+}
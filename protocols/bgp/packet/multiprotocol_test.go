@@ -0,0 +1,163 @@
+package packet
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeMultiProtocolReachNLRI(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		wantFail bool
+		expected MultiProtocolReachNLRI
+	}{
+		{
+			name: "IPv6 global next hop",
+			input: []byte{
+				128, 14, 26, // Attribute flags, type and length
+				0, 2, // AFI
+				1,                                                          // SAFI
+				16,                                                         // Next hop length
+				0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, // 2001:db8::1
+				0,                          // Reserved
+				32, 0x20, 0x01, 0x0d, 0xb8, // 2001:db8::/32
+			},
+			expected: MultiProtocolReachNLRI{
+				AFI:     IPv6AFI,
+				SAFI:    UnicastSAFI,
+				NextHop: net.ParseIP("2001:db8::1"),
+				NLRI: &NLRI{
+					IP:     [16]byte{0x20, 0x01, 0x0d, 0xb8},
+					Pfxlen: 32,
+				},
+			},
+		},
+		{
+			name: "IPv6 global and link-local next hop",
+			input: []byte{
+				128, 14, 42, // Attribute flags, type and length
+				0, 2, // AFI
+				1,                                                          // SAFI
+				32,                                                         // Next hop length
+				0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, // 2001:db8::1
+				0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, // fe80::1
+				0,                          // Reserved
+				32, 0x20, 0x01, 0x0d, 0xb8, // 2001:db8::/32
+			},
+			expected: MultiProtocolReachNLRI{
+				AFI:              IPv6AFI,
+				SAFI:             UnicastSAFI,
+				NextHop:          net.ParseIP("2001:db8::1"),
+				LinkLocalNextHop: net.ParseIP("fe80::1"),
+				NLRI: &NLRI{
+					IP:     [16]byte{0x20, 0x01, 0x0d, 0xb8},
+					Pfxlen: 32,
+				},
+			},
+		},
+		{
+			name: "Invalid next hop length",
+			input: []byte{
+				128, 14, 14, // Attribute flags, type and length
+				0, 2, // AFI
+				1, // SAFI
+				4, // Next hop length
+				192, 0, 2, 1,
+				0,                          // Reserved
+				32, 0x20, 0x01, 0x0d, 0xb8, // 2001:db8::/32
+			},
+			wantFail: true,
+		},
+		{
+			name: "Next hop exceeds attribute",
+			input: []byte{
+				128, 14, 8, // Attribute flags, type and length
+				0, 2, // AFI
+				1,  // SAFI
+				16, // Next hop length
+				0x20, 0x01, 0x0d, 0xb8,
+			},
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		pa, _, err := decodePathAttr(bytes.NewBuffer(test.input), &DecodeOptions{})
+
+		if test.wantFail && err == nil {
+			t.Errorf("Expected error did not happen for test %q", test.name)
+		}
+
+		if !test.wantFail && err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		assert.Equal(t, test.expected, pa.Value, test.name)
+	}
+}
+
+func TestDecodeMultiProtocolUnreachNLRI(t *testing.T) {
+	input := []byte{
+		128, 15, 8, // Attribute flags, type and length
+		0, 2, // AFI
+		1,                          // SAFI
+		32, 0x20, 0x01, 0x0d, 0xb8, // 2001:db8::/32
+	}
+
+	pa, _, err := decodePathAttr(bytes.NewBuffer(input), &DecodeOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected failure: %v", err)
+	}
+
+	assert.Equal(t, MultiProtocolUnreachNLRI{
+		AFI:  IPv6AFI,
+		SAFI: UnicastSAFI,
+		NLRI: &NLRI{
+			IP:     [16]byte{0x20, 0x01, 0x0d, 0xb8},
+			Pfxlen: 32,
+		},
+	}, pa.Value)
+}
+
+func TestDecodeMultiProtocolUnsupportedAddressFamily(t *testing.T) {
+	input := []byte{
+		128, 14, 21, // Attribute flags, type and length
+		0, 1, // AFI
+		128,                                  // SAFI (MPLS-labeled VPN)
+		12,                                   // Next hop length
+		0, 0, 0, 0, 0, 0, 0, 0, 192, 0, 2, 1, // Route distinguisher and next hop
+		0,          // Reserved
+		0, 0, 0, 0, // NLRI
+		128, 15, 7, // Attribute flags, type and length
+		0, 1, // AFI
+		128,        // SAFI
+		0, 0, 0, 0, // NLRI
+		64, 1, 1, // Attribute flags, type and length
+		IGP, // ORIGIN
+	}
+
+	pa, err := decodePathAttrs(bytes.NewBuffer(input), uint16(len(input)), &DecodeOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, MultiProtocolReachNLRI{AFI: IPv4AFI, SAFI: 128}, pa.Value)
+	if !assert.NotNil(t, pa.Next) {
+		return
+	}
+	assert.Equal(t, MultiProtocolUnreachNLRI{AFI: IPv4AFI, SAFI: 128}, pa.Next.Value)
+	if !assert.NotNil(t, pa.Next.Next) {
+		return
+	}
+	assert.Equal(t, uint8(OriginAttr), pa.Next.Next.TypeCode)
+	assert.Equal(t, uint8(IGP), pa.Next.Next.Value)
+	assert.Nil(t, pa.Next.Next.Next)
+}
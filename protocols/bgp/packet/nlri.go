@@ -3,11 +3,10 @@ package packet
 import (
 	"bytes"
 	"fmt"
-	"math"
 	"net"
 )
 
-func decodeNLRIs(buf *bytes.Buffer, length uint16) (*NLRI, error) {
+func decodeNLRIs(buf *bytes.Buffer, length uint16, afi uint16, addPath bool) (*NLRI, error) {
 	var ret *NLRI
 	var eol *NLRI
 	var nlri *NLRI
@@ -16,9 +15,9 @@ func decodeNLRIs(buf *bytes.Buffer, length uint16) (*NLRI, error) {
 	p := uint16(0)
 
 	for p < length {
-		nlri, consumed, err = decodeNLRI(buf)
+		nlri, consumed, err = decodeNLRI(buf, afi, addPath, length-p)
 		if err != nil {
-			return nil, fmt.Errorf("Unable to decode NLRI: %v", err)
+			return nil, fmt.Errorf("Unable to decode NLRI: %w", err)
 		}
 		p += uint16(consumed)
 
@@ -35,26 +34,78 @@ func decodeNLRIs(buf *bytes.Buffer, length uint16) (*NLRI, error) {
 	return ret, nil
 }
 
-func decodeNLRI(buf *bytes.Buffer) (*NLRI, uint8, error) {
-	var addr [4]byte
+// decodeNLRI decodes a single prefix of at most remaining octets. The address of an IPv4
+// prefix is stored as [4]byte, the address of an IPv6 prefix as [16]byte. Bits beyond the
+// prefix length are cleared. If addPath is set the prefix is preceded by a path identifier
+// (RFC 7911).
+func decodeNLRI(buf *bytes.Buffer, afi uint16, addPath bool, remaining uint16) (*NLRI, uint8, error) {
+	addrLen := net.IPv4len
+	if afi == IPv6AFI {
+		addrLen = net.IPv6len
+	}
+
+	var addr [net.IPv6len]byte
 	nlri := &NLRI{}
+	consumed := uint8(0)
+
+	if addPath {
+		if remaining < 4 {
+			return nil, 0, invalidNetworkFieldErr("Path identifier exceeds NLRI length")
+		}
+
+		err := decode(buf, []interface{}{&nlri.PathIdentifier})
+		if err != nil {
+			return nil, 0, err
+		}
+		consumed += 4
+	}
+
+	if remaining < uint16(consumed)+1 {
+		return nil, 0, invalidNetworkFieldErr("Prefix length exceeds NLRI length")
+	}
 
 	err := decode(buf, []interface{}{&nlri.Pfxlen})
 	if err != nil {
 		return nil, 0, err
 	}
+	consumed++
 
-	toCopy := uint8(math.Ceil(float64(nlri.Pfxlen) / float64(OctetLen)))
-	for i := uint8(0); i < net.IPv4len%OctetLen; i++ {
-		if i < toCopy {
-			err := decode(buf, []interface{}{&addr[i]})
-			if err != nil {
-				return nil, 0, err
-			}
-		} else {
-			addr[i] = 0
-		}
+	if int(nlri.Pfxlen) > addrLen*OctetLen {
+		return nil, 0, invalidNetworkFieldErr(fmt.Sprintf("Invalid prefix length: %d", nlri.Pfxlen))
+	}
+
+	toCopy := (nlri.Pfxlen + OctetLen - 1) / OctetLen
+	if remaining < uint16(consumed)+uint16(toCopy) {
+		return nil, 0, invalidNetworkFieldErr(fmt.Sprintf("Prefix of length %d exceeds NLRI length", nlri.Pfxlen))
+	}
+
+	n, err := buf.Read(addr[:toCopy])
+	if err != nil && toCopy > 0 {
+		return nil, 0, err
+	}
+	if n != int(toCopy) {
+		return nil, 0, fmt.Errorf("Unable to read prefix: buf.Read read %d bytes", n)
+	}
+
+	if bits := nlri.Pfxlen % OctetLen; bits != 0 {
+		addr[toCopy-1] &= ^byte(0) << (OctetLen - bits)
+	}
+
+	if afi == IPv6AFI {
+		nlri.IP = addr
+	} else {
+		var addr4 [net.IPv4len]byte
+		copy(addr4[:], addr[:])
+		nlri.IP = addr4
+	}
+
+	return nlri, consumed + toCopy, nil
+}
+
+func invalidNetworkFieldErr(msg string) error {
+	return BGPError{
+		ErrorCode:    UpdateMessageError,
+		ErrorSubCode: InvalidNetworkField,
+		ErrorStr:     msg,
 	}
-	nlri.IP = addr
-	return nlri, toCopy + 1, nil
 }
@@ -2,6 +2,7 @@ package packet
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -48,7 +49,7 @@ func TestDecodeNLRIs(t *testing.T) {
 
 	for _, test := range tests {
 		buf := bytes.NewBuffer(test.input)
-		res, err := decodeNLRIs(buf, uint16(len(test.input)))
+		res, err := decodeNLRIs(buf, uint16(len(test.input)), IPv4AFI, false)
 
 		if test.wantFail && err == nil {
 			t.Errorf("Expected error did not happen for test %q", test.name)
@@ -62,12 +63,52 @@ func TestDecodeNLRIs(t *testing.T) {
 	}
 }
 
+func TestDecodeUpdateMsgAddPath(t *testing.T) {
+	input := []byte{
+		0, 8, // Withdrawn Routes Length
+		0, 0, 0, 3, 24, 10, 0, 1, // 10.0.1.0/24 path ID 3
+		0, 4, // Total Path Attribute Length
+		64, 1, 1, 0, // ORIGIN
+		0, 0, 0, 1, 24, 10, 0, 0, // 10.0.0.0/24 path ID 1
+		0, 0, 0, 2, 24, 10, 0, 0, // 10.0.0.0/24 path ID 2
+	}
+
+	opt := &DecodeOptions{
+		AddPath: map[AddressFamily]bool{
+			{AFI: IPv4AFI, SAFI: UnicastSAFI}: true,
+		},
+	}
+
+	msg, err := decodeUpdateMsg(bytes.NewBuffer(input), uint16(len(input)), opt)
+	if err != nil {
+		t.Fatalf("Unexpected failure: %v", err)
+	}
+
+	assert.Equal(t, &NLRI{
+		PathIdentifier: 3,
+		IP:             [4]byte{10, 0, 1, 0},
+		Pfxlen:         24,
+	}, msg.WithdrawnRoutes)
+
+	assert.Equal(t, &NLRI{
+		PathIdentifier: 1,
+		IP:             [4]byte{10, 0, 0, 0},
+		Pfxlen:         24,
+		Next: &NLRI{
+			PathIdentifier: 2,
+			IP:             [4]byte{10, 0, 0, 0},
+			Pfxlen:         24,
+		},
+	}, msg.NLRI)
+}
+
 func TestDecodeNLRI(t *testing.T) {
 	tests := []struct {
-		name     string
-		input    []byte
-		wantFail bool
-		expected *NLRI
+		name           string
+		input          []byte
+		explicitLength uint16
+		wantFail       bool
+		expected       *NLRI
 	}{
 		{
 			name: "Valid NRLI #1",
@@ -110,11 +151,42 @@ func TestDecodeNLRI(t *testing.T) {
 			input:    []byte{},
 			wantFail: true,
 		},
+		{
+			name: "Non-zero trailing bits",
+			input: []byte{
+				25, 10, 1, 1, 129,
+			},
+			wantFail: false,
+			expected: &NLRI{
+				IP:     [4]byte{10, 1, 1, 128},
+				Pfxlen: 25,
+			},
+		},
+		{
+			name: "Prefix length exceeds 32",
+			input: []byte{
+				33, 10, 1, 1, 0, 0,
+			},
+			wantFail: true,
+		},
+		{
+			name: "Truncated buffer",
+			input: []byte{
+				24, 10, 1,
+			},
+			explicitLength: 4,
+			wantFail:       true,
+		},
 	}
 
 	for _, test := range tests {
+		l := uint16(len(test.input))
+		if test.explicitLength != 0 {
+			l = test.explicitLength
+		}
+
 		buf := bytes.NewBuffer(test.input)
-		res, _, err := decodeNLRI(buf)
+		res, _, err := decodeNLRI(buf, IPv4AFI, false, l)
 
 		if test.wantFail && err == nil {
 			t.Errorf("Expected error did not happen for test %q", test.name)
@@ -127,3 +199,42 @@ func TestDecodeNLRI(t *testing.T) {
 		assert.Equal(t, test.expected, res)
 	}
 }
+
+func TestDecodeNLRIsMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []byte
+		length uint16
+		afi    uint16
+	}{
+		{
+			name:   "Truncated prefix",
+			input:  []byte{24, 10, 1, 8, 10},
+			length: 3,
+			afi:    IPv4AFI,
+		},
+		{
+			name:   "IPv4 prefix length exceeds 32",
+			input:  []byte{33, 10, 1, 1, 0, 0},
+			length: 6,
+			afi:    IPv4AFI,
+		},
+		{
+			name:   "IPv6 prefix length exceeds 128",
+			input:  []byte{129, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			length: 18,
+			afi:    IPv6AFI,
+		},
+	}
+
+	for _, test := range tests {
+		_, err := decodeNLRIs(bytes.NewBuffer(test.input), test.length, test.afi, false)
+
+		var bgperr BGPError
+		if !assert.True(t, errors.As(err, &bgperr), "%s: %v", test.name, err) {
+			continue
+		}
+		assert.Equal(t, uint8(UpdateMessageError), bgperr.ErrorCode, test.name)
+		assert.Equal(t, uint8(InvalidNetworkField), bgperr.ErrorSubCode, test.name)
+	}
+}
@@ -3,22 +3,42 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"net"
+	"strings"
+
+	"github.com/taktv6/tflow2/convert"
 )
 
-func decodePathAttrs(buf *bytes.Buffer, tpal uint16) (*PathAttribute, error) {
+// decodePathAttrs decodes the path attributes of an UPDATE. Attributes
+// requiring the UPDATE to be treated as withdraw are left out. The remaining
+// attributes are returned together with the first of these errors.
+func decodePathAttrs(buf *bytes.Buffer, tpal uint16, opt *DecodeOptions) (*PathAttribute, error) {
 	var ret *PathAttribute
 	var eol *PathAttribute
 	var pa *PathAttribute
 	var err error
+	var withdrawErr error
 	var consumed uint16
 
 	p := uint16(0)
 	for p < tpal {
-		pa, consumed, err = decodePathAttr(buf)
+		pa, consumed, err = decodePathAttr(buf, opt)
 		if err != nil {
-			return nil, fmt.Errorf("Unable to decode path attr: %v", err)
+			if !isTreatAsWithdraw(err) {
+				return nil, fmt.Errorf("Unable to decode path attr: %w", err)
+			}
+			if withdrawErr == nil {
+				withdrawErr = err
+			}
 		}
 		p += consumed
+		if p > tpal {
+			return nil, malformedAttrListErr(fmt.Sprintf("Path attributes of %d bytes exceed total path attribute length %d", p, tpal))
+		}
+
+		if pa == nil {
+			continue
+		}
 
 		if ret == nil {
 			ret = pa
@@ -29,15 +49,15 @@ func decodePathAttrs(buf *bytes.Buffer, tpal uint16) (*PathAttribute, error) {
 		}
 	}
 
-	return ret, nil
+	return ret, withdrawErr
 }
 
-func decodePathAttr(buf *bytes.Buffer) (pa *PathAttribute, consumed uint16, err error) {
+func decodePathAttr(buf *bytes.Buffer, opt *DecodeOptions) (pa *PathAttribute, consumed uint16, err error) {
 	pa = &PathAttribute{}
 
 	err = decodePathAttrFlags(buf, pa)
 	if err != nil {
-		return nil, consumed, fmt.Errorf("Unable to get path attribute flags: %v", err)
+		return nil, consumed, fmt.Errorf("Unable to get path attribute flags: %w", err)
 	}
 	consumed++
 
@@ -55,60 +75,203 @@ func decodePathAttr(buf *bytes.Buffer) (pa *PathAttribute, consumed uint16, err
 
 	switch pa.TypeCode {
 	case OriginAttr:
+		if err := pa.checkFlags(false, true); err != nil {
+			return nil, consumed, err
+		}
 		if err := pa.decodeOrigin(buf); err != nil {
-			return nil, consumed, fmt.Errorf("Failed to decode Origin: %v", err)
+			return nil, consumed, fmt.Errorf("Failed to decode Origin: %w", err)
 		}
 	case ASPathAttr:
-		if err := pa.decodeASPath(buf); err != nil {
-			return nil, consumed, fmt.Errorf("Failed to decode AS Path: %v", err)
+		var raw []byte
+		if opt != nil && opt.KeepRawASPath && int(pa.Length) <= buf.Len() {
+			raw = append(raw, buf.Bytes()[:pa.Length]...)
+		}
+		if err := pa.decodeASPath(buf, opt.asnLength()); err != nil {
+			return nil, consumed, fmt.Errorf("Failed to decode AS Path: %w", err)
+		}
+		if raw != nil {
+			pa.RawASPath = &RawASPath{
+				Value:     raw,
+				ASNLength: opt.asnLength(),
+			}
 		}
 	case NextHopAttr:
 		if err := pa.decodeNextHop(buf); err != nil {
-			return nil, consumed, fmt.Errorf("Failed to decode Next-Hop: %v", err)
+			return nil, consumed, fmt.Errorf("Failed to decode Next-Hop: %w", err)
+		}
+		if opt != nil && opt.LocalAddress != nil && opt.LocalAddress.Equal(pa.Value.(net.IP)) {
+			return nil, consumed, invalidNextHopErr(pa.Value.(net.IP))
 		}
 	case MEDAttr:
+		if err := pa.checkFlags(true, false); err != nil {
+			return nil, consumed, err
+		}
 		if err := pa.decodeMED(buf); err != nil {
-			return nil, consumed, fmt.Errorf("Failed to decode MED: %v", err)
+			return nil, consumed, fmt.Errorf("Failed to decode MED: %w", err)
 		}
 	case LocalPrefAttr:
+		if err := pa.checkFlags(false, true); err != nil {
+			return nil, consumed, err
+		}
 		if err := pa.decodeLocalPref(buf); err != nil {
-			return nil, consumed, fmt.Errorf("Failed to decode local pref: %v", err)
+			return nil, consumed, fmt.Errorf("Failed to decode local pref: %w", err)
 		}
 	case AggregatorAttr:
-		if err := pa.decodeAggregator(buf); err != nil {
-			return nil, consumed, fmt.Errorf("Failed to decode Aggregator: %v", err)
+		if err := pa.decodeAggregator(buf, opt.asnLength()); err != nil {
+			return nil, consumed, fmt.Errorf("Failed to decode Aggregator: %w", err)
+		}
+	case AS4PathAttr:
+		if err := pa.decodeASPath(buf, 4); err != nil {
+			return nil, consumed, fmt.Errorf("Failed to decode AS4_PATH: %w", err)
+		}
+	case AS4AggregatorAttr:
+		if err := pa.decodeAggregator(buf, 4); err != nil {
+			return nil, consumed, fmt.Errorf("Failed to decode AS4_AGGREGATOR: %w", err)
 		}
 	case AtomicAggrAttr:
-		// Nothing to do for 0 octet long attribute
+		if err := pa.decodeAtomicAggregate(); err != nil {
+			return nil, consumed, err
+		}
+	case CommunitiesAttr:
+		if err := pa.checkFlags(true, true); err != nil {
+			return nil, consumed, err
+		}
+		if err := pa.decodeCommunities(buf, opt.maxCommunities()); err != nil {
+			return nil, consumed + pa.Length, fmt.Errorf("Failed to decode Communities: %w", err)
+		}
+	case OriginatorIDAttr:
+		if err := pa.checkFlags(true, false); err != nil {
+			return nil, consumed, err
+		}
+		if err := pa.decodeOriginatorID(buf); err != nil {
+			return nil, consumed, fmt.Errorf("Failed to decode ORIGINATOR_ID: %w", err)
+		}
+	case ClusterListAttr:
+		if err := pa.checkFlags(true, false); err != nil {
+			return nil, consumed, err
+		}
+		if err := pa.decodeClusterList(buf); err != nil {
+			return nil, consumed, fmt.Errorf("Failed to decode CLUSTER_LIST: %w", err)
+		}
+	case MultiProtocolReachNLRIAttr:
+		if err := pa.checkFlags(true, false); err != nil {
+			return nil, consumed, err
+		}
+		if err := pa.decodeMultiProtocolReachNLRI(buf, opt); err != nil {
+			return nil, consumed, fmt.Errorf("Failed to decode MP_REACH_NLRI: %w", err)
+		}
+	case MultiProtocolUnreachNLRIAttr:
+		if err := pa.checkFlags(true, false); err != nil {
+			return nil, consumed, err
+		}
+		if err := pa.decodeMultiProtocolUnreachNLRI(buf, opt); err != nil {
+			return nil, consumed, fmt.Errorf("Failed to decode MP_UNREACH_NLRI: %w", err)
+		}
+	case ExtendedCommunitiesAttr:
+		if err := pa.checkFlags(true, true); err != nil {
+			return nil, consumed, err
+		}
+		if err := pa.decodeExtendedCommunities(buf, opt.maxExtendedCommunities()); err != nil {
+			return nil, consumed + pa.Length, fmt.Errorf("Failed to decode Extended Communities: %w", err)
+		}
+	case BGPsecPathAttr:
+		if err := pa.decodeBGPsecPath(buf); err != nil {
+			return nil, consumed, fmt.Errorf("Failed to decode BGPsec_Path: %w", err)
+		}
 	default:
-		return nil, consumed, fmt.Errorf("Invalid Attribute Type Code: %v", pa.TypeCode)
+		if err := pa.decodeUnknown(buf); err != nil {
+			return nil, consumed, err
+		}
+
+		// Unrecognized optional non-transitive attributes are ignored (RFC 4271, 5)
+		if !pa.Transitive {
+			return nil, consumed + pa.Length, nil
+		}
 	}
 
 	return pa, consumed + pa.Length, nil
 }
 
+// decodeUnknown retains the value of an unrecognized attribute. Optional
+// transitive attributes are passed on to other peers with the Partial flag
+// set. Unrecognized well-known attributes are an error.
+func (pa *PathAttribute) decodeUnknown(buf *bytes.Buffer) error {
+	if !pa.Optional {
+		return BGPError{
+			ErrorCode:    UpdateMessageError,
+			ErrorSubCode: UnrecognizedWellKnownAttr,
+			ErrorStr:     fmt.Sprintf("Unrecognized well-known attribute: %d", pa.TypeCode),
+		}
+	}
+
+	value := make([]byte, pa.Length)
+	err := decode(buf, []interface{}{&value})
+	if err != nil {
+		return err
+	}
+
+	pa.Value = UnknownAttribute(value)
+	if pa.Transitive {
+		pa.Partial = true
+	}
+
+	return nil
+}
+
 func (pa *PathAttribute) decodeOrigin(buf *bytes.Buffer) error {
-	origin := uint8(0)
+	if pa.Length != 1 {
+		return attrLengthErr(fmt.Sprintf("Invalid ORIGIN length: %d", pa.Length))
+	}
 
-	p := uint16(0)
+	origin := uint8(0)
 	err := decode(buf, []interface{}{&origin})
 	if err != nil {
-		return fmt.Errorf("Unable to decode: %v", err)
+		return fmt.Errorf("Unable to decode: %w", err)
+	}
+
+	if origin > INCOMPLETE {
+		return BGPError{
+			ErrorCode:    UpdateMessageError,
+			ErrorSubCode: InvalidOriginAttr,
+			ErrorStr:     fmt.Sprintf("Invalid ORIGIN: %d", origin),
+		}
 	}
 
 	pa.Value = origin
-	p++
+	return nil
+}
 
-	return dumpNBytes(buf, pa.Length-p)
+// checkFlags verifies the optional and transitive flags of a path attribute
+func (pa *PathAttribute) checkFlags(optional bool, transitive bool) error {
+	if pa.Optional == optional && pa.Transitive == transitive {
+		return nil
+	}
+
+	return BGPError{
+		ErrorCode:    UpdateMessageError,
+		ErrorSubCode: AttrFlagsError,
+		ErrorStr:     fmt.Sprintf("Invalid flags for attribute %d: optional=%v transitive=%v", pa.TypeCode, pa.Optional, pa.Transitive),
+	}
+}
+
+func attrLengthErr(msg string) error {
+	return BGPError{
+		ErrorCode:    UpdateMessageError,
+		ErrorSubCode: AttrLengthError,
+		ErrorStr:     msg,
+	}
 }
 
-func (pa *PathAttribute) decodeASPath(buf *bytes.Buffer) error {
-	pa.Value = make(ASPath, 0)
+func (pa *PathAttribute) decodeASPath(buf *bytes.Buffer, asnLength uint8) error {
+	path := make(ASPath, 0)
+	asns := 0
 
 	p := uint16(0)
 	for p < pa.Length {
-		segment := ASPathSegment{
-			ASNs: make([]uint32, 0),
+		segment := ASPathSegment{}
+
+		if pa.Length-p < 2 {
+			return malformedASPathErr("AS Path segment header exceeds attribute length")
 		}
 
 		err := decode(buf, []interface{}{&segment.Type, &segment.Count})
@@ -117,36 +280,71 @@ func (pa *PathAttribute) decodeASPath(buf *bytes.Buffer) error {
 		}
 		p += 2
 
-		if segment.Type != ASSet && segment.Type != ASSequence {
-			return fmt.Errorf("Invalid AS Path segment type: %d", segment.Type)
+		if segment.Type < ASSet || segment.Type > ASConfedSet {
+			return malformedASPathErr(fmt.Sprintf("Invalid AS Path segment type: %d", segment.Type))
 		}
 
 		if segment.Count == 0 {
-			return fmt.Errorf("Invalid AS Path segment length: %d", segment.Count)
+			return malformedASPathErr(fmt.Sprintf("Invalid AS Path segment length: %d", segment.Count))
 		}
 
-		for i := uint8(0); i < segment.Count; i++ {
-			asn := uint16(0)
+		if len(path) == MaxASPathSegments {
+			return malformedASPathErr(fmt.Sprintf("AS Path exceeds the maximum of %d segments", MaxASPathSegments))
+		}
+
+		if uint16(segment.Count)*uint16(asnLength) > pa.Length-p {
+			return malformedASPathErr(fmt.Sprintf("AS Path segment of %d ASNs exceeds attribute length", segment.Count))
+		}
+
+		asns += int(segment.Count)
+		if asns > MaxASPathASNs {
+			return malformedASPathErr(fmt.Sprintf("AS Path exceeds the maximum of %d ASNs", MaxASPathASNs))
+		}
 
-			err := decode(buf, []interface{}{&asn})
+		segment.ASNs = make([]uint32, 0, segment.Count)
+		for i := uint8(0); i < segment.Count; i++ {
+			asn, err := decodeASN(buf, asnLength)
 			if err != nil {
 				return err
 			}
-			p += 2
+			p += uint16(asnLength)
 
-			segment.ASNs = append(segment.ASNs, uint32(asn))
+			segment.ASNs = append(segment.ASNs, asn)
 		}
-		pa.Value = append(pa.Value.(ASPath), segment)
+		path = append(path, segment)
 	}
 
+	pa.Value = path
 	return nil
 }
 
+func decodeASN(buf *bytes.Buffer, asnLength uint8) (uint32, error) {
+	if asnLength == 4 {
+		asn := uint32(0)
+		err := decode(buf, []interface{}{&asn})
+		return asn, err
+	}
+
+	asn := uint16(0)
+	err := decode(buf, []interface{}{&asn})
+	return uint32(asn), err
+}
+
+func malformedASPathErr(msg string) error {
+	return BGPError{
+		ErrorCode:    UpdateMessageError,
+		ErrorSubCode: MalformedASPath,
+		ErrorStr:     msg,
+	}
+}
+
 func (pa *PathAttribute) decodeNextHop(buf *bytes.Buffer) error {
-	addr := [4]byte{}
+	if pa.Length != 4 {
+		return attrLengthErr(fmt.Sprintf("Invalid NEXT_HOP length: %d", pa.Length))
+	}
 
-	p := uint16(0)
-	n, err := buf.Read(addr[:])
+	addr := make(net.IP, 4)
+	n, err := buf.Read(addr)
 	if err != nil {
 		return err
 	}
@@ -154,16 +352,30 @@ func (pa *PathAttribute) decodeNextHop(buf *bytes.Buffer) error {
 		return fmt.Errorf("Unable to read next hop: buf.Read read %d bytes", n)
 	}
 
+	if !isValidIdentifier(convert.Uint32b(addr)) {
+		return invalidNextHopErr(addr)
+	}
+
 	pa.Value = addr
-	p += 4
+	return nil
+}
 
-	return dumpNBytes(buf, pa.Length-p)
+func invalidNextHopErr(addr net.IP) error {
+	return BGPError{
+		ErrorCode:    UpdateMessageError,
+		ErrorSubCode: InvalidNextHopAttr,
+		ErrorStr:     fmt.Sprintf("Invalid NEXT_HOP: %s", addr),
+	}
 }
 
 func (pa *PathAttribute) decodeMED(buf *bytes.Buffer) error {
+	if pa.Length != 4 {
+		return attrLengthErr(fmt.Sprintf("Invalid MULTI_EXIT_DISC length: %d", pa.Length))
+	}
+
 	med, err := pa.decodeUint32(buf)
 	if err != nil {
-		return fmt.Errorf("Unable to recode local pref: %v", err)
+		return fmt.Errorf("Unable to decode MED: %w", err)
 	}
 
 	pa.Value = uint32(med)
@@ -171,24 +383,73 @@ func (pa *PathAttribute) decodeMED(buf *bytes.Buffer) error {
 }
 
 func (pa *PathAttribute) decodeLocalPref(buf *bytes.Buffer) error {
+	if pa.Length != 4 {
+		return attrLengthErr(fmt.Sprintf("Invalid LOCAL_PREF length: %d", pa.Length))
+	}
+
 	lpref, err := pa.decodeUint32(buf)
 	if err != nil {
-		return fmt.Errorf("Unable to recode local pref: %v", err)
+		return fmt.Errorf("Unable to recode local pref: %w", err)
 	}
 
 	pa.Value = uint32(lpref)
 	return nil
 }
 
-func (pa *PathAttribute) decodeAggregator(buf *bytes.Buffer) error {
-	aggr := Aggretator{}
+// decodeOriginatorID decodes the ORIGINATOR_ID attribute (RFC 4456, 8)
+func (pa *PathAttribute) decodeOriginatorID(buf *bytes.Buffer) error {
+	if pa.Length != 4 {
+		return attrLengthErr(fmt.Sprintf("Invalid ORIGINATOR_ID length: %d", pa.Length))
+	}
 
-	p := uint16(0)
-	err := decode(buf, []interface{}{&aggr.ASN})
+	id, err := pa.decodeUint32(buf)
 	if err != nil {
-		return err
+		return fmt.Errorf("Unable to decode ORIGINATOR_ID: %w", err)
+	}
+
+	pa.Value = id
+	return nil
+}
+
+// decodeClusterList decodes the CLUSTER_LIST attribute (RFC 4456, 8)
+func (pa *PathAttribute) decodeClusterList(buf *bytes.Buffer) error {
+	if pa.Length%4 != 0 {
+		return attrLengthErr(fmt.Sprintf("Invalid CLUSTER_LIST length: %d", pa.Length))
+	}
+
+	ids := make([]uint32, pa.Length/4)
+	for i := range ids {
+		err := decode(buf, []interface{}{&ids[i]})
+		if err != nil {
+			return err
+		}
+	}
+
+	pa.Value = ids
+	return nil
+}
+
+// decodeAggregator decodes an AGGREGATOR attribute. The AS number is
+// asnLength octets long depending on the 4-octet AS capability (RFC 6793).
+func (pa *PathAttribute) decodeAggregator(buf *bytes.Buffer, asnLength uint8) error {
+	if pa.Length != uint16(asnLength)+4 {
+		return attrLengthErr(fmt.Sprintf("Invalid AGGREGATOR length: %d", pa.Length))
+	}
+
+	aggr := Aggretator{}
+	if asnLength == 4 {
+		err := decode(buf, []interface{}{&aggr.ASN})
+		if err != nil {
+			return err
+		}
+	} else {
+		asn := uint16(0)
+		err := decode(buf, []interface{}{&asn})
+		if err != nil {
+			return err
+		}
+		aggr.ASN = uint32(asn)
 	}
-	p += 2
 
 	n, err := buf.Read(aggr.Addr[:])
 	if err != nil {
@@ -197,10 +458,42 @@ func (pa *PathAttribute) decodeAggregator(buf *bytes.Buffer) error {
 	if n != 4 {
 		return fmt.Errorf("Unable to read aggregator IP: buf.Read read %d bytes", n)
 	}
-	p += 4
 
 	pa.Value = aggr
-	return dumpNBytes(buf, pa.Length-p)
+	return nil
+}
+
+// decodeAtomicAggregate validates an ATOMIC_AGGREGATE attribute. It carries no value.
+func (pa *PathAttribute) decodeAtomicAggregate() error {
+	if pa.Length != 0 {
+		return attrLengthErr(fmt.Sprintf("Invalid ATOMIC_AGGREGATE length: %d", pa.Length))
+	}
+
+	return nil
+}
+
+// decodeCommunities decodes a COMMUNITIES attribute. More than max communities
+// are discarded and the UPDATE is treated as withdraw.
+func (pa *PathAttribute) decodeCommunities(buf *bytes.Buffer, max int) error {
+	if pa.Length%4 != 0 {
+		return attrLengthErr(fmt.Sprintf("Invalid COMMUNITIES length: %d", pa.Length))
+	}
+
+	if int(pa.Length/4) > max {
+		buf.Next(int(pa.Length))
+		return treatAsWithdrawErr{msg: fmt.Sprintf("%d COMMUNITIES exceed the maximum of %d", pa.Length/4, max)}
+	}
+
+	comms := make([]uint32, pa.Length/4)
+	for i := range comms {
+		err := decode(buf, []interface{}{&comms[i]})
+		if err != nil {
+			return err
+		}
+	}
+
+	pa.Value = comms
+	return nil
 }
 
 func (pa *PathAttribute) setLength(buf *bytes.Buffer) (int, error) {
@@ -235,43 +528,41 @@ func (pa *PathAttribute) decodeUint32(buf *bytes.Buffer) (uint32, error) {
 	p += 4
 	err = dumpNBytes(buf, pa.Length-p)
 	if err != nil {
-		return 0, fmt.Errorf("dumpNBytes failed: %v", err)
+		return 0, fmt.Errorf("dumpNBytes failed: %w", err)
 	}
 
 	return v, nil
 }
 
-func (pa *PathAttribute) ASPathString() (ret string) {
-	for _, p := range *pa.Value.(*ASPath) {
-		if p.Type == ASSet {
-			ret += " ("
-		}
-		n := len(p.ASNs)
-		for i, asn := range p.ASNs {
-			if i < n-1 {
-				ret += fmt.Sprintf("%d ", asn)
-				continue
-			}
-			ret += fmt.Sprintf("%d", asn)
-		}
-		if p.Type == ASSet {
-			ret += ")"
-		}
-	}
+// ASPathString returns the AS_PATH of an AS_PATH attribute in human readable form
+func (pa *PathAttribute) ASPathString() string {
+	return pa.Value.(ASPath).String()
+}
 
-	return
+// ASPathLen returns the length of the AS_PATH of an AS_PATH attribute
+func (pa *PathAttribute) ASPathLen() uint16 {
+	return pa.Value.(ASPath).Length()
 }
 
-func (pa *PathAttribute) ASPathLen() (ret uint16) {
-	for _, p := range *pa.Value.(*ASPath) {
-		if p.Type == ASSet {
-			ret++
-			continue
-		}
-		ret += uint16(len(p.ASNs))
+// CommunitiesString returns the communities of a COMMUNITIES attribute in ASN:value notation
+func (pa *PathAttribute) CommunitiesString() string {
+	comms := pa.Value.([]uint32)
+	strs := make([]string, len(comms))
+	for i, c := range comms {
+		strs[i] = CommunityString(c)
 	}
 
-	return
+	return strings.Join(strs, " ")
+}
+
+// CommunityString formats a community as ASN:value
+func CommunityString(c uint32) string {
+	return fmt.Sprintf("%d:%d", c>>16, c&0xffff)
+}
+
+// ASPathNeighborAS returns the neighbor AS of an AS_PATH attribute
+func (pa *PathAttribute) ASPathNeighborAS() (asn uint32, ok bool) {
+	return pa.Value.(ASPath).NeighborAS()
 }
 
 // dumpNBytes is used to dump n bytes of buf. This is useful in case an path attributes
@@ -2,9 +2,12 @@ package packet
 
 import (
 	"bytes"
+	"errors"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/taktv6/tflow2/convert"
 )
 
 func TestDecodePathAttrs(t *testing.T) {
@@ -17,7 +20,7 @@ func TestDecodePathAttrs(t *testing.T) {
 		{
 			name: "Valid attribute set",
 			input: []byte{
-				0,              // Attr. Flags
+				64,             // Attr. Flags
 				1,              // Attr. Type Code
 				1,              // Attr. Length
 				1,              // EGP
@@ -28,13 +31,14 @@ func TestDecodePathAttrs(t *testing.T) {
 			},
 			wantFail: false,
 			expected: &PathAttribute{
-				TypeCode: 1,
-				Length:   1,
-				Value:    uint8(1),
+				TypeCode:   1,
+				Length:     1,
+				Transitive: true,
+				Value:      uint8(1),
 				Next: &PathAttribute{
 					TypeCode: 3,
 					Length:   4,
-					Value:    [4]byte{10, 20, 30, 40},
+					Value:    net.IP{10, 20, 30, 40},
 				},
 			},
 		},
@@ -50,7 +54,7 @@ func TestDecodePathAttrs(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		res, err := decodePathAttrs(bytes.NewBuffer(test.input), uint16(len(test.input)))
+		res, err := decodePathAttrs(bytes.NewBuffer(test.input), uint16(len(test.input)), &DecodeOptions{})
 
 		if test.wantFail && err == nil {
 			t.Errorf("Expected error did not happen for test %q", test.name)
@@ -76,22 +80,32 @@ func TestDecodePathAttr(t *testing.T) {
 		{
 			name: "Valid origin",
 			input: []byte{
-				0, // Attr. Flags
-				1, // Attr. Type Code
-				1, // Attr. Length
-				1, // EGP
+				64, // Attr. Flags
+				1,  // Attr. Type Code
+				1,  // Attr. Length
+				1,  // EGP
 			},
 			wantFail: false,
 			expected: &PathAttribute{
 				Length:         1,
 				Optional:       false,
-				Transitive:     false,
+				Transitive:     true,
 				Partial:        false,
 				ExtendedLength: false,
 				TypeCode:       OriginAttr,
 				Value:          uint8(1),
 			},
 		},
+		{
+			name: "Optional origin",
+			input: []byte{
+				192, // Attr. Flags
+				1,   // Attr. Type Code
+				1,   // Attr. Length
+				1,   // EGP
+			},
+			wantFail: true,
+		},
 		{
 			name: "Missing TypeCode",
 			input: []byte{
@@ -137,18 +151,70 @@ func TestDecodePathAttr(t *testing.T) {
 		{
 			name: "Missing value MED",
 			input: []byte{
-				0, // Attr. Flags
-				4, // Attr. Type Code
-				4, // Attr. Length
+				128, // Attr. Flags
+				4,   // Attr. Type Code
+				4,   // Attr. Length
 			},
 			wantFail: true,
 		},
 		{
 			name: "Missing value LocalPref",
 			input: []byte{
-				0, // Attr. Flags
-				5, // Attr. Type Code
-				4, // Attr. Length
+				64, // Attr. Flags
+				5,  // Attr. Type Code
+				4,  // Attr. Length
+			},
+			wantFail: true,
+		},
+		{
+			name: "Valid MED",
+			input: []byte{
+				128,          // Attr. Flags
+				4,            // Attr. Type Code
+				4,            // Attr. Length
+				0, 0, 0, 100, // MED
+			},
+			wantFail: false,
+			expected: &PathAttribute{
+				Length:   4,
+				Optional: true,
+				TypeCode: MEDAttr,
+				Value:    uint32(100),
+			},
+		},
+		{
+			name: "Transitive MED",
+			input: []byte{
+				192,          // Attr. Flags
+				4,            // Attr. Type Code
+				4,            // Attr. Length
+				0, 0, 0, 100, // MED
+			},
+			wantFail: true,
+		},
+		{
+			name: "Valid LocalPref",
+			input: []byte{
+				64,           // Attr. Flags
+				5,            // Attr. Type Code
+				4,            // Attr. Length
+				0, 0, 0, 200, // Local Pref
+			},
+			wantFail: false,
+			expected: &PathAttribute{
+				Length:     4,
+				Transitive: true,
+				TypeCode:   LocalPrefAttr,
+				Value:      uint32(200),
+			},
+		},
+		{
+			name: "Optional LocalPref",
+			input: []byte{
+				128,          // Attr. Flags
+				5,            // Attr. Type Code
+				4,            // Attr. Length
+				0, 0, 0, 200, // Local Pref
 			},
 			wantFail: true,
 		},
@@ -173,7 +239,7 @@ func TestDecodePathAttr(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		res, _, err := decodePathAttr(bytes.NewBuffer(test.input))
+		res, _, err := decodePathAttr(bytes.NewBuffer(test.input), &DecodeOptions{})
 
 		if test.wantFail && err == nil {
 			t.Errorf("Expected error did not happen for test %q", test.name)
@@ -234,6 +300,20 @@ func TestDecodeOrigin(t *testing.T) {
 			input:    []byte{},
 			wantFail: true,
 		},
+		{
+			name: "Invalid origin",
+			input: []byte{
+				5,
+			},
+			wantFail: true,
+		},
+		{
+			name: "Invalid length",
+			input: []byte{
+				0, 0,
+			},
+			wantFail: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -264,6 +344,7 @@ func TestDecodeASPath(t *testing.T) {
 		input          []byte
 		wantFail       bool
 		explicitLength uint16
+		malformed      bool
 		expected       *PathAttribute
 	}{
 		{
@@ -314,6 +395,78 @@ func TestDecodeASPath(t *testing.T) {
 			explicitLength: 5,
 			wantFail:       true,
 		},
+		{
+			name: "AS_SET and AS_SEQUENCE",
+			input: []byte{
+				2, // AS_SEQUENCE
+				2, // Path Length
+				0, 100, 0, 200,
+				1, // AS_SET
+				2, // Path Length
+				0, 222, 0, 240,
+			},
+			wantFail: false,
+			expected: &PathAttribute{
+				Length: 12,
+				Value: ASPath{
+					ASPathSegment{
+						Type:  2,
+						Count: 2,
+						ASNs: []uint32{
+							100, 200,
+						},
+					},
+					ASPathSegment{
+						Type:  1,
+						Count: 2,
+						ASNs: []uint32{
+							222, 240,
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "AS_CONFED_SEQUENCE and AS_CONFED_SET",
+			input: []byte{
+				3, // AS_CONFED_SEQUENCE
+				1, // Path Length
+				0, 100,
+				4, // AS_CONFED_SET
+				2, // Path Length
+				0, 222, 0, 240,
+			},
+			wantFail: false,
+			expected: &PathAttribute{
+				Length: 10,
+				Value: ASPath{
+					ASPathSegment{
+						Type:  ASConfedSequence,
+						Count: 1,
+						ASNs: []uint32{
+							100,
+						},
+					},
+					ASPathSegment{
+						Type:  ASConfedSet,
+						Count: 2,
+						ASNs: []uint32{
+							222, 240,
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Invalid segment type",
+			input: []byte{
+				5, // Unknown
+				1, // Path Length
+				0, 100,
+			},
+			wantFail:  true,
+			malformed: true,
+		},
 		{
 			name: "Incomplete AS_PATH",
 			input: []byte{
@@ -323,6 +476,18 @@ func TestDecodeASPath(t *testing.T) {
 			},
 			wantFail: true,
 		},
+		{
+			name: "Segment exceeding attribute length",
+			input: []byte{
+				2, // AS_SEQUENCE
+				3, // Path Length
+				0, 100, 0, 222,
+				64, 3, 4, // Start of next attribute
+			},
+			explicitLength: 6,
+			wantFail:       true,
+			malformed:      true,
+		},
 	}
 
 	for _, test := range tests {
@@ -333,12 +498,19 @@ func TestDecodeASPath(t *testing.T) {
 		pa := &PathAttribute{
 			Length: l,
 		}
-		err := pa.decodeASPath(bytes.NewBuffer(test.input))
+		err := pa.decodeASPath(bytes.NewBuffer(test.input), 2)
 
 		if test.wantFail && err == nil {
 			t.Errorf("Expected error did not happen for test %q", test.name)
 		}
 
+		if test.malformed {
+			bgperr, ok := err.(BGPError)
+			if !ok || bgperr.ErrorSubCode != MalformedASPath {
+				t.Errorf("Expected MalformedASPath for test %q, got: %v", test.name, err)
+			}
+		}
+
 		if !test.wantFail && err != nil {
 			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
 		}
@@ -351,6 +523,135 @@ func TestDecodeASPath(t *testing.T) {
 	}
 }
 
+func TestDecodeASPathASNLength(t *testing.T) {
+	input := []byte{
+		2, 2, // AS_SEQUENCE, 2 ASNs
+		91, 160, // AS_TRANS
+		253, 232, // AS65000
+		2, 1, // AS_SEQUENCE, 1 ASN
+		253, 233, // AS65001
+	}
+
+	tests := []struct {
+		name      string
+		asnLength uint8
+		expected  ASPath
+	}{
+		{
+			name:      "2-octet ASNs",
+			asnLength: 2,
+			expected: ASPath{
+				{
+					Type:  ASSequence,
+					Count: 2,
+					ASNs:  []uint32{ASTrans, 65000},
+				},
+				{
+					Type:  ASSequence,
+					Count: 1,
+					ASNs:  []uint32{65001},
+				},
+			},
+		},
+		{
+			name:      "4-octet ASNs",
+			asnLength: 4,
+			expected: ASPath{
+				{
+					Type:  ASSequence,
+					Count: 2,
+					ASNs:  []uint32{1537277416, 33684969},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		pa := &PathAttribute{
+			Length: uint16(len(input)),
+		}
+		err := pa.decodeASPath(bytes.NewBuffer(input), test.asnLength)
+		if err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+			continue
+		}
+
+		assert.Equal(t, test.expected, pa.Value, test.name)
+	}
+}
+
+func TestDecodeASPathLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments int
+		asns     int
+		wantFail bool
+	}{
+		{
+			name:     "Maximum number of segments",
+			segments: MaxASPathSegments,
+			asns:     1,
+			wantFail: false,
+		},
+		{
+			name:     "Thousands of one ASN segments",
+			segments: 2000,
+			asns:     1,
+			wantFail: true,
+		},
+		{
+			name:     "Maximum number of ASNs",
+			segments: 8,
+			asns:     MaxASPathASNs / 8,
+			wantFail: false,
+		},
+		{
+			name:     "Too many ASNs",
+			segments: 5,
+			asns:     255,
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		input := make([]byte, 0)
+		for i := 0; i < test.segments; i++ {
+			input = append(input, ASSequence, uint8(test.asns))
+			for j := 0; j < test.asns; j++ {
+				input = append(input, 0, 100)
+			}
+		}
+
+		pa := &PathAttribute{
+			Length: uint16(len(input)),
+		}
+		err := pa.decodeASPath(bytes.NewBuffer(input), 2)
+
+		if test.wantFail {
+			if err == nil {
+				t.Errorf("Expected error did not happen for test %q", test.name)
+				continue
+			}
+
+			bgpErr, ok := err.(BGPError)
+			if !ok {
+				t.Errorf("Unexpected error type for test %q: %v", test.name, err)
+				continue
+			}
+			assert.Equal(t, uint8(UpdateMessageError), bgpErr.ErrorCode, test.name)
+			assert.Equal(t, uint8(MalformedASPath), bgpErr.ErrorSubCode, test.name)
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+			continue
+		}
+
+		assert.Equal(t, test.segments, len(pa.Value.(ASPath)), test.name)
+	}
+}
+
 func TestDecodeNextHop(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -367,23 +668,47 @@ func TestDecodeNextHop(t *testing.T) {
 			wantFail: false,
 			expected: &PathAttribute{
 				Length: 4,
-				Value: [4]byte{
-					10, 20, 30, 40,
-				},
+				Value:  net.IP{10, 20, 30, 40},
+			},
+		},
+		{
+			name: "192.0.2.1",
+			input: []byte{
+				192, 0, 2, 1,
+			},
+			wantFail: false,
+			expected: &PathAttribute{
+				Length: 4,
+				Value:  net.IP{192, 0, 2, 1},
 			},
 		},
 		{
 			name:           "Test #2",
 			input:          []byte{},
-			explicitLength: 5,
+			explicitLength: 4,
 			wantFail:       true,
 		},
 		{
-			name:           "Incomplete IP-Address",
-			input:          []byte{10, 20, 30},
+			name:     "Incomplete IP-Address",
+			input:    []byte{10, 20, 30},
+			wantFail: true,
+		},
+		{
+			name:           "Invalid length",
+			input:          []byte{10, 20, 30, 40, 50},
 			explicitLength: 5,
 			wantFail:       true,
 		},
+		{
+			name:     "Loopback",
+			input:    []byte{127, 0, 0, 1},
+			wantFail: true,
+		},
+		{
+			name:     "Multicast",
+			input:    []byte{224, 0, 0, 5},
+			wantFail: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -412,6 +737,32 @@ func TestDecodeNextHop(t *testing.T) {
 	}
 }
 
+func TestDecodeNextHopLocalAddress(t *testing.T) {
+	input := []byte{
+		64,           // Attr. Flags
+		3,            // Attr. Type Code
+		4,            // Attr. Length
+		192, 0, 2, 1, // Next Hop
+	}
+
+	_, _, err := decodePathAttr(bytes.NewBuffer(input), &DecodeOptions{
+		LocalAddress: net.IP{192, 0, 2, 1},
+	})
+	if err == nil {
+		t.Fatalf("NEXT_HOP pointing to the local address was accepted")
+	}
+
+	var bgperr BGPError
+	if assert.True(t, errors.As(err, &bgperr)) {
+		assert.Equal(t, uint8(InvalidNextHopAttr), bgperr.ErrorSubCode)
+	}
+
+	_, _, err = decodePathAttr(bytes.NewBuffer(input), &DecodeOptions{
+		LocalAddress: net.IP{192, 0, 2, 2},
+	})
+	assert.NoError(t, err)
+}
+
 func TestDecodeMED(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -437,6 +788,13 @@ func TestDecodeMED(t *testing.T) {
 			explicitLength: 5,
 			wantFail:       true,
 		},
+		{
+			name: "Invalid length",
+			input: []byte{
+				0, 0, 0, 3, 232,
+			},
+			wantFail: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -490,6 +848,13 @@ func TestDecodeLocalPref(t *testing.T) {
 			explicitLength: 5,
 			wantFail:       true,
 		},
+		{
+			name: "Invalid length",
+			input: []byte{
+				0, 0, 0, 3, 232,
+			},
+			wantFail: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -523,6 +888,7 @@ func TestDecodeAggregator(t *testing.T) {
 	tests := []struct {
 		name           string
 		input          []byte
+		asnLength      uint8
 		wantFail       bool
 		explicitLength uint16
 		expected       *PathAttribute
@@ -542,6 +908,40 @@ func TestDecodeAggregator(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Valid aggregator with 4-octet AS",
+			input: []byte{
+				0, 3, 13, 64, // ASN 200000
+				10, 20, 30, 40, // Aggregator IP
+			},
+			asnLength: 4,
+			wantFail:  false,
+			expected: &PathAttribute{
+				Length: 8,
+				Value: Aggretator{
+					ASN:  200000,
+					Addr: [4]byte{10, 20, 30, 40},
+				},
+			},
+		},
+		{
+			name: "2-octet AS aggregator with 4-octet AS negotiated",
+			input: []byte{
+				0, 222, // ASN
+				10, 20, 30, 40, // Aggregator IP
+			},
+			asnLength: 4,
+			wantFail:  true,
+		},
+		{
+			name: "Trailing bytes",
+			input: []byte{
+				0, 222, // ASN
+				10, 20, 30, 40, // Aggregator IP
+				0,
+			},
+			wantFail: true,
+		},
 		{
 			name: "Incomplete Address",
 			input: []byte{
@@ -572,7 +972,11 @@ func TestDecodeAggregator(t *testing.T) {
 		pa := &PathAttribute{
 			Length: l,
 		}
-		err := pa.decodeAggregator(bytes.NewBuffer(test.input))
+		asnLength := test.asnLength
+		if asnLength == 0 {
+			asnLength = 2
+		}
+		err := pa.decodeAggregator(bytes.NewBuffer(test.input), asnLength)
 
 		if test.wantFail {
 			if err != nil {
@@ -591,6 +995,234 @@ func TestDecodeAggregator(t *testing.T) {
 	}
 }
 
+func TestDecodeAtomicAggregate(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		wantFail bool
+		expected *PathAttribute
+	}{
+		{
+			name:  "Valid ATOMIC_AGGREGATE",
+			input: []byte{64, 6, 0},
+			expected: &PathAttribute{
+				Transitive: true,
+				TypeCode:   AtomicAggrAttr,
+			},
+		},
+		{
+			name:     "Phantom payload",
+			input:    []byte{64, 6, 2, 0, 0},
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		pa, _, err := decodePathAttr(bytes.NewBuffer(test.input), &DecodeOptions{})
+		if test.wantFail {
+			var bgperr BGPError
+			if assert.True(t, errors.As(err, &bgperr), test.name) {
+				assert.Equal(t, uint8(UpdateMessageError), bgperr.ErrorCode, test.name)
+				assert.Equal(t, uint8(AttrLengthError), bgperr.ErrorSubCode, test.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+			continue
+		}
+
+		assert.Equal(t, test.expected, pa, test.name)
+	}
+}
+
+func TestDecodeCommunities(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		wantFail bool
+		expected *PathAttribute
+	}{
+		{
+			name: "NO_EXPORT and 64512:100",
+			input: []byte{
+				192, 8, 8, // Attribute flags, type and length
+				255, 255, 255, 1, // 65535:65281 (NO_EXPORT)
+				252, 0, 0, 100, // 64512:100
+			},
+			wantFail: false,
+			expected: &PathAttribute{
+				Length:     8,
+				Optional:   true,
+				Transitive: true,
+				TypeCode:   CommunitiesAttr,
+				Value:      []uint32{0xffffff01, 0xfc000064},
+			},
+		},
+		{
+			name: "Invalid length",
+			input: []byte{
+				192, 8, 6,
+				255, 255, 255, 1,
+				251, 0,
+			},
+			wantFail: true,
+		},
+		{
+			name: "Invalid flags",
+			input: []byte{
+				64, 8, 4,
+				255, 255, 255, 1,
+			},
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		pa, _, err := decodePathAttr(bytes.NewBuffer(test.input), &DecodeOptions{})
+
+		if test.wantFail && err == nil {
+			t.Errorf("Expected error did not happen for test %q", test.name)
+		}
+
+		if !test.wantFail && err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		assert.Equal(t, test.expected, pa)
+		assert.Equal(t, "65535:65281 64512:100", pa.CommunitiesString())
+	}
+}
+
+func TestDecodeCommunitiesExtendedLength(t *testing.T) {
+	// 64 communities need 256 bytes, which does not fit into a 1 byte length
+	comms := make([]uint32, 64)
+	valid := []byte{208, 8, 1, 0}
+	for i := range comms {
+		comms[i] = 4259840000 + uint32(i) // 65000:i
+		valid = append(valid, convert.Uint32Byte(comms[i])...)
+	}
+
+	tests := []struct {
+		name     string
+		input    []byte
+		wantFail bool
+		expected *PathAttribute
+	}{
+		{
+			name:  "64 communities",
+			input: valid,
+			expected: &PathAttribute{
+				Length:         256,
+				Optional:       true,
+				Transitive:     true,
+				ExtendedLength: true,
+				TypeCode:       CommunitiesAttr,
+				Value:          comms,
+			},
+		},
+		{
+			name:     "Length not a multiple of 4",
+			input:    append([]byte{208, 8, 1, 2}, make([]byte, 258)...),
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		pa, _, err := decodePathAttr(bytes.NewBuffer(test.input), &DecodeOptions{})
+		if test.wantFail {
+			assert.Error(t, err, test.name)
+			continue
+		}
+
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, pa, test.name)
+		}
+	}
+}
+
+func TestDecodeRouteReflectionAttrs(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		wantFail bool
+		expected *PathAttribute
+	}{
+		{
+			name: "ORIGINATOR_ID",
+			input: []byte{
+				128, 9, 4, // Attribute flags, type and length
+				192, 0, 2, 1,
+			},
+			expected: &PathAttribute{
+				Length:   4,
+				Optional: true,
+				TypeCode: OriginatorIDAttr,
+				Value:    uint32(3221225985),
+			},
+		},
+		{
+			name: "ORIGINATOR_ID with invalid length",
+			input: []byte{
+				128, 9, 5,
+				192, 0, 2, 1, 0,
+			},
+			wantFail: true,
+		},
+		{
+			name: "ORIGINATOR_ID with invalid flags",
+			input: []byte{
+				192, 9, 4,
+				192, 0, 2, 1,
+			},
+			wantFail: true,
+		},
+		{
+			name: "CLUSTER_LIST",
+			input: []byte{
+				128, 10, 8, // Attribute flags, type and length
+				0, 0, 0, 1,
+				0, 0, 0, 2,
+			},
+			expected: &PathAttribute{
+				Length:   8,
+				Optional: true,
+				TypeCode: ClusterListAttr,
+				Value:    []uint32{1, 2},
+			},
+		},
+		{
+			name: "CLUSTER_LIST with invalid length",
+			input: []byte{
+				128, 10, 6,
+				0, 0, 0, 1,
+				0, 0,
+			},
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		pa, _, err := decodePathAttr(bytes.NewBuffer(test.input), &DecodeOptions{})
+		if test.wantFail {
+			assert.Error(t, err, test.name)
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+			continue
+		}
+
+		assert.Equal(t, test.expected, pa, test.name)
+	}
+}
+
 func TestSetLength(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -725,7 +1357,7 @@ func TestASPathString(t *testing.T) {
 		{
 			name: "Test #1",
 			pa: &PathAttribute{
-				Value: &ASPath{
+				Value: ASPath{
 					{
 						Type: ASSequence,
 						ASNs: []uint32{10, 20, 30},
@@ -737,7 +1369,7 @@ func TestASPathString(t *testing.T) {
 		{
 			name: "Test #2",
 			pa: &PathAttribute{
-				Value: &ASPath{
+				Value: ASPath{
 					{
 						Type: ASSequence,
 						ASNs: []uint32{10, 20, 30},
@@ -757,3 +1389,219 @@ func TestASPathString(t *testing.T) {
 		assert.Equal(t, test.expected, res)
 	}
 }
+
+func TestASPathNeighborAS(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     ASPath
+		wantOK   bool
+		expected uint32
+	}{
+		{
+			name: "AS_SEQUENCE",
+			path: ASPath{
+				{Type: ASSequence, ASNs: []uint32{65001, 65002}},
+			},
+			wantOK:   true,
+			expected: 65001,
+		},
+		{
+			name: "AS_SET",
+			path: ASPath{
+				{Type: ASSet, ASNs: []uint32{65001, 65002}},
+			},
+			wantOK: false,
+		},
+		{
+			name:   "Empty",
+			path:   ASPath{},
+			wantOK: false,
+		},
+	}
+
+	for _, test := range tests {
+		pa := &PathAttribute{
+			Value: test.path,
+		}
+
+		asn, ok := pa.ASPathNeighborAS()
+		assert.Equal(t, test.wantOK, ok, test.name)
+		assert.Equal(t, test.expected, asn, test.name)
+	}
+}
+
+func TestDecodeUnknownAttr(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     []byte
+		wantFail  bool
+		expected  *PathAttribute
+		reencoded []byte
+	}{
+		{
+			name: "Optional transitive",
+			input: []byte{
+				192, 99, 3, // Attribute flags, type and length
+				1, 2, 3,
+				64, 1, 1, 0, // ORIGIN: IGP
+			},
+			expected: &PathAttribute{
+				Length:     3,
+				Optional:   true,
+				Transitive: true,
+				Partial:    true,
+				TypeCode:   99,
+				Value:      UnknownAttribute{1, 2, 3},
+				Next: &PathAttribute{
+					Length:     1,
+					Transitive: true,
+					TypeCode:   OriginAttr,
+					Value:      uint8(IGP),
+				},
+			},
+			reencoded: []byte{
+				224, 99, 3, // Partial flag set
+				1, 2, 3,
+				64, 1, 1, 0,
+			},
+		},
+		{
+			name: "Optional non-transitive",
+			input: []byte{
+				128, 99, 3,
+				1, 2, 3,
+				64, 1, 1, 0,
+			},
+			expected: &PathAttribute{
+				Length:     1,
+				Transitive: true,
+				TypeCode:   OriginAttr,
+				Value:      uint8(IGP),
+			},
+			reencoded: []byte{
+				64, 1, 1, 0,
+			},
+		},
+		{
+			name: "Well-known",
+			input: []byte{
+				64, 99, 3,
+				1, 2, 3,
+			},
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		pa, err := decodePathAttrs(bytes.NewBuffer(test.input), uint16(len(test.input)), &DecodeOptions{})
+		if test.wantFail {
+			bgperr, ok := AsBGPError(err)
+			if assert.True(t, ok, test.name) {
+				assert.Equal(t, uint8(UnrecognizedWellKnownAttr), bgperr.SubCode(), test.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+			continue
+		}
+		assert.Equal(t, test.expected, pa, test.name)
+
+		buf, err := serializePathAttrs(pa, 2)
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.reencoded, buf, test.name)
+		}
+	}
+}
+
+func TestDecodePathAttrsExtendedLength(t *testing.T) {
+	comms := make([]uint32, 75)
+	input := []byte{
+		208, 8, 1, 44, // Optional, transitive, extended length COMMUNITIES of 300 bytes
+	}
+	for i := range comms {
+		comms[i] = 4259840000 + uint32(i) // 65000:i
+		input = append(input, convert.Uint32Byte(comms[i])...)
+	}
+	input = append(input, 64, 1, 1, 2) // ORIGIN: INCOMPLETE
+
+	pa, err := decodePathAttrs(bytes.NewBuffer(input), uint16(len(input)), &DecodeOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, &PathAttribute{
+		Length:         300,
+		Optional:       true,
+		Transitive:     true,
+		ExtendedLength: true,
+		TypeCode:       CommunitiesAttr,
+		Value:          comms,
+		Next: &PathAttribute{
+			Length:     1,
+			Transitive: true,
+			TypeCode:   OriginAttr,
+			Value:      uint8(INCOMPLETE),
+		},
+	}, pa)
+
+	buf, err := serializePathAttrs(pa, 2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, input, buf)
+	}
+}
+
+func TestDecodePathAttrsKeepRawASPath(t *testing.T) {
+	input := []byte{
+		64, 2, 6, // AS_PATH
+		2, 2, 254, 177, 91, 160, // AS_SEQUENCE 65201 23456
+	}
+
+	pa, err := decodePathAttrs(bytes.NewBuffer(input), uint16(len(input)), &DecodeOptions{
+		KeepRawASPath: true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, &RawASPath{
+		Value:     input[3:],
+		ASNLength: 2,
+	}, pa.RawASPath)
+
+	// The raw AS_PATH takes precedence over a modified value of the same ASN length
+	pa.Value = ASPath{
+		{Type: ASSequence, Count: 1, ASNs: []uint32{65201}},
+		{Type: ASSet, Count: 1, ASNs: []uint32{4200000000}},
+	}
+	buf, err := serializePathAttrs(pa, 2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, input, buf)
+	}
+
+	buf, err = serializePathAttrs(pa, 4)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte{
+			64, 2, 12,
+			2, 1, 0, 0, 254, 177,
+			1, 1, 250, 86, 234, 0,
+		}, buf)
+	}
+}
+
+func TestDecodePathAttrsExceedingTotalLength(t *testing.T) {
+	input := []byte{
+		64, 1, 1, 0, // ORIGIN: IGP
+		208, 8, 1, 44, // Extended length COMMUNITIES of 300 bytes
+	}
+	input = append(input, make([]byte, 300)...)
+
+	// The total path attribute length only covers 200 bytes of COMMUNITIES
+	_, err := decodePathAttrs(bytes.NewBuffer(input), 208, &DecodeOptions{})
+	bgperr, ok := AsBGPError(err)
+	if assert.True(t, ok) {
+		assert.Equal(t, uint8(UpdateMessageError), bgperr.Code())
+		assert.Equal(t, uint8(MalformedAttributeList), bgperr.SubCode())
+	}
+}
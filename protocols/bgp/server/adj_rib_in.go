@@ -0,0 +1,73 @@
+package server
+
+import (
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/rt"
+)
+
+// AdjRIBInClient is notified of paths added to or removed from the Adj-RIB-In
+// of a peer. rt.RIB implements it, so received paths can be fed into a Loc-RIB
+// directly.
+type AdjRIBInClient interface {
+	// ReplacePath removes old from and adds new to the route for pfx. old or
+	// new may be nil.
+	ReplacePath(pfx *tnet.Prefix, old *rt.Path, new *rt.Path)
+}
+
+var _ AdjRIBInClient = &rt.RIB{}
+
+// RegisterAdjRIBIn adds a client notified of all changes of the Adj-RIB-In. c
+// is called by the FSM, or by the dampening stage once a suppressed path is
+// reused, and must not block.
+func (fsm *FSM) RegisterAdjRIBIn(c AdjRIBInClient) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	fsm.adjRIBInClients = append(fsm.adjRIBInClients, c)
+}
+
+// adjRIBInClients passes the changes let through by the dampening stage on to
+// the clients of the Adj-RIB-In
+type adjRIBInClients struct {
+	fsm *FSM
+}
+
+func (c adjRIBInClients) ReplacePath(pfx *tnet.Prefix, old *rt.Path, new *rt.Path) {
+	c.fsm.notifyAdjRIBInClients(pfx, old, new)
+}
+
+func (fsm *FSM) notifyAdjRIBIn(pfx *tnet.Prefix, old *rt.Path, new *rt.Path) {
+	if old == nil && new == nil {
+		return
+	}
+
+	if fsm.dampening != nil {
+		fsm.dampening.ReplacePath(pfx, old, new)
+		return
+	}
+
+	fsm.notifyAdjRIBInClients(pfx, old, new)
+}
+
+func (fsm *FSM) notifyAdjRIBInClients(pfx *tnet.Prefix, old *rt.Path, new *rt.Path) {
+	fsm.mu.RLock()
+	clients := fsm.adjRIBInClients
+	fsm.mu.RUnlock()
+
+	for _, c := range clients {
+		c.ReplacePath(pfx, old, new)
+	}
+}
+
+// dropAdjRIBIn notifies the clients of the removal of all paths of rib
+func (fsm *FSM) dropAdjRIBIn(rib rt.Trie) {
+	if rib == nil {
+		return
+	}
+
+	rib.Walk(func(r *rt.Route) {
+		for _, p := range r.Paths() {
+			fsm.notifyAdjRIBIn(r.Prefix(), p, nil)
+		}
+	})
+}
@@ -0,0 +1,436 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultEBGPAdvertisementInterval is the minimum route advertisement interval
+// used for eBGP peers if none is configured (RFC 4271 10)
+const defaultEBGPAdvertisementInterval = 30 * time.Second
+
+// AdjRIBOut advertises the changes of a Loc-RIB it is registered with to a
+// peer. The RIB only queues its changes, they are exported and sent by the
+// FSM, so a slow peer does not hold up the RIB. Announcements are queued again
+// and sent once the minimum route advertisement interval (MRAI, RFC 4271
+// 9.2.1.1) of the peer expired, so multiple changes of a prefix within the
+// interval result in a single UPDATE carrying the final state. Withdrawals are
+// sent immediately. With a coalescing interval the changes of the Loc-RIB
+// are collected for the interval before they are processed, so a prefix
+// withdrawn and added again within it is only announced again. Aggregates are advertised in place of or in addition to
+// their contributing routes. Only IPv4 unicast routes are advertised. If
+// ADD-PATH was negotiated, multiple paths of a prefix are advertised with the
+// path identifiers 1 to n in the order of the active paths.
+type AdjRIBOut struct {
+	fsm      *FSM
+	mrai     time.Duration
+	coalesce time.Duration
+	send     func(*packet.BGPUpdate) error
+
+	// changes holds the latest active paths of every prefix changed since the
+	// FSM processed them last. changed signals the FSM there are changes.
+	changesMu sync.Mutex
+	rib       *rt.RIB
+	changes   map[string]*ribChange
+	changed   chan struct{}
+
+	// The advertisement state is only changed by the FSM. mu guards it
+	// against readers like Aggregates.
+	mu            sync.Mutex
+	pending       map[string]*queuedRoute
+	advertised    map[string]int
+	mraiTimer     timer
+	mraiActive    bool
+	coalesceTimer timer
+	coalescing    bool
+	aggregates    []*aggregate
+}
+
+var _ rt.RIBClient = &AdjRIBOut{}
+
+// ribChange is a change of the active paths of a prefix in the Loc-RIB
+type ribChange struct {
+	pfx   *tnet.Prefix
+	paths []*rt.Path
+}
+
+// queuedRoute is an announcement waiting for the MRAI timer
+type queuedRoute struct {
+	pfx   *tnet.Prefix
+	paths []*rt.BGPPath
+}
+
+func newAdjRIBOut(fsm *FSM, mrai time.Duration, send func(*packet.BGPUpdate) error) *AdjRIBOut {
+	a := &AdjRIBOut{
+		fsm:           fsm,
+		mrai:          mrai,
+		send:          send,
+		changes:       make(map[string]*ribChange),
+		changed:       make(chan struct{}, 1),
+		pending:       make(map[string]*queuedRoute),
+		advertised:    make(map[string]int),
+		mraiTimer:     fsm.clock.NewTimer(0),
+		coalesceTimer: fsm.clock.NewTimer(0),
+	}
+	stopTimer(a.mraiTimer)
+	stopTimer(a.coalesceTimer)
+
+	return a
+}
+
+// Attach registers a with rib. All best paths of rib are advertised whenever
+// the session becomes established, changes are advertised as they happen.
+func (a *AdjRIBOut) Attach(rib *rt.RIB) {
+	a.changesMu.Lock()
+	a.rib = rib
+	a.changesMu.Unlock()
+
+	rib.Register(a)
+}
+
+// dump queues the best paths of all prefixes of the attached RIB for
+// advertisement
+func (a *AdjRIBOut) dump() {
+	a.changesMu.Lock()
+	rib := a.rib
+	a.changesMu.Unlock()
+
+	if rib != nil {
+		rib.Refresh(a)
+	}
+}
+
+// refresh advertises all routes to the peer again
+func (a *AdjRIBOut) refresh() {
+	a.mu.Lock()
+	for _, agg := range a.aggregates {
+		if agg.path != nil {
+			a.queue(agg.cfg.Prefix, []*rt.BGPPath{agg.path})
+		}
+	}
+	a.mu.Unlock()
+
+	a.dump()
+}
+
+// UpdateActivePaths queues the change of the active paths of pfx for the FSM.
+// A change of pfx the FSM did not process yet is replaced.
+func (a *AdjRIBOut) UpdateActivePaths(pfx *tnet.Prefix, paths []*rt.Path) {
+	if pfx.AFI() != packet.IPv4AFI || a.fsm.getState() != Established {
+		return
+	}
+
+	a.changesMu.Lock()
+	a.changes[pfx.String()] = &ribChange{
+		pfx:   pfx,
+		paths: paths,
+	}
+	a.changesMu.Unlock()
+
+	select {
+	case a.changed <- struct{}{}:
+	default:
+	}
+}
+
+// changesQueued processes the changes queued by the RIB. With a coalescing
+// interval they are processed once the interval passed since the first of
+// them. It is called by the FSM.
+func (a *AdjRIBOut) changesQueued() {
+	if a.coalesce == 0 {
+		a.processChanges()
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.coalescing {
+		a.coalescing = true
+		a.coalesceTimer.Reset(a.coalesce)
+	}
+}
+
+// coalesceExpired processes the changes collected during the coalescing
+// interval. It is called by the FSM.
+func (a *AdjRIBOut) coalesceExpired() {
+	a.mu.Lock()
+	a.coalescing = false
+	a.mu.Unlock()
+
+	a.processChanges()
+}
+
+// processChanges advertises the changes queued by the RIB
+func (a *AdjRIBOut) processChanges() {
+	a.changesMu.Lock()
+	changes := a.changes
+	a.changes = make(map[string]*ribChange)
+	a.changesMu.Unlock()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, c := range changes {
+		a.update(c.pfx, c.paths)
+	}
+}
+
+// update queues the best path of pfx, or as many active paths as negotiated
+// with the peer, for advertisement. pfx is withdrawn if it has no paths left,
+// none of the paths is exported to the peer or pfx is suppressed by an
+// aggregate. Routes for the prefix of an aggregate are not advertised, the
+// aggregate takes their place.
+func (a *AdjRIBOut) update(pfx *tnet.Prefix, paths []*rt.Path) {
+	candidates := paths
+	if n := a.fsm.advertisedPaths(); len(candidates) > n {
+		candidates = candidates[:n]
+	}
+
+	var exported []*rt.BGPPath
+	for _, p := range candidates {
+		if x, accept := a.fsm.exportPath(pfx, p); accept {
+			exported = append(exported, x.BGPPath)
+		}
+	}
+
+	if a.isAggregate(pfx) {
+		return
+	}
+
+	suppressed := a.updateAggregates(pfx, paths)
+	if len(exported) == 0 || suppressed {
+		a.withdraw(pfx)
+		return
+	}
+
+	a.queue(pfx, exported)
+}
+
+// queue queues pfx for advertisement with the attributes of paths
+func (a *AdjRIBOut) queue(pfx *tnet.Prefix, paths []*rt.BGPPath) {
+	a.pending[pfx.String()] = &queuedRoute{
+		pfx:   pfx,
+		paths: paths,
+	}
+
+	if a.mrai == 0 {
+		a.flush()
+		return
+	}
+
+	if !a.mraiActive {
+		a.startTimer()
+	}
+}
+
+// withdraw drops a queued announcement of pfx and sends a withdrawal if pfx
+// has been advertised
+func (a *AdjRIBOut) withdraw(pfx *tnet.Prefix) {
+	key := pfx.String()
+	delete(a.pending, key)
+	n, ok := a.advertised[key]
+	if !ok {
+		return
+	}
+
+	err := a.withdrawPaths(pfx, 0, n)
+	if err != nil {
+		a.logSendError(err)
+		return
+	}
+
+	delete(a.advertised, key)
+	a.fsm.updatePrefixesAdvert(-1)
+}
+
+// withdrawPaths withdraws the paths from+1 to n of pfx with a single UPDATE
+func (a *AdjRIBOut) withdrawPaths(pfx *tnet.Prefix, from int, n int) error {
+	var withdrawn *packet.NLRI
+	for i := n - 1; i >= from; i-- {
+		withdrawn = &packet.NLRI{
+			PathIdentifier: a.fsm.pathID(i),
+			IP:             ipv4Bytes(pfx.Addr()),
+			Pfxlen:         pfx.Pfxlen(),
+			Next:           withdrawn,
+		}
+	}
+
+	if withdrawn == nil {
+		return nil
+	}
+
+	return a.send(&packet.BGPUpdate{
+		WithdrawnRoutes: withdrawn,
+	})
+}
+
+// startTimer starts the MRAI timer. The FSM flushes the queue once it expired.
+func (a *AdjRIBOut) startTimer() {
+	a.mraiActive = true
+	a.mraiTimer.Reset(a.mrai)
+}
+
+// mraiExpired sends the announcements queued while the MRAI timer was running.
+// It is called by the FSM.
+func (a *AdjRIBOut) mraiExpired() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.mraiActive = false
+	a.flush()
+}
+
+// flush sends the queued announcements. Paths advertised before that are not
+// part of the announcement any more are withdrawn.
+func (a *AdjRIBOut) flush() {
+	for key, r := range a.pending {
+		err := a.announce(r)
+		if err != nil {
+			a.logSendError(err)
+			continue
+		}
+
+		n, ok := a.advertised[key]
+		if !ok {
+			a.fsm.updatePrefixesAdvert(1)
+		}
+
+		a.advertised[key] = len(r.paths)
+		err = a.withdrawPaths(r.pfx, len(r.paths), n)
+		if err != nil {
+			a.logSendError(err)
+			a.advertised[key] = n
+		}
+	}
+
+	a.pending = make(map[string]*queuedRoute)
+}
+
+// announce sends an UPDATE for each path of r
+func (a *AdjRIBOut) announce(r *queuedRoute) error {
+	for i, b := range r.paths {
+		err := a.send(a.fsm.update(r.pfx, b, a.fsm.pathID(i)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reset forgets all queued and advertised routes, e.g. when the session went
+// down
+func (a *AdjRIBOut) reset() {
+	a.changesMu.Lock()
+	a.changes = make(map[string]*ribChange)
+	a.changesMu.Unlock()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stopTimer(a.mraiTimer)
+	a.mraiActive = false
+	stopTimer(a.coalesceTimer)
+	a.coalescing = false
+	a.pending = make(map[string]*queuedRoute)
+	a.advertised = make(map[string]int)
+	for _, agg := range a.aggregates {
+		agg.reset()
+	}
+}
+
+func (a *AdjRIBOut) logSendError(err error) {
+	log.WithFields(log.Fields{
+		"peer":  a.fsm.remote.String(),
+		"error": err,
+	}).Warning("Unable to send UPDATE")
+}
+
+// advertisementInterval returns the MRAI of the session. Zero selects the
+// default, which delays advertisements to eBGP peers only.
+func (fsm *FSM) advertisementInterval(configured uint16) time.Duration {
+	if configured != 0 {
+		return time.Duration(configured) * time.Second
+	}
+
+	if fsm.external() {
+		return defaultEBGPAdvertisementInterval
+	}
+
+	return 0
+}
+
+// advertisedPaths returns the number of active paths per prefix advertised to
+// the peer
+func (fsm *FSM) advertisedPaths() int {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+
+	if !fsm.encodeOptions.AddPath || fsm.advertisePaths <= 1 {
+		return 1
+	}
+
+	if fsm.advertisePaths == config.AdvertiseAllActivePaths {
+		return math.MaxInt32
+	}
+
+	return int(fsm.advertisePaths)
+}
+
+// pathID returns the path identifier of the i-th path advertised for a
+// prefix. It is zero unless ADD-PATH was negotiated.
+func (fsm *FSM) pathID(i int) uint32 {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+
+	if !fsm.encodeOptions.AddPath {
+		return 0
+	}
+
+	return uint32(i) + 1
+}
+
+// update builds the UPDATE announcing pfx with the attributes of b
+func (fsm *FSM) update(pfx *tnet.Prefix, b *rt.BGPPath, pathID uint32) *packet.BGPUpdate {
+	return &packet.BGPUpdate{
+		PathAttributes: b.PathAttributes(!fsm.external()),
+		NLRI: &packet.NLRI{
+			PathIdentifier: pathID,
+			IP:             ipv4Bytes(pfx.Addr()),
+			Pfxlen:         pfx.Pfxlen(),
+		},
+	}
+}
+
+// sendUpdate sends an UPDATE on the established session encoded as
+// negotiated with the peer
+func (fsm *FSM) sendUpdate(u *packet.BGPUpdate) error {
+	fsm.mu.RLock()
+	c := fsm.con
+	opt := fsm.encodeOptions
+	fsm.mu.RUnlock()
+	if c == nil {
+		return fmt.Errorf("No connection")
+	}
+
+	msg, err := packet.SerializeUpdate(u, opt)
+	if err != nil {
+		return fmt.Errorf("Unable to serialize UPDATE: %w", err)
+	}
+
+	_, err = c.Write(msg)
+	return err
+}
+
+func ipv4Bytes(addr uint32) [4]byte {
+	return [4]byte{byte(addr >> 24), byte(addr >> 16), byte(addr >> 8), byte(addr)}
+}
@@ -0,0 +1,604 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+// adjRIBOutFSM creates an established session whose UPDATEs are sent to the
+// returned channel
+func adjRIBOutFSM(t *testing.T, peerAS uint32) (*FSM, *fakeClock, chan *packet.BGPUpdate) {
+	clk := newFakeClock()
+	fsm := newFSM(config.Peer{
+		LocalAS:      65200,
+		PeerAS:       peerAS,
+		LocalAddress: net.IP{169, 254, 0, 1},
+		PeerAddress:  net.IP{169, 254, 0, 2},
+	}, clk)
+	stopTimer(fsm.connectRetryTimer)
+	stopTimer(fsm.holdTimer)
+	stopTimer(fsm.keepaliveTimer)
+	fsm.changeState(Established, "Test")
+
+	sent := make(chan *packet.BGPUpdate, 10)
+	fsm.adjRIBOut = newAdjRIBOut(fsm, fsm.advertisementInterval(0), func(u *packet.BGPUpdate) error {
+		sent <- u
+		return nil
+	})
+	serveAdjRIBOut(t, fsm.adjRIBOut)
+
+	return fsm, clk, sent
+}
+
+// serveAdjRIBOut processes the changes queued in a like an established FSM
+// until the test ends
+func serveAdjRIBOut(t *testing.T, a *AdjRIBOut) {
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+	})
+
+	go func() {
+		for {
+			select {
+			case <-a.changed:
+				a.changesQueued()
+			case <-a.coalesceTimer.C():
+				a.coalesceExpired()
+			case <-a.mraiTimer.C():
+				a.mraiExpired()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// bgpPathWithMED returns an eBGP path whose MED was set by the import policy,
+// so it is also advertised to eBGP peers
+func bgpPathWithMED(med uint32) []*rt.Path {
+	return []*rt.Path{
+		{
+			Type: rt.BGPPathType,
+			BGPPath: &rt.BGPPath{
+				NextHop:   3325256705, // 198.51.100.1
+				LocalPref: 100,
+				MED:       med,
+				HasMED:    true,
+				EBGP:      true,
+				Received: &rt.BGPPath{
+					NextHop: 3325256705, // 198.51.100.1
+					EBGP:    true,
+				},
+			},
+		},
+	}
+}
+
+func receiveUpdate(t *testing.T, sent chan *packet.BGPUpdate) *packet.BGPUpdate {
+	select {
+	case u := <-sent:
+		return u
+	case <-time.After(time.Second):
+		t.Fatalf("No UPDATE was sent")
+	}
+
+	return nil
+}
+
+func assertNoUpdate(t *testing.T, sent chan *packet.BGPUpdate, msg string) {
+	select {
+	case u := <-sent:
+		t.Fatalf("%s: unexpected UPDATE: %v", msg, u)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+// assertPrefixesAdvertised waits for the number of prefixes advertised to be
+// counted, which happens after they were sent
+func assertPrefixesAdvertised(t *testing.T, fsm *FSM, n uint64) {
+	assert.Eventually(t, func() bool {
+		return fsm.Info().PrefixesAdvertised == n
+	}, time.Second, time.Millisecond, "%d prefixes advertised, expected %d", fsm.Info().PrefixesAdvertised, n)
+}
+
+func TestAdjRIBOutCoalescesChanges(t *testing.T) {
+	fsm, clk, sent := adjRIBOutFSM(t, 65201)
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	fsm.adjRIBOut.UpdateActivePaths(pfx, bgpPathWithMED(10))
+	fsm.adjRIBOut.UpdateActivePaths(pfx, bgpPathWithMED(20))
+	assertNoUpdate(t, sent, "Announcement was not delayed")
+
+	waitForTimer(t, clk)
+	clk.Advance(29 * time.Second)
+	assertNoUpdate(t, sent, "Announcement was sent before the MRAI expired")
+
+	clk.Advance(time.Second)
+	u := receiveUpdate(t, sent)
+	assertNoUpdate(t, sent, "Changes were not coalesced")
+
+	med := &packet.PathAttribute{
+		TypeCode: packet.MEDAttr,
+		Optional: true,
+		Value:    uint32(20),
+	}
+	nextHop := &packet.PathAttribute{
+		TypeCode:   packet.NextHopAttr,
+		Transitive: true,
+		Value:      net.IP{169, 254, 0, 1},
+		Next:       med,
+	}
+	asPath := &packet.PathAttribute{
+		TypeCode:   packet.ASPathAttr,
+		Transitive: true,
+		Value: packet.ASPath{
+			{Type: packet.ASSequence, Count: 1, ASNs: []uint32{65200}},
+		},
+		Next: nextHop,
+	}
+	expected := &packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode:   packet.OriginAttr,
+			Transitive: true,
+			Value:      uint8(0),
+			Next:       asPath,
+		},
+		NLRI: &packet.NLRI{
+			IP:     [4]byte{192, 0, 2, 0},
+			Pfxlen: 24,
+		},
+	}
+	assert.Equal(t, expected, u)
+	assertPrefixesAdvertised(t, fsm, 1)
+
+	_, err := packet.SerializeUpdateMsg(u)
+	assert.NoError(t, err)
+}
+
+func TestAdjRIBOutExpeditesWithdrawals(t *testing.T) {
+	fsm, clk, sent := adjRIBOutFSM(t, 65201)
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	fsm.adjRIBOut.UpdateActivePaths(pfx, bgpPathWithMED(10))
+	waitForTimer(t, clk)
+	clk.Advance(30 * time.Second)
+	receiveUpdate(t, sent)
+
+	fsm.adjRIBOut.UpdateActivePaths(pfx, nil)
+	u := receiveUpdate(t, sent)
+	assert.Equal(t, &packet.BGPUpdate{
+		WithdrawnRoutes: &packet.NLRI{
+			IP:     [4]byte{192, 0, 2, 0},
+			Pfxlen: 24,
+		},
+	}, u)
+	assertPrefixesAdvertised(t, fsm, 0)
+}
+
+func TestAdjRIBOutWithdrawQueued(t *testing.T) {
+	fsm, clk, sent := adjRIBOutFSM(t, 65201)
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	fsm.adjRIBOut.UpdateActivePaths(pfx, bgpPathWithMED(10))
+	fsm.adjRIBOut.UpdateActivePaths(pfx, nil)
+	assertNoUpdate(t, sent, "Never advertised prefix was withdrawn")
+
+	clk.Advance(30 * time.Second)
+	assertNoUpdate(t, sent, "Withdrawn announcement was sent")
+}
+
+func TestAdjRIBOutCoalescingInterval(t *testing.T) {
+	fsm, clk, sent := adjRIBOutFSM(t, 65200)
+	fsm.adjRIBOut.coalesce = 100 * time.Millisecond
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	fsm.adjRIBOut.UpdateActivePaths(pfx, bgpPathWithMED(10))
+	waitForTimer(t, clk)
+	fsm.adjRIBOut.UpdateActivePaths(pfx, nil)
+	fsm.adjRIBOut.UpdateActivePaths(pfx, bgpPathWithMED(30))
+	assertNoUpdate(t, sent, "Change was sent within the coalescing interval")
+
+	clk.Advance(100 * time.Millisecond)
+	u := receiveUpdate(t, sent)
+	assertNoUpdate(t, sent, "Changes were not coalesced")
+	assert.Nil(t, u.WithdrawnRoutes)
+	assert.Equal(t, uint32(30), pathAttribute(u, packet.MEDAttr).Value)
+}
+
+func TestAdjRIBOutIBGPWithoutDelay(t *testing.T) {
+	fsm, _, sent := adjRIBOutFSM(t, 65200)
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	fsm.adjRIBOut.UpdateActivePaths(pfx, bgpPathWithMED(10))
+	u := receiveUpdate(t, sent)
+
+	var localPref interface{}
+	for pa := u.PathAttributes; pa != nil; pa = pa.Next {
+		if pa.TypeCode == packet.LocalPrefAttr {
+			localPref = pa.Value
+		}
+	}
+	assert.Equal(t, uint32(100), localPref)
+}
+
+func TestAdjRIBOutNextHop(t *testing.T) {
+	tests := []struct {
+		name     string
+		peerAS   uint32
+		expected net.IP
+	}{
+		{
+			name:     "Unchanged towards iBGP peers",
+			peerAS:   65200,
+			expected: net.IP{198, 51, 100, 1},
+		},
+		{
+			name:     "Self towards eBGP peers",
+			peerAS:   65201,
+			expected: net.IP{169, 254, 0, 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fsm, clk, sent := adjRIBOutFSM(t, test.peerAS)
+			pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+			fsm.adjRIBOut.UpdateActivePaths(pfx, bgpPathWithMED(10))
+			if fsm.external() {
+				waitForTimer(t, clk)
+				clk.Advance(30 * time.Second)
+			}
+
+			u := receiveUpdate(t, sent)
+			assert.Equal(t, test.expected, pathAttribute(u, packet.NextHopAttr).Value)
+		})
+	}
+}
+
+func TestSendUpdateASNWidth(t *testing.T) {
+	tests := []struct {
+		name        string
+		use32BitASN bool
+		asPath      packet.ASPath
+	}{
+		{
+			name:        "4-octet AS speaker",
+			use32BitASN: true,
+			asPath: packet.ASPath{
+				{Type: packet.ASSequence, Count: 2, ASNs: []uint32{4200000000, 65201}},
+			},
+		},
+		{
+			name: "2-octet AS speaker",
+			asPath: packet.ASPath{
+				{Type: packet.ASSequence, Count: 2, ASNs: []uint32{packet.ASTrans, 65201}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fsm := newFSM(config.Peer{
+				LocalAS:      4200000000,
+				PeerAS:       65201,
+				LocalAddress: net.IP{169, 254, 0, 1},
+				PeerAddress:  net.IP{169, 254, 0, 2},
+			}, newFakeClock())
+			c, s := tcpPair(t)
+			defer c.Close()
+			defer s.Close()
+			fsm.con = c
+			fsm.encodeOptions.Use32BitASN = test.use32BitASN
+
+			b := &rt.BGPPath{
+				NextHop:        2851995649, // 169.254.0.1
+				AggregatorAS:   4200000000,
+				AggregatorAddr: 167772161, // 10.0.0.1
+			}
+			b.SetASPath(packet.ASPath{
+				{Type: packet.ASSequence, Count: 2, ASNs: []uint32{4200000000, 65201}},
+			})
+			err := fsm.sendUpdate(fsm.update(tnet.NewPfx(3221225984, 24), b, 0)) // 192.0.2.0/24
+			if err != nil {
+				t.Fatalf("Unable to send UPDATE: %v", err)
+			}
+
+			buf := make([]byte, 4096)
+			s.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := s.Read(buf)
+			if err != nil {
+				t.Fatalf("Unable to read UPDATE: %v", err)
+			}
+
+			msg, err := packet.Decode(bytes.NewBuffer(buf[:n]), &packet.DecodeOptions{Use32BitASN: test.use32BitASN})
+			if err != nil {
+				t.Fatalf("Unable to decode UPDATE: %v", err)
+			}
+
+			u := msg.Body.(*packet.BGPUpdate)
+			for pa := u.PathAttributes; pa != nil; pa = pa.Next {
+				if pa.TypeCode == packet.ASPathAttr {
+					assert.Equal(t, test.asPath, pa.Value)
+				}
+			}
+
+			// A 2-octet AS speaker restores the 4-octet ASNs from AS4_PATH and AS4_AGGREGATOR
+			peer := newFSM(config.Peer{
+				LocalAS:      65201,
+				PeerAS:       4200000000,
+				LocalAddress: net.IP{169, 254, 0, 2},
+				PeerAddress:  net.IP{169, 254, 0, 1},
+			}, newFakeClock())
+			peer.decodeOptions.Use32BitASN = test.use32BitASN
+			res := peer.bgpPath(u.PathAttributes)
+			assert.Equal(t, b.ASPath, res.ASPath)
+			assert.Equal(t, uint32(4200000000), res.AggregatorAS)
+			assert.Equal(t, uint32(167772161), res.AggregatorAddr)
+			assert.Empty(t, res.UnknownAttributes)
+		})
+	}
+}
+
+func TestAdjRIBOutDumpOnEstablished(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:      65200,
+		PeerAS:       65200,
+		LocalAddress: net.IP{169, 254, 0, 1},
+		PeerAddress:  net.IP{169, 254, 0, 2},
+	}, newFakeClock())
+	sent := make(chan *packet.BGPUpdate, 10)
+	fsm.adjRIBOut = newAdjRIBOut(fsm, fsm.advertisementInterval(0), func(u *packet.BGPUpdate) error {
+		sent <- u
+		return nil
+	})
+	serveAdjRIBOut(t, fsm.adjRIBOut)
+
+	rib := rt.NewRIB(nil)
+	rib.AddPath(tnet.NewPfx(3221225984, 24), bgpPathWithMED(10)[0]) // 192.0.2.0/24
+	fsm.adjRIBOut.Attach(rib)
+	rib.AddPath(tnet.NewPfx(167772160, 8), bgpPathWithMED(10)[0]) // 10.0.0.0/8
+	assertNoUpdate(t, sent, "Route was advertised before the session was established")
+
+	fsm.changeState(Established, "Test")
+	fsm.adjRIBOut.dump()
+
+	var advertised []*packet.NLRI
+	for i := 0; i < 2; i++ {
+		advertised = append(advertised, receiveUpdate(t, sent).NLRI)
+	}
+	assertNoUpdate(t, sent, "Route was advertised twice")
+	assert.ElementsMatch(t, []*packet.NLRI{
+		{IP: [4]byte{10, 0, 0, 0}, Pfxlen: 8},
+		{IP: [4]byte{192, 0, 2, 0}, Pfxlen: 24},
+	}, advertised)
+	assertPrefixesAdvertised(t, fsm, 2)
+}
+
+func TestAdjRIBOutRouteRefresh(t *testing.T) {
+	fsm, _, sent := adjRIBOutFSM(t, 65200)
+	rib := rt.NewRIB(nil)
+	fsm.adjRIBOut.Attach(rib)
+	rib.AddPath(tnet.NewPfx(3221225984, 24), bgpPathWithMED(10)[0]) // 192.0.2.0/24
+	receiveUpdate(t, sent)
+
+	fsm.routeRefreshReceived(&packet.BGPRouteRefresh{AFI: packet.IPv6AFI, SAFI: packet.UnicastSAFI})
+	assertNoUpdate(t, sent, "Routes were advertised again for IPv6 unicast")
+
+	fsm.routeRefreshReceived(&packet.BGPRouteRefresh{AFI: packet.IPv4AFI, SAFI: packet.UnicastSAFI})
+	u := receiveUpdate(t, sent)
+	assert.Equal(t, &packet.NLRI{IP: [4]byte{192, 0, 2, 0}, Pfxlen: 24}, u.NLRI)
+	assertNoUpdate(t, sent, "Route was advertised twice")
+	assertPrefixesAdvertised(t, fsm, 1)
+}
+
+func TestAdjRIBOutSlowPeer(t *testing.T) {
+	fsm, _, _ := adjRIBOutFSM(t, 65200)
+	blocked := make(chan struct{})
+	defer close(blocked)
+	fsm.adjRIBOut.send = func(u *packet.BGPUpdate) error {
+		<-blocked
+		return nil
+	}
+
+	rib := rt.NewRIB(nil)
+	fsm.adjRIBOut.Attach(rib)
+
+	done := make(chan struct{})
+	go func() {
+		rib.AddPath(tnet.NewPfx(3221225984, 24), bgpPathWithMED(10)[0]) // 192.0.2.0/24
+		rib.AddPath(tnet.NewPfx(167772160, 8), bgpPathWithMED(10)[0])   // 10.0.0.0/8
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("RIB was blocked by a peer not reading its UPDATEs")
+	}
+}
+
+func TestAdjRIBOutMaintenance(t *testing.T) {
+	fsm, _, sent := adjRIBOutFSM(t, 65200)
+	rib := rt.NewRIB(nil)
+	fsm.adjRIBOut.Attach(rib)
+	rib.AddPath(tnet.NewPfx(3221225984, 24), bgpPathWithMED(10)[0]) // 192.0.2.0/24
+	receiveUpdate(t, sent)
+
+	attrs := func(u *packet.BGPUpdate) (localPref interface{}, communities interface{}) {
+		for pa := u.PathAttributes; pa != nil; pa = pa.Next {
+			switch pa.TypeCode {
+			case packet.LocalPrefAttr:
+				localPref = pa.Value
+			case packet.CommunitiesAttr:
+				communities = pa.Value
+			}
+		}
+		return
+	}
+
+	rib.EnterMaintenance()
+	localPref, communities := attrs(receiveUpdate(t, sent))
+	assert.Equal(t, uint32(0), localPref)
+	assert.Equal(t, []uint32{rt.GracefulShutdownCommunity}, communities)
+
+	rib.ExitMaintenance()
+	localPref, communities = attrs(receiveUpdate(t, sent))
+	assert.Equal(t, uint32(100), localPref)
+	assert.Nil(t, communities)
+	assertNoUpdate(t, sent, "Maintenance caused extra UPDATEs")
+}
+
+// multiPathRoute returns three active paths learned from different eBGP peers
+// that only differ in their next hops 198.51.100.1 to 198.51.100.3
+func multiPathRoute() []*rt.Path {
+	var paths []*rt.Path
+	for i := uint32(1); i <= 3; i++ {
+		paths = append(paths, &rt.Path{
+			Type: rt.BGPPathType,
+			BGPPath: &rt.BGPPath{
+				NextHop:   3325256704 + i, // 198.51.100.i
+				LocalPref: 100,
+				EBGP:      true,
+				Source:    167772160 + i, // 10.0.0.i
+			},
+		})
+	}
+
+	return paths
+}
+
+func TestAdjRIBOutBestPathOnly(t *testing.T) {
+	tests := []struct {
+		name           string
+		advertisePaths uint8
+		addPath        bool
+	}{
+		{
+			name: "Best path only",
+		},
+		{
+			name:           "ADD-PATH not negotiated",
+			advertisePaths: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fsm, _, sent := adjRIBOutFSM(t, 65200)
+			fsm.advertisePaths = test.advertisePaths
+			pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+			fsm.adjRIBOut.UpdateActivePaths(pfx, multiPathRoute())
+			u := receiveUpdate(t, sent)
+			assertNoUpdate(t, sent, "More than one path was advertised")
+			assert.Equal(t, &packet.NLRI{
+				IP:     [4]byte{192, 0, 2, 0},
+				Pfxlen: 24,
+			}, u.NLRI)
+			assert.Equal(t, net.IP{198, 51, 100, 1}, pathAttribute(u, packet.NextHopAttr).Value)
+		})
+	}
+}
+
+func TestAdjRIBOutAddPath(t *testing.T) {
+	fsm, _, sent := adjRIBOutFSM(t, 65200)
+	fsm.advertisePaths = 2
+	fsm.encodeOptions.AddPath = true
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	paths := multiPathRoute()
+
+	fsm.adjRIBOut.UpdateActivePaths(pfx, paths)
+	for i := uint32(1); i <= 2; i++ {
+		u := receiveUpdate(t, sent)
+		assert.Equal(t, &packet.NLRI{
+			PathIdentifier: i,
+			IP:             [4]byte{192, 0, 2, 0},
+			Pfxlen:         24,
+		}, u.NLRI)
+		assert.Equal(t, net.IP{198, 51, 100, byte(i)}, pathAttribute(u, packet.NextHopAttr).Value)
+	}
+	assertNoUpdate(t, sent, "More than two paths were advertised")
+	assertPrefixesAdvertised(t, fsm, 1)
+
+	// The second path is withdrawn once the route has a single path left
+	fsm.adjRIBOut.UpdateActivePaths(pfx, paths[2:])
+	u := receiveUpdate(t, sent)
+	assert.Equal(t, uint32(1), u.NLRI.PathIdentifier)
+	assert.Equal(t, net.IP{198, 51, 100, 3}, pathAttribute(u, packet.NextHopAttr).Value)
+	assert.Equal(t, &packet.NLRI{
+		PathIdentifier: 2,
+		IP:             [4]byte{192, 0, 2, 0},
+		Pfxlen:         24,
+	}, receiveUpdate(t, sent).WithdrawnRoutes)
+
+	fsm.adjRIBOut.UpdateActivePaths(pfx, nil)
+	assert.Equal(t, &packet.NLRI{
+		PathIdentifier: 1,
+		IP:             [4]byte{192, 0, 2, 0},
+		Pfxlen:         24,
+	}, receiveUpdate(t, sent).WithdrawnRoutes)
+	assertNoUpdate(t, sent, "Unexpected withdrawal")
+	assertPrefixesAdvertised(t, fsm, 0)
+}
+
+func TestNegotiateAddPath(t *testing.T) {
+	tests := []struct {
+		name           string
+		advertisePaths uint8
+		sendReceive    uint8
+		expected       bool
+	}{
+		{
+			name:           "Peer receives multiple paths",
+			advertisePaths: 2,
+			sendReceive:    packet.AddPathSendReceive,
+			expected:       true,
+		},
+		{
+			name:           "Peer only sends multiple paths",
+			advertisePaths: 2,
+			sendReceive:    packet.AddPathSend,
+		},
+		{
+			name:        "Best path only",
+			sendReceive: packet.AddPathReceive,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fsm := newFSM(config.Peer{
+				LocalAS:        65200,
+				PeerAS:         65201,
+				AdvertisePaths: test.advertisePaths,
+			}, newFakeClock())
+			c, s := tcpPair(t)
+			defer c.Close()
+			defer s.Close()
+			fsm.con = c
+
+			open := &packet.BGPOpen{}
+			open.AddCapability(packet.Capability{
+				Code: packet.AddPathCapabilityCode,
+				Value: packet.AddPathCapability{
+					AddressFamilies: []packet.AddPathAddressFamily{
+						{AFI: packet.IPv4AFI, SAFI: packet.UnicastSAFI, SendReceive: test.sendReceive},
+					},
+				},
+			})
+			err := fsm.acceptOpen(open)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, fsm.encodeOptions.AddPath)
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+)
+
+// aggregate tracks the routes contributing to an aggregate advertised to a peer
+type aggregate struct {
+	cfg config.Aggregate
+
+	// contributors holds the best path of every more specific prefix
+	contributors map[string]contributor
+
+	// path is the aggregate path last queued for advertisement, nil if the
+	// aggregate is not advertised
+	path *rt.BGPPath
+}
+
+// contributor is the best path of a prefix contributing to an aggregate
+type contributor struct {
+	pfx  *tnet.Prefix
+	path *rt.Path
+}
+
+func newAggregates(cfgs []config.Aggregate) []*aggregate {
+	res := make([]*aggregate, 0, len(cfgs))
+	for _, c := range cfgs {
+		if c.Prefix == nil || c.Prefix.AFI() != packet.IPv4AFI {
+			continue
+		}
+
+		res = append(res, &aggregate{
+			cfg:          c,
+			contributors: make(map[string]contributor),
+		})
+	}
+
+	return res
+}
+
+func (agg *aggregate) reset() {
+	agg.contributors = make(map[string]contributor)
+	agg.path = nil
+}
+
+// Aggregates returns the aggregates currently advertised to the peer. The
+// contributing prefixes of each are available through Route.Contributors.
+func (a *AdjRIBOut) Aggregates() []*rt.Route {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	res := make([]*rt.Route, 0, len(a.aggregates))
+	for _, agg := range a.aggregates {
+		if agg.path == nil {
+			continue
+		}
+
+		r := rt.NewRoute(agg.cfg.Prefix, nil)
+		r.AddPath(&rt.Path{
+			Type:    rt.BGPPathType,
+			BGPPath: agg.path,
+		})
+		r.SetContributors(agg.contributorPrefixes())
+		res = append(res, r)
+	}
+
+	return res
+}
+
+// contributorPrefixes returns the prefixes of the contributors of agg ordered
+// by address and prefix length
+func (agg *aggregate) contributorPrefixes() []*tnet.Prefix {
+	res := make([]*tnet.Prefix, 0, len(agg.contributors))
+	for _, c := range agg.contributors {
+		res = append(res, c.pfx)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Addr() != res[j].Addr() {
+			return res[i].Addr() < res[j].Addr()
+		}
+
+		return res[i].Pfxlen() < res[j].Pfxlen()
+	})
+
+	return res
+}
+
+// isAggregate checks if pfx is the prefix of an aggregate
+func (a *AdjRIBOut) isAggregate(pfx *tnet.Prefix) bool {
+	for _, agg := range a.aggregates {
+		if agg.cfg.Prefix.Equal(pfx) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateAggregates records the best path of pfx as contributor of all
+// aggregates containing pfx and advertises or withdraws them accordingly. It
+// returns true if pfx is suppressed by a summary only aggregate.
+func (a *AdjRIBOut) updateAggregates(pfx *tnet.Prefix, paths []*rt.Path) (suppressed bool) {
+	key := pfx.String()
+	for _, agg := range a.aggregates {
+		if !agg.cfg.Prefix.Contains(pfx) {
+			continue
+		}
+
+		if len(paths) == 0 {
+			delete(agg.contributors, key)
+		} else {
+			agg.contributors[key] = contributor{
+				pfx:  pfx,
+				path: paths[0],
+			}
+			suppressed = suppressed || agg.cfg.SummaryOnly
+		}
+
+		a.advertiseAggregate(agg)
+	}
+
+	return suppressed
+}
+
+// advertiseAggregate queues agg for advertisement if its attributes changed.
+// It is withdrawn once no contributors are left or the export policy rejects
+// it.
+func (a *AdjRIBOut) advertiseAggregate(agg *aggregate) {
+	pfx := agg.cfg.Prefix
+	if len(agg.contributors) == 0 {
+		agg.path = nil
+		a.withdraw(pfx)
+		return
+	}
+
+	exported, accept := a.fsm.exportAggregate(pfx, a.fsm.aggregatePath(agg))
+	if !accept {
+		agg.path = nil
+		a.withdraw(pfx)
+		return
+	}
+
+	if agg.path.Equal(exported.BGPPath) {
+		return
+	}
+
+	agg.path = exported.BGPPath
+	a.queue(pfx, []*rt.BGPPath{exported.BGPPath})
+}
+
+// aggregatePath forms the path of agg from its contributors. The ORIGIN is
+// the least preferred one of all contributors (RFC 4271, 9.2.2.2).
+func (fsm *FSM) aggregatePath(agg *aggregate) *rt.BGPPath {
+	b := &rt.BGPPath{
+		LocalPref: 100,
+	}
+
+	paths := make([]packet.ASPath, 0, len(agg.contributors))
+	for _, c := range agg.contributors {
+		p := c.path
+		switch p.Type {
+		case rt.BGPPathType:
+			paths = append(paths, p.BGPPath.ASPathSegments)
+			b.Origin = maxOrigin(b.Origin, p.BGPPath.Origin)
+			b.AtomicAggregate = b.AtomicAggregate || p.BGPPath.AtomicAggregate
+		case rt.LocalPathType:
+			paths = append(paths, packet.ASPath{})
+			b.Origin = maxOrigin(b.Origin, p.LocalPath.Origin)
+		}
+	}
+
+	path, lost := packet.AggregateASPaths(paths, !agg.cfg.DisableASSet)
+	b.SetASPath(path)
+	b.AtomicAggregate = b.AtomicAggregate || lost
+
+	if agg.cfg.Aggregator {
+		b.AggregatorAS = fsm.localASN
+		b.AggregatorAddr = fsm.routerID
+	}
+
+	return b
+}
+
+// exportAggregate returns the path the aggregate pfx is advertised with. Like
+// locally originated paths it carries the local address of the session as
+// next hop.
+func (fsm *FSM) exportAggregate(pfx *tnet.Prefix, b *rt.BGPPath) (res *rt.Path, accept bool) {
+	b.SetNextHop(fsm.localAddress())
+	return fsm.exportBGPPath(pfx, b)
+}
+
+// maxOrigin returns the less preferred of the ORIGINs a and b. IGP is
+// preferred over EGP which is preferred over INCOMPLETE.
+func maxOrigin(a, b uint8) uint8 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
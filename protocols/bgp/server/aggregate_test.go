@@ -0,0 +1,186 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+// aggregateFSM creates an established eBGP session advertising aggregates
+// without delay
+func aggregateFSM(t *testing.T, aggs ...config.Aggregate) (*FSM, chan *packet.BGPUpdate) {
+	fsm, _, sent := adjRIBOutFSM(t, 65201)
+	fsm.routerID = 167772161 // 10.0.0.1
+	fsm.adjRIBOut.mrai = 0
+	fsm.adjRIBOut.aggregates = newAggregates(aggs)
+
+	return fsm, sent
+}
+
+func contributorPath(asns ...uint32) []*rt.Path {
+	b := &rt.BGPPath{
+		NextHop:   3325256705, // 198.51.100.1
+		LocalPref: 100,
+		EBGP:      true,
+	}
+	b.SetASPath(packet.ASPath{
+		{Type: packet.ASSequence, Count: uint8(len(asns)), ASNs: asns},
+	})
+
+	return []*rt.Path{
+		{
+			Type:    rt.BGPPathType,
+			BGPPath: b,
+		},
+	}
+}
+
+func pathAttribute(u *packet.BGPUpdate, typeCode uint8) *packet.PathAttribute {
+	for pa := u.PathAttributes; pa != nil; pa = pa.Next {
+		if pa.TypeCode == typeCode {
+			return pa
+		}
+	}
+
+	return nil
+}
+
+func TestAdjRIBOutAggregate(t *testing.T) {
+	tests := []struct {
+		name            string
+		aggregate       config.Aggregate
+		expectedASPath  packet.ASPath
+		atomicAggregate bool
+		aggregator      bool
+	}{
+		{
+			name: "AS_SET",
+			aggregate: config.Aggregate{
+				Prefix:     tnet.NewPfx(167772160, 8), // 10.0.0.0/8
+				Aggregator: true,
+			},
+			expectedASPath: packet.ASPath{
+				{Type: packet.ASSequence, Count: 2, ASNs: []uint32{65200, 65201}},
+				{Type: packet.ASSet, Count: 2, ASNs: []uint32{65300, 65400}},
+			},
+			aggregator: true,
+		},
+		{
+			name: "AS_SET disabled",
+			aggregate: config.Aggregate{
+				Prefix:       tnet.NewPfx(167772160, 8),
+				DisableASSet: true,
+			},
+			expectedASPath: packet.ASPath{
+				{Type: packet.ASSequence, Count: 2, ASNs: []uint32{65200, 65201}},
+			},
+			atomicAggregate: true,
+		},
+	}
+
+	for _, test := range tests {
+		fsm, sent := aggregateFSM(t, test.aggregate)
+
+		fsm.adjRIBOut.UpdateActivePaths(tnet.NewPfx(167837696, 16), contributorPath(65201, 65300)) // 10.1.0.0/16
+		receiveUpdate(t, sent)
+		receiveUpdate(t, sent)
+
+		fsm.adjRIBOut.UpdateActivePaths(tnet.NewPfx(167903232, 16), contributorPath(65201, 65400)) // 10.2.0.0/16
+		u := receiveUpdate(t, sent)
+		assert.Equal(t, &packet.NLRI{IP: [4]byte{10, 0, 0, 0}, Pfxlen: 8}, u.NLRI, test.name)
+		assert.Equal(t, test.expectedASPath, pathAttribute(u, packet.ASPathAttr).Value, test.name)
+		assert.Equal(t, test.atomicAggregate, pathAttribute(u, packet.AtomicAggrAttr) != nil, test.name)
+
+		aggregator := pathAttribute(u, packet.AggregatorAttr)
+		if test.aggregator && assert.NotNil(t, aggregator, test.name) {
+			assert.Equal(t, packet.Aggretator{Addr: [4]byte{10, 0, 0, 1}, ASN: 65200}, aggregator.Value, test.name)
+		} else {
+			assert.Nil(t, aggregator, test.name)
+		}
+
+		_, err := packet.SerializeUpdateMsg(u)
+		assert.NoError(t, err, test.name)
+
+		u = receiveUpdate(t, sent)
+		assert.Equal(t, &packet.NLRI{IP: [4]byte{10, 2, 0, 0}, Pfxlen: 16}, u.NLRI, test.name)
+		assertNoUpdate(t, sent, test.name)
+	}
+}
+
+func TestAdjRIBOutAggregateSummaryOnly(t *testing.T) {
+	fsm, sent := aggregateFSM(t, config.Aggregate{
+		Prefix:      tnet.NewPfx(167772160, 8), // 10.0.0.0/8
+		SummaryOnly: true,
+	})
+	contributor := tnet.NewPfx(167837696, 16) // 10.1.0.0/16
+
+	fsm.adjRIBOut.UpdateActivePaths(contributor, contributorPath(65201, 65300))
+	u := receiveUpdate(t, sent)
+	assert.Equal(t, &packet.NLRI{IP: [4]byte{10, 0, 0, 0}, Pfxlen: 8}, u.NLRI)
+	assertNoUpdate(t, sent, "Contributor was not suppressed")
+
+	fsm.adjRIBOut.UpdateActivePaths(contributor, contributorPath(65201, 65300))
+	assertNoUpdate(t, sent, "Unchanged aggregate was advertised again")
+
+	fsm.adjRIBOut.UpdateActivePaths(tnet.NewPfx(3221225984, 24), contributorPath(65201)) // 192.0.2.0/24
+	u = receiveUpdate(t, sent)
+	assert.Equal(t, &packet.NLRI{IP: [4]byte{192, 0, 2, 0}, Pfxlen: 24}, u.NLRI)
+	assertPrefixesAdvertised(t, fsm, 2)
+
+	fsm.adjRIBOut.UpdateActivePaths(contributor, nil)
+	u = receiveUpdate(t, sent)
+	assert.Equal(t, &packet.BGPUpdate{
+		WithdrawnRoutes: &packet.NLRI{
+			IP:     [4]byte{10, 0, 0, 0},
+			Pfxlen: 8,
+		},
+	}, u)
+	assertNoUpdate(t, sent, "Suppressed contributor was withdrawn")
+}
+
+func TestAdjRIBOutAggregateContributorWithdrawn(t *testing.T) {
+	fsm, sent := aggregateFSM(t, config.Aggregate{
+		Prefix: tnet.NewPfx(167772160, 8), // 10.0.0.0/8
+	})
+	a := tnet.NewPfx(167837696, 16) // 10.1.0.0/16
+	b := tnet.NewPfx(167903232, 16) // 10.2.0.0/16
+
+	fsm.adjRIBOut.UpdateActivePaths(a, contributorPath(65300))
+	receiveUpdate(t, sent)
+	receiveUpdate(t, sent)
+	fsm.adjRIBOut.UpdateActivePaths(b, contributorPath(65400))
+	u := receiveUpdate(t, sent)
+	assert.Equal(t, packet.ASPath{
+		{Type: packet.ASSequence, Count: 1, ASNs: []uint32{65200}},
+		{Type: packet.ASSet, Count: 2, ASNs: []uint32{65300, 65400}},
+	}, pathAttribute(u, packet.ASPathAttr).Value)
+	receiveUpdate(t, sent)
+
+	aggs := fsm.adjRIBOut.Aggregates()
+	if assert.Len(t, aggs, 1) {
+		assert.Equal(t, []*tnet.Prefix{a, b}, aggs[0].Contributors())
+	}
+
+	// Withdrawing a contributor recomputes the AS_SET
+	fsm.adjRIBOut.UpdateActivePaths(a, nil)
+	var asPath interface{}
+	for i := 0; i < 2; i++ {
+		u := receiveUpdate(t, sent)
+		if u.NLRI != nil {
+			asPath = pathAttribute(u, packet.ASPathAttr).Value
+		}
+	}
+	assert.Equal(t, packet.ASPath{
+		{Type: packet.ASSequence, Count: 2, ASNs: []uint32{65200, 65400}},
+	}, asPath)
+
+	aggs = fsm.adjRIBOut.Aggregates()
+	if assert.Len(t, aggs, 1) {
+		assert.Equal(t, []*tnet.Prefix{b}, aggs[0].Contributors())
+		assert.Equal(t, "65200 65400", aggs[0].ActivePaths()[0].BGPPath.ASPath)
+	}
+}
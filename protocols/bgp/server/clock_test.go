@@ -0,0 +1,251 @@
+package server
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a clock which only advances when told to
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	tickers []*fakeTicker
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{
+		clock:    c,
+		ch:       make(chan time.Time, 1),
+		deadline: c.now.Add(d),
+		active:   true,
+	}
+	c.timers = append(c.timers, t)
+	c.fire()
+
+	return t
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTicker{
+		clock:  c,
+		ch:     make(chan time.Time, 1),
+		period: d,
+		next:   c.now.Add(d),
+		active: true,
+	}
+	c.tickers = append(c.tickers, t)
+
+	return t
+}
+
+// Advance moves the clock forward by d firing all timers and tickers due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	c.fire()
+}
+
+func (c *fakeClock) fire() {
+	for _, t := range c.timers {
+		if t.active && !t.deadline.After(c.now) {
+			t.active = false
+			select {
+			case t.ch <- c.now:
+			default:
+			}
+		}
+	}
+
+	for _, t := range c.tickers {
+		for t.active && !t.next.After(c.now) {
+			t.next = t.next.Add(t.period)
+			select {
+			case t.ch <- c.now:
+			default:
+			}
+		}
+	}
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	ch       chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	wasActive := t.active
+	t.active = true
+	t.deadline = t.clock.now.Add(d)
+	t.clock.fire()
+
+	return wasActive
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	wasActive := t.active
+	t.active = false
+
+	return wasActive
+}
+
+type fakeTicker struct {
+	clock  *fakeClock
+	ch     chan time.Time
+	period time.Duration
+	next   time.Time
+	active bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	t.active = false
+}
+
+func TestHoldTimerExpiry(t *testing.T) {
+	clk := newFakeClock()
+	fsm := newFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+		HoldTimer:   90,
+	}, clk)
+	changes := fsm.Subscribe()
+
+	local, remote := tcpPair(t)
+	defer remote.Close()
+
+	// Drain the timers which fire on creation
+	stopTimer(fsm.holdTimer)
+	stopTimer(fsm.keepaliveTimer)
+
+	fsm.con = local
+	fsm.setHoldTime(90)
+	fsm.holdTimer = clk.NewTimer(time.Second * fsm.holdTime)
+	fsm.changeState(Established, "Test")
+
+	done := make(chan int)
+	go func() {
+		done <- fsm.established()
+	}()
+
+	clk.Advance(89 * time.Second)
+	select {
+	case <-done:
+		t.Fatalf("Session went down before the hold timer expired")
+	default:
+	}
+
+	clk.Advance(time.Second)
+	assert.Equal(t, Idle, <-done)
+
+	buf := make([]byte, packet.MinLen+2)
+	_, err := io.ReadFull(remote, buf)
+	if err != nil {
+		t.Fatalf("Unable to read NOTIFICATION: %v", err)
+	}
+
+	assert.Equal(t, []byte{packet.NotificationMsg, packet.HoldTimeExpired, 0}, buf[packet.MinLen-1:])
+
+	assert.Equal(t, StateChange{Old: Idle, New: Established, Reason: "Test"}, <-changes)
+	assert.Equal(t, StateChange{
+		Old:    Established,
+		New:    Idle,
+		Reason: "Holdtimer expired",
+		Down: SessionDown{
+			Notification: &packet.BGPNotification{ErrorCode: packet.HoldTimeExpired},
+			Local:        true,
+			Event:        HoldTimerExpires,
+		},
+	}, <-changes)
+}
+
+func TestHoldTimeZeroDisablesKeepalives(t *testing.T) {
+	clk := newFakeClock()
+	fsm := newFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+		HoldTimer:   90,
+	}, clk)
+
+	local, remote := tcpPair(t)
+	defer remote.Close()
+
+	// Drain the timers which fire on creation
+	stopTimer(fsm.holdTimer)
+	stopTimer(fsm.keepaliveTimer)
+
+	fsm.con = local
+	fsm.setHoldTime(negotiateHoldTime(90, 0))
+	fsm.resetHoldTimer()
+	fsm.resetKeepaliveTimer()
+	fsm.changeState(Established, "Test")
+
+	done := make(chan int)
+	go func() {
+		done <- fsm.established()
+	}()
+
+	clk.Advance(time.Hour)
+
+	remote.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	buf := make([]byte, packet.MinLen)
+	_, err := io.ReadFull(remote, buf)
+	assert.Error(t, err, "Unexpected message received")
+
+	select {
+	case <-done:
+		t.Fatalf("Session went down without hold timer")
+	default:
+	}
+
+	fsm.eventCh <- ManualStop
+	assert.Equal(t, Idle, <-done)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net"
+
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	log "github.com/sirupsen/logrus"
+)
+
+// resolveCollision decides which of two connections to the peer survives once
+// its BGP identifier is known (RFC 4271, 6.8). The connection initiated by the
+// speaker with the higher BGP identifier is kept, the other one is closed with
+// a Cease NOTIFICATION. The surviving connection is fsm.con afterwards.
+func (fsm *FSM) resolveCollision() {
+	if fsm.con2 == nil {
+		return
+	}
+
+	if fsm.isPassive(fsm.con) == fsm.isPassive(fsm.con2) {
+		return
+	}
+
+	// Keep the connection we initiated if our identifier is higher
+	keepActive := fsm.routerID > fsm.neighborID
+
+	log.WithFields(log.Fields{
+		"peer":        fsm.remote.String(),
+		"router_id":   fsm.routerID,
+		"neighbor_id": fsm.neighborID,
+		"keep_active": keepActive,
+	}).Info("Resolving connection collision")
+
+	if fsm.isPassive(fsm.con) == keepActive {
+		dumpCon(fsm.con)
+		fsm.setCon(fsm.con2, nil)
+		return
+	}
+
+	dumpCon(fsm.con2)
+	fsm.setCon(fsm.con, nil)
+}
+
+// dropCon closes c which is one of two colliding connections. The other one
+// is fsm.con afterwards.
+func (fsm *FSM) dropCon(c *net.TCPConn) {
+	c.Close()
+	if c == fsm.con {
+		fsm.setCon(fsm.con2, nil)
+		return
+	}
+	fsm.setCon(fsm.con, nil)
+}
+
+func dumpCon(c *net.TCPConn) {
+	sendNotification(c, packet.Cease, packet.ConnectionCollisionResolution)
+	c.Close()
+}
+
+// isPassive returns true if c was initiated by the peer
+func (fsm *FSM) isPassive(c *net.TCPConn) bool {
+	return c.LocalAddr().(*net.TCPAddr).Port == BGPPORT
+}
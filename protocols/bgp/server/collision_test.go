@@ -0,0 +1,289 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// bgpPortPair returns both ends of a TCP connection to the BGP port
+func bgpPortPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IP{127, 0, 0, 1}, Port: BGPPORT})
+	if err != nil {
+		t.Skipf("Unable to listen on BGP port: %v", err)
+	}
+	defer l.Close()
+
+	c, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatalf("Unable to connect: %v", err)
+	}
+
+	s, err := l.AcceptTCP()
+	if err != nil {
+		t.Fatalf("Unable to accept: %v", err)
+	}
+
+	return c, s
+}
+
+func TestResolveCollision(t *testing.T) {
+	tests := []struct {
+		name         string
+		routerID     uint32
+		neighborID   uint32
+		activeFirst  bool
+		keepsPassive bool
+	}{
+		{
+			name:        "Higher local ID keeps active connection",
+			routerID:    167772162,
+			neighborID:  167772161,
+			activeFirst: false,
+		},
+		{
+			name:        "Higher local ID keeps active connection, active first",
+			routerID:    167772162,
+			neighborID:  167772161,
+			activeFirst: true,
+		},
+		{
+			name:         "Higher neighbor ID keeps passive connection",
+			routerID:     167772161,
+			neighborID:   167772162,
+			activeFirst:  false,
+			keepsPassive: true,
+		},
+		{
+			name:         "Higher neighbor ID keeps passive connection, active first",
+			routerID:     167772161,
+			neighborID:   167772162,
+			activeFirst:  true,
+			keepsPassive: true,
+		},
+	}
+
+	for _, test := range tests {
+		// passive was initiated by the peer, active by us
+		passivePeer, passive := bgpPortPair(t)
+		active, activePeer := tcpPair(t)
+
+		fsm := newFSM(config.Peer{
+			LocalAS:     65200,
+			PeerAS:      65201,
+			PeerAddress: net.IP{127, 0, 0, 1},
+			RouterID:    test.routerID,
+		}, newFakeClock())
+		fsm.neighborID = test.neighborID
+
+		fsm.con, fsm.con2 = passive, active
+		if test.activeFirst {
+			fsm.con, fsm.con2 = active, passive
+		}
+
+		fsm.resolveCollision()
+
+		kept, dumpedPeer := active, passivePeer
+		if test.keepsPassive {
+			kept, dumpedPeer = passive, activePeer
+		}
+
+		assert.Equalf(t, kept, fsm.con, "Test %q", test.name)
+		assert.Nilf(t, fsm.con2, "Test %q", test.name)
+
+		buf := make([]byte, packet.MinLen+2)
+		_, err := io.ReadFull(dumpedPeer, buf)
+		if err != nil {
+			t.Fatalf("Test %q: Unable to read: %v", test.name, err)
+		}
+		assert.Equalf(t, []byte{packet.NotificationMsg, packet.Cease, packet.ConnectionCollisionResolution}, buf[packet.MinLen-1:], "Test %q", test.name)
+
+		for _, c := range []*net.TCPConn{passivePeer, passive, active, activePeer} {
+			c.Close()
+		}
+	}
+}
+
+// readMsgType reads messages from c until one of type typ is received
+func readMsgType(t *testing.T, c *net.TCPConn, typ uint8) []byte {
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		msg, err := recvMsg(c)
+		if err != nil {
+			t.Fatalf("Unable to read message of type %d: %v", typ, err)
+		}
+
+		if msg[packet.MinLen-1] == typ {
+			return msg
+		}
+	}
+}
+
+func collisionOpen(t *testing.T, id uint32) []byte {
+	open, err := packet.SerializeOpenMsg(&packet.BGPOpen{
+		Version:       packet.BGP4Version,
+		AS:            65201,
+		HoldTime:      90,
+		BGPIdentifier: id,
+	})
+	if err != nil {
+		t.Fatalf("Unable to serialize OPEN: %v", err)
+	}
+
+	return open
+}
+
+func TestCollisionInOpenSent(t *testing.T) {
+	tests := []struct {
+		name         string
+		routerID     uint32
+		neighborID   uint32
+		activeFirst  bool
+		keepsPassive bool
+	}{
+		{
+			name:       "Higher local ID",
+			routerID:   167772162,
+			neighborID: 167772161,
+		},
+		{
+			name:        "Higher local ID, OPEN on active connection first",
+			routerID:    167772162,
+			neighborID:  167772161,
+			activeFirst: true,
+		},
+		{
+			name:         "Higher neighbor ID",
+			routerID:     167772161,
+			neighborID:   167772162,
+			keepsPassive: true,
+		},
+		{
+			name:         "Higher neighbor ID, OPEN on active connection first",
+			routerID:     167772161,
+			neighborID:   167772162,
+			activeFirst:  true,
+			keepsPassive: true,
+		},
+	}
+
+	for _, test := range tests {
+		passivePeer, passive := bgpPortPair(t)
+		active, activePeer := tcpPair(t)
+
+		fsm := newFSM(config.Peer{
+			LocalAS:     65200,
+			PeerAS:      65201,
+			PeerAddress: net.IP{127, 0, 0, 1},
+			HoldTimer:   90,
+			RouterID:    test.routerID,
+		}, newFakeClock())
+		stopTimer(fsm.holdTimer)
+		stopTimer(fsm.keepaliveTimer)
+		fsm.con = active
+
+		done := make(chan int)
+		go func() {
+			done <- fsm.openSent()
+		}()
+
+		fsm.conCh <- passive
+		readMsgType(t, passivePeer, packet.OpenMsg)
+
+		first, second := passivePeer, activePeer
+		if test.activeFirst {
+			first, second = activePeer, passivePeer
+		}
+		first.Write(collisionOpen(t, test.neighborID))
+		second.Write(collisionOpen(t, test.neighborID))
+
+		assert.Equalf(t, OpenConfirm, <-done, "Test %q", test.name)
+
+		kept, keptPeer, dumpedPeer := active, activePeer, passivePeer
+		if test.keepsPassive {
+			kept, keptPeer, dumpedPeer = passive, passivePeer, activePeer
+		}
+
+		assert.Equalf(t, kept, fsm.con, "Test %q", test.name)
+		assert.Nilf(t, fsm.con2, "Test %q", test.name)
+
+		readMsgType(t, keptPeer, packet.KeepaliveMsg)
+		msg := readMsgType(t, dumpedPeer, packet.NotificationMsg)
+		assert.Equalf(t, []byte{packet.Cease, packet.ConnectionCollisionResolution}, msg[packet.MinLen:packet.MinLen+2], "Test %q", test.name)
+
+		fsm.disconnect()
+		passivePeer.Close()
+		activePeer.Close()
+	}
+}
+
+func TestCollisionInOpenConfirm(t *testing.T) {
+	tests := []struct {
+		name         string
+		routerID     uint32
+		neighborID   uint32
+		keepsPassive bool
+	}{
+		{
+			name:       "Higher local ID",
+			routerID:   167772162,
+			neighborID: 167772161,
+		},
+		{
+			name:         "Higher neighbor ID",
+			routerID:     167772161,
+			neighborID:   167772162,
+			keepsPassive: true,
+		},
+	}
+
+	for _, test := range tests {
+		passivePeer, passive := bgpPortPair(t)
+		active, activePeer := tcpPair(t)
+
+		fsm := newFSM(config.Peer{
+			LocalAS:     65200,
+			PeerAS:      65201,
+			PeerAddress: net.IP{127, 0, 0, 1},
+			HoldTimer:   90,
+			RouterID:    test.routerID,
+		}, newFakeClock())
+		stopTimer(fsm.holdTimer)
+		stopTimer(fsm.keepaliveTimer)
+		fsm.con = active
+		fsm.neighborID = test.neighborID
+
+		go fsm.msgReceiver(active)
+		done := make(chan int)
+		go func() {
+			done <- fsm.openConfirm()
+		}()
+
+		fsm.conCh <- passive
+		readMsgType(t, passivePeer, packet.OpenMsg)
+		passivePeer.Write(collisionOpen(t, test.neighborID))
+
+		kept, keptPeer, dumpedPeer := active, activePeer, passivePeer
+		if test.keepsPassive {
+			kept, keptPeer, dumpedPeer = passive, passivePeer, activePeer
+			readMsgType(t, keptPeer, packet.KeepaliveMsg)
+		}
+
+		msg := readMsgType(t, dumpedPeer, packet.NotificationMsg)
+		assert.Equalf(t, []byte{packet.Cease, packet.ConnectionCollisionResolution}, msg[packet.MinLen:packet.MinLen+2], "Test %q", test.name)
+
+		keptPeer.Write(packet.SerializeKeepaliveMsg())
+		assert.Equalf(t, Established, <-done, "Test %q", test.name)
+		assert.Equalf(t, kept, fsm.con, "Test %q", test.name)
+		assert.Nilf(t, fsm.con2, "Test %q", test.name)
+
+		fsm.disconnect()
+		passivePeer.Close()
+		activePeer.Close()
+	}
+}
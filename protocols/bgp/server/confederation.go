@@ -0,0 +1,34 @@
+package server
+
+import (
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+)
+
+// external checks if the peer is outside of the local AS and, if configured,
+// the confederation
+func (fsm *FSM) external() bool {
+	return fsm.localASN != fsm.remoteASN && !fsm.confederationPeer
+}
+
+// sessionASN returns the AS number the local side uses on the session. Peers
+// outside of the confederation see the confederation ID.
+func (fsm *FSM) sessionASN() uint32 {
+	if fsm.confederationID != 0 && fsm.external() {
+		return fsm.confederationID
+	}
+
+	return fsm.localASN
+}
+
+// peerASPath returns the AS_PATH to advertise path with to a peer of another
+// AS (RFC 5065, 4). Peers of other member ASes of the confederation get the
+// local AS prepended as AS_CONFED_SEQUENCE. External peers get the path
+// without confederation segments and the confederation ID or local AS
+// prepended.
+func (fsm *FSM) peerASPath(path packet.ASPath) packet.ASPath {
+	if fsm.confederationPeer {
+		return path.PrependConfederation(fsm.localASN)
+	}
+
+	return path.StripConfederation().Prepend(fsm.sessionASN(), 1)
+}
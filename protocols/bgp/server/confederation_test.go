@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfederationExport(t *testing.T) {
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	path := packet.ASPath{
+		{Type: packet.ASConfedSequence, Count: 2, ASNs: []uint32{65102, 65103}},
+		{Type: packet.ASSequence, Count: 1, ASNs: []uint32{64496}},
+	}
+
+	tests := []struct {
+		name     string
+		peer     config.Peer
+		expected packet.ASPath
+	}{
+		{
+			name: "External peer",
+			peer: config.Peer{PeerAS: 64497},
+			expected: packet.ASPath{
+				{Type: packet.ASSequence, Count: 2, ASNs: []uint32{65000, 64496}},
+			},
+		},
+		{
+			name: "Peer of another member AS",
+			peer: config.Peer{PeerAS: 65104, ConfederationPeer: true},
+			expected: packet.ASPath{
+				{Type: packet.ASConfedSequence, Count: 3, ASNs: []uint32{65101, 65102, 65103}},
+				{Type: packet.ASSequence, Count: 1, ASNs: []uint32{64496}},
+			},
+		},
+		{
+			name:     "iBGP peer",
+			peer:     config.Peer{PeerAS: 65101},
+			expected: path,
+		},
+	}
+
+	for _, test := range tests {
+		test.peer.LocalAS = 65101
+		test.peer.ConfederationID = 65000
+		test.peer.LocalAddress = net.IP{169, 254, 0, 1}
+		test.peer.PeerAddress = net.IP{169, 254, 0, 2}
+		fsm := newFSM(test.peer, newFakeClock())
+
+		b := &rt.BGPPath{EBGP: true}
+		b.SetASPath(path)
+
+		res, accept := fsm.exportPath(pfx, &rt.Path{
+			Type:    rt.BGPPathType,
+			BGPPath: b,
+		})
+		if assert.True(t, accept, test.name) {
+			assert.Equal(t, test.expected, res.BGPPath.ASPathSegments, test.name)
+			assert.Equal(t, test.expected.String(), res.BGPPath.ASPath, test.name)
+		}
+	}
+}
+
+func TestConfederationPeerImport(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:           65101,
+		PeerAS:            65102,
+		PeerAddress:       net.IP{169, 254, 0, 2},
+		ConfederationID:   65000,
+		ConfederationPeer: true,
+	}, newFakeClock())
+	fsm.adjRibIn = rt.New()
+
+	fsm.processUpdate(&packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode: packet.ASPathAttr,
+			Value: packet.ASPath{
+				{Type: packet.ASConfedSequence, Count: 1, ASNs: []uint32{65102}},
+				{Type: packet.ASSequence, Count: 1, ASNs: []uint32{64496}},
+			},
+		},
+		NLRI: &packet.NLRI{
+			IP:     [4]byte{192, 0, 2, 0},
+			Pfxlen: 24,
+		},
+	})
+
+	routes := fsm.adjRibIn.Get(tnet.NewPfx(3221225984, 24), false)
+	if !assert.Len(t, routes, 1) {
+		return
+	}
+
+	b := routes[0].Paths()[0].BGPPath
+	assert.False(t, b.EBGP)
+	assert.True(t, b.Confederation)
+	assert.Equal(t, uint16(1), b.ASPathLen)
+	assert.Equal(t, uint32(64496), b.NeighborAS)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+const maxIdleBuckets = 1024
+
+// connRateLimiter limits the rate of incoming connection attempts per source address
+// using a token bucket for each source
+type connRateLimiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	dropped uint64
+	mu      sync.Mutex
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newConnRateLimiter creates a limiter allowing rate connection attempts per second
+// and source with bursts of up to burst attempts
+func newConnRateLimiter(rate float64, burst uint) *connRateLimiter {
+	return &connRateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow checks if a connection attempt from src at time now is within the limit
+func (l *connRateLimiter) allow(src string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[src]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.purge(now)
+		}
+
+		b = &tokenBucket{
+			tokens: l.burst,
+			last:   now,
+		}
+		l.buckets[src] = b
+	}
+
+	b.refill(now, l.rate, l.burst)
+	if b.tokens < 1 {
+		l.dropped++
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// droppedCount returns the number of connection attempts that have been refused
+func (l *connRateLimiter) droppedCount() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.dropped
+}
+
+// purge removes all buckets that have been refilled completely and thus carry no state
+func (l *connRateLimiter) purge(now time.Time) {
+	for src, b := range l.buckets {
+		b.refill(now, l.rate, l.burst)
+		if b.tokens >= l.burst {
+			delete(l.buckets, src)
+		}
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time, rate float64, burst float64) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	b.tokens += elapsed * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
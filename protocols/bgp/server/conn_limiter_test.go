@@ -0,0 +1,91 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	tests := []struct {
+		name     string
+		attempts []struct {
+			src    string
+			offset time.Duration
+		}
+		expected []bool
+	}{
+		{
+			name: "Burst exceeded by one source",
+			attempts: []struct {
+				src    string
+				offset time.Duration
+			}{
+				{src: "10.0.0.1"},
+				{src: "10.0.0.1"},
+				{src: "10.0.0.1"},
+				{src: "10.0.0.1"},
+			},
+			expected: []bool{true, true, true, false},
+		},
+		{
+			name: "Other sources are not affected",
+			attempts: []struct {
+				src    string
+				offset time.Duration
+			}{
+				{src: "10.0.0.1"},
+				{src: "10.0.0.1"},
+				{src: "10.0.0.1"},
+				{src: "10.0.0.2"},
+				{src: "10.0.0.1"},
+			},
+			expected: []bool{true, true, true, true, false},
+		},
+		{
+			name: "Tokens are refilled over time",
+			attempts: []struct {
+				src    string
+				offset time.Duration
+			}{
+				{src: "10.0.0.1"},
+				{src: "10.0.0.1"},
+				{src: "10.0.0.1"},
+				{src: "10.0.0.1"},
+				{src: "10.0.0.1", offset: time.Second},
+				{src: "10.0.0.1", offset: time.Second},
+			},
+			expected: []bool{true, true, true, false, true, false},
+		},
+	}
+
+	for _, test := range tests {
+		l := newConnRateLimiter(1, 3)
+		dropped := uint64(0)
+		for i, a := range test.attempts {
+			res := l.allow(a.src, now.Add(a.offset))
+			assert.Equalf(t, test.expected[i], res, "Test %q attempt %d", test.name, i)
+			if !res {
+				dropped++
+			}
+		}
+		assert.Equal(t, dropped, l.droppedCount(), test.name)
+	}
+}
+
+func TestConnRateLimiterPurge(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newConnRateLimiter(1, 3)
+
+	l.allow("10.0.0.1", now)
+	l.allow("10.0.0.2", now)
+	l.allow("10.0.0.2", now.Add(time.Second))
+	l.allow("10.0.0.2", now.Add(time.Second))
+
+	l.purge(now.Add(2 * time.Second))
+	assert.Equal(t, 1, len(l.buckets))
+	assert.NotNil(t, l.buckets["10.0.0.2"])
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// defaultConnectRetryTime is the initial ConnectRetry time
+	defaultConnectRetryTime = 5 * time.Second
+
+	// defaultConnectRetryTimeMax caps the ConnectRetry time
+	defaultConnectRetryTimeMax = 120 * time.Second
+
+	// defaultConnectRetryJitter is the fraction the ConnectRetry time is
+	// randomly reduced by as suggested by RFC 4271 section 10
+	defaultConnectRetryJitter = 0.25
+)
+
+// connectRetryBackoff computes the ConnectRetry time. It doubles with every
+// failed connection attempt up to max. Jitter randomly reduces each delay so
+// sessions dropped by a shared outage do not reconnect in lockstep.
+type connectRetryBackoff struct {
+	base    time.Duration
+	max     time.Duration
+	jitter  float64
+	current time.Duration
+	rand    func() float64
+}
+
+// newConnectRetryBackoff creates a backoff starting at base seconds capped at
+// max seconds. Zero values select the defaults, a negative jitter disables it.
+func newConnectRetryBackoff(base uint16, max uint16, jitter float64) *connectRetryBackoff {
+	b := &connectRetryBackoff{
+		base:   time.Duration(base) * time.Second,
+		max:    time.Duration(max) * time.Second,
+		jitter: jitter,
+		rand:   rand.Float64,
+	}
+
+	if b.base == 0 {
+		b.base = defaultConnectRetryTime
+	}
+
+	if b.max == 0 {
+		b.max = defaultConnectRetryTimeMax
+	}
+
+	if b.max < b.base {
+		b.max = b.base
+	}
+
+	switch {
+	case b.jitter == 0:
+		b.jitter = defaultConnectRetryJitter
+	case b.jitter < 0:
+		b.jitter = 0
+	case b.jitter > 1:
+		b.jitter = 1
+	}
+
+	b.reset()
+	return b
+}
+
+// delay returns the delay until the next connection attempt
+func (b *connectRetryBackoff) delay() time.Duration {
+	return b.current - time.Duration(b.jitter*b.rand()*float64(b.current))
+}
+
+// failed doubles the delay after a failed connection attempt
+func (b *connectRetryBackoff) failed() {
+	b.current *= 2
+	if b.current > b.max {
+		b.current = b.max
+	}
+}
+
+// reset restarts the backoff at the base delay
+func (b *connectRetryBackoff) reset() {
+	b.current = b.base
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     uint16
+		max      uint16
+		jitter   float64
+		rand     float64
+		expected []time.Duration
+	}{
+		{
+			name:   "Defaults without jitter",
+			jitter: -1,
+			expected: []time.Duration{
+				5 * time.Second,
+				10 * time.Second,
+				20 * time.Second,
+				40 * time.Second,
+				80 * time.Second,
+				120 * time.Second,
+				120 * time.Second,
+			},
+		},
+		{
+			name:   "Capped",
+			base:   2,
+			max:    5,
+			jitter: -1,
+			expected: []time.Duration{
+				2 * time.Second,
+				4 * time.Second,
+				5 * time.Second,
+				5 * time.Second,
+			},
+		},
+		{
+			name:   "Cap below base",
+			base:   10,
+			max:    5,
+			jitter: -1,
+			expected: []time.Duration{
+				10 * time.Second,
+				10 * time.Second,
+			},
+		},
+		{
+			name: "Default jitter",
+			base: 4,
+			max:  16,
+			rand: 1,
+			expected: []time.Duration{
+				3 * time.Second,
+				6 * time.Second,
+				12 * time.Second,
+				12 * time.Second,
+			},
+		},
+		{
+			name:   "Half of the jitter",
+			base:   10,
+			max:    40,
+			jitter: 0.5,
+			rand:   0.5,
+			expected: []time.Duration{
+				7500 * time.Millisecond,
+				15 * time.Second,
+				30 * time.Second,
+				30 * time.Second,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		b := newConnectRetryBackoff(test.base, test.max, test.jitter)
+		b.rand = func() float64 {
+			return test.rand
+		}
+
+		res := make([]time.Duration, 0, len(test.expected))
+		for range test.expected {
+			res = append(res, b.delay())
+			b.failed()
+		}
+
+		assert.Equalf(t, test.expected, res, "Test %q", test.name)
+	}
+}
+
+func TestConnectRetryBackoffJitterRange(t *testing.T) {
+	b := newConnectRetryBackoff(8, 8, 0.25)
+
+	for i := 0; i < 1000; i++ {
+		d := b.delay()
+		if d < 6*time.Second || d > 8*time.Second {
+			t.Fatalf("Delay %v out of jitter range", d)
+		}
+	}
+}
+
+func TestConnectRetryBackoffResetOnEstablished(t *testing.T) {
+	clk := newFakeClock()
+	fsm := newFSM(config.Peer{
+		LocalAS:            65200,
+		PeerAS:             65201,
+		PeerAddress:        net.IP{169, 254, 123, 1},
+		ConnectRetryTime:   1,
+		ConnectRetryJitter: -1,
+	}, clk)
+
+	fsm.startConnectRetryTimer()
+	fsm.connectRetryFailed()
+	fsm.connectRetryFailed()
+	assert.Equal(t, 4*time.Second, fsm.connectRetry.current)
+
+	fsm.changeState(Established, "Received KEEPALIVE")
+	assert.Equal(t, time.Second, fsm.connectRetry.delay())
+}
+
+func TestConnectRetryBackoffOncePerFailure(t *testing.T) {
+	clk := newFakeClock()
+	fsm := newFSM(config.Peer{
+		LocalAS:            65200,
+		PeerAS:             65201,
+		PeerAddress:        net.IP{169, 254, 123, 1},
+		ConnectRetryTime:   1,
+		ConnectRetryJitter: -1,
+	}, clk)
+
+	// Restarting the timer without a failure keeps the delay
+	fsm.startConnectRetryTimer()
+	fsm.resetConnectRetryTimer()
+	assert.Equal(t, time.Second, fsm.connectRetry.current)
+
+	// Sending the OPEN fails and the session is restarted from Idle
+	local, remote := tcpPair(t)
+	remote.Close()
+	local.Close()
+	fsm.con = local
+	assert.Equal(t, Idle, fsm.activeSendOpen())
+	fsm.startConnectRetryTimer()
+	assert.Equal(t, 2*time.Second, fsm.connectRetry.current)
+
+	clk.Advance(time.Second)
+	select {
+	case <-fsm.connectRetryTimer.C():
+		t.Fatalf("ConnectRetry timer expired before the backed off delay")
+	default:
+	}
+
+	clk.Advance(time.Second)
+	select {
+	case <-fsm.connectRetryTimer.C():
+	default:
+		t.Fatalf("ConnectRetry timer did not expire after the backed off delay")
+	}
+}
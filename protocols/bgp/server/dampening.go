@@ -0,0 +1,294 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/rt"
+	log "github.com/sirupsen/logrus"
+	"github.com/taktv6/tflow2/convert"
+)
+
+const (
+	defaultDampeningHalfLife        = 900
+	defaultDampeningSuppress        = 2000
+	defaultDampeningReuse           = 750
+	defaultDampeningMaxSuppressTime = 3600
+
+	// withdrawalPenalty is added to the penalty of a path when it is withdrawn
+	withdrawalPenalty = 1000
+
+	// attributeChangePenalty is added to the penalty of a path when it is
+	// replaced by a path with other attributes
+	attributeChangePenalty = 500
+
+	// dampeningReuseInterval is the interval penalties are checked against
+	// the reuse threshold in
+	dampeningReuseInterval = 5 * time.Second
+)
+
+// Dampening suppresses flapping paths received from peers (RFC 2439) before
+// passing changes of the Adj-RIB-In on to a client like a Loc-RIB. Every
+// withdrawal or attribute change of a path adds to its penalty which decays
+// exponentially over time. Once the penalty exceeds the suppress threshold the
+// path is withheld from the client until the penalty decayed below the reuse
+// threshold. Paths are identified by prefix, peer and path identifier.
+type Dampening struct {
+	clock    clock
+	client   AdjRIBInClient
+	halfLife time.Duration
+	suppress float64
+	reuse    float64
+	ceiling  float64
+	stop     chan struct{}
+
+	// notifyMu keeps the changes passed on to the client in order. It is
+	// taken before mu, the client is never called holding mu.
+	notifyMu sync.Mutex
+
+	mu    sync.Mutex
+	paths map[string]*dampenedPath
+}
+
+// dampenedPath is the dampening state of a path
+type dampenedPath struct {
+	pfx        *tnet.Prefix
+	penalty    float64
+	updated    time.Time
+	suppressed bool
+
+	// path is the path the peer currently announces. It is held back from the
+	// client while suppressed.
+	path *rt.Path
+}
+
+// DampeningInfo is the dampening state of a path
+type DampeningInfo struct {
+	Penalty    float64
+	Suppressed bool
+}
+
+var _ AdjRIBInClient = &Dampening{}
+
+// NewDampening creates a dampening stage passing changes on to client
+func NewDampening(client AdjRIBInClient, c config.Dampening) *Dampening {
+	return newDampening(client, c, realClock{})
+}
+
+func newDampening(client AdjRIBInClient, c config.Dampening, clk clock) *Dampening {
+	if c.HalfLife == 0 {
+		c.HalfLife = defaultDampeningHalfLife
+	}
+	if c.SuppressThreshold == 0 {
+		c.SuppressThreshold = defaultDampeningSuppress
+	}
+	if c.ReuseThreshold == 0 {
+		c.ReuseThreshold = defaultDampeningReuse
+	}
+	if c.MaxSuppressTime == 0 {
+		c.MaxSuppressTime = defaultDampeningMaxSuppressTime
+	}
+
+	d := &Dampening{
+		clock:    clk,
+		client:   client,
+		halfLife: time.Duration(c.HalfLife) * time.Second,
+		suppress: float64(c.SuppressThreshold),
+		reuse:    float64(c.ReuseThreshold),
+		stop:     make(chan struct{}),
+		paths:    make(map[string]*dampenedPath),
+	}
+
+	// A path must decay from the ceiling to the reuse threshold within the
+	// maximum suppress time
+	d.ceiling = d.reuse * math.Exp2(float64(c.MaxSuppressTime)/float64(c.HalfLife))
+
+	go d.reuseWorker()
+	return d
+}
+
+// Stop stops reusing suppressed paths
+func (d *Dampening) Stop() {
+	close(d.stop)
+}
+
+// ReplacePath applies a change of the Adj-RIB-In to the penalty of the path
+// and passes it on to the client unless the path is suppressed. Paths other
+// than BGP paths are passed on unchanged.
+func (d *Dampening) ReplacePath(pfx *tnet.Prefix, old *rt.Path, new *rt.Path) {
+	p := new
+	if p == nil {
+		p = old
+	}
+	if p == nil || p.Type != rt.BGPPathType {
+		d.client.ReplacePath(pfx, old, new)
+		return
+	}
+
+	d.notifyMu.Lock()
+	defer d.notifyMu.Unlock()
+
+	old, new, ok := d.update(pfx, p.BGPPath, old, new)
+	if ok {
+		d.client.ReplacePath(pfx, old, new)
+	}
+}
+
+// update adds the penalty of the change of the path b to its state. It returns
+// the change to pass on to the client, ok is false if there is none.
+func (d *Dampening) update(pfx *tnet.Prefix, b *rt.BGPPath, old *rt.Path, new *rt.Path) (*rt.Path, *rt.Path, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := dampeningKey(pfx, b.Source, b.PathIdentifier)
+	s, ok := d.paths[key]
+	if !ok {
+		s = &dampenedPath{
+			pfx: pfx,
+		}
+		d.paths[key] = s
+	}
+	d.decay(s)
+
+	switch {
+	case new == nil:
+		d.addPenalty(s, withdrawalPenalty)
+	case old != nil && !old.Equal(new):
+		d.addPenalty(s, attributeChangePenalty)
+	}
+
+	if s.suppressed {
+		s.path = new
+		return nil, nil, false
+	}
+
+	if s.penalty > d.suppress {
+		s.suppressed = true
+		s.path = new
+
+		log.WithFields(log.Fields{
+			"prefix":  pfx.String(),
+			"penalty": s.penalty,
+		}).Info("Suppressing flapping path")
+		return old, nil, old != nil
+	}
+
+	d.forget(key, s)
+	return old, new, true
+}
+
+// Info returns the dampening state of the path with identifier pathID received
+// for pfx from the peer with address source. ok is false if the path has no
+// penalty.
+func (d *Dampening) Info(pfx *tnet.Prefix, source uint32, pathID uint32) (info DampeningInfo, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, ok := d.paths[dampeningKey(pfx, source, pathID)]
+	if !ok {
+		return DampeningInfo{}, false
+	}
+	d.decay(s)
+
+	return DampeningInfo{
+		Penalty:    s.penalty,
+		Suppressed: s.suppressed,
+	}, true
+}
+
+func (d *Dampening) reuseWorker() {
+	t := d.clock.NewTicker(dampeningReuseInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C():
+			d.reusePaths()
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// reusePaths passes on the suppressed paths whose penalty decayed below the
+// reuse threshold
+func (d *Dampening) reusePaths() {
+	d.notifyMu.Lock()
+	defer d.notifyMu.Unlock()
+
+	for _, s := range d.reusablePaths() {
+		d.client.ReplacePath(s.pfx, nil, s.path)
+	}
+}
+
+// reusablePaths ends the suppression of paths whose penalty decayed below the
+// reuse threshold and returns those the peer still announces. Paths whose
+// penalty is negligible are forgotten.
+func (d *Dampening) reusablePaths() []*dampenedPath {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var res []*dampenedPath
+	for key, s := range d.paths {
+		d.decay(s)
+		if s.suppressed && s.penalty < d.reuse {
+			s.suppressed = false
+			if s.path != nil {
+				res = append(res, &dampenedPath{
+					pfx:  s.pfx,
+					path: s.path,
+				})
+			}
+
+			log.WithFields(log.Fields{
+				"prefix":  s.pfx.String(),
+				"penalty": s.penalty,
+			}).Info("Reusing suppressed path")
+		}
+
+		d.forget(key, s)
+	}
+
+	return res
+}
+
+// decay reduces the penalty of s by the time passed since its last update
+func (d *Dampening) decay(s *dampenedPath) {
+	now := d.clock.Now()
+	if !s.updated.IsZero() {
+		s.penalty *= math.Exp2(-float64(now.Sub(s.updated)) / float64(d.halfLife))
+	}
+	s.updated = now
+}
+
+func (d *Dampening) addPenalty(s *dampenedPath, penalty float64) {
+	s.penalty = math.Min(s.penalty+penalty, d.ceiling)
+}
+
+// forget drops the state of a path that is not suppressed once its penalty
+// fell below half of the reuse threshold
+func (d *Dampening) forget(key string, s *dampenedPath) {
+	if !s.suppressed && s.penalty < d.reuse/2 {
+		delete(d.paths, key)
+	}
+}
+
+func dampeningKey(pfx *tnet.Prefix, source uint32, pathID uint32) string {
+	return fmt.Sprintf("%s/%d/%d", pfx.String(), source, pathID)
+}
+
+// dampeningInfo returns the dampening state of the path with identifier pathID
+// received from the peer for pfx. ok is false if dampening is disabled or the
+// path has no penalty.
+func (fsm *FSM) dampeningInfo(pfx *tnet.Prefix, pathID uint32) (info DampeningInfo, ok bool) {
+	addr := fsm.remote.To4()
+	if fsm.dampening == nil || addr == nil {
+		return DampeningInfo{}, false
+	}
+
+	return fsm.dampening.Info(pfx, convert.Uint32b(addr), pathID)
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+// pathChange is a call of ReplacePath
+type pathChange struct {
+	old *rt.Path
+	new *rt.Path
+}
+
+// recordingAdjRIBInClient sends all changes it is notified of to a channel
+type recordingAdjRIBInClient chan pathChange
+
+func (c recordingAdjRIBInClient) ReplacePath(pfx *tnet.Prefix, old *rt.Path, new *rt.Path) {
+	c <- pathChange{old: old, new: new}
+}
+
+func receivePathChange(t *testing.T, c recordingAdjRIBInClient) pathChange {
+	select {
+	case x := <-c:
+		return x
+	case <-time.After(time.Second):
+		t.Fatalf("No path change was passed on")
+	}
+
+	return pathChange{}
+}
+
+func assertNoPathChange(t *testing.T, c recordingAdjRIBInClient, msg string) {
+	select {
+	case x := <-c:
+		t.Fatalf("%s: unexpected path change: %v", msg, x)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func dampenedPathWithMED(med uint32) *rt.Path {
+	return &rt.Path{
+		Type: rt.BGPPathType,
+		BGPPath: &rt.BGPPath{
+			NextHop: 3325256705, // 198.51.100.1
+			MED:     med,
+			Source:  2851995650, // 169.254.0.2
+		},
+	}
+}
+
+func TestDampeningSuppressesFlappingPath(t *testing.T) {
+	clk := newFakeClock()
+	client := make(recordingAdjRIBInClient, 10)
+	d := newDampening(client, config.Dampening{
+		HalfLife: 60,
+	}, clk)
+	defer d.Stop()
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	_, ok := d.Info(pfx, 2851995650, 0)
+	assert.False(t, ok, "Path has a penalty before it flapped")
+
+	for i := 0; i < 3; i++ {
+		p := dampenedPathWithMED(uint32(i))
+		d.ReplacePath(pfx, nil, p)
+		assert.Equal(t, pathChange{new: p}, receivePathChange(t, client))
+
+		d.ReplacePath(pfx, p, nil)
+		assert.Equal(t, pathChange{old: p}, receivePathChange(t, client))
+	}
+
+	info, ok := d.Info(pfx, 2851995650, 0)
+	if assert.True(t, ok) {
+		assert.Equal(t, DampeningInfo{Penalty: 3000, Suppressed: true}, info)
+	}
+
+	p := dampenedPathWithMED(10)
+	d.ReplacePath(pfx, nil, p)
+	assertNoPathChange(t, client, "Suppressed path was passed on")
+
+	// The penalty decays to 1500 after one half-life
+	clk.Advance(time.Minute)
+	assertNoPathChange(t, client, "Path was reused above the reuse threshold")
+	info, _ = d.Info(pfx, 2851995650, 0)
+	assert.InDelta(t, 1500, info.Penalty, 0.001)
+	assert.True(t, info.Suppressed)
+
+	// and below the reuse threshold of 750 after two
+	clk.Advance(time.Minute + dampeningReuseInterval)
+	assert.Equal(t, pathChange{new: p}, receivePathChange(t, client))
+
+	info, _ = d.Info(pfx, 2851995650, 0)
+	assert.False(t, info.Suppressed)
+	assert.True(t, info.Penalty < 750)
+
+	d.ReplacePath(pfx, p, nil)
+	assert.Equal(t, pathChange{old: p}, receivePathChange(t, client))
+}
+
+func TestDampeningAttributeChange(t *testing.T) {
+	clk := newFakeClock()
+	client := make(recordingAdjRIBInClient, 10)
+	d := newDampening(client, config.Dampening{}, clk)
+	defer d.Stop()
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	old := dampenedPathWithMED(0)
+	d.ReplacePath(pfx, nil, old)
+	receivePathChange(t, client)
+
+	for i := 1; i <= 5; i++ {
+		p := dampenedPathWithMED(uint32(i))
+		d.ReplacePath(pfx, old, p)
+		if i < 5 {
+			assert.Equal(t, pathChange{old: old, new: p}, receivePathChange(t, client))
+		}
+		old = p
+	}
+
+	// The fifth change exceeds the suppress threshold and removes the path
+	assert.Equal(t, pathChange{old: dampenedPathWithMED(4)}, receivePathChange(t, client))
+	info, _ := d.Info(pfx, 2851995650, 0)
+	assert.Equal(t, DampeningInfo{Penalty: 2500, Suppressed: true}, info)
+}
+
+func TestDampeningMaxSuppressTime(t *testing.T) {
+	clk := newFakeClock()
+	client := make(recordingAdjRIBInClient, 100)
+	d := newDampening(client, config.Dampening{
+		HalfLife:        60,
+		MaxSuppressTime: 120,
+	}, clk)
+	defer d.Stop()
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	p := dampenedPathWithMED(0)
+	for i := 0; i < 10; i++ {
+		d.ReplacePath(pfx, nil, p)
+		d.ReplacePath(pfx, p, nil)
+	}
+
+	info, _ := d.Info(pfx, 2851995650, 0)
+	assert.Equal(t, DampeningInfo{Penalty: 3000, Suppressed: true}, info)
+}
+
+// inspectingAdjRIBInClient looks up the dampening state of every path it is
+// notified of
+type inspectingAdjRIBInClient struct {
+	d    *Dampening
+	info chan DampeningInfo
+}
+
+func (c *inspectingAdjRIBInClient) ReplacePath(pfx *tnet.Prefix, old *rt.Path, new *rt.Path) {
+	info, _ := c.d.Info(pfx, 2851995650, 0)
+	c.info <- info
+}
+
+func TestDampeningReuseCallsClientUnlocked(t *testing.T) {
+	clk := newFakeClock()
+	client := &inspectingAdjRIBInClient{
+		info: make(chan DampeningInfo, 10),
+	}
+	d := newDampening(client, config.Dampening{
+		HalfLife: 60,
+	}, clk)
+	defer d.Stop()
+	client.d = d
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	p := dampenedPathWithMED(0)
+	for i := 0; i < 3; i++ {
+		d.ReplacePath(pfx, nil, p)
+		d.ReplacePath(pfx, p, nil)
+	}
+	d.ReplacePath(pfx, nil, p)
+	for i := 0; i < 6; i++ {
+		<-client.info
+	}
+
+	clk.Advance(time.Minute)
+	select {
+	case <-client.info:
+		t.Fatalf("Path was reused above the reuse threshold")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clk.Advance(time.Minute + dampeningReuseInterval)
+	select {
+	case info := <-client.info:
+		assert.False(t, info.Suppressed)
+	case <-time.After(time.Second):
+		t.Fatalf("Reused path was not passed on")
+	}
+}
+
+func TestFSMDampening(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:      65200,
+		PeerAS:       65201,
+		LocalAddress: net.IP{169, 254, 0, 1},
+		PeerAddress:  net.IP{169, 254, 0, 2},
+		Dampening: &config.Dampening{
+			HalfLife: 60,
+		},
+	}, newFakeClock())
+	defer fsm.dampening.Stop()
+	client := make(recordingAdjRIBInClient, 10)
+	fsm.RegisterAdjRIBIn(client)
+	fsm.adjRibIn = rt.New()
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	for i := 0; i < 3; i++ {
+		fsm.announce(fsm.adjRibIn, pfx, dampenedPathWithMED(0).BGPPath)
+		receivePathChange(t, client)
+		fsm.withdraw(fsm.adjRibIn, pfx, 0)
+		receivePathChange(t, client)
+	}
+
+	fsm.announce(fsm.adjRibIn, pfx, dampenedPathWithMED(0).BGPPath)
+	assertNoPathChange(t, client, "Flapping path was passed on")
+	assert.Len(t, fsm.adjRibIn.Get(pfx, false), 1, "Suppressed path is missing in the Adj-RIB-In")
+
+	info, ok := fsm.dampeningInfo(pfx, 0)
+	if assert.True(t, ok) {
+		assert.Equal(t, DampeningInfo{Penalty: 3000, Suppressed: true}, info)
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+// TransportError is returned when the TCP connection to a peer fails
+type TransportError struct {
+	Err error
+}
+
+func (e TransportError) Error() string {
+	return e.Err.Error()
+}
+
+func (e TransportError) Unwrap() error {
+	return e.Err
+}
+
+// ProtocolError is returned when a peer violates the BGP protocol. Err wraps
+// the packet.BGPError describing the NOTIFICATION sent to the peer if any.
+type ProtocolError struct {
+	Err error
+}
+
+func (e ProtocolError) Error() string {
+	return e.Err.Error()
+}
+
+func (e ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// PolicyError is returned when routes are rejected by import or export policy
+type PolicyError struct {
+	Err error
+}
+
+func (e PolicyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e PolicyError) Unwrap() error {
+	return e.Err
+}
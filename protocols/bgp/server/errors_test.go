@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bio-routing/bio-rd/config"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeMsgProtocolError(t *testing.T) {
+	fsm := NewFSM(config.Peer{})
+
+	msg := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0, 19, // Length
+		42, // Invalid type
+	}
+
+	_, err := fsm.decodeMsg(msg)
+	if !assert.Error(t, err) {
+		return
+	}
+
+	var perr ProtocolError
+	assert.True(t, errors.As(err, &perr))
+
+	var bgperr packet.BGPError
+	if assert.True(t, errors.As(err, &bgperr)) {
+		assert.Equal(t, uint8(packet.MessageHeaderError), bgperr.ErrorCode)
+		assert.Equal(t, uint8(packet.BadMessageType), bgperr.ErrorSubCode)
+	}
+
+	var terr TransportError
+	assert.False(t, errors.As(err, &terr))
+}
+
+func TestTransportError(t *testing.T) {
+	c, s := tcpPair(t)
+	s.Close()
+	c.Close()
+
+	_, err := recvMsg(c)
+	var terr TransportError
+	assert.True(t, errors.As(err, &terr), "recvMsg")
+
+	err = sendNotification(c, packet.Cease, packet.AdminShut)
+	assert.True(t, errors.As(err, &terr), "sendNotification")
+
+	var perr ProtocolError
+	assert.False(t, errors.As(err, &perr))
+}
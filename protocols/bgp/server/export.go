@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net"
+
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+)
+
+// exportPath returns the path a path p of the Loc-RIB is advertised with.
+// Paths are not advertised back to the peer they were received from and iBGP
+// paths are only advertised to iBGP peers if route reflection allows it.
+// Locally originated paths are converted into BGP paths. The next hop is set
+// to the local address of the session for external peers, if next hop self is
+// configured or if a local path has no next hop. Local paths get the
+// configured MED or, if a MED resolver is configured, the IGP metric towards
+// their original next hop. The received MED is not advertised to external
+// peers. The AS_PATH is extended for peers
+// of other ASes. Route server clients get paths with their NEXT_HOP
+// and AS_PATH untouched. The export policy is applied last. accept is false if
+// the policy rejected p.
+func (fsm *FSM) exportPath(pfx *tnet.Prefix, p *rt.Path) (res *rt.Path, accept bool) {
+	var b *rt.BGPPath
+	switch p.Type {
+	case rt.BGPPathType:
+		if !fsm.advertisable(p.BGPPath) {
+			return nil, false
+		}
+
+		b = p.BGPPath.Copy()
+		fsm.reflect(b)
+		fsm.stripMED(p.BGPPath, b)
+		fsm.stripExtendedCommunities(b)
+		if fsm.rewriteNextHop() {
+			b.SetNextHop(fsm.localAddress())
+		}
+	case rt.LocalPathType:
+		b = &rt.BGPPath{
+			Origin:      p.LocalPath.Origin,
+			NextHop:     p.LocalPath.NextHop,
+			MED:         fsm.med,
+			HasMED:      fsm.med != 0,
+			Communities: append([]uint32(nil), p.LocalPath.Communities...),
+		}
+		if b.NextHop != 0 {
+			fsm.deriveMED(b)
+		}
+		if b.NextHop == 0 || fsm.rewriteNextHop() {
+			b.SetNextHop(fsm.localAddress())
+		}
+	default:
+		return nil, false
+	}
+
+	return fsm.exportBGPPath(pfx, b)
+}
+
+// exportBGPPath extends the AS_PATH of b if required and applies the export
+// policy
+func (fsm *FSM) exportBGPPath(pfx *tnet.Prefix, b *rt.BGPPath) (res *rt.Path, accept bool) {
+	if fsm.localASN != fsm.remoteASN && !fsm.routeServerClient {
+		b.SetASPath(fsm.peerASPath(b.ASPathSegments))
+	}
+
+	res = &rt.Path{
+		Type:    rt.BGPPathType,
+		BGPPath: b,
+	}
+	if fsm.exportPolicy == nil {
+		return res, true
+	}
+
+	return fsm.exportPolicy.Process(pfx, res)
+}
+
+// deriveMED sets the MED of the locally originated path b to the IGP metric
+// towards its next hop if a MED resolver is configured and resolves it. Paths
+// are exported by the FSM without the lock of the Loc-RIB held, so the
+// resolver may be the Loc-RIB itself.
+func (fsm *FSM) deriveMED(b *rt.BGPPath) {
+	if fsm.medResolver == nil {
+		return
+	}
+
+	if metric, ok := fsm.medResolver.ResolveNextHop(b.NextHopIP()); ok {
+		b.MED = metric
+		b.HasMED = true
+	}
+}
+
+// stripMED removes the MED of the copy b of the path p before it is
+// advertised to an external peer, as a MED received from a neighboring AS
+// must not be passed on to other ASes (RFC 4271, 5.1.4). A MED set by the
+// import policy is kept. The export policy may set a MED again.
+func (fsm *FSM) stripMED(p *rt.BGPPath, b *rt.BGPPath) {
+	if !fsm.external() {
+		return
+	}
+
+	r := p.ReceivedAttributes()
+	if b.HasMED != r.HasMED || b.MED != r.MED {
+		return
+	}
+
+	b.MED = 0
+	b.HasMED = false
+}
+
+// stripExtendedCommunities removes the non-transitive extended communities of
+// b before it is advertised to an external peer (RFC 4360, 6)
+func (fsm *FSM) stripExtendedCommunities(b *rt.BGPPath) {
+	if !fsm.external() || len(b.ExtendedCommunities) == 0 {
+		return
+	}
+
+	res := make([]packet.ExtendedCommunity, 0, len(b.ExtendedCommunities))
+	for _, c := range b.ExtendedCommunities {
+		if c.IsTransitive() {
+			res = append(res, c)
+		}
+	}
+	b.ExtendedCommunities = res
+}
+
+// rewriteNextHop checks if the next hop of paths advertised to the peer is set
+// to the local address of the session
+func (fsm *FSM) rewriteNextHop() bool {
+	if fsm.routeServerClient {
+		return false
+	}
+
+	return fsm.nextHopSelf || fsm.external()
+}
+
+// localAddress returns the local address of the session. It falls back to the
+// configured local address if there is no connection.
+func (fsm *FSM) localAddress() net.IP {
+	fsm.mu.RLock()
+	c := fsm.con
+	fsm.mu.RUnlock()
+
+	if c != nil {
+		if addr, ok := c.LocalAddr().(*net.TCPAddr); ok {
+			return addr.IP
+		}
+	}
+
+	return fsm.local
+}
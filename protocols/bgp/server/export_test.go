@@ -0,0 +1,499 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/policy"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportPath(t *testing.T) {
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	ebgpPath := &rt.Path{
+		Type: rt.BGPPathType,
+		BGPPath: &rt.BGPPath{
+			NextHop:   3325256705, // 198.51.100.1
+			LocalPref: 100,
+			EBGP:      true,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		peer     config.Peer
+		path     *rt.Path
+		expected uint32
+		rejected bool
+	}{
+		{
+			name: "iBGP without next hop self",
+			peer: config.Peer{
+				LocalAS: 65200,
+				PeerAS:  65200,
+			},
+			path:     ebgpPath,
+			expected: 3325256705,
+		},
+		{
+			name: "iBGP with next hop self",
+			peer: config.Peer{
+				LocalAS:     65200,
+				PeerAS:      65200,
+				NextHopSelf: true,
+			},
+			path:     ebgpPath,
+			expected: 2851995649, // 169.254.0.1
+		},
+		{
+			name: "eBGP",
+			peer: config.Peer{
+				LocalAS: 65200,
+				PeerAS:  65201,
+			},
+			path:     ebgpPath,
+			expected: 2851995649,
+		},
+		{
+			name: "Next hop set by policy",
+			peer: config.Peer{
+				LocalAS:     65200,
+				PeerAS:      65200,
+				NextHopSelf: true,
+				ExportPolicy: &policy.Filter{
+					Terms: []*policy.Term{
+						{
+							Modifiers: []policy.Modifier{policy.SetNextHop(net.IP{203, 0, 113, 1})},
+						},
+					},
+					Default: policy.Accept,
+				},
+			},
+			path:     ebgpPath,
+			expected: 3405803777, // 203.0.113.1
+		},
+		{
+			name: "Rejected by policy",
+			peer: config.Peer{
+				LocalAS:      65200,
+				PeerAS:       65200,
+				ExportPolicy: &policy.Filter{Default: policy.Reject},
+			},
+			path:     ebgpPath,
+			rejected: true,
+		},
+		{
+			name: "Local path without next hop",
+			peer: config.Peer{
+				LocalAS: 65200,
+				PeerAS:  65200,
+			},
+			path: &rt.Path{
+				Type:      rt.LocalPathType,
+				LocalPath: &rt.LocalPath{},
+			},
+			expected: 2851995649,
+		},
+		{
+			name: "Local path with next hop",
+			peer: config.Peer{
+				LocalAS: 65200,
+				PeerAS:  65200,
+			},
+			path: &rt.Path{
+				Type: rt.LocalPathType,
+				LocalPath: &rt.LocalPath{
+					NextHop: 3221225985, // 192.0.2.1
+				},
+			},
+			expected: 3221225985,
+		},
+	}
+
+	for _, test := range tests {
+		test.peer.LocalAddress = net.IP{169, 254, 0, 1}
+		test.peer.PeerAddress = net.IP{169, 254, 0, 2}
+		fsm := newFSM(test.peer, newFakeClock())
+
+		res, accept := fsm.exportPath(pfx, test.path)
+		if test.rejected {
+			assert.False(t, accept, test.name)
+			continue
+		}
+
+		if !assert.True(t, accept, test.name) {
+			continue
+		}
+		assert.Equal(t, test.expected, res.BGPPath.NextHop, test.name)
+	}
+
+	// The Loc-RIB path is left alone
+	assert.Equal(t, uint32(3325256705), ebgpPath.BGPPath.NextHop)
+}
+
+func TestExportPathUnknownAttributes(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:      65200,
+		PeerAS:       65201,
+		LocalAddress: net.IP{169, 254, 0, 1},
+		PeerAddress:  net.IP{169, 254, 0, 2},
+	}, newFakeClock())
+	fsm.adjRibIn = rt.New()
+
+	unknown := &packet.PathAttribute{
+		Length:     3,
+		Optional:   true,
+		Transitive: true,
+		Partial:    true,
+		TypeCode:   99,
+		Value:      packet.UnknownAttribute{1, 2, 3},
+	}
+	fsm.processUpdate(&packet.BGPUpdate{
+		PathAttributes: unknown,
+		NLRI: &packet.NLRI{
+			IP:     [4]byte{192, 0, 2, 0},
+			Pfxlen: 24,
+		},
+	})
+
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	routes := fsm.adjRibIn.Get(pfx, false)
+	if !assert.Len(t, routes, 1) {
+		return
+	}
+
+	res, accept := newFSM(config.Peer{
+		LocalAS:      65200,
+		PeerAS:       65202,
+		LocalAddress: net.IP{169, 254, 1, 1},
+		PeerAddress:  net.IP{169, 254, 1, 2},
+	}, newFakeClock()).exportPath(pfx, routes[0].Paths()[0])
+	if assert.True(t, accept) {
+		assert.Equal(t, []packet.PathAttribute{*unknown}, res.BGPPath.UnknownAttributes)
+	}
+}
+
+func TestExportPathTagPrefixes(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:      65200,
+		PeerAS:       65201,
+		LocalAddress: net.IP{169, 254, 0, 1},
+		PeerAddress:  net.IP{169, 254, 0, 2},
+		ExportPolicy: &policy.Filter{
+			Terms: []*policy.Term{
+				policy.TagPrefixes("documentation", policy.PrefixList{
+					{Prefix: tnet.NewPfx(3325256704, 24), LE: 32}, // 198.51.100.0/24
+				}, 4259840100), // 65000:100
+				{
+					Conditions: []policy.Condition{policy.HasCommunity(4259840200)}, // 65000:200
+					Verdict:    policy.Reject,
+				},
+			},
+			Default: policy.Accept,
+		},
+	}, newFakeClock())
+
+	p := &rt.Path{
+		Type: rt.BGPPathType,
+		BGPPath: &rt.BGPPath{
+			NextHop:     3325256705, // 198.51.100.1
+			Communities: []uint32{4259840300},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		pfx      *tnet.Prefix
+		expected []uint32
+	}{
+		{
+			name:     "Within 198.51.100.0/24",
+			pfx:      tnet.NewPfx(3325256704, 24),
+			expected: []uint32{4259840300, 4259840100},
+		},
+		{
+			name:     "More specific of 198.51.100.0/24",
+			pfx:      tnet.NewPfx(3325256832, 25), // 198.51.100.128/25
+			expected: []uint32{4259840300, 4259840100},
+		},
+		{
+			name:     "Outside of 198.51.100.0/24",
+			pfx:      tnet.NewPfx(3221225984, 24), // 192.0.2.0/24
+			expected: []uint32{4259840300},
+		},
+	}
+
+	for _, test := range tests {
+		res, accept := fsm.exportPath(test.pfx, p)
+		if !assert.True(t, accept, test.name) {
+			continue
+		}
+		assert.Equal(t, test.expected, res.BGPPath.Communities, test.name)
+	}
+	assert.Equal(t, []uint32{4259840300}, p.BGPPath.Communities, "Loc-RIB path was modified")
+}
+
+// metricResolver resolves next hops to the IGP metrics it maps their
+// addresses to
+type metricResolver map[string]uint32
+
+func (r metricResolver) ResolveNextHop(addr net.IP) (uint32, bool) {
+	metric, ok := r[addr.String()]
+	return metric, ok
+}
+
+func TestExportPathMED(t *testing.T) {
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	resolver := metricResolver{
+		"198.51.100.1": 20,
+	}
+
+	tests := []struct {
+		name     string
+		resolver rt.NextHopResolver
+		path     *rt.Path
+		expected uint32
+	}{
+		{
+			name: "Originated route",
+			path: &rt.Path{
+				Type:      rt.LocalPathType,
+				LocalPath: &rt.LocalPath{},
+			},
+			expected: 50,
+		},
+		{
+			name:     "Originated route with IGP derived MED",
+			resolver: resolver,
+			path: &rt.Path{
+				Type: rt.LocalPathType,
+				LocalPath: &rt.LocalPath{
+					NextHop: 3325256705, // 198.51.100.1
+				},
+			},
+			expected: 20,
+		},
+		{
+			name:     "Originated route without next hop",
+			resolver: resolver,
+			path: &rt.Path{
+				Type:      rt.LocalPathType,
+				LocalPath: &rt.LocalPath{},
+			},
+			expected: 50,
+		},
+		{
+			name:     "Originated route with unresolvable next hop",
+			resolver: resolver,
+			path: &rt.Path{
+				Type: rt.LocalPathType,
+				LocalPath: &rt.LocalPath{
+					NextHop: 3325256706, // 198.51.100.2
+				},
+			},
+			expected: 50,
+		},
+		{
+			name:     "BGP route keeps the MED set by the import policy",
+			resolver: resolver,
+			path: &rt.Path{
+				Type: rt.BGPPathType,
+				BGPPath: &rt.BGPPath{
+					NextHop: 3325256705, // 198.51.100.1
+					MED:     10,
+					HasMED:  true,
+					Received: &rt.BGPPath{
+						NextHop: 3325256705, // 198.51.100.1
+						MED:     5,
+						HasMED:  true,
+					},
+				},
+			},
+			expected: 10,
+		},
+	}
+
+	for _, test := range tests {
+		fsm := newFSM(config.Peer{
+			LocalAS:      65200,
+			PeerAS:       65201,
+			LocalAddress: net.IP{169, 254, 0, 1},
+			PeerAddress:  net.IP{169, 254, 0, 2},
+			MED:          50,
+			MEDResolver:  test.resolver,
+		}, newFakeClock())
+
+		res, accept := fsm.exportPath(pfx, test.path)
+		if !assert.True(t, accept, test.name) {
+			continue
+		}
+		assert.Equal(t, test.expected, res.BGPPath.MED, test.name)
+		assert.Equal(t, uint32(2851995649), res.BGPPath.NextHop, test.name) // 169.254.0.1
+	}
+}
+
+func TestExportMEDResolvedInLocRIB(t *testing.T) {
+	fsm, _, sent := adjRIBOutFSM(t, 65200)
+	locRIB := rt.NewRIB(nil)
+	fsm.medResolver = locRIB
+
+	// The next hop of the originated route is reached through a BGP route
+	// with an IGP metric of 20
+	locRIB.AddPath(tnet.NewPfx(3325256704, 24), &rt.Path{ // 198.51.100.0/24
+		Type: rt.BGPPathType,
+		BGPPath: &rt.BGPPath{
+			NextHop:   167772161, // 10.0.0.1
+			LocalPref: 100,
+			IGPMetric: 20,
+		},
+	})
+	fsm.adjRIBOut.Attach(locRIB)
+
+	go locRIB.Originate(tnet.NewPfx(3221225984, 24), &rt.LocalPath{ // 192.0.2.0/24
+		NextHop: 3325256705, // 198.51.100.1
+	})
+
+	u := receiveUpdate(t, sent)
+	assert.Equal(t, &packet.NLRI{IP: [4]byte{192, 0, 2, 0}, Pfxlen: 24}, u.NLRI)
+	assert.Equal(t, uint32(20), pathAttribute(u, packet.MEDAttr).Value)
+}
+
+func TestExportMEDToExternalPeers(t *testing.T) {
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	received := func(med uint32) *rt.Path {
+		b := &rt.BGPPath{
+			NextHop: 3325256705, // 198.51.100.1
+			MED:     med,
+			HasMED:  true,
+			EBGP:    true,
+		}
+		b.SetReceived()
+		return &rt.Path{
+			Type:    rt.BGPPathType,
+			BGPPath: b,
+		}
+	}
+
+	tests := []struct {
+		name         string
+		peerAS       uint32
+		exportPolicy rt.Policy
+		path         *rt.Path
+		expected     interface{}
+	}{
+		{
+			name:   "Received MED to eBGP peer",
+			peerAS: 65201,
+			path:   received(10),
+		},
+		{
+			name:     "Received MED to iBGP peer",
+			peerAS:   65200,
+			path:     received(10),
+			expected: uint32(10),
+		},
+		{
+			name:     "MED of 0",
+			peerAS:   65200,
+			path:     received(0),
+			expected: uint32(0),
+		},
+		{
+			name:   "MED set by the export policy",
+			peerAS: 65201,
+			exportPolicy: &policy.Filter{
+				Default: policy.Accept,
+				Terms: []*policy.Term{
+					{
+						Modifiers: []policy.Modifier{policy.SetMED(30)},
+						Verdict:   policy.Accept,
+					},
+				},
+			},
+			path:     received(10),
+			expected: uint32(30),
+		},
+	}
+
+	for _, test := range tests {
+		fsm := newFSM(config.Peer{
+			LocalAS:      65200,
+			PeerAS:       test.peerAS,
+			LocalAddress: net.IP{169, 254, 0, 1},
+			PeerAddress:  net.IP{169, 254, 0, 2},
+			ExportPolicy: test.exportPolicy,
+		}, newFakeClock())
+
+		res, accept := fsm.exportPath(pfx, test.path)
+		if !assert.True(t, accept, test.name) {
+			continue
+		}
+
+		med := pathAttribute(fsm.update(pfx, res.BGPPath, 0), packet.MEDAttr)
+		if test.expected == nil {
+			assert.Nil(t, med, test.name)
+			continue
+		}
+		if assert.NotNil(t, med, test.name) {
+			assert.Equal(t, test.expected, med.Value, test.name)
+		}
+	}
+}
+
+func TestExportExtendedCommunities(t *testing.T) {
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	target := packet.TwoOctetASRouteTarget(65000, 1)
+	nonTransitive := packet.ExtendedCommunity{0x40, 0x03}
+	b := &rt.BGPPath{
+		NextHop:             3325256705, // 198.51.100.1
+		EBGP:                true,
+		ExtendedCommunities: []packet.ExtendedCommunity{target, nonTransitive},
+	}
+	b.SetReceived()
+	p := &rt.Path{
+		Type:    rt.BGPPathType,
+		BGPPath: b,
+	}
+
+	tests := []struct {
+		name     string
+		peerAS   uint32
+		expected []packet.ExtendedCommunity
+	}{
+		{
+			name:     "iBGP peer",
+			peerAS:   65200,
+			expected: []packet.ExtendedCommunity{target, nonTransitive},
+		},
+		{
+			name:     "eBGP peer",
+			peerAS:   65201,
+			expected: []packet.ExtendedCommunity{target},
+		},
+	}
+
+	for _, test := range tests {
+		fsm := newFSM(config.Peer{
+			LocalAS:      65200,
+			PeerAS:       test.peerAS,
+			LocalAddress: net.IP{169, 254, 0, 1},
+			PeerAddress:  net.IP{169, 254, 0, 2},
+		}, newFakeClock())
+
+		res, accept := fsm.exportPath(pfx, p)
+		if !assert.True(t, accept, test.name) {
+			continue
+		}
+
+		comms := pathAttribute(fsm.update(pfx, res.BGPPath, 0), packet.ExtendedCommunitiesAttr)
+		if assert.NotNil(t, comms, test.name) {
+			assert.Equal(t, test.expected, comms.Value, test.name)
+		}
+	}
+	assert.Len(t, p.BGPPath.ExtendedCommunities, 2, "Exported path was modified")
+}
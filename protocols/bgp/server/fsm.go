@@ -2,9 +2,13 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math"
 	"net"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/bio-routing/bio-rd/config"
@@ -41,12 +45,62 @@ const (
 	Established = 6
 )
 
+// stateChangeBufferSize is the number of state changes buffered per subscriber.
+// Changes are dropped for subscribers that fall further behind.
+const stateChangeBufferSize = 16
+
+// StateChange describes a transition of the FSM
+type StateChange struct {
+	Old    int
+	New    int
+	Reason string
+
+	// Down describes how the session was closed if New is Idle
+	Down SessionDown
+}
+
+// SessionDown describes how a session was closed
+type SessionDown struct {
+	// Notification is the NOTIFICATION sent or received when the session was
+	// closed. It is nil if the session was closed without one.
+	Notification *packet.BGPNotification
+
+	// Local is set if the session was closed by the local system
+	Local bool
+
+	// Event is the FSM event which made the local system close the session.
+	// It is 0 if the session was not closed by an FSM event.
+	Event int
+}
+
+// shutdownPollInterval is the interval a shutdown checks if the session is down
+const shutdownPollInterval = 10 * time.Millisecond
+
 type FSM struct {
 	t           tomb.Tomb
+	clock       clock
+	mu          sync.RWMutex
 	stateReason string
 	state       int
 	lastState   int
+	lastError   string
 	eventCh     chan int
+	subscribers []chan StateChange
+
+	// updateHandlers are called with every UPDATE received in Established
+	updateHandlers []func(msg []byte)
+
+	// adjRIBInClients are notified of all changes of the Adj-RIB-In
+	adjRIBInClients []AdjRIBInClient
+
+	// dampening suppresses flapping paths before the changes of the
+	// Adj-RIB-In reach adjRIBInClients, nil if disabled
+	dampening *Dampening
+
+	adminDown       bool
+	adminDownReason string
+
+	establishedTime time.Time
 
 	con         *net.TCPConn
 	con2        *net.TCPConn
@@ -54,37 +108,99 @@ type FSM struct {
 	conErrCh    chan error
 	initiateCon chan struct{}
 	passive     bool
+	activeOnly  bool
+	tcpOptions  tcpOptions
+	md5Password string
+
+	// updateLimiter paces the UPDATEs received from the peer. It is nil if no
+	// limit is configured.
+	updateLimiter *msgRateLimiter
 
 	local  net.IP
 	remote net.IP
 
-	localASN  uint16
-	remoteASN uint16
+	localASN  uint32
+	remoteASN uint32
 
 	neighborID uint32
 	routerID   uint32
 
+	peerCapabilities packet.Capabilities
+	decodeOptions    packet.DecodeOptions
+	encodeOptions    packet.EncodeOptions
+	advertisePaths   uint8
+
 	delayOpen      bool
 	delayOpenTime  time.Duration
-	delayOpenTimer *time.Timer
+	delayOpenTimer timer
 
-	connectRetryTime    time.Duration
-	connectRetryTimer   *time.Timer
+	connectRetry        *connectRetryBackoff
+	connectRetryTimer   timer
 	connectRetryCounter int
 
 	holdTimeConfigured time.Duration
 	holdTime           time.Duration
-	holdTimer          *time.Timer
+	holdTimer          timer
 
 	keepaliveTime  time.Duration
-	keepaliveTimer *time.Timer
+	keepaliveTimer timer
+
+	gracefulRestartTime uint16
+	restartTimer        timer
+	staleRoutes         map[*rt.Path]stalePath
+
+	prefixLimit        uint64
+	prefixLimitWarning uint8
+	prefixLimitWarned  bool
+
+	allowASIn    uint8
+	importPolicy rt.Policy
+
+	// prePolicy holds the paths received from the peer before the import
+	// policy was applied if soft reconfiguration is enabled. It is nil
+	// otherwise.
+	softReconfigInbound bool
+	prePolicy           map[prePolicyKey]prePolicyPath
+	softReconfigCh      chan struct{}
+
+	maxCommunities         int
+	maxExtendedCommunities int
+
+	idleHoldTimeConfigured time.Duration
+	idleHoldTime           time.Duration
+	idleHoldTimer          timer
+	idleHoldPending        bool
+	lastNotification       *packet.BGPNotification
+
+	// sentOpen and receivedOpen are the OPEN messages of the last session
+	sentOpen     *packet.BGPOpen
+	receivedOpen *packet.BGPOpen
+
+	// down describes how the session is being closed. It is reported with
+	// the next state change.
+	down SessionDown
+
+	nextHopSelf  bool
+	med          uint32
+	medResolver  rt.NextHopResolver
+	exportPolicy rt.Policy
+
+	routeReflectorClient bool
+	routeServerClient    bool
+	clusterID            uint32
+
+	confederationID   uint32
+	confederationPeer bool
 
 	msgRecvCh     chan msgRecvMsg
 	msgRecvFailCh chan msgRecvErr
 	stopMsgRecvCh chan struct{}
 
-	adjRibIn  *rt.LPM
-	adjRibOut *rt.LPM
+	adjRibIn       rt.Trie
+	adjRibIn6      rt.Trie
+	adjRIBOut      *AdjRIBOut
+	prefixesRcvd   uint64
+	prefixesAdvert uint64
 }
 
 type msgRecvMsg struct {
@@ -98,69 +214,210 @@ type msgRecvErr struct {
 }
 
 func NewFSM(c config.Peer) *FSM {
+	return newFSM(c, realClock{})
+}
+
+func newFSM(c config.Peer, clk clock) *FSM {
 	fsm := &FSM{
+		clock:             clk,
 		state:             Idle,
-		passive:           true,
-		connectRetryTime:  5,
-		connectRetryTimer: time.NewTimer(time.Second * time.Duration(20)),
+		passive:           c.Passive,
+		activeOnly:        c.Active && !c.Passive,
+		connectRetry:      newConnectRetryBackoff(c.ConnectRetryTime, c.ConnectRetryTimeMax, c.ConnectRetryJitter),
+		connectRetryTimer: clk.NewTimer(time.Second * time.Duration(20)),
 
 		msgRecvCh:     make(chan msgRecvMsg),
 		msgRecvFailCh: make(chan msgRecvErr),
 		stopMsgRecvCh: make(chan struct{}),
 
 		holdTimeConfigured: time.Duration(c.HoldTimer),
-		holdTimer:          time.NewTimer(0),
+		holdTimer:          clk.NewTimer(0),
 
 		keepaliveTime:  time.Duration(c.KeepAlive),
-		keepaliveTimer: time.NewTimer(0),
+		keepaliveTimer: clk.NewTimer(0),
+
+		gracefulRestartTime: c.GracefulRestartTime,
+		restartTimer:        clk.NewTimer(0),
+
+		prefixLimit:        c.PrefixLimit,
+		prefixLimitWarning: c.PrefixLimitWarning,
+
+		allowASIn:    c.AllowASIn,
+		importPolicy: c.ImportPolicy,
+
+		softReconfigInbound: c.SoftReconfigInbound,
+		softReconfigCh:      make(chan struct{}, 1),
+
+		maxCommunities:         c.MaxCommunities,
+		maxExtendedCommunities: c.MaxExtendedCommunities,
+
+		idleHoldTimeConfigured: time.Duration(c.IdleHoldTime) * time.Second,
+		idleHoldTimer:          clk.NewTimer(0),
+
+		nextHopSelf:    c.NextHopSelf,
+		med:            c.MED,
+		medResolver:    c.MEDResolver,
+		exportPolicy:   c.ExportPolicy,
+		advertisePaths: c.AdvertisePaths,
+
+		routeReflectorClient: c.RouteReflectorClient,
+		routeServerClient:    c.RouteServerClient,
+		clusterID:            c.ClusterID,
+
+		confederationID:   c.ConfederationID,
+		confederationPeer: c.ConfederationPeer,
+
+		routerID:  c.RouterID,
+		remote:    c.PeerAddress,
+		local:     c.LocalAddress,
+		localASN:  c.LocalAS,
+		remoteASN: c.PeerAS,
+		eventCh:   make(chan int),
+		conCh:     make(chan *net.TCPConn),
+		conErrCh:  make(chan error), initiateCon: make(chan struct{}),
+		tcpOptions: tcpOptions{
+			sendBufferSize:    c.SendBufferSize,
+			receiveBufferSize: c.ReceiveBufferSize,
+			nagle:             c.Nagle,
+		},
+		md5Password: c.MD5Password,
+	}
+	fsm.adjRIBOut = newAdjRIBOut(fsm, fsm.advertisementInterval(c.AdvertisementInterval), fsm.sendUpdate)
+	fsm.adjRIBOut.coalesce = time.Duration(c.CoalesceInterval) * time.Millisecond
+	fsm.adjRIBOut.aggregates = newAggregates(c.Aggregates)
 
-		routerID: c.RouterID,
-		remote:   c.PeerAddress,
-		local:    c.LocalAddress,
-		localASN: uint16(c.LocalAS),
-		eventCh:  make(chan int),
-		conCh:    make(chan *net.TCPConn),
-		conErrCh: make(chan error), initiateCon: make(chan struct{}),
+	if c.UpdateRateLimit > 0 {
+		fsm.updateLimiter = newMsgRateLimiter(c.UpdateRateLimit, c.UpdateRateBurst, clk)
 	}
+	if c.Dampening != nil {
+		fsm.dampening = newDampening(adjRIBInClients{fsm: fsm}, *c.Dampening, clk)
+	}
+
+	stopTimer(fsm.restartTimer)
+	stopTimer(fsm.idleHoldTimer)
+
+	if fsm.clusterID == 0 {
+		fsm.clusterID = fsm.routerID
+	}
+
 	return fsm
 }
 
+// setTCPOptions applies the configured socket options to c
+func (fsm *FSM) setTCPOptions(c *net.TCPConn) {
+	err := setTCPOptions(c, fsm.tcpOptions)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"peer":  fsm.remote.String(),
+			"error": err,
+		}).Warning("Unable to set socket options")
+	}
+}
+
 func (fsm *FSM) disconnect() {
 	if fsm.con != nil {
 		fsm.con.Close()
-		fsm.con = nil
 	}
 	if fsm.con2 != nil {
 		fsm.con2.Close()
-		fsm.con2 = nil
 	}
+	fsm.setCon(nil, nil)
 }
 
-func (fsm *FSM) changeState(new int, reason string) int {
-	state := map[int]string{
-		Cease:       "Cease",
-		Idle:        "Idle",
-		Connect:     "Connect",
-		Active:      "Active",
-		OpenSent:    "OpenSent",
-		OpenConfirm: "OpenConfirm",
-		Established: "Established",
-	}
+// setCon replaces the connections to the peer. They are only changed by the
+// FSM goroutine holding fsm.mu, so other goroutines can read them holding
+// fsm.mu.
+func (fsm *FSM) setCon(con *net.TCPConn, con2 *net.TCPConn) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	fsm.con = con
+	fsm.con2 = con2
+}
+
+var stateNames = map[int]string{
+	Cease:       "Cease",
+	Idle:        "Idle",
+	Connect:     "Connect",
+	Active:      "Active",
+	OpenSent:    "OpenSent",
+	OpenConfirm: "OpenConfirm",
+	Established: "Established",
+}
 
+func (fsm *FSM) changeState(new int, reason string) int {
 	log.WithFields(log.Fields{
 		"peer":       fsm.remote.String(),
-		"last_state": state[fsm.state],
-		"new_state":  state[new],
+		"last_state": stateNames[fsm.state],
+		"new_state":  stateNames[new],
 		"reason":     reason,
 	}).Info("FSM: Neighbor state change")
 
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
 	fsm.lastState = fsm.state
 	fsm.state = new
 	fsm.stateReason = reason
 
+	if new == Established {
+		fsm.establishedTime = fsm.clock.Now()
+		fsm.connectRetry.reset()
+	}
+
+	if new == Idle && fsm.lastState != Idle {
+		fsm.lastError = reason
+	}
+
+	change := StateChange{
+		Old:    fsm.lastState,
+		New:    new,
+		Reason: reason,
+	}
+	if new == Idle {
+		change.Down = fsm.down
+	}
+	fsm.down = SessionDown{}
+	for _, ch := range fsm.subscribers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+
 	return fsm.state
 }
 
+// Subscribe returns a channel receiving all state changes of the FSM. The FSM
+// never blocks on a subscriber, changes are dropped if the channel is full.
+func (fsm *FSM) Subscribe() <-chan StateChange {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	ch := make(chan StateChange, stateChangeBufferSize)
+	fsm.subscribers = append(fsm.subscribers, ch)
+	return ch
+}
+
+// OnUpdate registers f to be called with the raw message of every UPDATE
+// received. f is called by the FSM and must not block.
+func (fsm *FSM) OnUpdate(f func(msg []byte)) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	fsm.updateHandlers = append(fsm.updateHandlers, f)
+}
+
+func (fsm *FSM) handleUpdate(msg []byte) {
+	fsm.mu.RLock()
+	handlers := fsm.updateHandlers
+	fsm.mu.RUnlock()
+
+	for _, f := range handlers {
+		f(msg)
+	}
+}
+
 func (fsm *FSM) activate() {
 	fsm.eventCh <- ManualStart
 }
@@ -168,9 +425,63 @@ func (fsm *FSM) activate() {
 func (fsm *FSM) Stop() error {
 	fsm.eventCh <- ManualStop
 	fsm.t.Kill(nil)
+	if fsm.dampening != nil {
+		fsm.dampening.Stop()
+	}
 	return fsm.t.Wait()
 }
 
+func (fsm *FSM) getState() int {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+
+	return fsm.state
+}
+
+// shutdown stops the session sending an administrative shutdown NOTIFICATION
+// to the peer. It returns once the FSM is back in Idle or ctx is done.
+func (fsm *FSM) shutdown(ctx context.Context) error {
+	if fsm.getState() == Idle {
+		return nil
+	}
+
+	select {
+	case fsm.eventCh <- ManualStop:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	t := fsm.clock.NewTicker(shutdownPollInterval)
+	defer t.Stop()
+
+	for fsm.getState() != Idle {
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// setAdminDown sets the sticky administrative state. While down the FSM does
+// not leave Idle.
+func (fsm *FSM) setAdminDown(down bool, reason string) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	fsm.adminDown = down
+	fsm.adminDownReason = reason
+}
+
+func (fsm *FSM) isAdminDown() bool {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+
+	return fsm.adminDown
+}
+
 func (fsm *FSM) start() {
 	fsm.t.Go(fsm.main)
 	fsm.t.Go(fsm.tcpConnector)
@@ -201,33 +512,52 @@ func (fsm *FSM) main() error {
 }
 
 func (fsm *FSM) idle() int {
-	fsm.adjRibIn = nil
-	fsm.adjRibOut = nil
+	if !fsm.retainStaleRoutes() {
+		fsm.clearAdjRibIn()
+	}
+	fsm.adjRIBOut.reset()
+	fsm.startIdleHoldTimer()
 	for {
+		var e int
 		select {
+		case <-fsm.restartTimer.C():
+			fsm.purgeStaleRoutes()
+			continue
 		case c := <-fsm.conCh:
 			c.Close()
 			continue
-		case e := <-fsm.eventCh:
-			reason := ""
-			switch e {
-			case ManualStart:
-				reason = "Received ManualStart event %d for %s peer"
-			case AutomaticStart:
-				reason = "Received AutomaticStart event %d for %s peer"
-			default:
-				continue
-			}
+		case <-fsm.idleHoldTimer.C():
+			e = AutomaticStart
+		case e = <-fsm.eventCh:
+		}
 
-			fsm.connectRetryCounter = 0
-			fsm.startConnectRetryTimer()
-			if fsm.passive {
-				return fsm.changeState(Active, fmt.Sprintf(reason, "passive"))
-			}
-			fsm.tcpConnect()
-			return fsm.changeState(Connect, fmt.Sprintf(reason, "active"))
+		reason := ""
+		switch e {
+		case ManualStart:
+			stopTimer(fsm.idleHoldTimer)
+			fsm.idleHoldTime = 0
+			reason = "Received ManualStart event %d for %s peer"
+		case AutomaticStart:
+			stopTimer(fsm.idleHoldTimer)
+			reason = "Received AutomaticStart event %d for %s peer"
+		default:
+			continue
+		}
+
+		if fsm.isAdminDown() {
+			log.WithFields(log.Fields{
+				"peer": fsm.remote.String(),
+			}).Info("Ignoring start event for administratively down peer")
+			continue
 		}
 
+		fsm.connectRetryCounter = 0
+		fsm.startConnectRetryTimer()
+		if !fsm.activeOnly {
+			return fsm.changeState(Active, fmt.Sprintf(reason, e, "passive"))
+		}
+		fsm.tcpConnect()
+		return fsm.changeState(Connect, fmt.Sprintf(reason, e, "active"))
 	}
 }
 
@@ -235,20 +565,21 @@ func (fsm *FSM) tcpConnector() error {
 	for {
 		select {
 		case <-fsm.initiateCon:
-			c, err := net.DialTCP("tcp", &net.TCPAddr{IP: fsm.local}, &net.TCPAddr{IP: fsm.remote, Port: BGPPORT})
+			c, err := fsm.dial()
 			if err != nil {
 				select {
 				case fsm.conErrCh <- err:
 					continue
-				case <-time.NewTimer(time.Second * 30).C:
+				case <-fsm.clock.NewTimer(time.Second * 30).C():
 					continue
 				}
 			}
 
+			fsm.setTCPOptions(c)
 			select {
 			case fsm.conCh <- c:
 				continue
-			case <-time.NewTimer(time.Second * 30).C:
+			case <-fsm.clock.NewTimer(time.Second * 30).C():
 				c.Close()
 				continue
 			}
@@ -258,6 +589,27 @@ func (fsm *FSM) tcpConnector() error {
 	}
 }
 
+// dial opens a TCP connection to the peer. The TCP-MD5 key has to be set
+// before connecting as it also covers the SYN.
+func (fsm *FSM) dial() (*net.TCPConn, error) {
+	d := net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: fsm.local},
+	}
+
+	if fsm.md5Password != "" {
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			return setTCPMD5(c, fsm.remote, fsm.md5Password)
+		}
+	}
+
+	c, err := d.Dial("tcp", net.JoinHostPort(fsm.remote.String(), strconv.Itoa(BGPPORT)))
+	if err != nil {
+		return nil, err
+	}
+
+	return c.(*net.TCPConn), nil
+}
+
 func (fsm *FSM) tcpConnect() {
 	fsm.initiateCon <- struct{}{}
 }
@@ -266,6 +618,9 @@ func (fsm *FSM) tcpConnect() {
 func (fsm *FSM) connect() int {
 	for {
 		select {
+		case <-fsm.restartTimer.C():
+			fsm.purgeStaleRoutes()
+			continue
 		case e := <-fsm.eventCh:
 			if e == ManualStop {
 				fsm.connectRetryCounter = 0
@@ -273,12 +628,12 @@ func (fsm *FSM) connect() int {
 				return fsm.changeState(Idle, "Manual stop event")
 			}
 			continue
-		case <-fsm.connectRetryTimer.C:
-			fsm.resetConnectRetryTimer()
+		case <-fsm.connectRetryTimer.C():
+			fsm.connectRetryFailed()
 			fsm.tcpConnect()
 			continue
 		case c := <-fsm.conCh:
-			fsm.con = c
+			fsm.setCon(c, fsm.con2)
 			stopTimer(fsm.connectRetryTimer)
 			return fsm.connectSendOpen()
 		}
@@ -289,9 +644,10 @@ func (fsm *FSM) connectSendOpen() int {
 	err := fsm.sendOpen(fsm.con)
 	if err != nil {
 		stopTimer(fsm.connectRetryTimer)
+		fsm.connectRetry.failed()
 		return fsm.changeState(Idle, fmt.Sprintf("Sending OPEN message failed: %v", err))
 	}
-	fsm.holdTimer = time.NewTimer(time.Minute * 4)
+	fsm.holdTimer = fsm.clock.NewTimer(time.Minute * 4)
 	return fsm.changeState(OpenSent, "Sent OPEN message")
 }
 
@@ -299,6 +655,9 @@ func (fsm *FSM) connectSendOpen() int {
 func (fsm *FSM) active() int {
 	for {
 		select {
+		case <-fsm.restartTimer.C():
+			fsm.purgeStaleRoutes()
+			continue
 		case e := <-fsm.eventCh:
 			if e == ManualStop {
 				fsm.disconnect()
@@ -307,12 +666,15 @@ func (fsm *FSM) active() int {
 				return fsm.changeState(Active, "Manual stop event")
 			}
 			continue
-		case <-fsm.connectRetryTimer.C:
+		case <-fsm.connectRetryTimer.C():
+			if fsm.passive {
+				continue
+			}
 			fsm.resetConnectRetryTimer()
 			fsm.tcpConnect()
 			return fsm.changeState(Connect, "Connect retry timer expired")
 		case c := <-fsm.conCh:
-			fsm.con = c
+			fsm.setCon(c, fsm.con2)
 			stopTimer(fsm.connectRetryTimer)
 			return fsm.activeSendOpen()
 		}
@@ -322,11 +684,11 @@ func (fsm *FSM) active() int {
 func (fsm *FSM) activeSendOpen() int {
 	err := fsm.sendOpen(fsm.con)
 	if err != nil {
-		fsm.resetConnectRetryTimer()
+		fsm.connectRetryFailed()
 		fsm.connectRetryCounter++
 		return fsm.changeState(Idle, fmt.Sprintf("Sending OPEN message failed: %v", err))
 	}
-	fsm.holdTimer = time.NewTimer(time.Minute * 4)
+	fsm.holdTimer = fsm.clock.NewTimer(time.Minute * 4)
 	return fsm.changeState(OpenSent, "Sent OPEN message")
 }
 
@@ -340,10 +702,18 @@ func (fsm *FSM) msgReceiver(c *net.TCPConn) error {
 			/*select {
 			case fsm.msgRecvFailCh <- msgRecvErr{err: err, con: c}:
 				continue
-			case <-time.NewTimer(time.Second * 60).C:
+			case <-fsm.clock.NewTimer(time.Second * 60).C():
 				return nil
 			}*/
 		}
+		if fsm.updateLimiter != nil && msg[18] == packet.UpdateMsg {
+			// Not reading from the connection while waiting makes TCP
+			// flow control slow down the peer
+			if !fsm.updateLimiter.wait(fsm.t.Dying()) {
+				return nil
+			}
+		}
+
 		fsm.msgRecvCh <- msgRecvMsg{msg: msg, con: c}
 
 		select {
@@ -360,17 +730,20 @@ func (fsm *FSM) openSent() int {
 
 	for {
 		select {
+		case <-fsm.restartTimer.C():
+			fsm.purgeStaleRoutes()
+			continue
 		case e := <-fsm.eventCh:
 			if e == ManualStop {
-				sendNotification(fsm.con, packet.Cease, 0)
+				fsm.sendNotification(packet.Cease, packet.AdminShut)
 				stopTimer(fsm.connectRetryTimer)
 				fsm.disconnect()
 				fsm.connectRetryCounter = 0
 				return fsm.changeState(Idle, "Manual stop event")
 			}
 			continue
-		case <-fsm.holdTimer.C:
-			sendNotification(fsm.con, packet.HoldTimeExpired, 0)
+		case <-fsm.holdTimer.C():
+			fsm.sendNotification(packet.HoldTimeExpired, 0)
 			stopTimer(fsm.connectRetryTimer)
 			fsm.disconnect()
 			fsm.connectRetryCounter++
@@ -390,15 +763,19 @@ func (fsm *FSM) openSent() int {
 				c.Close()
 				continue
 			}
-			fsm.con2 = c
+			fsm.setCon(fsm.con, c)
 			go fsm.msgReceiver(c)
 			continue
 		case recvMsg := <-fsm.msgRecvCh:
-			msg, err := packet.Decode(bytes.NewBuffer(recvMsg.msg))
+			if recvMsg.con != fsm.con && recvMsg.con != fsm.con2 {
+				// Connection was dumped by collision resolution
+				continue
+			}
+
+			msg, err := fsm.decodeMsg(recvMsg.msg)
 			if err != nil {
-				switch bgperr := err.(type) {
-				case packet.BGPError:
-					sendNotification(fsm.con, bgperr.ErrorCode, bgperr.ErrorSubCode)
+				if bgperr, ok := packet.AsBGPError(err); ok {
+					fsm.sendNotification(bgperr.ErrorCode, bgperr.ErrorSubCode)
 					sendNotification(fsm.con2, bgperr.ErrorCode, bgperr.ErrorSubCode)
 				}
 				stopTimer(fsm.connectRetryTimer)
@@ -410,55 +787,69 @@ func (fsm *FSM) openSent() int {
 			case packet.NotificationMsg:
 				nMsg := msg.Body.(*packet.BGPNotification)
 				if nMsg.ErrorCode == packet.UnsupportedVersionNumber {
+					fsm.notificationReceived(nMsg)
 					stopTimer(fsm.connectRetryTimer)
 					fsm.disconnect()
 					return fsm.changeState(Idle, "Received NOTIFICATION")
 				}
 
-				if nMsg.ErrorCode == packet.Cease {
-					// Was this connection to be closed anyways?
-					if fsm.dumpCon(recvMsg.con) {
-						continue
-					}
+				if nMsg.ErrorCode == packet.Cease && fsm.con2 != nil {
+					// The peer dumped one of the colliding connections
+					fsm.dropCon(recvMsg.con)
+					continue
 				}
+				fsm.notificationReceived(nMsg)
 				stopTimer(fsm.connectRetryTimer)
 				fsm.disconnect()
 				fsm.connectRetryCounter++
 				return fsm.changeState(Idle, "Received NOTIFICATION")
 			case packet.OpenMsg:
 				openMsg := msg.Body.(*packet.BGPOpen)
-				fsm.neighborID = openMsg.BGPIdentifier
+				fsm.setNeighborID(openMsg.BGPIdentifier)
 				fsm.resolveCollision()
+				if recvMsg.con != fsm.con {
+					// Received on the connection dumped by collision
+					// resolution. The OPEN on the surviving one follows.
+					continue
+				}
+
+				if err := fsm.checkOpen(openMsg); err != nil {
+					if bgperr, ok := packet.AsBGPError(err); ok {
+						fsm.sendNotification(bgperr.ErrorCode, bgperr.ErrorSubCode)
+					}
+					stopTimer(fsm.connectRetryTimer)
+					fsm.disconnect()
+					fsm.connectRetryCounter++
+					return fsm.changeState(Idle, fmt.Sprintf("Invalid OPEN message: %v", err))
+				}
 				stopTimer(fsm.connectRetryTimer)
-				err := fsm.sendKeepalive()
+				err := fsm.acceptOpen(openMsg)
 				if err != nil {
 					return fsm.openSentTCPFail(err)
 				}
-				fsm.holdTime = time.Duration(math.Min(float64(fsm.holdTimeConfigured), float64(openMsg.HoldTime)))
-				if fsm.holdTime != 0 {
-					fsm.holdTimer.Reset(time.Second * fsm.holdTime)
-					fsm.keepaliveTime = fsm.holdTime / 3
-					fsm.keepaliveTimer.Reset(time.Second * fsm.keepaliveTime)
-				}
 				return fsm.changeState(OpenConfirm, "Received OPEN message")
 			default:
-				sendNotification(fsm.con, packet.FiniteStateMachineError, 0)
+				fsm.sendNotification(packet.FiniteStateMachineError, 0)
 				stopTimer(fsm.connectRetryTimer)
 				fsm.con.Close()
 				fsm.connectRetryCounter++
 				return fsm.changeState(Idle, "FSM Error")
 			}
 		case err := <-fsm.msgRecvFailCh:
+			if err.con != fsm.con && err.con != fsm.con2 {
+				// Connection was dumped by collision resolution
+				continue
+			}
+
 			if err.con == fsm.con && fsm.con2 != nil {
 				fsm.con.Close()
-				fsm.con = fsm.con2
-				fsm.con2 = nil
+				fsm.setCon(fsm.con2, nil)
 				continue
 			}
 
 			if err.con == fsm.con2 {
 				fsm.con2.Close()
-				fsm.con2 = nil
+				fsm.setCon(fsm.con, nil)
 				continue
 			}
 			return fsm.openSentTCPFail(err.err)
@@ -466,84 +857,123 @@ func (fsm *FSM) openSent() int {
 	}
 }
 
-func (fsm *FSM) openSentTCPFail(err error) int {
-	fsm.con.Close()
-	fsm.resetConnectRetryTimer()
-	return fsm.changeState(Active, fmt.Sprintf("TCP failure: %v", err))
+func (fsm *FSM) setPeerCapabilities(caps packet.Capabilities) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	fsm.peerCapabilities = caps
 }
 
-func (fsm *FSM) dumpCon(c *net.TCPConn) bool {
-	p := fsm.isPassive(c)
-	if fsm.routerID > fsm.neighborID {
-		return p
-	}
-	return !p
+// PeerCapabilities returns the capabilities the peer advertised in its OPEN message
+func (fsm *FSM) PeerCapabilities() packet.Capabilities {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+
+	return fsm.peerCapabilities
 }
 
-func (fsm *FSM) resolveCollision() {
-	if fsm.con2 == nil {
-		return
+func (fsm *FSM) setNeighborID(id uint32) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	fsm.neighborID = id
+}
+
+// setHoldTime sets the negotiated hold time and derives the keepalive time from it
+func (fsm *FSM) setHoldTime(holdTime time.Duration) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	fsm.holdTime = holdTime
+	if holdTime != 0 {
+		fsm.keepaliveTime = holdTime / 3
 	}
+}
 
-	if fsm.routerID > fsm.neighborID {
-		// Terminate passive connection
-		if fsm.isPassive(fsm.con) {
-			dumpCon(fsm.con)
-			fsm.con = fsm.con2
-			return
+// checkOpen validates an OPEN received from the peer against the configuration
+func (fsm *FSM) checkOpen(msg *packet.BGPOpen) error {
+	if msg.ASN() != fsm.remoteASN {
+		return ProtocolError{
+			Err: packet.BGPError{
+				ErrorCode:    packet.OpenMessageError,
+				ErrorSubCode: packet.BadPeerAS,
+				ErrorStr:     fmt.Sprintf("Peer AS %d does not match configured AS %d", msg.ASN(), fsm.remoteASN),
+			},
 		}
-		if fsm.isPassive(fsm.con2) {
-			dumpCon(fsm.con2)
-			return
+	}
+
+	// A hold time must be either zero or at least three seconds (RFC 4271, 4.2)
+	if msg.HoldTime == 1 || msg.HoldTime == 2 {
+		return ProtocolError{
+			Err: packet.BGPError{
+				ErrorCode:    packet.OpenMessageError,
+				ErrorSubCode: packet.UnacceptableHoldTime,
+				ErrorStr:     fmt.Sprintf("Unacceptable hold time: %d", msg.HoldTime),
+			},
 		}
-		return
 	}
 
-	// Terminate active connection
-	if !fsm.isPassive(fsm.con) {
-		dumpCon(fsm.con)
-		fsm.con = fsm.con2
-		return
+	return nil
+}
+
+// acceptOpen applies the OPEN received from the peer on fsm.con and confirms it
+// with a KEEPALIVE
+func (fsm *FSM) acceptOpen(openMsg *packet.BGPOpen) error {
+	fsm.setPeerCapabilities(openMsg.Capabilities())
+	fsm.mu.Lock()
+	fsm.receivedOpen = openMsg
+	fsm.decodeOptions = packet.DecodeOptions{
+		// We always announce the 4-octet AS capability
+		Use32BitASN:            openMsg.Capabilities().Has(packet.ASN4CapabilityCode),
+		LocalAddress:           fsm.con.LocalAddr().(*net.TCPAddr).IP,
+		MaxCommunities:         fsm.maxCommunities,
+		MaxExtendedCommunities: fsm.maxExtendedCommunities,
+		KeepRawASPath:          fsm.routeServerClient,
 	}
-	if !fsm.isPassive(fsm.con2) {
-		dumpCon(fsm.con2)
-		fsm.con2.Close()
-		fsm.con2 = nil
-		return
+	fsm.encodeOptions = packet.EncodeOptions{
+		Use32BitASN: fsm.decodeOptions.Use32BitASN,
+		AddPath:     fsm.advertisePaths > 1 && openMsg.Capabilities().AddPath(packet.IPv4AFI, packet.UnicastSAFI, packet.AddPathReceive),
 	}
-}
+	fsm.mu.Unlock()
 
-func dumpCon(c *net.TCPConn) {
-	sendNotification(c, packet.Cease, packet.ConnectionCollisionResolution)
-	c.Close()
+	err := fsm.sendKeepalive()
+	if err != nil {
+		return err
+	}
+	fsm.setHoldTime(negotiateHoldTime(fsm.holdTimeConfigured, time.Duration(openMsg.HoldTime)))
+	fsm.resetHoldTimer()
+	fsm.resetKeepaliveTimer()
+	return nil
 }
 
-func (fsm *FSM) isPassive(c *net.TCPConn) bool {
-	if c.LocalAddr().String() == fmt.Sprintf("%s:179", fsm.local.String()) {
-		return true
-	}
-	return false
+func (fsm *FSM) openSentTCPFail(err error) int {
+	fsm.con.Close()
+	fsm.connectRetryFailed()
+	return fsm.changeState(Active, fmt.Sprintf("TCP failure: %v", err))
 }
 
 func (fsm *FSM) openConfirm() int {
 	for {
 		select {
+		case <-fsm.restartTimer.C():
+			fsm.purgeStaleRoutes()
+			continue
 		case e := <-fsm.eventCh:
 			if e == ManualStop { // Event 2
-				sendNotification(fsm.con, packet.Cease, 0)
+				fsm.sendNotification(packet.Cease, packet.AdminShut)
 				stopTimer(fsm.connectRetryTimer)
 				fsm.disconnect()
 				fsm.connectRetryCounter = 0
 				return fsm.changeState(Idle, "Manual stop event")
 			}
 			continue
-		case <-fsm.holdTimer.C:
-			sendNotification(fsm.con, packet.HoldTimeExpired, 0)
+		case <-fsm.holdTimer.C():
+			fsm.sendNotification(packet.HoldTimeExpired, 0)
 			stopTimer(fsm.connectRetryTimer)
 			fsm.disconnect()
 			fsm.connectRetryCounter++
 			return fsm.changeState(Idle, "Holdtimer expired")
-		case <-fsm.keepaliveTimer.C:
+		case <-fsm.keepaliveTimer.C():
 			err := fsm.sendKeepalive()
 			if err != nil {
 				stopTimer(fsm.connectRetryTimer)
@@ -551,7 +981,7 @@ func (fsm *FSM) openConfirm() int {
 				fsm.connectRetryCounter++
 				return fsm.changeState(Idle, fmt.Sprintf("Failed to send keepalive: %v", err))
 			}
-			fsm.keepaliveTimer.Reset(time.Second * fsm.keepaliveTime)
+			fsm.resetKeepaliveTimer()
 			continue
 		case c := <-fsm.conCh:
 			if fsm.con2 != nil {
@@ -568,16 +998,20 @@ func (fsm *FSM) openConfirm() int {
 				c.Close()
 				continue
 			}
-			fsm.con2 = c
+			fsm.setCon(fsm.con, c)
 			go fsm.msgReceiver(c)
 			continue
 		case recvMsg := <-fsm.msgRecvCh:
-			msg, err := packet.Decode(bytes.NewBuffer(recvMsg.msg))
+			if recvMsg.con != fsm.con && recvMsg.con != fsm.con2 {
+				// Connection was dumped by collision resolution
+				continue
+			}
+
+			msg, err := fsm.decodeMsg(recvMsg.msg)
 			if err != nil {
 				fmt.Printf("Failed to decode message: %v\n", recvMsg.msg)
-				switch bgperr := err.(type) {
-				case packet.BGPError:
-					sendNotification(fsm.con, bgperr.ErrorCode, bgperr.ErrorSubCode)
+				if bgperr, ok := packet.AsBGPError(err); ok {
+					fsm.sendNotification(bgperr.ErrorCode, bgperr.ErrorSubCode)
 					sendNotification(fsm.con2, bgperr.ErrorCode, bgperr.ErrorSubCode)
 				}
 				stopTimer(fsm.connectRetryTimer)
@@ -588,46 +1022,78 @@ func (fsm *FSM) openConfirm() int {
 
 			switch msg.Header.Type {
 			case packet.NotificationMsg:
-				nMsg := msg.Body.(packet.BGPNotification)
+				nMsg := msg.Body.(*packet.BGPNotification)
 				if nMsg.ErrorCode == packet.UnsupportedVersionNumber {
+					fsm.notificationReceived(nMsg)
 					stopTimer(fsm.connectRetryTimer)
 					fsm.con.Close()
 					return fsm.changeState(Idle, "Received NOTIFICATION")
 				}
 
-				if nMsg.ErrorCode == packet.Cease {
-					// Was this connection to be closed anyways?
-					if fsm.dumpCon(recvMsg.con) {
-						continue
-					}
+				if nMsg.ErrorCode == packet.Cease && fsm.con2 != nil {
+					// The peer dumped one of the colliding connections
+					fsm.dropCon(recvMsg.con)
+					continue
 				}
 
+				fsm.notificationReceived(nMsg)
 				return fsm.openConfirmTCPFail(fmt.Errorf("NOTIFICATION received"))
 			case packet.KeepaliveMsg:
-				fsm.holdTimer.Reset(time.Second * fsm.holdTime)
+				if recvMsg.con != fsm.con {
+					// Collision is not resolved yet
+					continue
+				}
+
+				if fsm.con2 != nil {
+					// The peer never sent an OPEN on the second connection
+					dumpCon(fsm.con2)
+					fsm.setCon(fsm.con, nil)
+				}
+				fsm.resetHoldTimer()
 				return fsm.changeState(Established, "Received KEEPALIVE")
 			case packet.OpenMsg:
 				openMsg := msg.Body.(*packet.BGPOpen)
-				fsm.neighborID = openMsg.BGPIdentifier
+				fsm.setNeighborID(openMsg.BGPIdentifier)
 				fsm.resolveCollision()
+				if recvMsg.con != fsm.con {
+					continue
+				}
+
+				// Either the connection the OPEN was accepted on lost the
+				// collision or the peer repeated its OPEN
+				if err := fsm.checkOpen(openMsg); err != nil {
+					if bgperr, ok := packet.AsBGPError(err); ok {
+						fsm.sendNotification(bgperr.ErrorCode, bgperr.ErrorSubCode)
+					}
+					return fsm.openConfirmTCPFail(err)
+				}
+				err := fsm.acceptOpen(openMsg)
+				if err != nil {
+					return fsm.openConfirmTCPFail(err)
+				}
+				continue
 			default:
-				sendNotification(fsm.con, packet.FiniteStateMachineError, 0)
+				fsm.sendNotification(packet.FiniteStateMachineError, 0)
 				stopTimer(fsm.connectRetryTimer)
 				fsm.con.Close()
 				fsm.connectRetryCounter++
 				return fsm.changeState(Idle, "FSM Error")
 			}
 		case err := <-fsm.msgRecvFailCh:
+			if err.con != fsm.con && err.con != fsm.con2 {
+				// Connection was dumped by collision resolution
+				continue
+			}
+
 			if err.con == fsm.con && fsm.con2 != nil {
 				fsm.con.Close()
-				fsm.con = fsm.con2
-				fsm.con2 = nil
+				fsm.setCon(fsm.con2, nil)
 				continue
 			}
 
 			if err.con == fsm.con2 {
 				fsm.con2.Close()
-				fsm.con2 = nil
+				fsm.setCon(fsm.con, nil)
 				continue
 			}
 			return fsm.openConfirmTCPFail(err.err)
@@ -637,49 +1103,79 @@ func (fsm *FSM) openConfirm() int {
 
 func (fsm *FSM) openConfirmTCPFail(err error) int {
 	fsm.con.Close()
-	fsm.resetConnectRetryTimer()
+	fsm.connectRetryFailed()
 	fsm.connectRetryCounter++
 	return fsm.changeState(Idle, fmt.Sprintf("Failure: %v", err))
 }
 
 func (fsm *FSM) established() int {
-	fsm.adjRibIn = rt.New()
-	go func() {
+	if !fsm.resumeStaleRoutes() {
+		fsm.clearAdjRibIn()
+		fsm.adjRibIn = rt.New()
+		fsm.adjRibIn6 = rt.New()
+	}
+	stopDump := make(chan struct{})
+	defer close(stopDump)
+	go func(adjRibIn rt.Trie) {
+		t := fsm.clock.NewTicker(time.Second * 10)
+		defer t.Stop()
+
 		for {
-			time.Sleep(time.Second * 10)
-			fmt.Printf("Dumping AdjRibIn\n")
-			routes := fsm.adjRibIn.Dump()
-			for _, route := range routes {
-				fmt.Printf("LPM: %s\n", route.Prefix().String())
+			select {
+			case <-t.C():
+			case <-stopDump:
+				return
+			}
+			if log.GetLevel() < log.DebugLevel {
+				continue
 			}
+
+			log.WithField("peer", fsm.remote.String()).Debug("Dumping Adj-RIB-In")
+			adjRibIn.Walk(func(route *rt.Route) {
+				log.WithFields(log.Fields{
+					"peer":   fsm.remote.String(),
+					"prefix": route.Prefix().String(),
+				}).Debug("Adj-RIB-In route")
+			})
 		}
-	}()
+	}(fsm.adjRibIn)
+
+	fsm.adjRIBOut.dump()
 
 	for {
 		select {
+		case <-fsm.restartTimer.C():
+			fsm.purgeStaleRoutes()
+			continue
 		case e := <-fsm.eventCh:
 			if e == ManualStop { // Event 2
-				sendNotification(fsm.con, packet.Cease, 0)
+				fsm.closedBy(ManualStop)
+				fsm.sendNotification(packet.Cease, packet.AdminShut)
 				stopTimer(fsm.connectRetryTimer)
 				fsm.con.Close()
 				fsm.connectRetryCounter = 0
+				// Routes are only retained over a graceful restart if the session failed
+				fsm.clearAdjRibIn()
 				return fsm.changeState(Idle, "Manual stop event")
 			}
 			if e == AutomaticStop { // Event 8
-				sendNotification(fsm.con, packet.Cease, 0)
+				fsm.closedBy(AutomaticStop)
+				fsm.sendNotification(packet.Cease, 0)
 				stopTimer(fsm.connectRetryTimer)
 				fsm.con.Close()
 				fsm.connectRetryCounter++
+				fsm.clearAdjRibIn()
 				return fsm.changeState(Idle, "Automatic stop event")
 			}
 			continue
-		case <-fsm.holdTimer.C:
-			sendNotification(fsm.con, packet.HoldTimeExpired, 0)
+		case <-fsm.holdTimer.C():
+			fsm.closedBy(HoldTimerExpires)
+			fsm.sendNotification(packet.HoldTimeExpired, 0)
 			stopTimer(fsm.connectRetryTimer)
 			fsm.con.Close()
 			fsm.connectRetryCounter++
 			return fsm.changeState(Idle, "Holdtimer expired")
-		case <-fsm.keepaliveTimer.C:
+		case <-fsm.keepaliveTimer.C():
 			err := fsm.sendKeepalive()
 			if err != nil {
 				stopTimer(fsm.connectRetryTimer)
@@ -687,17 +1183,29 @@ func (fsm *FSM) established() int {
 				fsm.connectRetryCounter++
 				return fsm.changeState(Idle, fmt.Sprintf("Failed to send keepalive: %v", err))
 			}
-			fsm.keepaliveTimer.Reset(time.Second * fsm.keepaliveTime)
+			fsm.resetKeepaliveTimer()
 			continue
 		case c := <-fsm.conCh:
-			c.Close()
+			// Collision with an established session (RFC 4271, 6.8)
+			dumpCon(c)
+			continue
+		case <-fsm.softReconfigCh:
+			fsm.softReconfigure()
+			continue
+		case <-fsm.adjRIBOut.changed:
+			fsm.adjRIBOut.changesQueued()
+			continue
+		case <-fsm.adjRIBOut.coalesceTimer.C():
+			fsm.adjRIBOut.coalesceExpired()
+			continue
+		case <-fsm.adjRIBOut.mraiTimer.C():
+			fsm.adjRIBOut.mraiExpired()
 			continue
 		case recvMsg := <-fsm.msgRecvCh:
-			msg, err := packet.Decode(bytes.NewBuffer(recvMsg.msg))
+			msg, err := fsm.decodeMsg(recvMsg.msg)
 			if err != nil {
-				switch bgperr := err.(type) {
-				case packet.BGPError:
-					sendNotification(fsm.con, bgperr.ErrorCode, bgperr.ErrorSubCode)
+				if bgperr, ok := packet.AsBGPError(err); ok {
+					fsm.sendNotification(bgperr.ErrorCode, bgperr.ErrorSubCode)
 				}
 				stopTimer(fsm.connectRetryTimer)
 				fsm.con.Close()
@@ -706,88 +1214,74 @@ func (fsm *FSM) established() int {
 			}
 			switch msg.Header.Type {
 			case packet.NotificationMsg:
+				fsm.notificationReceived(msg.Body.(*packet.BGPNotification))
 				stopTimer(fsm.connectRetryTimer)
 				fsm.con.Close()
 				fsm.connectRetryCounter++
 				return fsm.changeState(Idle, "Received NOTIFICATION")
 			case packet.UpdateMsg:
 				if fsm.holdTime != 0 {
-					fsm.holdTimer.Reset(time.Second * fsm.holdTime)
-				}
-
-				u := msg.Body.(*packet.BGPUpdate)
-
-				for r := u.WithdrawnRoutes; r != nil; r = r.Next {
-					x := r.IP.([4]byte)
-					pfx := tnet.NewPfx(convert.Uint32b(x[:]), r.Pfxlen)
-					fmt.Printf("LPM: Removing prefix %s\n", pfx.String())
-					fsm.adjRibIn.RemovePfx(pfx)
+					fsm.resetHoldTimer()
 				}
 
-				for r := u.NLRI; r != nil; r = r.Next {
-					x := r.IP.([4]byte)
-					pfx := tnet.NewPfx(convert.Uint32b(x[:]), r.Pfxlen)
-					fmt.Printf("LPM: Adding prefix %s\n", pfx.String())
-
-					path := &rt.Path{
-						Type:    rt.BGPPathType,
-						BGPPath: &rt.BGPPath{},
-					}
-
-					for pa := u.PathAttributes; pa.Next != nil; pa = pa.Next {
-						switch pa.TypeCode {
-						case packet.OriginAttr:
-							path.BGPPath.Origin = pa.Value.(uint8)
-						case packet.LocalPrefAttr:
-							path.BGPPath.LocalPref = pa.Value.(uint32)
-						case packet.MEDAttr:
-							path.BGPPath.MED = pa.Value.(uint32)
-						case packet.NextHopAttr:
-							path.BGPPath.NextHop = pa.Value.(uint32)
-						case packet.ASPathAttr:
-							path.BGPPath.ASPath = pa.ASPathString()
-							path.BGPPath.ASPathLen = pa.ASPathLen()
-						}
-					}
-					fsm.adjRibIn.Insert(rt.NewRoute(pfx, []*rt.Path{path}))
+				fsm.handleUpdate(recvMsg.msg[:msg.Header.Length])
+				fsm.processUpdate(msg.Body.(*packet.BGPUpdate))
+				if fsm.prefixLimitExceeded() {
+					fsm.sendNotification(packet.Cease, packet.MaxPrefReached)
+					stopTimer(fsm.connectRetryTimer)
+					fsm.con.Close()
+					fsm.connectRetryCounter++
+					fsm.clearAdjRibIn()
+					return fsm.changeState(Idle, "Maximum number of prefixes reached")
 				}
-
 				continue
 			case packet.KeepaliveMsg:
 				if fsm.holdTime != 0 {
-					fsm.holdTimer.Reset(time.Second * fsm.holdTime)
+					fsm.resetHoldTimer()
 				}
 				continue
+			case packet.CapabilityMsg:
+				log.WithFields(log.Fields{
+					"peer": fsm.remote.String(),
+				}).Info("Ignoring unsupported CAPABILITY message")
+				continue
+			case packet.RouteRefreshMsg:
+				fsm.routeRefreshReceived(msg.Body.(*packet.BGPRouteRefresh))
+				continue
 			case packet.OpenMsg:
 				if fsm.con2 != nil {
 					sendNotification(fsm.con2, packet.Cease, packet.ConnectionCollisionResolution)
 					fsm.con2.Close()
-					fsm.con2 = nil
+					fsm.setCon(fsm.con, nil)
 					continue
 				}
-				sendNotification(fsm.con, packet.FiniteStateMachineError, 0)
+				fsm.sendNotification(packet.FiniteStateMachineError, 0)
 				stopTimer(fsm.connectRetryTimer)
 				fsm.con.Close()
 				fsm.connectRetryCounter++
 				return fsm.changeState(Idle, "FSM Error")
 			default:
-				sendNotification(fsm.con, packet.FiniteStateMachineError, 0)
+				fsm.sendNotification(packet.FiniteStateMachineError, 0)
 				stopTimer(fsm.connectRetryTimer)
 				fsm.con.Close()
 				fsm.connectRetryCounter++
 				return fsm.changeState(Idle, "FSM Error")
 			}
 		case err := <-fsm.msgRecvFailCh:
+			if err.con != fsm.con && err.con != fsm.con2 {
+				// Connection was dumped by collision resolution
+				continue
+			}
+
 			if err.con == fsm.con && fsm.con2 != nil {
 				fsm.con.Close()
-				fsm.con = fsm.con2
-				fsm.con2 = nil
+				fsm.setCon(fsm.con2, nil)
 				continue
 			}
 
 			if err.con == fsm.con2 {
 				fsm.con2.Close()
-				fsm.con2 = nil
+				fsm.setCon(fsm.con, nil)
 				continue
 			}
 			return fsm.openConfirmTCPFail(err.err)
@@ -795,29 +1289,390 @@ func (fsm *FSM) established() int {
 	}
 }
 
-func stopTimer(t *time.Timer) {
+// processUpdate applies an UPDATE to the Adj-RIB-In. Withdrawals are applied
+// before announcements so a prefix both withdrawn and announced in the same
+// message ends up announced. An End-of-RIB marker removes all routes still
+// stale after a graceful restart of the peer. Announced routes whose AS_PATH
+// contains the local AS more often than allowed or that were reflected back to
+// us are treated as withdrawn.
+func (fsm *FSM) processUpdate(u *packet.BGPUpdate) {
+	if af, ok := u.EndOfRIBAddressFamily(); ok {
+		if rib := fsm.adjRibInFor(af.AFI, af.SAFI); rib != nil {
+			fsm.purgeStaleRoutesFor(rib)
+		}
+		return
+	}
+
+	for r := u.WithdrawnRoutes; r != nil; r = r.Next {
+		fsm.withdraw(fsm.adjRibIn, nlriPrefix(r), r.PathIdentifier)
+	}
+
+	for pa := u.PathAttributes; pa != nil; pa = pa.Next {
+		if pa.TypeCode != packet.MultiProtocolUnreachNLRIAttr {
+			continue
+		}
+
+		mp := pa.Value.(packet.MultiProtocolUnreachNLRI)
+		rib := fsm.adjRibInFor(mp.AFI, mp.SAFI)
+		if rib == nil {
+			fsm.warnUnsupportedAddressFamily(mp.AFI, mp.SAFI)
+			continue
+		}
+
+		for r := mp.NLRI; r != nil; r = r.Next {
+			fsm.withdraw(rib, nlriPrefix(r), r.PathIdentifier)
+		}
+	}
+
+	if u.TreatAsWithdraw {
+		log.WithFields(log.Fields{
+			"peer": fsm.remote.String(),
+		}).Warning("Treating UPDATE with malformed path attributes as withdraw")
+	}
+
+	attrs := fsm.bgpPath(u.PathAttributes)
+	withdraw := u.TreatAsWithdraw || fsm.asPathLoop(u.PathAttributes) || fsm.reflectionLoop(attrs)
+	for r := u.NLRI; r != nil; r = r.Next {
+		if withdraw {
+			fsm.withdraw(fsm.adjRibIn, nlriPrefix(r), r.PathIdentifier)
+			continue
+		}
+
+		b := attrs.Copy()
+		b.PathIdentifier = r.PathIdentifier
+		fsm.announce(fsm.adjRibIn, nlriPrefix(r), b)
+	}
+
+	for pa := u.PathAttributes; pa != nil; pa = pa.Next {
+		if pa.TypeCode != packet.MultiProtocolReachNLRIAttr {
+			continue
+		}
+
+		mp := pa.Value.(packet.MultiProtocolReachNLRI)
+		rib := fsm.adjRibInFor(mp.AFI, mp.SAFI)
+		if rib == nil {
+			fsm.warnUnsupportedAddressFamily(mp.AFI, mp.SAFI)
+			continue
+		}
+
+		for r := mp.NLRI; r != nil; r = r.Next {
+			if withdraw {
+				fsm.withdraw(rib, nlriPrefix(r), r.PathIdentifier)
+				continue
+			}
+
+			b := attrs.Copy()
+			b.PathIdentifier = r.PathIdentifier
+			b.NextHop = 0
+			if addr := mp.NextHop.To4(); addr != nil {
+				b.NextHop = convert.Uint32b(addr)
+			} else {
+				copy(b.NextHop6[:], mp.NextHop)
+			}
+			fsm.announce(rib, nlriPrefix(r), b)
+		}
+	}
+}
+
+// asPathLoop checks if the AS_PATH of attrs contains the local AS or the
+// confederation ID more often than allowed
+func (fsm *FSM) asPathLoop(attrs *packet.PathAttribute) bool {
+	for pa := attrs; pa != nil; pa = pa.Next {
+		if pa.TypeCode != packet.ASPathAttr {
+			continue
+		}
+
+		path := pa.Value.(packet.ASPath)
+		n := path.Occurrences(fsm.localASN)
+		if fsm.confederationID != 0 && fsm.confederationID != fsm.localASN {
+			n += path.Occurrences(fsm.confederationID)
+		}
+		return n > int(fsm.allowASIn)
+	}
+
+	return false
+}
+
+// adjRibInFor returns the Adj-RIB-In for an address family. It returns nil
+// for address families that are not supported.
+func (fsm *FSM) adjRibInFor(afi uint16, safi uint8) rt.Trie {
+	if safi != packet.UnicastSAFI {
+		return nil
+	}
+
+	switch afi {
+	case packet.IPv4AFI:
+		return fsm.adjRibIn
+	case packet.IPv6AFI:
+		return fsm.adjRibIn6
+	}
+
+	return nil
+}
+
+// warnUnsupportedAddressFamily logs that the NLRI of an address family that is
+// not carried were ignored
+func (fsm *FSM) warnUnsupportedAddressFamily(afi uint16, safi uint8) {
+	log.WithFields(log.Fields{
+		"peer": fsm.remote.String(),
+		"afi":  afi,
+		"safi": safi,
+	}).Warning("Ignoring NLRI of unsupported address family")
+}
+
+// withdraw removes the path with identifier pathID from the route for pfx. The
+// route is removed once its last path is gone.
+func (fsm *FSM) withdraw(rib rt.Trie, pfx *tnet.Prefix, pathID uint32) {
+	log.WithFields(log.Fields{
+		"peer":   fsm.remote.String(),
+		"prefix": pfx.String(),
+	}).Debug("Withdrawing prefix")
+	fsm.forgetPrePolicy(pfx, pathID)
+	fsm.removePath(rib, pfx, pathID)
+}
+
+// removePath removes the path with identifier pathID from the route for pfx
+// in the Adj-RIB-In rib
+func (fsm *FSM) removePath(rib rt.Trie, pfx *tnet.Prefix, pathID uint32) {
+	routes := rib.Get(pfx, false)
+	if len(routes) == 0 {
+		return
+	}
+
+	removed, final := removeBGPPath(routes[0], pathID)
+	if final {
+		fsm.updatePrefixesRcvd(-1)
+		rib.RemovePfx(pfx)
+	}
+	fsm.notifyAdjRIBIn(pfx, removed, nil)
+}
+
+// announce adds a path to the route for pfx. A path previously received with
+// the same path identifier is implicitly withdrawn, as is the path if the
+// import policy rejects it.
+func (fsm *FSM) announce(rib rt.Trie, pfx *tnet.Prefix, b *rt.BGPPath) {
+	log.WithFields(log.Fields{
+		"peer":   fsm.remote.String(),
+		"prefix": pfx.String(),
+	}).Debug("Adding prefix")
+	b.SetReceived()
+	fsm.storePrePolicy(pfx, b)
+	fsm.install(rib, pfx, b)
+}
+
+// install runs the path b received for pfx through the import policy and adds
+// it to the Adj-RIB-In rib
+func (fsm *FSM) install(rib rt.Trie, pfx *tnet.Prefix, b *rt.BGPPath) {
+	path, accept := fsm.importPath(pfx, &rt.Path{
+		Type:    rt.BGPPathType,
+		BGPPath: b,
+	})
+	if !accept {
+		fsm.removePath(rib, pfx, b.PathIdentifier)
+		return
+	}
+
+	routes := rib.Get(pfx, false)
+	if len(routes) == 0 {
+		fsm.updatePrefixesRcvd(1)
+		rib.Insert(rt.NewRoute(pfx, []*rt.Path{path}))
+		fsm.notifyAdjRIBIn(pfx, nil, path)
+		return
+	}
+
+	old, _ := removeBGPPath(routes[0], b.PathIdentifier)
+	routes[0].AddPath(path)
+	fsm.notifyAdjRIBIn(pfx, old, path)
+}
+
+// removeBGPPath removes the BGP path with identifier pathID from r. It returns
+// the removed path, nil if there was none, and true if r has no paths left.
+func removeBGPPath(r *rt.Route, pathID uint32) (removed *rt.Path, final bool) {
+	for _, p := range r.Paths() {
+		if p.Type == rt.BGPPathType && p.BGPPath.PathIdentifier == pathID {
+			return p, r.RemovePath(p)
+		}
+	}
+
+	return nil, len(r.Paths()) == 0
+}
+
+// bgpPath builds the BGP path attributes of the routes of an UPDATE
+func (fsm *FSM) bgpPath(attrs *packet.PathAttribute) *rt.BGPPath {
+	b := &rt.BGPPath{
+		RouterID:        fsm.neighborID,
+		EBGP:            fsm.external(),
+		NeighborAS:      fsm.localASN,
+		ReflectorClient: fsm.routeReflectorClient,
+		Confederation:   fsm.confederationPeer,
+	}
+	if addr := fsm.remote.To4(); addr != nil {
+		b.Source = convert.Uint32b(addr)
+	}
+
+	var asPath, as4Path packet.ASPath
+	var rawASPath *packet.RawASPath
+	var as4Aggr *packet.Aggretator
+	for pa := attrs; pa != nil; pa = pa.Next {
+		switch pa.TypeCode {
+		case packet.OriginAttr:
+			b.Origin = pa.Value.(uint8)
+		case packet.LocalPrefAttr:
+			b.LocalPref = pa.Value.(uint32)
+		case packet.MEDAttr:
+			b.MED = pa.Value.(uint32)
+			b.HasMED = true
+		case packet.NextHopAttr:
+			b.NextHop = convert.Uint32b(pa.Value.(net.IP).To4())
+		case packet.ASPathAttr:
+			asPath = pa.Value.(packet.ASPath)
+			rawASPath = pa.RawASPath
+		case packet.AS4PathAttr:
+			as4Path = pa.Value.(packet.ASPath)
+		case packet.AS4AggregatorAttr:
+			aggr := pa.Value.(packet.Aggretator)
+			as4Aggr = &aggr
+		case packet.CommunitiesAttr:
+			b.Communities = pa.Value.([]uint32)
+		case packet.ExtendedCommunitiesAttr:
+			b.ExtendedCommunities = pa.Value.([]packet.ExtendedCommunity)
+		case packet.OriginatorIDAttr:
+			b.OriginatorID = pa.Value.(uint32)
+		case packet.ClusterListAttr:
+			b.ClusterList = pa.Value.([]uint32)
+		case packet.AtomicAggrAttr:
+			b.AtomicAggregate = true
+		case packet.AggregatorAttr:
+			aggr := pa.Value.(packet.Aggretator)
+			b.AggregatorAS = aggr.ASN
+			b.AggregatorAddr = convert.Uint32b(aggr.Addr[:])
+		default:
+			if _, ok := pa.Value.(packet.UnknownAttribute); ok {
+				x := *pa
+				x.Next = nil
+				b.UnknownAttributes = append(b.UnknownAttributes, x)
+			}
+		}
+	}
+
+	// AS4_PATH and AS4_AGGREGATOR are only valid from 2-octet AS speakers and
+	// are ignored if the AGGREGATOR names a 2-octet AS (RFC 6793, 4.2.3)
+	if !fsm.decodeOptions.Use32BitASN && (b.AggregatorAS == 0 || b.AggregatorAS == packet.ASTrans) {
+		if as4Aggr != nil && b.AggregatorAS == packet.ASTrans {
+			b.AggregatorAS = as4Aggr.ASN
+			b.AggregatorAddr = convert.Uint32b(as4Aggr.Addr[:])
+		}
+		asPath = packet.MergeAS4Path(asPath, as4Path)
+	}
+	if asPath != nil {
+		b.SetASPath(asPath)
+		b.ASPathRaw = rawASPath
+	}
+
+	return b
+}
+
+// nlriPrefix converts a decoded NLRI into a prefix
+func nlriPrefix(r *packet.NLRI) *tnet.Prefix {
+	if x, ok := r.IP.([16]byte); ok {
+		return tnet.NewPfx6(x, r.Pfxlen)
+	}
+
+	x := r.IP.([4]byte)
+	return tnet.NewPfx(convert.Uint32b(x[:]), r.Pfxlen)
+}
+
+func (fsm *FSM) updatePrefixesRcvd(delta int64) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	fsm.prefixesRcvd = uint64(int64(fsm.prefixesRcvd) + delta)
+}
+
+func (fsm *FSM) updatePrefixesAdvert(delta int64) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	fsm.prefixesAdvert = uint64(int64(fsm.prefixesAdvert) + delta)
+}
+
+func (fsm *FSM) resetPrefixCounters() {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	fsm.prefixesRcvd = 0
+	fsm.prefixesAdvert = 0
+}
+
+func stopTimer(t timer) {
 	if !t.Stop() {
 		select {
-		case <-t.C:
+		case <-t.C():
 		default:
 		}
 	}
 }
 
+// negotiateHoldTime returns the hold time of a session as the lower of the
+// configured and the received hold time
+func negotiateHoldTime(configured, received time.Duration) time.Duration {
+	if received < configured {
+		return received
+	}
+
+	return configured
+}
+
+// resetHoldTimer restarts the hold timer. A hold time of 0 disables it.
+func (fsm *FSM) resetHoldTimer() {
+	stopTimer(fsm.holdTimer)
+	if fsm.holdTime != 0 {
+		fsm.holdTimer.Reset(time.Second * fsm.holdTime)
+	}
+}
+
+// resetKeepaliveTimer restarts the keepalive timer. No KEEPALIVEs are sent
+// if the hold time is 0.
+func (fsm *FSM) resetKeepaliveTimer() {
+	stopTimer(fsm.keepaliveTimer)
+	if fsm.holdTime != 0 {
+		fsm.keepaliveTimer.Reset(time.Second * fsm.keepaliveTime)
+	}
+}
+
 func (fsm *FSM) startConnectRetryTimer() {
-	fsm.connectRetryTimer = time.NewTimer(time.Second * fsm.connectRetryTime)
+	fsm.connectRetryTimer = fsm.clock.NewTimer(fsm.connectRetry.delay())
 }
 
 func (fsm *FSM) resetConnectRetryTimer() {
-	if !fsm.connectRetryTimer.Reset(time.Second * fsm.connectRetryTime) {
-		<-fsm.connectRetryTimer.C
-	}
+	stopTimer(fsm.connectRetryTimer)
+	fsm.connectRetryTimer.Reset(fsm.connectRetry.delay())
+}
+
+// connectRetryFailed backs off and restarts the ConnectRetry timer after a
+// failed connection attempt
+func (fsm *FSM) connectRetryFailed() {
+	fsm.connectRetry.failed()
+	fsm.resetConnectRetryTimer()
 }
 
 func (fsm *FSM) resetDelayOpenTimer() {
 	if !fsm.delayOpenTimer.Reset(time.Second * fsm.delayOpenTime) {
-		<-fsm.delayOpenTimer.C
+		<-fsm.delayOpenTimer.C()
+	}
+}
+
+// decodeMsg decodes a message received from the peer. Decoding failures are
+// returned as ProtocolError.
+func (fsm *FSM) decodeMsg(msg []byte) (*packet.BGPMessage, error) {
+	m, err := packet.Decode(bytes.NewBuffer(msg), &fsm.decodeOptions)
+	if err != nil {
+		return nil, ProtocolError{
+			Err: err,
+		}
 	}
+
+	return m, nil
 }
 
 func (fsm *FSM) sendKeepalive() error {
@@ -825,26 +1680,95 @@ func (fsm *FSM) sendKeepalive() error {
 
 	_, err := fsm.con.Write(msg)
 	if err != nil {
-		return fmt.Errorf("Unable to send KEEPALIVE message: %v", err)
+		return TransportError{
+			Err: fmt.Errorf("Unable to send KEEPALIVE message: %w", err),
+		}
 	}
 
 	return nil
 }
 
 func (fsm *FSM) sendOpen(c *net.TCPConn) error {
-	msg := packet.SerializeOpenMsg(&packet.BGPOpen{
+	asn := fsm.sessionASN()
+	as := uint16(packet.ASTrans)
+	if asn <= math.MaxUint16 {
+		as = uint16(asn)
+	}
+
+	open := &packet.BGPOpen{
 		Version:       BGPVersion,
-		AS:            fsm.localASN,
+		AS:            as,
 		HoldTime:      uint16(fsm.holdTimeConfigured),
 		BGPIdentifier: fsm.routerID,
-		OptParmLen:    0,
+	}
+	open.AddCapability(packet.Capability{
+		Code:  packet.ASN4CapabilityCode,
+		Value: packet.ASN4Capability{ASN4: asn},
+	})
+	open.AddCapability(packet.Capability{
+		Code: packet.RouteRefreshCapabilityCode,
 	})
+	if fsm.advertisePaths > 1 {
+		open.AddCapability(packet.Capability{
+			Code: packet.AddPathCapabilityCode,
+			Value: packet.AddPathCapability{
+				AddressFamilies: []packet.AddPathAddressFamily{
+					{AFI: packet.IPv4AFI, SAFI: packet.UnicastSAFI, SendReceive: packet.AddPathSend},
+				},
+			},
+		})
+	}
+	if fsm.gracefulRestartTime != 0 {
+		open.AddCapability(packet.Capability{
+			Code: packet.GracefulRestartCapabilityCode,
+			Value: packet.GracefulRestartCapability{
+				RestartTime: fsm.gracefulRestartTime,
+				AddressFamilies: []packet.GracefulRestartAddressFamily{
+					{AFI: packet.IPv4AFI, SAFI: packet.UnicastSAFI},
+					{AFI: packet.IPv6AFI, SAFI: packet.UnicastSAFI},
+				},
+			},
+		})
+	}
+
+	msg, err := packet.SerializeOpenMsg(open)
+	if err != nil {
+		return fmt.Errorf("Unable to serialize OPEN message: %w", err)
+	}
 
-	_, err := c.Write(msg)
+	_, err = c.Write(msg)
 	if err != nil {
-		return fmt.Errorf("Unable to send OPEN message: %v", err)
+		return TransportError{
+			Err: fmt.Errorf("Unable to send OPEN message: %w", err),
+		}
 	}
 
+	fsm.mu.Lock()
+	fsm.sentOpen = open
+	fsm.mu.Unlock()
+
+	return nil
+}
+
+// closedBy records that the local system closes the session on event e
+func (fsm *FSM) closedBy(e int) {
+	fsm.down.Local = true
+	fsm.down.Event = e
+}
+
+// sendNotification sends a NOTIFICATION on fsm.con and records it as the
+// reason the session is closed
+func (fsm *FSM) sendNotification(errorCode uint8, errorSubCode uint8) error {
+	err := sendNotification(fsm.con, errorCode, errorSubCode)
+	if err != nil {
+		return err
+	}
+
+	fsm.down.Notification = &packet.BGPNotification{
+		ErrorCode:    errorCode,
+		ErrorSubcode: errorSubCode,
+	}
+	fsm.down.Local = true
 	return nil
 }
 
@@ -853,11 +1777,19 @@ func sendNotification(c *net.TCPConn, errorCode uint8, errorSubCode uint8) error
 		return fmt.Errorf("connection is nil")
 	}
 
-	msg := packet.SerializeNotificationMsg(&packet.BGPNotification{})
+	msg, err := packet.SerializeNotificationMsg(&packet.BGPNotification{
+		ErrorCode:    errorCode,
+		ErrorSubcode: errorSubCode,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to serialize NOTIFICATION message: %w", err)
+	}
 
-	_, err := c.Write(msg)
+	_, err = c.Write(msg)
 	if err != nil {
-		return fmt.Errorf("Unable to send NOTIFICATION message: %v", err)
+		return TransportError{
+			Err: fmt.Errorf("Unable to send NOTIFICATION message: %w", err),
+		}
 	}
 
 	return nil
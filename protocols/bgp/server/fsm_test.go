@@ -0,0 +1,705 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessUpdateWithdrawAndAnnounce(t *testing.T) {
+	fsm := NewFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+	})
+	fsm.adjRibIn = rt.New()
+
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	nlri := &packet.NLRI{
+		IP:     [4]byte{192, 0, 2, 0},
+		Pfxlen: 24,
+	}
+
+	fsm.processUpdate(&packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode: packet.LocalPrefAttr,
+			Value:    uint32(100),
+		},
+		NLRI: nlri,
+	})
+
+	fsm.processUpdate(&packet.BGPUpdate{
+		WithdrawnRoutes: nlri,
+		PathAttributes: &packet.PathAttribute{
+			TypeCode: packet.LocalPrefAttr,
+			Value:    uint32(200),
+			Next: &packet.PathAttribute{
+				TypeCode: packet.NextHopAttr,
+				Value:    net.IP{192, 0, 2, 1},
+			},
+		},
+		NLRI: nlri,
+	})
+
+	routes := fsm.adjRibIn.Get(pfx, false)
+	if !assert.Len(t, routes, 1) {
+		return
+	}
+
+	paths := routes[0].Paths()
+	if !assert.Len(t, paths, 1) {
+		return
+	}
+	assert.Equal(t, uint32(200), paths[0].BGPPath.LocalPref)
+	assert.Equal(t, uint32(3221225985), paths[0].BGPPath.NextHop)
+	assert.Equal(t, uint64(1), fsm.Info().PrefixesReceived)
+}
+
+func TestProcessUpdateTreatAsWithdraw(t *testing.T) {
+	fsm := NewFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+	})
+	fsm.adjRibIn = rt.New()
+
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	nlri := &packet.NLRI{
+		IP:     [4]byte{192, 0, 2, 0},
+		Pfxlen: 24,
+	}
+	attrs := &packet.PathAttribute{
+		TypeCode: packet.LocalPrefAttr,
+		Value:    uint32(100),
+	}
+
+	fsm.processUpdate(&packet.BGPUpdate{
+		PathAttributes: attrs,
+		NLRI:           nlri,
+	})
+	assert.Len(t, fsm.adjRibIn.Get(pfx, false), 1)
+
+	fsm.processUpdate(&packet.BGPUpdate{
+		PathAttributes:  attrs,
+		NLRI:            nlri,
+		TreatAsWithdraw: true,
+	})
+	assert.Len(t, fsm.adjRibIn.Get(pfx, false), 0)
+	assert.Equal(t, uint64(0), fsm.Info().PrefixesReceived)
+}
+
+func TestProcessUpdateASPathLoop(t *testing.T) {
+	tests := []struct {
+		name      string
+		asPath    packet.ASPath
+		allowASIn uint8
+		expected  bool
+	}{
+		{
+			name: "No loop",
+			asPath: packet.ASPath{
+				{Type: packet.ASSequence, ASNs: []uint32{65201, 65300}},
+			},
+			expected: true,
+		},
+		{
+			name: "Local AS in AS_SEQUENCE",
+			asPath: packet.ASPath{
+				{Type: packet.ASSequence, ASNs: []uint32{65201, 65200, 65300}},
+			},
+			expected: false,
+		},
+		{
+			name: "Local AS in AS_SET",
+			asPath: packet.ASPath{
+				{Type: packet.ASSequence, ASNs: []uint32{65201}},
+				{Type: packet.ASSet, ASNs: []uint32{65300, 65200}},
+			},
+			expected: false,
+		},
+		{
+			name: "Single occurrence with allowas-in 1",
+			asPath: packet.ASPath{
+				{Type: packet.ASSequence, ASNs: []uint32{65201, 65200, 65300}},
+			},
+			allowASIn: 1,
+			expected:  true,
+		},
+		{
+			name: "Two occurrences with allowas-in 1",
+			asPath: packet.ASPath{
+				{Type: packet.ASSequence, ASNs: []uint32{65201, 65200, 65200, 65300}},
+			},
+			allowASIn: 1,
+			expected:  false,
+		},
+	}
+
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	for _, test := range tests {
+		fsm := newFSM(config.Peer{
+			LocalAS:     65200,
+			PeerAS:      65201,
+			PeerAddress: net.IP{169, 254, 123, 1},
+			AllowASIn:   test.allowASIn,
+		}, newFakeClock())
+		fsm.adjRibIn = rt.New()
+
+		fsm.processUpdate(&packet.BGPUpdate{
+			PathAttributes: &packet.PathAttribute{
+				TypeCode: packet.ASPathAttr,
+				Value:    test.asPath,
+			},
+			NLRI: &packet.NLRI{
+				IP:     [4]byte{192, 0, 2, 0},
+				Pfxlen: 24,
+			},
+		})
+
+		routes := fsm.adjRibIn.Get(pfx, false)
+		if !test.expected {
+			assert.Len(t, routes, 0, test.name)
+			assert.Equal(t, uint64(0), fsm.Info().PrefixesReceived, test.name)
+			continue
+		}
+		assert.Len(t, routes, 1, test.name)
+	}
+}
+
+func TestProcessUpdateASPathLoopWithdraws(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+	}, newFakeClock())
+	fsm.adjRibIn = rt.New()
+
+	nlri := &packet.NLRI{
+		IP:     [4]byte{192, 0, 2, 0},
+		Pfxlen: 24,
+	}
+	fsm.processUpdate(&packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode: packet.ASPathAttr,
+			Value: packet.ASPath{
+				{Type: packet.ASSequence, ASNs: []uint32{65201}},
+			},
+		},
+		NLRI: nlri,
+	})
+
+	// A looped path replacing a previously accepted one is treated as withdraw
+	fsm.processUpdate(&packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode: packet.ASPathAttr,
+			Value: packet.ASPath{
+				{Type: packet.ASSequence, ASNs: []uint32{65201, 65200}},
+			},
+		},
+		NLRI: nlri,
+	})
+
+	assert.Len(t, fsm.adjRibIn.Get(tnet.NewPfx(3221225984, 24), false), 0)
+	assert.Equal(t, uint64(0), fsm.Info().PrefixesReceived)
+}
+
+func TestProcessUpdateMultiProtocol(t *testing.T) {
+	fsm := NewFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+	})
+	fsm.adjRibIn = rt.New()
+	fsm.adjRibIn6 = rt.New()
+
+	nlri := &packet.NLRI{
+		IP:     [16]byte{0x20, 0x01, 0x0d, 0xb8},
+		Pfxlen: 32,
+	}
+	pfx := tnet.NewPfx6([16]byte{0x20, 0x01, 0x0d, 0xb8}, 32)
+
+	fsm.processUpdate(&packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode: packet.LocalPrefAttr,
+			Value:    uint32(100),
+			Next: &packet.PathAttribute{
+				TypeCode: packet.MultiProtocolReachNLRIAttr,
+				Value: packet.MultiProtocolReachNLRI{
+					AFI:     packet.IPv6AFI,
+					SAFI:    packet.UnicastSAFI,
+					NextHop: net.ParseIP("2001:db8::1"),
+					NLRI:    nlri,
+				},
+			},
+		},
+	})
+
+	routes := fsm.adjRibIn6.Get(pfx, false)
+	if !assert.Len(t, routes, 1) {
+		return
+	}
+	assert.Equal(t, uint16(tnet.IPv6AFI), routes[0].Prefix().AFI())
+	assert.Equal(t, "2001:db8::/32", routes[0].Prefix().String())
+
+	paths := routes[0].Paths()
+	if !assert.Len(t, paths, 1) {
+		return
+	}
+	assert.Equal(t, uint32(100), paths[0].BGPPath.LocalPref)
+	assert.Equal(t, [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}, paths[0].BGPPath.NextHop6)
+	assert.Equal(t, uint64(1), fsm.prefixesRcvd)
+
+	fsm.processUpdate(&packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode: packet.MultiProtocolUnreachNLRIAttr,
+			Value: packet.MultiProtocolUnreachNLRI{
+				AFI:  packet.IPv6AFI,
+				SAFI: packet.UnicastSAFI,
+				NLRI: nlri,
+			},
+		},
+	})
+
+	assert.Len(t, fsm.adjRibIn6.Get(pfx, false), 0)
+	assert.Equal(t, uint64(0), fsm.prefixesRcvd)
+}
+
+func TestProcessUpdateAddPath(t *testing.T) {
+	fsm := NewFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+	})
+	fsm.adjRibIn = rt.New()
+
+	pfx := tnet.NewPfx(167772160, 24) // 10.0.0.0/24
+	nlri := func(id uint32) *packet.NLRI {
+		return &packet.NLRI{
+			PathIdentifier: id,
+			IP:             [4]byte{10, 0, 0, 0},
+			Pfxlen:         24,
+		}
+	}
+
+	fsm.processUpdate(&packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode: packet.LocalPrefAttr,
+			Value:    uint32(100),
+		},
+		NLRI: &packet.NLRI{
+			PathIdentifier: 1,
+			IP:             [4]byte{10, 0, 0, 0},
+			Pfxlen:         24,
+			Next:           nlri(2),
+		},
+	})
+
+	routes := fsm.adjRibIn.Get(pfx, false)
+	if !assert.Len(t, routes, 1) {
+		return
+	}
+
+	paths := routes[0].Paths()
+	if !assert.Len(t, paths, 2) {
+		return
+	}
+	assert.Equal(t, uint32(1), paths[0].BGPPath.PathIdentifier)
+	assert.Equal(t, uint32(2), paths[1].BGPPath.PathIdentifier)
+	assert.Equal(t, uint64(1), fsm.prefixesRcvd)
+
+	// Re-announcing path 2 replaces it
+	fsm.processUpdate(&packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode: packet.LocalPrefAttr,
+			Value:    uint32(200),
+		},
+		NLRI: nlri(2),
+	})
+
+	paths = fsm.adjRibIn.Get(pfx, false)[0].Paths()
+	if !assert.Len(t, paths, 2) {
+		return
+	}
+	assert.Equal(t, uint32(2), paths[1].BGPPath.PathIdentifier)
+	assert.Equal(t, uint32(200), paths[1].BGPPath.LocalPref)
+
+	fsm.processUpdate(&packet.BGPUpdate{
+		WithdrawnRoutes: nlri(1),
+	})
+
+	paths = fsm.adjRibIn.Get(pfx, false)[0].Paths()
+	if !assert.Len(t, paths, 1) {
+		return
+	}
+	assert.Equal(t, uint32(2), paths[0].BGPPath.PathIdentifier)
+
+	fsm.processUpdate(&packet.BGPUpdate{
+		WithdrawnRoutes: nlri(2),
+	})
+
+	assert.Len(t, fsm.adjRibIn.Get(pfx, false), 0)
+	assert.Equal(t, uint64(0), fsm.prefixesRcvd)
+}
+
+func TestCheckOpen(t *testing.T) {
+	tests := []struct {
+		name           string
+		peerAS         uint32
+		msg            *packet.BGPOpen
+		wantFail       bool
+		expectedSubErr uint8
+	}{
+		{
+			name:   "Matching AS",
+			peerAS: 65201,
+			msg: &packet.BGPOpen{
+				AS: 65201,
+			},
+		},
+		{
+			name:   "Matching 4-octet AS",
+			peerAS: 4200000000,
+			msg: &packet.BGPOpen{
+				AS: 23456,
+				OptParams: []packet.OptParam{
+					{
+						Type: packet.CapabilitiesParamType,
+						Value: packet.Capabilities{
+							{
+								Code:  packet.ASN4CapabilityCode,
+								Value: packet.ASN4Capability{ASN4: 4200000000},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:   "Mismatching AS",
+			peerAS: 65201,
+			msg: &packet.BGPOpen{
+				AS: 65202,
+			},
+			wantFail:       true,
+			expectedSubErr: packet.BadPeerAS,
+		},
+		{
+			name:   "Hold time 1",
+			peerAS: 65201,
+			msg: &packet.BGPOpen{
+				AS:       65201,
+				HoldTime: 1,
+			},
+			wantFail:       true,
+			expectedSubErr: packet.UnacceptableHoldTime,
+		},
+		{
+			name:   "Hold time 0",
+			peerAS: 65201,
+			msg: &packet.BGPOpen{
+				AS:       65201,
+				HoldTime: 0,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		fsm := NewFSM(config.Peer{
+			LocalAS:     65200,
+			PeerAS:      test.peerAS,
+			PeerAddress: net.IP{169, 254, 123, 1},
+		})
+
+		err := fsm.checkOpen(test.msg)
+		if !test.wantFail {
+			assert.NoError(t, err, test.name)
+			continue
+		}
+
+		if !assert.Error(t, err, test.name) {
+			continue
+		}
+
+		assert.IsType(t, ProtocolError{}, err, test.name)
+
+		var bgperr packet.BGPError
+		if !assert.True(t, errors.As(err, &bgperr), test.name) {
+			continue
+		}
+		assert.Equal(t, uint8(packet.OpenMessageError), bgperr.ErrorCode, test.name)
+		assert.Equal(t, test.expectedSubErr, bgperr.ErrorSubCode, test.name)
+	}
+}
+
+func TestNegotiateHoldTime(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured time.Duration
+		received   time.Duration
+		expected   time.Duration
+	}{
+		{
+			name:       "Received lower",
+			configured: 90,
+			received:   30,
+			expected:   30,
+		},
+		{
+			name:       "Configured lower",
+			configured: 30,
+			received:   90,
+			expected:   30,
+		},
+		{
+			name:       "Received zero",
+			configured: 90,
+			received:   0,
+			expected:   0,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, negotiateHoldTime(test.configured, test.received), test.name)
+	}
+}
+
+func TestSendNotification(t *testing.T) {
+	c, s := tcpPair(t)
+	defer c.Close()
+	defer s.Close()
+
+	err := sendNotification(c, packet.OpenMessageError, packet.BadPeerAS)
+	assert.NoError(t, err)
+
+	buf := make([]byte, packet.MinLen+2)
+	_, err = io.ReadFull(s, buf)
+	if err != nil {
+		t.Fatalf("Unable to read: %v", err)
+	}
+
+	assert.Equal(t, []byte{packet.NotificationMsg, packet.OpenMessageError, packet.BadPeerAS}, buf[packet.MinLen-1:])
+}
+
+func TestOpenConfirmRejectsUpdate(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+	}, newFakeClock())
+	fsm.adjRibIn = rt.New()
+
+	// Drain the timers which fire on creation
+	stopTimer(fsm.holdTimer)
+	stopTimer(fsm.keepaliveTimer)
+
+	local, remote := tcpPair(t)
+	defer remote.Close()
+	fsm.con = local
+
+	update := []byte{
+		255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255,
+		0, 38, // Length
+		packet.UpdateMsg,
+		0, 0, // Withdrawn Routes Length
+		0, 11, // Total Path Attribute Length
+		64, 1, 1, 0, // ORIGIN: IGP
+		64, 3, 4, 192, 0, 2, 1, // NEXT_HOP: 192.0.2.1
+		24, 192, 0, 2, // 192.0.2.0/24
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- fsm.openConfirm()
+	}()
+
+	fsm.msgRecvCh <- msgRecvMsg{msg: update, con: local}
+	assert.Equal(t, Idle, <-done)
+
+	buf := make([]byte, packet.MinLen+2)
+	_, err := io.ReadFull(remote, buf)
+	if err != nil {
+		t.Fatalf("Unable to read NOTIFICATION: %v", err)
+	}
+	assert.Equal(t, []byte{packet.NotificationMsg, packet.FiniteStateMachineError, 0}, buf[packet.MinLen-1:])
+
+	routes := 0
+	fsm.adjRibIn.Walk(func(*rt.Route) {
+		routes++
+	})
+	assert.Equal(t, 0, routes)
+	assert.Equal(t, uint64(0), fsm.Info().PrefixesReceived)
+}
+
+func TestOpenHandshake(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+		HoldTimer:   90,
+	}, newFakeClock())
+	changes := fsm.Subscribe()
+
+	// Drain the timers which fire on creation
+	stopTimer(fsm.holdTimer)
+	stopTimer(fsm.keepaliveTimer)
+
+	local, remote := tcpPair(t)
+	defer remote.Close()
+	fsm.con = local
+	fsm.changeState(OpenSent, "Test")
+
+	done := make(chan int)
+	go func() {
+		if fsm.openSent() != OpenConfirm {
+			done <- fsm.getState()
+			return
+		}
+		done <- fsm.openConfirm()
+	}()
+
+	open, err := packet.SerializeOpenMsg(&packet.BGPOpen{
+		Version:       packet.BGP4Version,
+		AS:            65201,
+		HoldTime:      90,
+		BGPIdentifier: 0x0a000001,
+	})
+	if err != nil {
+		t.Fatalf("Unable to serialize OPEN: %v", err)
+	}
+	_, err = remote.Write(open)
+	if err != nil {
+		t.Fatalf("Unable to send OPEN: %v", err)
+	}
+
+	buf := make([]byte, packet.MinLen)
+	_, err = io.ReadFull(remote, buf)
+	if err != nil {
+		t.Fatalf("Unable to read KEEPALIVE: %v", err)
+	}
+	assert.Equal(t, uint8(packet.KeepaliveMsg), buf[packet.MinLen-1])
+
+	_, err = remote.Write(packet.SerializeKeepaliveMsg())
+	if err != nil {
+		t.Fatalf("Unable to send KEEPALIVE: %v", err)
+	}
+
+	assert.Equal(t, Established, <-done)
+	assert.Equal(t, uint32(0x0a000001), fsm.neighborID)
+
+	assert.Equal(t, StateChange{Old: Idle, New: OpenSent, Reason: "Test"}, <-changes)
+	assert.Equal(t, StateChange{Old: OpenSent, New: OpenConfirm, Reason: "Received OPEN message"}, <-changes)
+	assert.Equal(t, StateChange{Old: OpenConfirm, New: Established, Reason: "Received KEEPALIVE"}, <-changes)
+
+	fsm.disconnect()
+}
+
+func TestSendOpenCapabilities(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+		RouterID:    0x0a000002,
+		HoldTimer:   90,
+	}, newFakeClock())
+
+	local, remote := tcpPair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	err := fsm.sendOpen(local)
+	if err != nil {
+		t.Fatalf("Unable to send OPEN: %v", err)
+	}
+
+	raw := readMsgType(t, remote, packet.OpenMsg)
+	msg, err := packet.Decode(bytes.NewBuffer(raw), &packet.DecodeOptions{})
+	if err != nil {
+		t.Fatalf("Unable to decode OPEN: %v", err)
+	}
+	caps := msg.Body.(*packet.BGPOpen).Capabilities()
+	assert.True(t, caps.Has(packet.ASN4CapabilityCode))
+	assert.True(t, caps.Has(packet.RouteRefreshCapabilityCode))
+	assert.False(t, caps.Has(packet.AddPathCapabilityCode))
+	assert.False(t, caps.Has(packet.GracefulRestartCapabilityCode))
+}
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer l.Close()
+
+	c, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatalf("Unable to connect: %v", err)
+	}
+
+	s, err := l.AcceptTCP()
+	if err != nil {
+		t.Fatalf("Unable to accept: %v", err)
+	}
+
+	return c, s
+}
+
+func TestActiveStateConnectRetryExpires(t *testing.T) {
+	tests := []struct {
+		name     string
+		passive  bool
+		dial     bool
+		expected int
+	}{
+		{
+			name:     "Passive and active",
+			dial:     true,
+			expected: Connect,
+		},
+		{
+			name:     "Passive only",
+			passive:  true,
+			expected: Active,
+		},
+	}
+
+	for _, test := range tests {
+		clk := newFakeClock()
+		fsm := newFSM(config.Peer{
+			LocalAS:            65200,
+			PeerAS:             65201,
+			PeerAddress:        net.IP{169, 254, 123, 1},
+			Passive:            test.passive,
+			ConnectRetryTime:   1,
+			ConnectRetryJitter: -1,
+		}, clk)
+		fsm.startConnectRetryTimer()
+
+		done := make(chan int)
+		go func() {
+			done <- fsm.active()
+		}()
+
+		clk.Advance(time.Second)
+
+		dialed := false
+		select {
+		case <-fsm.initiateCon:
+			dialed = true
+		case <-time.After(50 * time.Millisecond):
+			fsm.eventCh <- ManualStop
+		}
+
+		assert.Equalf(t, test.dial, dialed, "Test %q", test.name)
+		assert.Equalf(t, test.expected, <-done, "Test %q", test.name)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"time"
+
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	log "github.com/sirupsen/logrus"
+)
+
+// stalePath locates a path retained over a restart of the peer
+type stalePath struct {
+	rib rt.Trie
+	pfx *tnet.Prefix
+}
+
+// clearAdjRibIn drops all routes received from the peer including those
+// retained over a restart
+func (fsm *FSM) clearAdjRibIn() {
+	fsm.dropAdjRIBIn(fsm.adjRibIn)
+	fsm.dropAdjRIBIn(fsm.adjRibIn6)
+	fsm.adjRibIn = nil
+	fsm.adjRibIn6 = nil
+	fsm.prePolicy = nil
+	fsm.staleRoutes = nil
+	stopTimer(fsm.restartTimer)
+	fsm.resetPrefixCounters()
+}
+
+// retainStaleRoutes marks all routes of the Adj-RIB-In stale after the session
+// to a graceful restart capable peer went down (RFC4724, 4.2). The routes are
+// kept until the peer sent an End-of-RIB marker or the restart time it
+// advertised elapsed. It returns false if the routes have to be dropped.
+func (fsm *FSM) retainStaleRoutes() bool {
+	if fsm.staleRoutes != nil {
+		return true
+	}
+
+	if fsm.lastState != Established || fsm.adjRibIn == nil || fsm.isAdminDown() {
+		return false
+	}
+
+	c, ok := fsm.PeerCapabilities().Get(packet.GracefulRestartCapabilityCode)
+	if !ok {
+		return false
+	}
+
+	fsm.staleRoutes = make(map[*rt.Path]stalePath)
+	for _, rib := range []rt.Trie{fsm.adjRibIn, fsm.adjRibIn6} {
+		rib.Walk(func(r *rt.Route) {
+			for _, p := range r.Paths() {
+				fsm.staleRoutes[p] = stalePath{
+					rib: rib,
+					pfx: r.Prefix(),
+				}
+			}
+		})
+	}
+
+	restartTime := c.Value.(packet.GracefulRestartCapability).RestartTime
+	fsm.restartTimer.Reset(time.Second * time.Duration(restartTime))
+
+	log.WithFields(log.Fields{
+		"peer":         fsm.remote.String(),
+		"stale_paths":  len(fsm.staleRoutes),
+		"restart_time": restartTime,
+	}).Info("Retaining stale routes for graceful restart")
+
+	return true
+}
+
+// resumeStaleRoutes checks if the routes retained over a restart can be kept
+// for the new session. This is the case if the peer set the restart state flag
+// in its OPEN message.
+func (fsm *FSM) resumeStaleRoutes() bool {
+	if fsm.staleRoutes == nil {
+		return false
+	}
+
+	c, ok := fsm.PeerCapabilities().Get(packet.GracefulRestartCapabilityCode)
+	if !ok {
+		return false
+	}
+
+	return c.Value.(packet.GracefulRestartCapability).RestartState
+}
+
+// purgeStaleRoutes removes all retained paths the peer did not announce again
+// since its restart
+func (fsm *FSM) purgeStaleRoutes() {
+	for p, s := range fsm.staleRoutes {
+		fsm.purgeStalePath(p, s)
+	}
+
+	fsm.staleRoutes = nil
+	stopTimer(fsm.restartTimer)
+}
+
+// purgeStaleRoutesFor removes the retained paths of rib the peer did not
+// announce again since its restart. The restart ends once no stale paths are
+// left.
+func (fsm *FSM) purgeStaleRoutesFor(rib rt.Trie) {
+	for p, s := range fsm.staleRoutes {
+		if s.rib != rib {
+			continue
+		}
+
+		fsm.purgeStalePath(p, s)
+		delete(fsm.staleRoutes, p)
+	}
+
+	if fsm.staleRoutes != nil && len(fsm.staleRoutes) == 0 {
+		fsm.staleRoutes = nil
+		stopTimer(fsm.restartTimer)
+	}
+}
+
+// purgeStalePath removes p from its route unless it was replaced or withdrawn
+// in the meantime
+func (fsm *FSM) purgeStalePath(p *rt.Path, s stalePath) {
+	routes := s.rib.Get(s.pfx, false)
+	if len(routes) == 0 {
+		return
+	}
+
+	for _, x := range routes[0].Paths() {
+		if x != p {
+			continue
+		}
+
+		if routes[0].RemovePath(p) {
+			fsm.updatePrefixesRcvd(-1)
+			s.rib.RemovePfx(s.pfx)
+		}
+		fsm.notifyAdjRIBIn(s.pfx, p, nil)
+		return
+	}
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+func gracefulRestartCaps(restartState bool) packet.Capabilities {
+	return packet.Capabilities{
+		{
+			Code: packet.GracefulRestartCapabilityCode,
+			Value: packet.GracefulRestartCapability{
+				RestartState: restartState,
+				RestartTime:  120,
+			},
+		},
+	}
+}
+
+// flappedFSM returns an FSM whose session to a peer went down after it
+// announced 192.0.2.0/24 and 198.51.100.0/24
+func flappedFSM(clk clock, caps packet.Capabilities) *FSM {
+	fsm := newFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+	}, clk)
+	fsm.adjRibIn = rt.New()
+	fsm.adjRibIn6 = rt.New()
+	fsm.setPeerCapabilities(caps)
+
+	fsm.processUpdate(&packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode: packet.LocalPrefAttr,
+			Value:    uint32(100),
+		},
+		NLRI: &packet.NLRI{
+			IP:     [4]byte{192, 0, 2, 0},
+			Pfxlen: 24,
+			Next: &packet.NLRI{
+				IP:     [4]byte{198, 51, 100, 0},
+				Pfxlen: 24,
+			},
+		},
+	})
+
+	fsm.changeState(Established, "Test")
+	fsm.changeState(Idle, "Test")
+
+	return fsm
+}
+
+func TestRetainStaleRoutes(t *testing.T) {
+	tests := []struct {
+		name     string
+		caps     packet.Capabilities
+		adminOff bool
+		expected bool
+	}{
+		{
+			name:     "Graceful restart capable peer",
+			caps:     gracefulRestartCaps(false),
+			expected: true,
+		},
+		{
+			name:     "Peer without graceful restart",
+			caps:     packet.Capabilities{},
+			expected: false,
+		},
+		{
+			name:     "Administratively down peer",
+			caps:     gracefulRestartCaps(false),
+			adminOff: true,
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		fsm := flappedFSM(newFakeClock(), test.caps)
+		fsm.setAdminDown(test.adminOff, "Test")
+
+		assert.Equal(t, test.expected, fsm.retainStaleRoutes(), test.name)
+		if test.expected {
+			assert.Len(t, fsm.staleRoutes, 2, test.name)
+		}
+	}
+}
+
+func TestStaleRoutesEndOfRIB(t *testing.T) {
+	fsm := flappedFSM(newFakeClock(), gracefulRestartCaps(false))
+	if !assert.True(t, fsm.retainStaleRoutes()) {
+		return
+	}
+
+	fsm.setPeerCapabilities(gracefulRestartCaps(true))
+	if !assert.True(t, fsm.resumeStaleRoutes()) {
+		return
+	}
+
+	// 192.0.2.0/24 is announced again after the restart
+	fsm.processUpdate(&packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode: packet.LocalPrefAttr,
+			Value:    uint32(200),
+		},
+		NLRI: &packet.NLRI{
+			IP:     [4]byte{192, 0, 2, 0},
+			Pfxlen: 24,
+		},
+	})
+	assert.Len(t, fsm.adjRibIn.Get(tnet.NewPfx(3325256704, 24), false), 1)
+	assert.Equal(t, uint64(2), fsm.Info().PrefixesReceived)
+
+	// The IPv6 End-of-RIB marker leaves the IPv4 routes alone
+	fsm.processUpdate(packet.EndOfRIB(packet.IPv6AFI, packet.UnicastSAFI))
+	assert.Len(t, fsm.adjRibIn.Get(tnet.NewPfx(3325256704, 24), false), 1)
+
+	fsm.processUpdate(packet.EndOfRIB(packet.IPv4AFI, packet.UnicastSAFI))
+
+	routes := fsm.adjRibIn.Get(tnet.NewPfx(3221225984, 24), false)
+	if assert.Len(t, routes, 1) && assert.Len(t, routes[0].Paths(), 1) {
+		assert.Equal(t, uint32(200), routes[0].Paths()[0].BGPPath.LocalPref)
+	}
+	assert.Len(t, fsm.adjRibIn.Get(tnet.NewPfx(3325256704, 24), false), 0)
+	assert.Equal(t, uint64(1), fsm.Info().PrefixesReceived)
+	assert.Nil(t, fsm.staleRoutes)
+}
+
+func TestStaleRoutesEndOfRIBPerAddressFamily(t *testing.T) {
+	v4 := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	v6 := tnet.NewPfx6([16]byte{0x20, 0x01, 0x0d, 0xb8}, 32)
+
+	tests := []struct {
+		name     string
+		eor      []byte
+		purged   *tnet.Prefix
+		retained *tnet.Prefix
+	}{
+		{
+			name: "Empty UPDATE",
+			eor: []byte{
+				0, 0, // Withdrawn routes length
+				0, 0, // Total path attribute length
+			},
+			purged:   v4,
+			retained: v6,
+		},
+		{
+			name: "MP_UNREACH_NLRI without NLRI",
+			eor: []byte{
+				0, 0, // Withdrawn routes length
+				0, 6, // Total path attribute length
+				128, 15, 3, // Attribute flags, type and length
+				0, 2, // AFI
+				1, // SAFI
+			},
+			purged:   v6,
+			retained: v4,
+		},
+	}
+
+	for _, test := range tests {
+		fsm := newFSM(config.Peer{
+			LocalAS:     65200,
+			PeerAS:      65201,
+			PeerAddress: net.IP{169, 254, 123, 1},
+		}, newFakeClock())
+		fsm.adjRibIn = rt.New()
+		fsm.adjRibIn6 = rt.New()
+		fsm.setPeerCapabilities(gracefulRestartCaps(false))
+
+		fsm.processUpdate(&packet.BGPUpdate{
+			PathAttributes: &packet.PathAttribute{
+				TypeCode: packet.LocalPrefAttr,
+				Value:    uint32(100),
+				Next: &packet.PathAttribute{
+					TypeCode: packet.MultiProtocolReachNLRIAttr,
+					Value: packet.MultiProtocolReachNLRI{
+						AFI:     packet.IPv6AFI,
+						SAFI:    packet.UnicastSAFI,
+						NextHop: net.ParseIP("2001:db8::1"),
+						NLRI: &packet.NLRI{
+							IP:     [16]byte{0x20, 0x01, 0x0d, 0xb8},
+							Pfxlen: 32,
+						},
+					},
+				},
+			},
+			NLRI: &packet.NLRI{
+				IP:     [4]byte{192, 0, 2, 0},
+				Pfxlen: 24,
+			},
+		})
+
+		fsm.changeState(Established, "Test")
+		fsm.changeState(Idle, "Test")
+		if !assert.True(t, fsm.retainStaleRoutes(), test.name) {
+			continue
+		}
+
+		fsm.setPeerCapabilities(gracefulRestartCaps(true))
+		if !assert.True(t, fsm.resumeStaleRoutes(), test.name) {
+			continue
+		}
+
+		hdr := []byte{
+			0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+			0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+			0, byte(packet.HeaderLen + len(test.eor)),
+			packet.UpdateMsg,
+		}
+		msg, err := packet.Decode(bytes.NewBuffer(append(hdr, test.eor...)), &packet.DecodeOptions{})
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+		fsm.processUpdate(msg.Body.(*packet.BGPUpdate))
+
+		assert.Len(t, fsm.adjRibInFor(test.purged.AFI(), packet.UnicastSAFI).Get(test.purged, false), 0, test.name)
+		assert.Len(t, fsm.adjRibInFor(test.retained.AFI(), packet.UnicastSAFI).Get(test.retained, false), 1, test.name)
+		assert.NotNil(t, fsm.staleRoutes, test.name)
+	}
+}
+
+func TestStaleRoutesRestartTimerExpiry(t *testing.T) {
+	clk := newFakeClock()
+	fsm := flappedFSM(clk, gracefulRestartCaps(false))
+	if !assert.True(t, fsm.retainStaleRoutes()) {
+		return
+	}
+
+	clk.Advance(119 * time.Second)
+	select {
+	case <-fsm.restartTimer.C():
+		t.Fatalf("Restart timer expired early")
+	default:
+	}
+
+	clk.Advance(time.Second)
+	<-fsm.restartTimer.C()
+	fsm.purgeStaleRoutes()
+
+	assert.Len(t, fsm.adjRibIn.Get(tnet.NewPfx(3221225984, 24), false), 0)
+	assert.Len(t, fsm.adjRibIn.Get(tnet.NewPfx(3325256704, 24), false), 0)
+	assert.Equal(t, uint64(0), fsm.Info().PrefixesReceived)
+}
+
+func TestResumeStaleRoutesWithoutRestartState(t *testing.T) {
+	fsm := flappedFSM(newFakeClock(), gracefulRestartCaps(false))
+	if !assert.True(t, fsm.retainStaleRoutes()) {
+		return
+	}
+
+	assert.False(t, fsm.resumeStaleRoutes())
+}
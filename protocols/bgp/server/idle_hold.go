@@ -0,0 +1,70 @@
+package server
+
+import (
+	"time"
+
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxIdleHoldTimeFactor limits the idle hold time to a multiple of the
+// configured idle hold time
+const maxIdleHoldTimeFactor = 64
+
+// notificationReceived records a NOTIFICATION which closed the session. If an
+// idle hold time is configured the session is restarted once it expired. The
+// idle hold time is doubled if the session was not established for longer
+// than the current idle hold time, so a peer rejecting the session over and
+// over again is not hammered with connection attempts.
+func (fsm *FSM) notificationReceived(n *packet.BGPNotification) {
+	log.WithFields(log.Fields{
+		"peer":    fsm.remote.String(),
+		"code":    n.ErrorCode,
+		"subcode": n.ErrorSubcode,
+		"data":    n.Data,
+	}).Warning("Received NOTIFICATION")
+
+	fsm.down = SessionDown{Notification: n}
+
+	fsm.mu.Lock()
+	fsm.lastNotification = n
+	stable := fsm.state == Established && fsm.clock.Now().Sub(fsm.establishedTime) > fsm.idleHoldTime
+	fsm.mu.Unlock()
+
+	if fsm.idleHoldTimeConfigured == 0 {
+		return
+	}
+
+	switch {
+	case stable || fsm.idleHoldTime == 0:
+		fsm.idleHoldTime = fsm.idleHoldTimeConfigured
+	case fsm.idleHoldTime < maxIdleHoldTimeFactor*fsm.idleHoldTimeConfigured:
+		fsm.idleHoldTime *= 2
+	}
+	fsm.idleHoldPending = true
+}
+
+// startIdleHoldTimer starts the idle hold timer if the session was closed by a
+// NOTIFICATION
+func (fsm *FSM) startIdleHoldTimer() {
+	if !fsm.idleHoldPending {
+		return
+	}
+
+	fsm.idleHoldPending = false
+	fsm.idleHoldTimer.Reset(fsm.idleHoldTime)
+
+	log.WithFields(log.Fields{
+		"peer":      fsm.remote.String(),
+		"idle_hold": fsm.idleHoldTime / time.Second,
+	}).Info("Restarting session after idle hold time")
+}
+
+// LastNotification returns the last NOTIFICATION received from the peer. It is
+// nil if none was received.
+func (fsm *FSM) LastNotification() *packet.BGPNotification {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+
+	return fsm.lastNotification
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstablishedReceivesCease(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:      65200,
+		PeerAS:       65201,
+		PeerAddress:  net.IP{169, 254, 123, 1},
+		IdleHoldTime: 10,
+	}, newFakeClock())
+	fsm.adjRibIn = rt.New()
+	fsm.adjRibIn6 = rt.New()
+	fsm.changeState(Established, "Test")
+
+	// Drain the timers which fire on creation
+	stopTimer(fsm.holdTimer)
+	stopTimer(fsm.keepaliveTimer)
+
+	local, remote := tcpPair(t)
+	defer remote.Close()
+	fsm.con = local
+
+	notification := []byte{
+		255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255,
+		0, 33, // Length
+		packet.NotificationMsg,
+		packet.Cease, packet.AdminShut,
+		11, 'm', 'a', 'i', 'n', 't', 'e', 'n', 'a', 'n', 'c', 'e', // Shutdown communication
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- fsm.established()
+	}()
+
+	fsm.msgRecvCh <- msgRecvMsg{msg: notification, con: local}
+	assert.Equal(t, Idle, <-done)
+
+	expected := &packet.BGPNotification{
+		ErrorCode:    packet.Cease,
+		ErrorSubcode: packet.AdminShut,
+		Data:         []byte{11, 'm', 'a', 'i', 'n', 't', 'e', 'n', 'a', 'n', 'c', 'e'},
+	}
+	assert.Equal(t, expected, fsm.LastNotification())
+	assert.Equal(t, expected, fsm.Info().LastNotification)
+	assert.Equal(t, "Received NOTIFICATION", fsm.Info().LastError)
+	assert.True(t, fsm.idleHoldPending)
+	assert.Equal(t, 10*time.Second, fsm.idleHoldTime)
+}
+
+func TestIdleHoldTimerRestartsSession(t *testing.T) {
+	clk := newFakeClock()
+	fsm := newFSM(config.Peer{
+		LocalAS:      65200,
+		PeerAS:       65201,
+		PeerAddress:  net.IP{169, 254, 123, 1},
+		Passive:      true,
+		IdleHoldTime: 10,
+	}, clk)
+
+	fsm.notificationReceived(&packet.BGPNotification{
+		ErrorCode:    packet.Cease,
+		ErrorSubcode: packet.AdminShut,
+	})
+
+	done := make(chan int)
+	go func() {
+		done <- fsm.idle()
+	}()
+
+	idleHoldTimer := fsm.idleHoldTimer.(*fakeTimer)
+	for i := 0; ; i++ {
+		clk.mu.Lock()
+		active := idleHoldTimer.active
+		clk.mu.Unlock()
+
+		if active {
+			break
+		}
+		if i == 1000 {
+			t.Fatalf("Idle hold timer was not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	clk.Advance(9 * time.Second)
+	select {
+	case <-done:
+		t.Fatalf("Session was restarted before the idle hold time expired")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clk.Advance(time.Second)
+	assert.Equal(t, Active, <-done)
+}
+
+func TestIdleHoldTimeDampening(t *testing.T) {
+	tests := []struct {
+		name        string
+		configured  uint16
+		current     time.Duration
+		established time.Duration
+		expected    time.Duration
+	}{
+		{
+			name:     "Disabled",
+			expected: 0,
+		},
+		{
+			name:       "First NOTIFICATION",
+			configured: 10,
+			expected:   10 * time.Second,
+		},
+		{
+			name:       "Repeated NOTIFICATION",
+			configured: 10,
+			current:    10 * time.Second,
+			expected:   20 * time.Second,
+		},
+		{
+			name:        "Repeated NOTIFICATION shortly after establishment",
+			configured:  10,
+			current:     20 * time.Second,
+			established: 15 * time.Second,
+			expected:    40 * time.Second,
+		},
+		{
+			name:        "Session was stable",
+			configured:  10,
+			current:     40 * time.Second,
+			established: time.Minute,
+			expected:    10 * time.Second,
+		},
+		{
+			name:       "Maximum",
+			configured: 10,
+			current:    640 * time.Second,
+			expected:   640 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		clk := newFakeClock()
+		fsm := newFSM(config.Peer{
+			LocalAS:      65200,
+			PeerAS:       65201,
+			PeerAddress:  net.IP{169, 254, 123, 1},
+			IdleHoldTime: test.configured,
+		}, clk)
+		fsm.idleHoldTime = test.current
+		if test.established != 0 {
+			fsm.changeState(Established, "Test")
+			clk.Advance(test.established)
+		}
+
+		fsm.notificationReceived(&packet.BGPNotification{ErrorCode: packet.Cease})
+		assert.Equal(t, test.expected, fsm.idleHoldTime, test.name)
+		assert.Equal(t, test.configured != 0, fsm.idleHoldPending, test.name)
+	}
+}
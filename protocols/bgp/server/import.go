@@ -0,0 +1,207 @@
+package server
+
+import (
+	"fmt"
+
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	log "github.com/sirupsen/logrus"
+)
+
+// SoftReconfigMode is how routes already received from a peer are
+// re-evaluated after its import policy changed
+type SoftReconfigMode int
+
+const (
+	// SoftReconfigStored applies the policy to the routes stored as received
+	SoftReconfigStored SoftReconfigMode = iota
+
+	// SoftReconfigRouteRefresh asks the peer to advertise its routes again
+	SoftReconfigRouteRefresh
+
+	// SoftReconfigNone only applies the policy to routes received later as
+	// the peer does not support route refresh
+	SoftReconfigNone
+)
+
+var softReconfigModeNames = map[SoftReconfigMode]string{
+	SoftReconfigStored:       "stored",
+	SoftReconfigRouteRefresh: "route-refresh",
+	SoftReconfigNone:         "none",
+}
+
+func (m SoftReconfigMode) String() string {
+	return softReconfigModeNames[m]
+}
+
+// softReconfigMode returns the soft reconfiguration mode of a peer that
+// announced caps
+func softReconfigMode(stored bool, caps packet.Capabilities) SoftReconfigMode {
+	if stored {
+		return SoftReconfigStored
+	}
+
+	if caps.Has(packet.RouteRefreshCapabilityCode) {
+		return SoftReconfigRouteRefresh
+	}
+
+	return SoftReconfigNone
+}
+
+// prePolicyKey identifies a path received from the peer
+type prePolicyKey struct {
+	pfx    string
+	pathID uint32
+}
+
+// prePolicyPath is a path as received from the peer
+type prePolicyPath struct {
+	pfx  *tnet.Prefix
+	path *rt.BGPPath
+}
+
+// storePrePolicy keeps the path b received for pfx if soft reconfiguration is
+// enabled
+func (fsm *FSM) storePrePolicy(pfx *tnet.Prefix, b *rt.BGPPath) {
+	if !fsm.softReconfigInbound {
+		return
+	}
+
+	if fsm.prePolicy == nil {
+		fsm.prePolicy = make(map[prePolicyKey]prePolicyPath)
+	}
+
+	fsm.prePolicy[prePolicyKey{pfx: pfx.String(), pathID: b.PathIdentifier}] = prePolicyPath{
+		pfx:  pfx,
+		path: b,
+	}
+}
+
+// forgetPrePolicy drops the path with identifier pathID withdrawn for pfx
+func (fsm *FSM) forgetPrePolicy(pfx *tnet.Prefix, pathID uint32) {
+	delete(fsm.prePolicy, prePolicyKey{pfx: pfx.String(), pathID: pathID})
+}
+
+// softReconfigure runs all paths stored as received through the import
+// policy again
+func (fsm *FSM) softReconfigure() {
+	for _, p := range fsm.prePolicy {
+		rib := fsm.adjRibInFor(p.pfx.AFI(), packet.UnicastSAFI)
+		if rib == nil {
+			continue
+		}
+
+		fsm.install(rib, p.pfx, p.path)
+	}
+}
+
+// importPath applies the import policy to a path p received for pfx. accept
+// is false if the policy rejected p.
+func (fsm *FSM) importPath(pfx *tnet.Prefix, p *rt.Path) (res *rt.Path, accept bool) {
+	fsm.mu.RLock()
+	policy := fsm.importPolicy
+	fsm.mu.RUnlock()
+
+	if policy == nil {
+		return p, true
+	}
+
+	return policy.Process(pfx, p)
+}
+
+// setImportPolicy replaces the import policy. With soft reconfiguration the
+// FSM applies it to the routes stored as received. Otherwise routes are only
+// stored after the policy was applied, so the peer is asked to advertise its
+// routes again with a ROUTE-REFRESH (RFC 2918) if it supports route refresh.
+// If it does not the policy only applies to routes received from now on.
+func (fsm *FSM) setImportPolicy(p rt.Policy) {
+	fsm.mu.Lock()
+	fsm.importPolicy = p
+	fsm.mu.Unlock()
+
+	if fsm.getState() != Established {
+		return
+	}
+
+	switch softReconfigMode(fsm.softReconfigInbound, fsm.PeerCapabilities()) {
+	case SoftReconfigStored:
+		select {
+		case fsm.softReconfigCh <- struct{}{}:
+		default:
+		}
+		return
+	case SoftReconfigNone:
+		log.WithFields(log.Fields{
+			"peer": fsm.remote.String(),
+		}).Warning("Peer does not support route refresh, import policy only applies to routes received from now on")
+		return
+	}
+
+	for _, af := range fsm.refreshAddressFamilies() {
+		err := fsm.sendRouteRefresh(af)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"peer":  fsm.remote.String(),
+				"afi":   af.AFI,
+				"safi":  af.SAFI,
+				"error": err,
+			}).Warning("Unable to send ROUTE-REFRESH")
+		}
+	}
+}
+
+// refreshAddressFamilies returns the unicast address families the peer
+// announced with the multiprotocol capability, IPv4 unicast if it announced
+// none
+func (fsm *FSM) refreshAddressFamilies() []packet.AddressFamily {
+	var res []packet.AddressFamily
+	for _, c := range fsm.PeerCapabilities() {
+		mpCap, ok := c.Value.(packet.MultiProtocolCapability)
+		if c.Code != packet.MultiProtocolCapabilityCode || !ok {
+			continue
+		}
+
+		if mpCap.SAFI == packet.UnicastSAFI && (mpCap.AFI == packet.IPv4AFI || mpCap.AFI == packet.IPv6AFI) {
+			res = append(res, packet.AddressFamily{AFI: mpCap.AFI, SAFI: mpCap.SAFI})
+		}
+	}
+
+	if len(res) == 0 {
+		res = append(res, packet.AddressFamily{AFI: packet.IPv4AFI, SAFI: packet.UnicastSAFI})
+	}
+
+	return res
+}
+
+// sendRouteRefresh asks the peer to advertise its routes of af again
+func (fsm *FSM) sendRouteRefresh(af packet.AddressFamily) error {
+	fsm.mu.RLock()
+	c := fsm.con
+	fsm.mu.RUnlock()
+	if c == nil {
+		return fmt.Errorf("No connection")
+	}
+
+	_, err := c.Write(packet.SerializeRouteRefreshMsg(&packet.BGPRouteRefresh{
+		AFI:  af.AFI,
+		SAFI: af.SAFI,
+	}))
+	return err
+}
+
+// routeRefreshReceived advertises the Adj-RIB-Out of the address family
+// requested by the peer again (RFC 2918, 4). Only IPv4 unicast routes are
+// advertised, a request for any other family is ignored.
+func (fsm *FSM) routeRefreshReceived(rr *packet.BGPRouteRefresh) {
+	if rr.AFI != packet.IPv4AFI || rr.SAFI != packet.UnicastSAFI {
+		log.WithFields(log.Fields{
+			"peer": fsm.remote.String(),
+			"afi":  rr.AFI,
+			"safi": rr.SAFI,
+		}).Info("Ignoring ROUTE-REFRESH for unsupported address family")
+		return
+	}
+
+	fsm.adjRIBOut.refresh()
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/policy"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportPolicy(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:      65200,
+		PeerAS:       65201,
+		LocalAddress: net.IP{169, 254, 0, 1},
+		PeerAddress:  net.IP{169, 254, 0, 2},
+		ImportPolicy: &policy.Filter{
+			Terms: []*policy.Term{
+				{
+					Conditions: []policy.Condition{policy.HasCommunity(4259840100)}, // 65000:100
+					Verdict:    policy.Reject,
+				},
+			},
+			Default: policy.Accept,
+		},
+	}, newFakeClock())
+	fsm.adjRibIn = rt.New()
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	fsm.announce(fsm.adjRibIn, pfx, &rt.BGPPath{NextHop: 2851995650})
+	assert.Len(t, fsm.adjRibIn.Get(pfx, false), 1, "Accepted route was not stored")
+
+	fsm.announce(fsm.adjRibIn, pfx, &rt.BGPPath{NextHop: 2851995650, Communities: []uint32{4259840100}})
+	assert.Empty(t, fsm.adjRibIn.Get(pfx, false), "Rejected route did not replace the accepted one")
+	assert.Equal(t, uint64(0), fsm.prefixesRcvd)
+}
+
+func TestSetImportPolicyRouteRefresh(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities packet.Capabilities
+		mode         SoftReconfigMode
+		expected     []packet.BGPRouteRefresh
+	}{
+		{
+			name: "Route refresh supported",
+			capabilities: packet.Capabilities{
+				{Code: packet.RouteRefreshCapabilityCode},
+			},
+			mode: SoftReconfigRouteRefresh,
+			expected: []packet.BGPRouteRefresh{
+				{AFI: packet.IPv4AFI, SAFI: packet.UnicastSAFI},
+			},
+		},
+		{
+			name: "Route refresh supported for IPv4 and IPv6",
+			capabilities: packet.Capabilities{
+				{Code: packet.MultiProtocolCapabilityCode, Value: packet.MultiProtocolCapability{AFI: packet.IPv4AFI, SAFI: packet.UnicastSAFI}},
+				{Code: packet.MultiProtocolCapabilityCode, Value: packet.MultiProtocolCapability{AFI: packet.IPv6AFI, SAFI: packet.UnicastSAFI}},
+				{Code: packet.RouteRefreshCapabilityCode},
+			},
+			mode: SoftReconfigRouteRefresh,
+			expected: []packet.BGPRouteRefresh{
+				{AFI: packet.IPv4AFI, SAFI: packet.UnicastSAFI},
+				{AFI: packet.IPv6AFI, SAFI: packet.UnicastSAFI},
+			},
+		},
+		{
+			name: "Route refresh not supported",
+			mode: SoftReconfigNone,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fsm, _, _ := adjRIBOutFSM(t, 65201)
+			c, s := tcpPair(t)
+			defer c.Close()
+			defer s.Close()
+			fsm.con = c
+			fsm.setPeerCapabilities(test.capabilities)
+			assert.Equal(t, test.mode, fsm.Info().SoftReconfig)
+
+			fsm.setImportPolicy(&policy.Filter{Default: policy.Reject})
+
+			s.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			msgs := bytes.NewBuffer(nil)
+			msgs.ReadFrom(s)
+
+			var received []packet.BGPRouteRefresh
+			for msgs.Len() > 0 {
+				msg, err := packet.Decode(msgs, &packet.DecodeOptions{})
+				if !assert.NoError(t, err) {
+					return
+				}
+				received = append(received, *msg.Body.(*packet.BGPRouteRefresh))
+			}
+			assert.Equal(t, test.expected, received)
+		})
+	}
+}
+
+func TestSoftReconfigInbound(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:             65200,
+		PeerAS:              65201,
+		LocalAddress:        net.IP{169, 254, 0, 1},
+		PeerAddress:         net.IP{169, 254, 0, 2},
+		SoftReconfigInbound: true,
+	}, newFakeClock())
+	stopTimer(fsm.holdTimer)
+	stopTimer(fsm.keepaliveTimer)
+	fsm.changeState(Established, "Test")
+	fsm.setPeerCapabilities(packet.Capabilities{
+		{Code: packet.RouteRefreshCapabilityCode},
+	})
+	fsm.adjRibIn = rt.New()
+	assert.Equal(t, SoftReconfigStored, fsm.Info().SoftReconfig)
+
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	fsm.announce(fsm.adjRibIn, pfx, &rt.BGPPath{NextHop: 2851995650, Communities: []uint32{4259840100}})
+	assert.Len(t, fsm.adjRibIn.Get(pfx, false), 1)
+
+	// No connection is needed to apply the policy to the stored routes
+	fsm.setImportPolicy(&policy.Filter{
+		Terms: []*policy.Term{
+			{
+				Conditions: []policy.Condition{policy.HasCommunity(4259840100)}, // 65000:100
+				Verdict:    policy.Reject,
+			},
+		},
+		Default: policy.Accept,
+	})
+	<-fsm.softReconfigCh
+	fsm.softReconfigure()
+	assert.Empty(t, fsm.adjRibIn.Get(pfx, false), "Rejected route was not removed")
+
+	fsm.setImportPolicy(nil)
+	<-fsm.softReconfigCh
+	fsm.softReconfigure()
+	assert.Len(t, fsm.adjRibIn.Get(pfx, false), 1, "Accepted route was not restored")
+
+	fsm.withdraw(fsm.adjRibIn, pfx, 0)
+	assert.Empty(t, fsm.prePolicy)
+}
+
+func TestSoftReconfigMode(t *testing.T) {
+	refresh := packet.Capabilities{
+		{Code: packet.RouteRefreshCapabilityCode},
+	}
+
+	assert.Equal(t, SoftReconfigStored, softReconfigMode(true, refresh))
+	assert.Equal(t, SoftReconfigRouteRefresh, softReconfigMode(false, refresh))
+	assert.Equal(t, SoftReconfigNone, softReconfigMode(false, nil))
+}
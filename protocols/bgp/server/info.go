@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+)
+
+// NeighborInfo summarizes the state of a BGP session
+type NeighborInfo struct {
+	PeerAddress        net.IP
+	LocalAddress       net.IP
+	PeerPort           uint16
+	LocalPort          uint16
+	PeerASN            uint32
+	LocalASN           uint32
+	State              string
+	Uptime             time.Duration
+	Capabilities       packet.Capabilities
+	HoldTime           time.Duration
+	KeepaliveTime      time.Duration
+	RouterID           uint32
+	NeighborID         uint32
+	PrefixesReceived   uint64
+	PrefixesAccepted   uint64
+	PrefixesAdvertised uint64
+	LastError          string
+	LastNotification   *packet.BGPNotification
+	SentOpen           *packet.BGPOpen
+	ReceivedOpen       *packet.BGPOpen
+	AdminDown          bool
+	AdminDownReason    string
+	UpdateRateLimit    float64
+	UpdateRateBurst    uint
+	SoftReconfig       SoftReconfigMode
+}
+
+// Info returns a summary of the session. It is safe to call in any state.
+func (fsm *FSM) Info() NeighborInfo {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+
+	info := NeighborInfo{
+		PeerAddress:        fsm.remote,
+		LocalAddress:       fsm.local,
+		PeerASN:            uint32(fsm.remoteASN),
+		LocalASN:           uint32(fsm.localASN),
+		State:              stateNames[fsm.state],
+		Capabilities:       fsm.peerCapabilities,
+		HoldTime:           fsm.holdTime * time.Second,
+		KeepaliveTime:      fsm.keepaliveTime * time.Second,
+		RouterID:           fsm.routerID,
+		NeighborID:         fsm.neighborID,
+		PrefixesReceived:   fsm.prefixesRcvd,
+		PrefixesAccepted:   fsm.prefixesRcvd,
+		PrefixesAdvertised: fsm.prefixesAdvert,
+		LastError:          fsm.lastError,
+		LastNotification:   fsm.lastNotification,
+		SentOpen:           fsm.sentOpen,
+		ReceivedOpen:       fsm.receivedOpen,
+		AdminDown:          fsm.adminDown,
+		AdminDownReason:    fsm.adminDownReason,
+		SoftReconfig:       softReconfigMode(fsm.softReconfigInbound, fsm.peerCapabilities),
+	}
+
+	if fsm.con != nil {
+		if addr, ok := fsm.con.LocalAddr().(*net.TCPAddr); ok {
+			info.LocalPort = uint16(addr.Port)
+		}
+		if addr, ok := fsm.con.RemoteAddr().(*net.TCPAddr); ok {
+			info.PeerPort = uint16(addr.Port)
+		}
+	}
+
+	if fsm.updateLimiter != nil {
+		info.UpdateRateLimit = fsm.updateLimiter.rate
+		info.UpdateRateBurst = uint(fsm.updateLimiter.burst)
+	}
+
+	if fsm.state == Established {
+		info.Uptime = fsm.clock.Now().Sub(fsm.establishedTime)
+	}
+
+	return info
+}
+
+// Info returns a summary of the session to the peer
+func (p *Peer) Info() NeighborInfo {
+	return p.fsm.Info()
+}
+
+// Neighbors returns a summary of all sessions ordered by peer address
+func (b *BGPServer) Neighbors() []NeighborInfo {
+	b.peersMu.RLock()
+	peers := make([]*Peer, 0, len(b.peers))
+	for _, p := range b.peers {
+		peers = append(peers, p)
+	}
+	b.peersMu.RUnlock()
+
+	res := make([]NeighborInfo, 0, len(peers))
+	for _, p := range peers {
+		res = append(res, p.Info())
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].PeerAddress.To16(), res[j].PeerAddress.To16()) < 0
+	})
+
+	return res
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFSMInfo(t *testing.T) {
+	fsm := NewFSM(config.Peer{
+		LocalAS:      65200,
+		PeerAS:       65201,
+		PeerAddress:  net.IP{169, 254, 123, 1},
+		LocalAddress: net.IP{169, 254, 123, 0},
+		HoldTimer:    90,
+		KeepAlive:    30,
+		RouterID:     100,
+	})
+
+	info := fsm.Info()
+	assert.Equal(t, "Idle", info.State)
+	assert.Equal(t, time.Duration(0), info.Uptime)
+
+	caps := packet.Capabilities{
+		{
+			Code: packet.RouteRefreshCapabilityCode,
+		},
+	}
+
+	fsm.setNeighborID(200)
+	fsm.setPeerCapabilities(caps)
+	fsm.setHoldTime(60)
+	fsm.updatePrefixesRcvd(2)
+	fsm.changeState(Established, "Received KEEPALIVE")
+	fsm.establishedTime = fsm.establishedTime.Add(-time.Minute)
+
+	info = fsm.Info()
+	assert.Equal(t, "Established", info.State)
+	assert.True(t, info.Uptime >= time.Minute)
+	assert.Equal(t, 60*time.Second, info.HoldTime)
+	assert.Equal(t, 20*time.Second, info.KeepaliveTime)
+	assert.Equal(t, uint32(65200), info.LocalASN)
+	assert.Equal(t, uint32(65201), info.PeerASN)
+	assert.Equal(t, uint32(100), info.RouterID)
+	assert.Equal(t, uint32(200), info.NeighborID)
+	assert.Equal(t, caps, info.Capabilities)
+	assert.Equal(t, uint64(2), info.PrefixesReceived)
+
+	fsm.changeState(Idle, "Holdtimer expired")
+	info = fsm.Info()
+	assert.Equal(t, "Idle", info.State)
+	assert.Equal(t, time.Duration(0), info.Uptime)
+	assert.Equal(t, "Holdtimer expired", info.LastError)
+}
+
+func TestBGPServerNeighbors(t *testing.T) {
+	b := NewBgpServer()
+	for _, addr := range []net.IP{{198, 51, 100, 2}, {192, 0, 2, 1}, {198, 51, 100, 1}} {
+		p, err := NewPeer(config.Peer{
+			LocalAS:     65200,
+			PeerAS:      65201,
+			PeerAddress: addr,
+		})
+		if err != nil {
+			t.Fatalf("Unable to create peer: %v", err)
+		}
+		b.peers[addr.String()] = p
+	}
+
+	res := make([]net.IP, 0)
+	for _, info := range b.Neighbors() {
+		res = append(res, info.PeerAddress)
+	}
+
+	assert.Equal(t, []net.IP{{192, 0, 2, 1}, {198, 51, 100, 1}, {198, 51, 100, 2}}, res)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// msgRateLimiter paces the messages received from a peer using a token bucket.
+// Messages exceeding the limit are delayed, never dropped, as dropping BGP
+// messages would corrupt the state of the session. It is safe for concurrent
+// use, e.g. by the receivers of both connections during a collision.
+type msgRateLimiter struct {
+	rate  float64
+	burst float64
+	clock clock
+
+	mu     sync.Mutex
+	bucket tokenBucket
+}
+
+// newMsgRateLimiter creates a limiter allowing rate messages per second with
+// bursts of up to burst messages
+func newMsgRateLimiter(rate float64, burst uint, c clock) *msgRateLimiter {
+	if burst == 0 {
+		burst = 1
+	}
+
+	return &msgRateLimiter{
+		rate:  rate,
+		burst: float64(burst),
+		bucket: tokenBucket{
+			tokens: float64(burst),
+			last:   c.Now(),
+		},
+		clock: c,
+	}
+}
+
+// wait blocks until the next message is within the limit. It returns false if
+// stop was closed while waiting.
+func (l *msgRateLimiter) wait(stop <-chan struct{}) bool {
+	for {
+		d := l.take()
+		if d == 0 {
+			return true
+		}
+
+		t := l.clock.NewTimer(d)
+		select {
+		case <-t.C():
+		case <-stop:
+			t.Stop()
+			return false
+		}
+	}
+}
+
+// take takes a token from the bucket. It returns the time until the next
+// token is available if there is none.
+func (l *msgRateLimiter) take() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bucket.refill(l.clock.Now(), l.rate, l.burst)
+	if l.bucket.tokens >= 1 {
+		l.bucket.tokens--
+		return 0
+	}
+
+	return time.Duration(math.Ceil((1 - l.bucket.tokens) / l.rate * float64(time.Second)))
+}
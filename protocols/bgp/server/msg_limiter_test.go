@@ -0,0 +1,183 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForTimer waits until a goroutine is blocked on a timer of clk
+func waitForTimer(t *testing.T, clk *fakeClock) {
+	for i := 0; i < 1000; i++ {
+		clk.mu.Lock()
+		active := 0
+		for _, tmr := range clk.timers {
+			if tmr.active {
+				active++
+			}
+		}
+		clk.mu.Unlock()
+
+		if active > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("No timer was started")
+}
+
+func TestMsgRateLimiterBurst(t *testing.T) {
+	clk := newFakeClock()
+	l := newMsgRateLimiter(10, 5, clk)
+	stop := make(chan struct{})
+
+	for i := 0; i < 5; i++ {
+		assert.True(t, l.wait(stop), "Message %d", i)
+	}
+
+	clk.mu.Lock()
+	assert.Empty(t, clk.timers, "Burst was delayed")
+	clk.mu.Unlock()
+}
+
+func TestMsgRateLimiterPacing(t *testing.T) {
+	clk := newFakeClock()
+	l := newMsgRateLimiter(10, 2, clk)
+	stop := make(chan struct{})
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 6; i++ {
+			done <- l.wait(stop)
+		}
+	}()
+
+	assert.True(t, <-done)
+	assert.True(t, <-done)
+
+	for i := 0; i < 4; i++ {
+		waitForTimer(t, clk)
+		clk.Advance(99 * time.Millisecond)
+		select {
+		case <-done:
+			t.Fatalf("Message %d was not paced", i)
+		default:
+		}
+
+		clk.Advance(time.Millisecond)
+		assert.True(t, <-done, "Message %d", i)
+	}
+}
+
+func TestMsgRateLimiterStop(t *testing.T) {
+	clk := newFakeClock()
+	l := newMsgRateLimiter(10, 1, clk)
+	stop := make(chan struct{})
+
+	assert.True(t, l.wait(stop))
+
+	done := make(chan bool)
+	go func() {
+		done <- l.wait(stop)
+	}()
+
+	waitForTimer(t, clk)
+	close(stop)
+	assert.False(t, <-done)
+}
+
+func TestMsgReceiverRateLimit(t *testing.T) {
+	clk := newFakeClock()
+	fsm := newFSM(config.Peer{
+		LocalAS:         65200,
+		PeerAS:          65201,
+		PeerAddress:     net.IP{169, 254, 123, 1},
+		UpdateRateLimit: 10,
+		UpdateRateBurst: 2,
+	}, clk)
+	stopTimer(fsm.holdTimer)
+	stopTimer(fsm.keepaliveTimer)
+
+	local, remote := tcpPair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	marker := []byte{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255}
+	update := append(append([]byte{}, marker...), 0, 23, packet.UpdateMsg, 0, 0, 0, 0)
+	msgs := map[uint8][]byte{
+		packet.UpdateMsg:       update,
+		packet.KeepaliveMsg:    append(append([]byte{}, marker...), 0, 19, packet.KeepaliveMsg),
+		packet.RouteRefreshMsg: append(append([]byte{}, marker...), 0, 23, packet.RouteRefreshMsg, 0, 1, 0, 1),
+	}
+
+	stream := []byte{}
+	types := []uint8{packet.UpdateMsg, packet.UpdateMsg, packet.KeepaliveMsg, packet.RouteRefreshMsg, packet.UpdateMsg, packet.UpdateMsg, packet.KeepaliveMsg}
+	for _, typ := range types {
+		stream = append(stream, msgs[typ]...)
+	}
+
+	_, err := remote.Write(stream)
+	if err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+
+	go fsm.msgReceiver(local)
+
+	recv := func() uint8 {
+		select {
+		case m := <-fsm.msgRecvCh:
+			return m.msg[18]
+		case <-time.After(time.Second):
+			t.Fatalf("No message received")
+		}
+		return 0
+	}
+
+	// The burst and the messages other than UPDATEs following it pass
+	// immediately
+	for _, typ := range types[:4] {
+		assert.Equal(t, typ, recv())
+	}
+
+	// Further updates are paced
+	for _, typ := range types[4:6] {
+		waitForTimer(t, clk)
+		select {
+		case <-fsm.msgRecvCh:
+			t.Fatalf("Update was not paced")
+		default:
+		}
+
+		clk.Advance(100 * time.Millisecond)
+		assert.Equal(t, typ, recv())
+	}
+
+	assert.Equal(t, types[6], recv())
+}
+
+func TestMsgRateLimiterConcurrent(t *testing.T) {
+	clk := newFakeClock()
+	l := newMsgRateLimiter(10, 100, clk)
+	stop := make(chan struct{})
+
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() {
+			for j := 0; j < 50; j++ {
+				l.wait(stop)
+			}
+			done <- true
+		}()
+	}
+	<-done
+	<-done
+
+	clk.mu.Lock()
+	assert.Empty(t, clk.timers, "Burst was delayed")
+	clk.mu.Unlock()
+}
@@ -1,9 +1,13 @@
 package server
 
 import (
+	"context"
 	"net"
 
 	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
 )
 
 type Peer struct {
@@ -30,7 +34,62 @@ func (p *Peer) GetASN() uint32 {
 	return p.asn
 }
 
+// PeerCapabilities returns the capabilities the peer advertised
+func (p *Peer) PeerCapabilities() packet.Capabilities {
+	return p.fsm.PeerCapabilities()
+}
+
+// AdjRIBOut returns the Adj-RIB-Out of the peer. It has to be attached to the
+// Loc-RIB whose routes are advertised to the peer.
+func (p *Peer) AdjRIBOut() *AdjRIBOut {
+	return p.fsm.adjRIBOut
+}
+
+// SetImportPolicy replaces the policy applied to routes received from the
+// peer. The peer is asked to advertise its routes again if it supports route
+// refresh.
+func (p *Peer) SetImportPolicy(policy rt.Policy) {
+	p.fsm.setImportPolicy(policy)
+}
+
+// Subscribe returns a channel receiving all state changes of the session
+func (p *Peer) Subscribe() <-chan StateChange {
+	return p.fsm.Subscribe()
+}
+
+// OnUpdate registers f to be called with the raw message of every UPDATE
+// received from the peer
+func (p *Peer) OnUpdate(f func(msg []byte)) {
+	p.fsm.OnUpdate(f)
+}
+
+// RegisterAdjRIBIn adds a client notified of all changes of the Adj-RIB-In of
+// the peer
+func (p *Peer) RegisterAdjRIBIn(c AdjRIBInClient) {
+	p.fsm.RegisterAdjRIBIn(c)
+}
+
+// DampeningInfo returns the dampening state of the path with identifier pathID
+// received from the peer for pfx. ok is false if dampening is disabled or the
+// path has no penalty.
+func (p *Peer) DampeningInfo(pfx *tnet.Prefix, pathID uint32) (info DampeningInfo, ok bool) {
+	return p.fsm.dampeningInfo(pfx, pathID)
+}
+
 func (p *Peer) Start() {
 	p.fsm.start()
 	p.fsm.activate()
 }
+
+// AdminDown shuts the session down sending a Cease/Administrative Shutdown
+// NOTIFICATION. The peer stays Idle, keeping its configuration, until AdminUp.
+func (p *Peer) AdminDown(reason string) error {
+	p.fsm.setAdminDown(true, reason)
+	return p.fsm.shutdown(context.Background())
+}
+
+// AdminUp clears the administrative down state and starts the session again
+func (p *Peer) AdminUp() {
+	p.fsm.setAdminDown(false, "")
+	p.fsm.activate()
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/bio-routing/bio-rd/config"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminDownUp(t *testing.T) {
+	p, err := NewPeer(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+	})
+	if err != nil {
+		t.Fatalf("Unable to create peer: %v", err)
+	}
+
+	local, remote := tcpPair(t)
+	defer remote.Close()
+
+	stopTimer(p.fsm.holdTimer)
+	stopTimer(p.fsm.keepaliveTimer)
+	p.fsm.con = local
+	p.fsm.changeState(Established, "Test")
+
+	done := make(chan int, 1)
+	go func() {
+		done <- p.fsm.established()
+	}()
+
+	err = p.AdminDown("Maintenance")
+	assert.NoError(t, err)
+	assert.Equal(t, Idle, <-done)
+
+	buf := make([]byte, packet.MinLen+2)
+	_, err = io.ReadFull(remote, buf)
+	if err != nil {
+		t.Fatalf("Unable to read NOTIFICATION: %v", err)
+	}
+	assert.Equal(t, []byte{packet.NotificationMsg, packet.Cease, packet.AdminShut}, buf[packet.MinLen-1:])
+
+	info := p.Info()
+	assert.True(t, info.AdminDown)
+	assert.Equal(t, "Maintenance", info.AdminDownReason)
+
+	go func() {
+		done <- p.fsm.idle()
+	}()
+
+	p.fsm.activate()
+
+	// The connection is only received once the start event was handled
+	c, s := tcpPair(t)
+	defer s.Close()
+	p.fsm.conCh <- c
+
+	_, err = s.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err, "Incoming connection was not closed")
+
+	select {
+	case s := <-done:
+		t.Fatalf("FSM left Idle while administratively down: %s", stateNames[s])
+	default:
+	}
+
+	p.AdminUp()
+	assert.Equal(t, Active, <-done)
+	assert.False(t, p.Info().AdminDown)
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// prefixLimitExceeded checks the number of prefixes received from the peer
+// against the configured limit. A warning is logged once the warning threshold
+// is reached. It is logged again after the number of prefixes dropped below the
+// threshold and reached it once more.
+func (fsm *FSM) prefixLimitExceeded() bool {
+	if fsm.prefixLimit == 0 {
+		return false
+	}
+
+	fsm.mu.RLock()
+	n := fsm.prefixesRcvd
+	fsm.mu.RUnlock()
+
+	if n > fsm.prefixLimit {
+		log.WithFields(log.Fields{
+			"peer":     fsm.remote.String(),
+			"prefixes": n,
+			"limit":    fsm.prefixLimit,
+		}).Error("Maximum number of prefixes exceeded")
+		return true
+	}
+
+	if fsm.prefixLimitWarning == 0 {
+		return false
+	}
+
+	if n*100 < fsm.prefixLimit*uint64(fsm.prefixLimitWarning) {
+		fsm.prefixLimitWarned = false
+		return false
+	}
+
+	if !fsm.prefixLimitWarned {
+		fsm.prefixLimitWarned = true
+		log.WithFields(log.Fields{
+			"peer":     fsm.remote.String(),
+			"prefixes": n,
+			"limit":    fsm.prefixLimit,
+		}).Warning("Prefix limit warning threshold reached")
+	}
+
+	return false
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/bio-routing/bio-rd/config"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+// prefixUpdate returns an UPDATE announcing or withdrawing the /24s 10.0.i.0
+// for i in [from, to)
+func prefixUpdate(from, to uint8, withdraw bool) *packet.BGPUpdate {
+	var nlri *packet.NLRI
+	for i := to; i > from; i-- {
+		nlri = &packet.NLRI{
+			IP:     [4]byte{10, 0, i - 1, 0},
+			Pfxlen: 24,
+			Next:   nlri,
+		}
+	}
+
+	if withdraw {
+		return &packet.BGPUpdate{
+			WithdrawnRoutes: nlri,
+		}
+	}
+
+	return &packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode: packet.LocalPrefAttr,
+			Value:    uint32(100),
+		},
+		NLRI: nlri,
+	}
+}
+
+func TestPrefixLimitExceeded(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:            65200,
+		PeerAS:             65201,
+		PeerAddress:        net.IP{169, 254, 123, 1},
+		PrefixLimit:        10,
+		PrefixLimitWarning: 80,
+	}, newFakeClock())
+	fsm.adjRibIn = rt.New()
+	fsm.adjRibIn6 = rt.New()
+
+	tests := []struct {
+		name             string
+		update           *packet.BGPUpdate
+		expectedPrefixes uint64
+		expectedWarned   bool
+		expected         bool
+	}{
+		{
+			name:             "Below warning threshold",
+			update:           prefixUpdate(0, 7, false),
+			expectedPrefixes: 7,
+		},
+		{
+			name:             "Crossing warning threshold",
+			update:           prefixUpdate(7, 8, false),
+			expectedPrefixes: 8,
+			expectedWarned:   true,
+		},
+		{
+			name:             "Implicit withdraw is not counted",
+			update:           prefixUpdate(0, 8, false),
+			expectedPrefixes: 8,
+			expectedWarned:   true,
+		},
+		{
+			name:             "Withdrawal below warning threshold",
+			update:           prefixUpdate(5, 8, true),
+			expectedPrefixes: 5,
+		},
+		{
+			name:             "At the limit",
+			update:           prefixUpdate(5, 10, false),
+			expectedPrefixes: 10,
+			expectedWarned:   true,
+		},
+		{
+			name:             "Limit exceeded",
+			update:           prefixUpdate(10, 11, false),
+			expectedPrefixes: 11,
+			expectedWarned:   true,
+			expected:         true,
+		},
+		{
+			name:             "Recovered after withdrawals",
+			update:           prefixUpdate(0, 6, true),
+			expectedPrefixes: 5,
+		},
+	}
+
+	for _, test := range tests {
+		fsm.processUpdate(test.update)
+		assert.Equal(t, test.expected, fsm.prefixLimitExceeded(), test.name)
+		assert.Equal(t, test.expectedPrefixes, fsm.Info().PrefixesReceived, test.name)
+		assert.Equal(t, test.expectedWarned, fsm.prefixLimitWarned, test.name)
+	}
+}
+
+func TestPrefixLimitDisabled(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+	}, newFakeClock())
+	fsm.adjRibIn = rt.New()
+
+	fsm.processUpdate(prefixUpdate(0, 100, false))
+	assert.False(t, fsm.prefixLimitExceeded())
+}
+
+func TestPrefixLimitTearsDownSession(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{169, 254, 123, 1},
+		PrefixLimit: 1,
+	}, newFakeClock())
+
+	// Drain the timers which fire on creation
+	stopTimer(fsm.holdTimer)
+	stopTimer(fsm.keepaliveTimer)
+
+	local, remote := tcpPair(t)
+	defer remote.Close()
+	fsm.con = local
+
+	update := []byte{
+		255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255,
+		0, 42, // Length
+		packet.UpdateMsg,
+		0, 0, // Withdrawn Routes Length
+		0, 11, // Total Path Attribute Length
+		64, 1, 1, 0, // ORIGIN: IGP
+		64, 3, 4, 192, 0, 2, 1, // NEXT_HOP: 192.0.2.1
+		24, 192, 0, 2, // 192.0.2.0/24
+		24, 198, 51, 100, // 198.51.100.0/24
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- fsm.established()
+	}()
+
+	fsm.msgRecvCh <- msgRecvMsg{msg: update, con: local}
+	assert.Equal(t, Idle, <-done)
+
+	buf := make([]byte, packet.MinLen+2)
+	_, err := io.ReadFull(remote, buf)
+	if err != nil {
+		t.Fatalf("Unable to read NOTIFICATION: %v", err)
+	}
+	assert.Equal(t, []byte{packet.NotificationMsg, packet.Cease, packet.MaxPrefReached}, buf[packet.MinLen-1:])
+
+	assert.Nil(t, fsm.adjRibIn)
+	assert.Equal(t, uint64(0), fsm.Info().PrefixesReceived)
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/taktv6/tflow2/convert"
+)
+
+// reflectionLoop checks if b was reflected back to us. This is the case if it
+// carries our router ID as ORIGINATOR_ID or our cluster ID in its CLUSTER_LIST
+// (RFC 4456, 8).
+func (fsm *FSM) reflectionLoop(b *rt.BGPPath) bool {
+	if b.OriginatorID != 0 && b.OriginatorID == fsm.routerID {
+		return true
+	}
+
+	for _, id := range b.ClusterList {
+		if id == fsm.clusterID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// advertisable checks if b may be advertised to the peer. Paths are never sent
+// back to the peer they were received from. Paths received via iBGP are only
+// sent to iBGP peers if either the path was received from or the peer is a
+// route reflector client.
+func (fsm *FSM) advertisable(b *rt.BGPPath) bool {
+	if addr := fsm.remote.To4(); addr != nil && b.Source == convert.Uint32b(addr) {
+		return false
+	}
+
+	if b.EBGP || b.Confederation || fsm.localASN != fsm.remoteASN {
+		return true
+	}
+
+	return b.ReflectorClient || fsm.routeReflectorClient
+}
+
+// reflect sets the route reflection attributes of b. Paths reflected to iBGP
+// peers keep their ORIGINATOR_ID or get the router ID of the peer they were
+// received from, and our cluster ID is prepended to their CLUSTER_LIST. Both
+// attributes are removed from paths advertised to eBGP peers.
+func (fsm *FSM) reflect(b *rt.BGPPath) {
+	if fsm.localASN != fsm.remoteASN {
+		b.OriginatorID = 0
+		b.ClusterList = nil
+		return
+	}
+
+	if b.EBGP || b.Confederation {
+		return
+	}
+
+	b.OriginatorID = b.OriginatorRouterID()
+	b.ClusterList = append([]uint32{fsm.clusterID}, b.ClusterList...)
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteReflectionExport(t *testing.T) {
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	tests := []struct {
+		name     string
+		peer     config.Peer
+		path     *rt.BGPPath
+		rejected bool
+		expected *rt.BGPPath
+	}{
+		{
+			name: "Client to non-client",
+			peer: config.Peer{PeerAS: 65200},
+			path: &rt.BGPPath{
+				RouterID:        10,
+				Source:          2851995658, // 169.254.0.10
+				ReflectorClient: true,
+			},
+			expected: &rt.BGPPath{
+				RouterID:        10,
+				Source:          2851995658,
+				ReflectorClient: true,
+				OriginatorID:    10,
+				ClusterList:     []uint32{100},
+			},
+		},
+		{
+			name: "Non-client to client",
+			peer: config.Peer{PeerAS: 65200, RouteReflectorClient: true},
+			path: &rt.BGPPath{
+				RouterID: 10,
+				Source:   2851995658,
+			},
+			expected: &rt.BGPPath{
+				RouterID:     10,
+				Source:       2851995658,
+				OriginatorID: 10,
+				ClusterList:  []uint32{100},
+			},
+		},
+		{
+			name: "Non-client to non-client",
+			peer: config.Peer{PeerAS: 65200},
+			path: &rt.BGPPath{
+				RouterID: 10,
+				Source:   2851995658,
+			},
+			rejected: true,
+		},
+		{
+			name: "ORIGINATOR_ID is preserved",
+			peer: config.Peer{PeerAS: 65200},
+			path: &rt.BGPPath{
+				RouterID:        10,
+				Source:          2851995658,
+				ReflectorClient: true,
+				OriginatorID:    20,
+				ClusterList:     []uint32{200},
+			},
+			expected: &rt.BGPPath{
+				RouterID:        10,
+				Source:          2851995658,
+				ReflectorClient: true,
+				OriginatorID:    20,
+				ClusterList:     []uint32{100, 200},
+			},
+		},
+		{
+			name: "eBGP path to non-client",
+			peer: config.Peer{PeerAS: 65200},
+			path: &rt.BGPPath{
+				RouterID: 10,
+				Source:   2851995658,
+				EBGP:     true,
+			},
+			expected: &rt.BGPPath{
+				RouterID: 10,
+				Source:   2851995658,
+				EBGP:     true,
+			},
+		},
+		{
+			name: "Reflected path to eBGP peer",
+			peer: config.Peer{PeerAS: 65201},
+			path: &rt.BGPPath{
+				RouterID:     10,
+				Source:       2851995658,
+				OriginatorID: 20,
+				ClusterList:  []uint32{200},
+			},
+			expected: &rt.BGPPath{
+				RouterID:   10,
+				Source:     2851995658,
+				NextHop:    2851995649, // 169.254.0.1
+				ASPath:     "65200",
+				ASPathLen:  1,
+				NeighborAS: 65200,
+				ASPathSegments: packet.ASPath{
+					{Type: packet.ASSequence, Count: 1, ASNs: []uint32{65200}},
+				},
+			},
+		},
+		{
+			name: "Not advertised back to its source",
+			peer: config.Peer{PeerAS: 65200, PeerAddress: net.IP{169, 254, 0, 10}},
+			path: &rt.BGPPath{
+				RouterID:        10,
+				Source:          2851995658,
+				ReflectorClient: true,
+			},
+			rejected: true,
+		},
+	}
+
+	for _, test := range tests {
+		test.peer.LocalAS = 65200
+		test.peer.LocalAddress = net.IP{169, 254, 0, 1}
+		test.peer.RouterID = 1
+		test.peer.ClusterID = 100
+		if test.peer.PeerAddress == nil {
+			test.peer.PeerAddress = net.IP{169, 254, 0, 2}
+		}
+		fsm := newFSM(test.peer, newFakeClock())
+
+		res, accept := fsm.exportPath(pfx, &rt.Path{
+			Type:    rt.BGPPathType,
+			BGPPath: test.path,
+		})
+		if test.rejected {
+			assert.False(t, accept, test.name)
+			continue
+		}
+
+		if assert.True(t, accept, test.name) {
+			assert.Equal(t, test.expected, res.BGPPath, test.name)
+		}
+	}
+}
+
+func TestRouteReflectionLoop(t *testing.T) {
+	tests := []struct {
+		name     string
+		attrs    *packet.PathAttribute
+		expected bool
+	}{
+		{
+			name: "Foreign cluster",
+			attrs: &packet.PathAttribute{
+				TypeCode: packet.ClusterListAttr,
+				Value:    []uint32{200, 300},
+			},
+			expected: true,
+		},
+		{
+			name: "Own cluster ID in CLUSTER_LIST",
+			attrs: &packet.PathAttribute{
+				TypeCode: packet.ClusterListAttr,
+				Value:    []uint32{200, 100},
+			},
+			expected: false,
+		},
+		{
+			name: "Own router ID as ORIGINATOR_ID",
+			attrs: &packet.PathAttribute{
+				TypeCode: packet.OriginatorIDAttr,
+				Value:    uint32(1),
+			},
+			expected: false,
+		},
+	}
+
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	for _, test := range tests {
+		fsm := newFSM(config.Peer{
+			LocalAS:     65200,
+			PeerAS:      65200,
+			PeerAddress: net.IP{169, 254, 0, 2},
+			RouterID:    1,
+			ClusterID:   100,
+		}, newFakeClock())
+		fsm.adjRibIn = rt.New()
+
+		fsm.processUpdate(&packet.BGPUpdate{
+			PathAttributes: test.attrs,
+			NLRI: &packet.NLRI{
+				IP:     [4]byte{192, 0, 2, 0},
+				Pfxlen: 24,
+			},
+		})
+
+		routes := fsm.adjRibIn.Get(pfx, false)
+		if !test.expected {
+			assert.Len(t, routes, 0, test.name)
+			continue
+		}
+
+		if assert.Len(t, routes, 1, test.name) {
+			assert.Equal(t, test.attrs.Value, routes[0].Paths()[0].BGPPath.ClusterList, test.name)
+		}
+	}
+}
+
+func TestOwnOriginatorIDWithdrawsPath(t *testing.T) {
+	fsm := newFSM(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65200,
+		PeerAddress: net.IP{169, 254, 0, 2},
+		RouterID:    1,
+		ClusterID:   100,
+	}, newFakeClock())
+	fsm.adjRibIn = rt.New()
+	client := make(recordingAdjRIBInClient, 10)
+	fsm.RegisterAdjRIBIn(client)
+
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	announce := func(originatorID uint32) {
+		fsm.processUpdate(&packet.BGPUpdate{
+			PathAttributes: &packet.PathAttribute{
+				TypeCode: packet.OriginatorIDAttr,
+				Value:    originatorID,
+			},
+			NLRI: &packet.NLRI{
+				IP:     [4]byte{192, 0, 2, 0},
+				Pfxlen: 24,
+			},
+		})
+	}
+
+	announce(2)
+	c := receivePathChange(t, client)
+	if !assert.NotNil(t, c.new) {
+		return
+	}
+	assert.Equal(t, uint32(2), c.new.BGPPath.OriginatorID)
+	assert.Len(t, fsm.adjRibIn.Get(pfx, false), 1)
+
+	// The route came back to its originator, the path announced before is
+	// withdrawn
+	announce(1)
+	c = receivePathChange(t, client)
+	assert.NotNil(t, c.old, "Path was not withdrawn")
+	assert.Nil(t, c.new, "Path carrying the own ORIGINATOR_ID was passed on")
+	assertNoPathChange(t, client, "Path carrying the own ORIGINATOR_ID was passed on")
+	assert.Len(t, fsm.adjRibIn.Get(pfx, false), 0)
+	assert.Equal(t, uint64(0), fsm.Info().PrefixesReceived)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"sync"
+
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/taktv6/tflow2/convert"
+)
+
+// RouteServer distributes the routes of its clients among each other like a
+// transparent route server at an internet exchange (RFC 7947). Every client
+// has its own RIB the paths of all other clients are imported into through
+// the import policy of the client, so clients with different policies get
+// different best paths for the same prefix. Clients have to be configured as
+// route server clients to get the paths with their NEXT_HOP and AS_PATH
+// untouched.
+type RouteServer struct {
+	mu      sync.RWMutex
+	paths   *rt.RIB
+	clients []*routeServerClient
+}
+
+// routeServerClient is a peer with its own RIB
+type routeServerClient struct {
+	addr uint32
+	rib  *rt.RIB
+}
+
+var _ AdjRIBInClient = &RouteServer{}
+
+// NewRouteServer creates a route server without clients
+func NewRouteServer() *RouteServer {
+	return &RouteServer{
+		paths: rt.NewRIB(nil),
+	}
+}
+
+// AddClient adds p as client. The paths received from all other clients are
+// imported into the RIB of p through importPolicy, nil accepts all of them.
+// The best paths of that RIB are advertised to p. Paths received from p are
+// distributed to all other clients. The RIB of p is returned.
+func (rs *RouteServer) AddClient(p *Peer, importPolicy rt.Policy) *rt.RIB {
+	c := &routeServerClient{
+		rib: rt.NewRIB(nil),
+	}
+	if addr := p.addr.To4(); addr != nil {
+		c.addr = convert.Uint32b(addr)
+	}
+	c.rib.SetImportPolicy(importPolicy)
+	p.AdjRIBOut().Attach(c.rib)
+
+	rs.mu.Lock()
+	for _, r := range rs.paths.Dump() {
+		for _, x := range r.Paths() {
+			if pathSource(x, nil) != c.addr {
+				c.rib.AddPath(r.Prefix(), x)
+			}
+		}
+	}
+	rs.clients = append(rs.clients, c)
+	rs.mu.Unlock()
+
+	p.fsm.RegisterAdjRIBIn(rs)
+	return c.rib
+}
+
+// ReplacePath distributes a change of the Adj-RIB-In of a client to the RIBs
+// of all other clients
+func (rs *RouteServer) ReplacePath(pfx *tnet.Prefix, old *rt.Path, new *rt.Path) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	rs.paths.ReplacePath(pfx, old, new)
+
+	src := pathSource(old, new)
+	for _, c := range rs.clients {
+		if c.addr != src {
+			c.rib.ReplacePath(pfx, old, new)
+		}
+	}
+}
+
+// pathSource returns the address of the peer old or new was received from
+func pathSource(old *rt.Path, new *rt.Path) uint32 {
+	for _, p := range []*rt.Path{old, new} {
+		if p != nil && p.Type == rt.BGPPathType {
+			return p.BGPPath.Source
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/bio-routing/bio-rd/config"
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/policy"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportPathRouteServerClient(t *testing.T) {
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	asPath := packet.ASPath{
+		{Type: packet.ASSequence, Count: 2, ASNs: []uint32{65201, 64496}},
+	}
+	b := &rt.BGPPath{
+		NextHop: 3325256705, // 198.51.100.1
+		EBGP:    true,
+	}
+	b.SetASPath(asPath)
+
+	tests := []struct {
+		name            string
+		peer            config.Peer
+		expectedNextHop uint32
+		expectedASPath  packet.ASPath
+	}{
+		{
+			name: "eBGP",
+			peer: config.Peer{
+				LocalAS: 65200,
+				PeerAS:  65202,
+			},
+			expectedNextHop: 2851995649, // 169.254.0.1
+			expectedASPath: packet.ASPath{
+				{Type: packet.ASSequence, Count: 3, ASNs: []uint32{65200, 65201, 64496}},
+			},
+		},
+		{
+			name: "Route server client",
+			peer: config.Peer{
+				LocalAS:           65200,
+				PeerAS:            65202,
+				RouteServerClient: true,
+			},
+			expectedNextHop: 3325256705,
+			expectedASPath:  asPath,
+		},
+		{
+			name: "Route server client with next hop self",
+			peer: config.Peer{
+				LocalAS:           65200,
+				PeerAS:            65202,
+				NextHopSelf:       true,
+				RouteServerClient: true,
+			},
+			expectedNextHop: 3325256705,
+			expectedASPath:  asPath,
+		},
+	}
+
+	for _, test := range tests {
+		test.peer.LocalAddress = net.IP{169, 254, 0, 1}
+		test.peer.PeerAddress = net.IP{169, 254, 0, 2}
+		fsm := newFSM(test.peer, newFakeClock())
+
+		res, accept := fsm.exportPath(pfx, &rt.Path{
+			Type:    rt.BGPPathType,
+			BGPPath: b,
+		})
+		if !assert.True(t, accept, test.name) {
+			continue
+		}
+
+		assert.Equal(t, test.expectedNextHop, res.BGPPath.NextHop, test.name)
+		assert.Equal(t, test.expectedASPath, res.BGPPath.ASPathSegments, test.name)
+	}
+}
+
+func newRouteServerClient(t *testing.T, addr net.IP, asn uint32) *Peer {
+	p, err := NewPeer(config.Peer{
+		LocalAS:           65200,
+		PeerAS:            asn,
+		LocalAddress:      net.IP{169, 254, 0, 254},
+		PeerAddress:       addr,
+		RouteServerClient: true,
+	})
+	if err != nil {
+		t.Fatalf("Unable to create peer: %v", err)
+	}
+
+	p.fsm.adjRibIn = rt.New()
+	p.fsm.adjRibIn6 = rt.New()
+	return p
+}
+
+func routeServerUpdate(asn uint32, nextHop net.IP) *packet.BGPUpdate {
+	return &packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode: packet.ASPathAttr,
+			Value: packet.ASPath{
+				{Type: packet.ASSequence, Count: 1, ASNs: []uint32{asn}},
+			},
+			Next: &packet.PathAttribute{
+				TypeCode: packet.NextHopAttr,
+				Value:    nextHop,
+			},
+		},
+		NLRI: &packet.NLRI{
+			IP:     [4]byte{192, 0, 2, 0},
+			Pfxlen: 24,
+		},
+	}
+}
+
+func TestRouteServerKeepsReceivedASPath(t *testing.T) {
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	rs := NewRouteServer()
+	a := newRouteServerClient(t, net.IP{169, 254, 0, 1}, 65201)
+	b := newRouteServerClient(t, net.IP{169, 254, 0, 2}, 65202)
+	rs.AddClient(a, nil)
+	ribB := rs.AddClient(b, nil)
+
+	// a is a 2-octet AS speaker. The AS_SET of AS4_PATH is merged into the
+	// AS path, which would be encoded differently than received.
+	a.fsm.decodeOptions = packet.DecodeOptions{KeepRawASPath: true}
+	msg, err := packet.SerializeUpdate(&packet.BGPUpdate{
+		PathAttributes: &packet.PathAttribute{
+			TypeCode:   packet.ASPathAttr,
+			Transitive: true,
+			Value: packet.ASPath{
+				{Type: packet.ASSequence, Count: 2, ASNs: []uint32{65201, packet.ASTrans}},
+			},
+			Next: &packet.PathAttribute{
+				TypeCode:   packet.NextHopAttr,
+				Transitive: true,
+				Value:      net.IP{169, 254, 0, 1},
+				Next: &packet.PathAttribute{
+					TypeCode:   packet.AS4PathAttr,
+					Optional:   true,
+					Transitive: true,
+					Value: packet.ASPath{
+						{Type: packet.ASSet, Count: 1, ASNs: []uint32{4200000000}},
+					},
+				},
+			},
+		},
+		NLRI: &packet.NLRI{
+			IP:     [4]byte{192, 0, 2, 0},
+			Pfxlen: 24,
+		},
+	}, packet.EncodeOptions{})
+	if err != nil {
+		t.Fatalf("Unable to serialize UPDATE: %v", err)
+	}
+	m, err := a.fsm.decodeMsg(msg)
+	if err != nil {
+		t.Fatalf("Unable to decode UPDATE: %v", err)
+	}
+	a.fsm.processUpdate(m.Body.(*packet.BGPUpdate))
+
+	r := ribB.Get(pfx)
+	if !assert.NotNil(t, r) {
+		return
+	}
+	res, accept := b.fsm.exportPath(pfx, r.ActivePaths()[0])
+	if !assert.True(t, accept) {
+		return
+	}
+	assert.Equal(t, packet.ASPath{
+		{Type: packet.ASSequence, Count: 1, ASNs: []uint32{65201}},
+		{Type: packet.ASSet, Count: 1, ASNs: []uint32{4200000000}},
+	}, res.BGPPath.ASPathSegments)
+
+	asPath := *pathAttribute(b.fsm.update(pfx, res.BGPPath, 0), packet.ASPathAttr)
+	asPath.Next = nil
+	buf, err := packet.SerializePathAttributes(&asPath, false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte{
+			64, 2, 6, // AS_PATH
+			2, 2, 254, 177, 91, 160, // AS_SEQUENCE 65201 23456
+		}, buf)
+	}
+}
+
+func TestRouteServerPerClientBestPaths(t *testing.T) {
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	rs := NewRouteServer()
+	a := newRouteServerClient(t, net.IP{169, 254, 0, 1}, 65201)
+	b := newRouteServerClient(t, net.IP{169, 254, 0, 2}, 65202)
+	ribA := rs.AddClient(a, nil)
+	ribB := rs.AddClient(b, nil)
+
+	// c prefers paths via a, d does not accept them at all
+	ribC := rs.AddClient(newRouteServerClient(t, net.IP{169, 254, 0, 3}, 65203), &policy.Filter{
+		Terms: []*policy.Term{
+			{
+				Conditions: []policy.Condition{policy.NextHop(net.IP{169, 254, 0, 1})},
+				Modifiers:  []policy.Modifier{policy.SetLocalPref(200)},
+			},
+		},
+		Default: policy.Accept,
+	})
+	ribD := rs.AddClient(newRouteServerClient(t, net.IP{169, 254, 0, 4}, 65204), &policy.Filter{
+		Terms: []*policy.Term{
+			{
+				Conditions: []policy.Condition{policy.NextHop(net.IP{169, 254, 0, 1})},
+				Verdict:    policy.Reject,
+			},
+		},
+		Default: policy.Accept,
+	})
+
+	a.fsm.processUpdate(routeServerUpdate(65201, net.IP{169, 254, 0, 1}))
+	b.fsm.processUpdate(routeServerUpdate(65202, net.IP{169, 254, 0, 2}))
+
+	tests := []struct {
+		name     string
+		rib      *rt.RIB
+		expected []uint32
+	}{
+		{
+			name:     "Own paths are not imported (a)",
+			rib:      ribA,
+			expected: []uint32{2851995650}, // 169.254.0.2
+		},
+		{
+			name:     "Own paths are not imported (b)",
+			rib:      ribB,
+			expected: []uint32{2851995649}, // 169.254.0.1
+		},
+		{
+			name:     "Preferring a",
+			rib:      ribC,
+			expected: []uint32{2851995649, 2851995650},
+		},
+		{
+			name:     "Rejecting a",
+			rib:      ribD,
+			expected: []uint32{2851995650},
+		},
+	}
+
+	for _, test := range tests {
+		r := test.rib.Get(pfx)
+		if !assert.NotNil(t, r, test.name) {
+			continue
+		}
+
+		nextHops := make([]uint32, 0)
+		for _, p := range r.Paths() {
+			nextHops = append(nextHops, p.BGPPath.NextHop)
+		}
+		assert.ElementsMatch(t, test.expected, nextHops, test.name)
+		if assert.NotEmpty(t, r.ActivePaths(), test.name) {
+			assert.Equal(t, test.expected[0], r.ActivePaths()[0].BGPPath.NextHop, test.name)
+		}
+	}
+
+	// Withdrawals are distributed as well
+	a.fsm.processUpdate(&packet.BGPUpdate{
+		WithdrawnRoutes: &packet.NLRI{
+			IP:     [4]byte{192, 0, 2, 0},
+			Pfxlen: 24,
+		},
+	})
+	assert.Equal(t, uint32(2851995650), ribC.Get(pfx).ActivePaths()[0].BGPPath.NextHop)
+	assert.Nil(t, ribB.Get(pfx))
+
+	// New clients get the paths already received from others
+	ribE := rs.AddClient(newRouteServerClient(t, net.IP{169, 254, 0, 5}, 65205), nil)
+	assert.Equal(t, uint32(2851995650), ribE.Get(pfx).ActivePaths()[0].BGPPath.NextHop)
+}
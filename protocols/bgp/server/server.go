@@ -1,10 +1,11 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
-	"strings"
+	"sync"
 
 	"github.com/bio-routing/bio-rd/config"
 	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
@@ -17,15 +18,20 @@ const (
 )
 
 type BGPServer struct {
-	listeners []*TCPListener
-	acceptCh  chan *net.TCPConn
-	peers     map[string]*Peer
-	routerID  uint32
+	listeners    []*TCPListener
+	acceptCh     chan *net.TCPConn
+	connLimiter  *connRateLimiter
+	peers        map[string]*Peer
+	dynamicPeers []config.DynamicPeerRange
+	peersMu      sync.RWMutex
+	routerID     uint32
+	clock        clock
 }
 
 func NewBgpServer() *BGPServer {
 	return &BGPServer{
 		peers: make(map[string]*Peer),
+		clock: realClock{},
 	}
 }
 
@@ -42,6 +48,7 @@ func (b *BGPServer) Start(c *config.Global) error {
 	b.routerID = c.RouterID
 
 	if c.Listen {
+		b.connLimiter = newConnRateLimiter(c.ConnRateLimit, c.ConnRateBurst)
 		acceptCh := make(chan *net.TCPConn, 4096)
 		for _, addr := range c.LocalAddressList {
 			l, err := NewTCPListener(addr, c.Port, acceptCh)
@@ -50,6 +57,15 @@ func (b *BGPServer) Start(c *config.Global) error {
 			}
 			b.listeners = append(b.listeners, l)
 		}
+
+		b.peersMu.RLock()
+		for _, p := range b.peers {
+			if err := b.setMD5Password(p.fsm.remote, p.fsm.md5Password); err != nil {
+				b.peersMu.RUnlock()
+				return fmt.Errorf("Unable to set TCP-MD5 password for %s: %w", p.addr, err)
+			}
+		}
+		b.peersMu.RUnlock()
 		b.acceptCh = acceptCh
 
 		go b.incomingConnectionWorker()
@@ -64,8 +80,18 @@ func (b *BGPServer) incomingConnectionWorker() {
 		fmt.Printf("Incoming connection!\n")
 		fmt.Printf("Connection from: %v\n", c.RemoteAddr())
 
-		peerAddr := strings.Split(c.RemoteAddr().String(), ":")[0]
-		if _, ok := b.peers[peerAddr]; !ok {
+		peerAddr := c.RemoteAddr().(*net.TCPAddr).IP.String()
+		if !b.connLimiter.allow(peerAddr, b.clock.Now()) {
+			c.Close()
+			log.WithFields(log.Fields{
+				"source":  c.RemoteAddr(),
+				"dropped": b.connLimiter.droppedCount(),
+			}).Warning("TCP connection rate limit exceeded")
+			continue
+		}
+
+		p, created := b.peerForConn(c.RemoteAddr().(*net.TCPAddr).IP, c.LocalAddr().(*net.TCPAddr).IP)
+		if p == nil {
 			c.Close()
 			log.WithFields(log.Fields{
 				"source": c.RemoteAddr(),
@@ -73,12 +99,29 @@ func (b *BGPServer) incomingConnectionWorker() {
 			continue
 		}
 
+		if p.fsm.activeOnly {
+			c.Close()
+			log.WithFields(log.Fields{
+				"source": c.RemoteAddr(),
+			}).Info("Refusing TCP connection from peer in active mode")
+			continue
+		}
+
+		if created {
+			log.WithFields(log.Fields{
+				"source": c.RemoteAddr(),
+			}).Info("Creating dynamic peer")
+			p.Start()
+		}
+
 		log.WithFields(log.Fields{
 			"source": c.RemoteAddr(),
 		}).Info("Incoming TCP connection")
 
+		p.fsm.setTCPOptions(c)
+
 		fmt.Printf("DEBUG: Sending incoming TCP connection to fsm for peer %s\n", peerAddr)
-		b.peers[peerAddr].fsm.conCh <- c
+		p.fsm.conCh <- c
 		fmt.Printf("DEBUG: Sending done\n")
 	}
 }
@@ -93,26 +136,159 @@ func (b *BGPServer) AddPeer(c config.Peer) error {
 		return err
 	}
 
+	if err := b.setMD5Password(c.PeerAddress, c.MD5Password); err != nil {
+		return fmt.Errorf("Unable to set TCP-MD5 password for %s: %w", c.PeerAddress, err)
+	}
+
 	peer.routerID = c.RouterID
 	peerAddr := peer.GetAddr().String()
+
+	b.peersMu.Lock()
 	b.peers[peerAddr] = peer
-	b.peers[peerAddr].Start()
+	b.peersMu.Unlock()
+
+	peer.Start()
 
 	return nil
 }
 
+// setMD5Password sets the TCP-MD5 password for connections from addr on all
+// listeners
+func (b *BGPServer) setMD5Password(addr net.IP, password string) error {
+	if password == "" {
+		return nil
+	}
+
+	for _, l := range b.listeners {
+		if err := l.SetMD5Password(addr, password); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemovePeer shuts the session to the peer with address addr down and removes
+// the peer including its TCP-MD5 key
+func (b *BGPServer) RemovePeer(addr net.IP) error {
+	b.peersMu.Lock()
+	p, ok := b.peers[addr.String()]
+	delete(b.peers, addr.String())
+	b.peersMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("Unknown peer: %s", addr)
+	}
+
+	// Keep the FSM from accepting or initiating further sessions
+	p.fsm.setAdminDown(true, "Peer removed")
+	if err := p.fsm.shutdown(context.Background()); err != nil {
+		return err
+	}
+
+	if p.fsm.md5Password == "" {
+		return nil
+	}
+
+	for _, l := range b.listeners {
+		if err := l.SetMD5Password(addr, ""); err != nil {
+			return fmt.Errorf("Unable to remove TCP-MD5 password for %s: %w", addr, err)
+		}
+	}
+
+	return nil
+}
+
+// AddDynamicPeerRange accepts sessions from any address within r.Range
+func (b *BGPServer) AddDynamicPeerRange(r config.DynamicPeerRange) error {
+	b.peersMu.Lock()
+	defer b.peersMu.Unlock()
+
+	b.dynamicPeers = append(b.dynamicPeers, r)
+	return nil
+}
+
+// peerForConn finds the peer a connection from src to local belongs to. If src is
+// not a configured peer but within a dynamic peer range a new passive peer is
+// created from the ranges template. Created peers are not started yet.
+func (b *BGPServer) peerForConn(src net.IP, local net.IP) (*Peer, bool) {
+	b.peersMu.Lock()
+	defer b.peersMu.Unlock()
+
+	if p, ok := b.peers[src.String()]; ok {
+		return p, false
+	}
+
+	for _, r := range b.dynamicPeers {
+		if !r.Range.Contains(src) {
+			continue
+		}
+
+		c := r.Template
+		c.PeerAddress = src
+		c.LocalAddress = local
+		c.Passive = true
+
+		p, err := NewPeer(c)
+		if err != nil {
+			return nil, false
+		}
+
+		p.routerID = c.RouterID
+		b.peers[src.String()] = p
+		return p, true
+	}
+
+	return nil, false
+}
+
+// Shutdown closes all listeners and stops all sessions sending a Cease/Administrative
+// Shutdown NOTIFICATION to each peer. It returns once all sessions are down or ctx is done.
+func (b *BGPServer) Shutdown(ctx context.Context) error {
+	for _, l := range b.listeners {
+		l.Close()
+	}
+
+	b.peersMu.RLock()
+	peers := make([]*Peer, 0, len(b.peers))
+	for _, p := range b.peers {
+		peers = append(peers, p)
+	}
+	b.peersMu.RUnlock()
+
+	errCh := make(chan error, len(peers))
+	for _, p := range peers {
+		go func(p *Peer) {
+			errCh <- p.fsm.shutdown(ctx)
+		}(p)
+	}
+
+	var ret error
+	for range peers {
+		if err := <-errCh; err != nil {
+			ret = fmt.Errorf("Unable to shut down all peers: %v", err)
+		}
+	}
+
+	return ret
+}
+
 func recvMsg(c *net.TCPConn) (msg []byte, err error) {
 	buffer := make([]byte, packet.MaxLen)
 	_, err = io.ReadFull(c, buffer[0:packet.MinLen])
 	if err != nil {
-		return nil, fmt.Errorf("Read failed: %v", err)
+		return nil, TransportError{
+			Err: fmt.Errorf("Read failed: %w", err),
+		}
 	}
 
 	l := int(buffer[16])*256 + int(buffer[17])
 	toRead := l
 	_, err = io.ReadFull(c, buffer[packet.MinLen:toRead])
 	if err != nil {
-		return nil, fmt.Errorf("Read failed: %v", err)
+		return nil, TransportError{
+			Err: fmt.Errorf("Read failed: %w", err),
+		}
 	}
 
 	return buffer, nil
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/config"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	b := NewBgpServer()
+
+	remotes := make([]*net.TCPConn, 0)
+	for _, addr := range []net.IP{{169, 254, 123, 1}, {169, 254, 124, 1}} {
+		p, err := NewPeer(config.Peer{
+			LocalAS:     65200,
+			PeerAS:      65201,
+			PeerAddress: addr,
+		})
+		if err != nil {
+			t.Fatalf("Unable to create peer: %v", err)
+		}
+
+		local, remote := tcpPair(t)
+		defer remote.Close()
+		remotes = append(remotes, remote)
+
+		stopTimer(p.fsm.holdTimer)
+		stopTimer(p.fsm.keepaliveTimer)
+		p.fsm.con = local
+		p.fsm.changeState(Established, "Test")
+		go p.fsm.established()
+
+		b.peers[addr.String()] = p
+	}
+
+	idle, err := NewPeer(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65202,
+		PeerAddress: net.IP{169, 254, 125, 1},
+	})
+	if err != nil {
+		t.Fatalf("Unable to create peer: %v", err)
+	}
+	b.peers["169.254.125.1"] = idle
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err = b.Shutdown(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, ctx.Err(), "Shutdown returned after the deadline")
+
+	for _, remote := range remotes {
+		buf := make([]byte, packet.MinLen+2)
+		_, err := io.ReadFull(remote, buf)
+		if err != nil {
+			t.Errorf("Unable to read NOTIFICATION: %v", err)
+			continue
+		}
+
+		assert.Equal(t, []byte{packet.NotificationMsg, packet.Cease, packet.AdminShut}, buf[packet.MinLen-1:])
+	}
+
+	for _, p := range b.peers {
+		assert.Equal(t, "Idle", p.Info().State)
+	}
+}
+
+func TestRemovePeer(t *testing.T) {
+	b := NewBgpServer()
+	p, err := NewPeer(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: net.IP{198, 51, 100, 1},
+	})
+	if err != nil {
+		t.Fatalf("Unable to create peer: %v", err)
+	}
+	b.peers["198.51.100.1"] = p
+
+	err = b.RemovePeer(net.IP{198, 51, 100, 1})
+	assert.NoError(t, err)
+	assert.Empty(t, b.peers)
+	assert.True(t, p.fsm.isAdminDown())
+
+	err = b.RemovePeer(net.IP{198, 51, 100, 1})
+	assert.Error(t, err)
+}
+
+func TestPeerForConn(t *testing.T) {
+	b := NewBgpServer()
+	err := b.AddDynamicPeerRange(config.DynamicPeerRange{
+		Range: net.IPNet{
+			IP:   net.IP{192, 0, 2, 0},
+			Mask: net.CIDRMask(24, 32),
+		},
+		Template: config.Peer{
+			LocalAS:  4200000000,
+			PeerAS:   65201,
+			RouterID: 100,
+		},
+	})
+	assert.NoError(t, err)
+
+	configured, err := NewPeer(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65202,
+		PeerAddress: net.IP{198, 51, 100, 1},
+	})
+	if err != nil {
+		t.Fatalf("Unable to create peer: %v", err)
+	}
+	b.peers["198.51.100.1"] = configured
+
+	local := net.IP{192, 0, 2, 254}
+
+	p, created := b.peerForConn(net.IP{198, 51, 100, 1}, local)
+	assert.Equal(t, configured, p)
+	assert.False(t, created)
+
+	p, created = b.peerForConn(net.IP{203, 0, 113, 1}, local)
+	assert.Nil(t, p)
+	assert.False(t, created)
+
+	p, created = b.peerForConn(net.IP{192, 0, 2, 1}, local)
+	if !assert.NotNil(t, p) {
+		return
+	}
+	assert.True(t, created)
+	assert.Equal(t, net.IP{192, 0, 2, 1}, p.GetAddr())
+	assert.Equal(t, uint32(65201), p.GetASN())
+	assert.Equal(t, local, p.fsm.local)
+
+	again, created := b.peerForConn(net.IP{192, 0, 2, 1}, local)
+	assert.Equal(t, p, again)
+	assert.False(t, created)
+}
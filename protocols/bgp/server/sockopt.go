@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"net"
 	"os"
 	"syscall"
@@ -11,6 +12,33 @@ const (
 	IPV6_MINHOPCOUNT = 73 // Generalized TTL Security Mechanism (RFC5082)
 )
 
+// tcpOptions are the socket options applied to a session's TCP connection
+type tcpOptions struct {
+	sendBufferSize    int
+	receiveBufferSize int
+	nagle             bool
+}
+
+func setTCPOptions(c *net.TCPConn, opt tcpOptions) error {
+	if opt.sendBufferSize > 0 {
+		if err := c.SetWriteBuffer(opt.sendBufferSize); err != nil {
+			return fmt.Errorf("Unable to set send buffer size: %w", err)
+		}
+	}
+
+	if opt.receiveBufferSize > 0 {
+		if err := c.SetReadBuffer(opt.receiveBufferSize); err != nil {
+			return fmt.Errorf("Unable to set receive buffer size: %w", err)
+		}
+	}
+
+	if err := c.SetNoDelay(!opt.nagle); err != nil {
+		return fmt.Errorf("Unable to set TCP_NODELAY: %w", err)
+	}
+
+	return nil
+}
+
 func SetListenTCPTTLSockopt(l *net.TCPListener, ttl int) error {
 	fi, family, err := extractFileAndFamilyFromTCPListener(l)
 	defer fi.Close()
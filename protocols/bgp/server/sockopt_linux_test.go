@@ -0,0 +1,53 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetTCPOptions(t *testing.T) {
+	c, s := tcpPair(t)
+	defer c.Close()
+	defer s.Close()
+
+	err := setTCPOptions(c, tcpOptions{
+		sendBufferSize:    65536,
+		receiveBufferSize: 131072,
+		nagle:             true,
+	})
+	if err != nil {
+		t.Fatalf("Unable to set socket options: %v", err)
+	}
+
+	raw, err := c.SyscallConn()
+	if err != nil {
+		t.Fatalf("Unable to get raw connection: %v", err)
+	}
+
+	var sndBuf, rcvBuf, noDelay int
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sndBuf, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+		if sockErr != nil {
+			return
+		}
+		rcvBuf, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		if sockErr != nil {
+			return
+		}
+		noDelay, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	})
+	if err != nil || sockErr != nil {
+		t.Fatalf("Unable to read socket options: %v %v", err, sockErr)
+	}
+
+	// Linux reports twice the requested buffer sizes
+	assert.Equal(t, 2*65536, sndBuf)
+	assert.Equal(t, 2*131072, rcvBuf)
+	assert.Equal(t, 0, noDelay)
+}
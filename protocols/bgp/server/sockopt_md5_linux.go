@@ -0,0 +1,71 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+const (
+	tcpMD5SigMaxKeyLen = 80
+	sockaddrStorageLen = 128
+	tcpMD5SigLen       = sockaddrStorageLen + 8 + tcpMD5SigMaxKeyLen
+)
+
+// tcpMD5Sig builds a struct tcp_md5sig as expected by the TCP_MD5SIG socket
+// option. An empty key removes the key for addr.
+//
+//	struct tcp_md5sig {
+//		struct __kernel_sockaddr_storage tcpm_addr;
+//		__u8	tcpm_flags;
+//		__u8	tcpm_prefixlen;
+//		__u16	tcpm_keylen;
+//		__u32	tcpm_ifindex;
+//		__u8	tcpm_key[TCP_MD5SIG_MAXKEYLEN];
+//	};
+func tcpMD5Sig(addr net.IP, key string) ([]byte, error) {
+	if len(key) > tcpMD5SigMaxKeyLen {
+		return nil, fmt.Errorf("TCP-MD5 key exceeds %d bytes", tcpMD5SigMaxKeyLen)
+	}
+
+	sig := make([]byte, tcpMD5SigLen)
+	if ip4 := addr.To4(); ip4 != nil {
+		// struct sockaddr_in: family, port, address
+		binary.NativeEndian.PutUint16(sig[0:2], syscall.AF_INET)
+		copy(sig[4:8], ip4)
+	} else if ip6 := addr.To16(); ip6 != nil {
+		// struct sockaddr_in6: family, port, flow info, address, scope ID
+		binary.NativeEndian.PutUint16(sig[0:2], syscall.AF_INET6)
+		copy(sig[8:24], ip6)
+	} else {
+		return nil, fmt.Errorf("Invalid address: %v", addr)
+	}
+
+	binary.NativeEndian.PutUint16(sig[sockaddrStorageLen+2:], uint16(len(key)))
+	copy(sig[sockaddrStorageLen+8:], key)
+
+	return sig, nil
+}
+
+// setTCPMD5 sets the TCP-MD5 key for connections with addr on c
+func setTCPMD5(c syscall.RawConn, addr net.IP, key string) error {
+	sig, err := tcpMD5Sig(addr, key)
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptString(int(fd), syscall.IPPROTO_TCP, TCP_MD5SIG, string(sig))
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.NewSyscallError("setsockopt", sockErr)
+}
@@ -0,0 +1,117 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/bio-routing/bio-rd/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTCPMD5Sig(t *testing.T) {
+	sig := func(family uint16, addr []byte, addrOffset int, key string) []byte {
+		b := make([]byte, 216)
+		binary.NativeEndian.PutUint16(b[0:2], family)
+		copy(b[addrOffset:], addr)
+		binary.NativeEndian.PutUint16(b[130:132], uint16(len(key)))
+		copy(b[136:], key)
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		addr     net.IP
+		key      string
+		wantFail bool
+		expected []byte
+	}{
+		{
+			name:     "IPv4",
+			addr:     net.ParseIP("192.0.2.1"),
+			key:      "secret",
+			expected: sig(syscall.AF_INET, []byte{192, 0, 2, 1}, 4, "secret"),
+		},
+		{
+			name:     "IPv6",
+			addr:     net.ParseIP("2001:db8::1"),
+			key:      "secret",
+			expected: sig(syscall.AF_INET6, []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, 8, "secret"),
+		},
+		{
+			name:     "Remove key",
+			addr:     net.ParseIP("192.0.2.1"),
+			key:      "",
+			expected: sig(syscall.AF_INET, []byte{192, 0, 2, 1}, 4, ""),
+		},
+		{
+			name:     "Maximum key length",
+			addr:     net.ParseIP("192.0.2.1"),
+			key:      strings.Repeat("x", 80),
+			expected: sig(syscall.AF_INET, []byte{192, 0, 2, 1}, 4, strings.Repeat("x", 80)),
+		},
+		{
+			name:     "Key too long",
+			addr:     net.ParseIP("192.0.2.1"),
+			key:      strings.Repeat("x", 81),
+			wantFail: true,
+		},
+		{
+			name:     "Invalid address",
+			addr:     net.IP{1, 2, 3},
+			key:      "secret",
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		res, err := tcpMD5Sig(test.addr, test.key)
+		if test.wantFail {
+			assert.Error(t, err, test.name)
+			continue
+		}
+
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, res, test.name)
+	}
+}
+
+func TestRemovePeerMD5Key(t *testing.T) {
+	l, err := NewTCPListener(net.IP{127, 0, 0, 1}, 0, make(chan *net.TCPConn))
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer l.Close()
+
+	b := NewBgpServer()
+	b.listeners = []*TCPListener{l}
+
+	addr := net.IP{127, 0, 0, 2}
+	p, err := NewPeer(config.Peer{
+		LocalAS:     65200,
+		PeerAS:      65201,
+		PeerAddress: addr,
+		MD5Password: "secret",
+	})
+	if err != nil {
+		t.Fatalf("Unable to create peer: %v", err)
+	}
+	b.peers[addr.String()] = p
+
+	err = b.setMD5Password(addr, "secret")
+	if err != nil {
+		t.Fatalf("Unable to set TCP-MD5 key: %v", err)
+	}
+
+	err = b.RemovePeer(addr)
+	assert.NoError(t, err)
+
+	// The key is gone, so removing it once more fails
+	err = l.SetMD5Password(addr, "")
+	assert.Error(t, err)
+}
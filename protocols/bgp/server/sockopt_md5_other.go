@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// setTCPMD5 sets the TCP-MD5 key for connections with addr on c
+func setTCPMD5(c syscall.RawConn, addr net.IP, key string) error {
+	return fmt.Errorf("TCP-MD5 is not supported on this platform")
+}
@@ -63,3 +63,24 @@ func NewTCPListener(address net.IP, port uint16, ch chan *net.TCPConn) (*TCPList
 
 	return tl, nil
 }
+
+// SetMD5Password sets the TCP-MD5 key for connections from addr. An empty
+// password removes the key. Addresses of another address family than the
+// listener are ignored.
+func (tl *TCPListener) SetMD5Password(addr net.IP, password string) error {
+	if (addr.To4() == nil) != (tl.l.Addr().(*net.TCPAddr).IP.To4() == nil) {
+		return nil
+	}
+
+	c, err := tl.l.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	return setTCPMD5(c, addr, password)
+}
+
+// Close stops accepting connections
+func (tl *TCPListener) Close() error {
+	return tl.l.Close()
+}
@@ -0,0 +1,160 @@
+// Package bmp implements the sending side of the BGP Monitoring Protocol
+// (RFC 7854)
+package bmp
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+)
+
+const (
+	// Version is the BMP version implemented
+	Version = 3
+
+	// CommonHeaderLen is the length of the common header of all messages
+	CommonHeaderLen = 6
+
+	// PerPeerHeaderLen is the length of the per peer header
+	PerPeerHeaderLen = 42
+
+	// Message types
+	RouteMonitoringType      = 0
+	StatisticsReportType     = 1
+	PeerDownNotificationType = 2
+	PeerUpNotificationType   = 3
+	InitiationMessageType    = 4
+	TerminationMessageType   = 5
+
+	// Peer types
+	GlobalInstancePeer = 0
+
+	// Per peer header flags
+	PeerFlagIPv6       = 0x80
+	PeerFlagPostPolicy = 0x40
+	PeerFlagLegacyASN  = 0x20
+
+	// Peer down reasons
+	PeerDownLocalNotification    = 1
+	PeerDownLocalNoNotification  = 2
+	PeerDownRemoteNotification   = 3
+	PeerDownRemoteNoNotification = 4
+	PeerDownDeconfigured         = 5
+
+	// Statistics types
+	StatAdjRIBInRoutes = 7
+	StatLocRIBRoutes   = 8
+
+	// Information TLV types
+	InfoString   = 0
+	InfoSysDescr = 1
+	InfoSysName  = 2
+)
+
+const statGauge64Len = 8
+
+// Message is a BMP message
+type Message struct {
+	Header *CommonHeader
+	Body   interface{}
+}
+
+// CommonHeader is the header of all BMP messages
+type CommonHeader struct {
+	Version uint8
+	Length  uint32
+	Type    uint8
+}
+
+// PerPeerHeader identifies the monitored peer of a message
+type PerPeerHeader struct {
+	PeerType          uint8
+	Flags             uint8
+	PeerDistinguisher uint64
+	PeerAddress       [16]byte
+	PeerAS            uint32
+	PeerBGPID         uint32
+	Timestamp         uint32
+	TimestampMicros   uint32
+}
+
+// PeerIP returns the address of the peer
+func (h *PerPeerHeader) PeerIP() net.IP {
+	if h.Flags&PeerFlagIPv6 == 0 {
+		return net.IP(h.PeerAddress[12:16])
+	}
+
+	return net.IP(h.PeerAddress[:])
+}
+
+// SetPeerIP sets the address of the peer and the address family flag
+func (h *PerPeerHeader) SetPeerIP(addr net.IP) {
+	h.PeerAddress = [16]byte{}
+	if x := addr.To4(); x != nil {
+		h.Flags &^= PeerFlagIPv6
+		copy(h.PeerAddress[12:], x)
+		return
+	}
+
+	h.Flags |= PeerFlagIPv6
+	copy(h.PeerAddress[:], addr.To16())
+}
+
+// RouteMonitoring carries an UPDATE received from or advertised by a peer
+type RouteMonitoring struct {
+	PerPeerHeader
+	Update *packet.BGPUpdate
+}
+
+// StatisticsReport carries statistics of a peer
+type StatisticsReport struct {
+	PerPeerHeader
+	Stats []Stat
+}
+
+// Stat is a single statistic of a StatisticsReport
+type Stat struct {
+	Type  uint16
+	Value []byte
+}
+
+// Gauge64Stat creates a statistic of a 64 bit gauge type
+func Gauge64Stat(typ uint16, value uint64) Stat {
+	v := make([]byte, statGauge64Len)
+	binary.BigEndian.PutUint64(v, value)
+	return Stat{
+		Type:  typ,
+		Value: v,
+	}
+}
+
+// PeerDownNotification reports a session that went down. Data holds the
+// NOTIFICATION message or FSM event code depending on Reason.
+type PeerDownNotification struct {
+	PerPeerHeader
+	Reason uint8
+	Data   []byte
+}
+
+// PeerUpNotification reports an established session with the OPEN messages
+// that were exchanged
+type PeerUpNotification struct {
+	PerPeerHeader
+	LocalAddress [16]byte
+	LocalPort    uint16
+	RemotePort   uint16
+	SentOpen     *packet.BGPOpen
+	ReceivedOpen *packet.BGPOpen
+}
+
+// InitiationMessage is sent by the monitored router first on a new connection
+type InitiationMessage struct {
+	TLVs []InformationTLV
+}
+
+// InformationTLV is a textual information of an InitiationMessage
+type InformationTLV struct {
+	Type  uint16
+	Value string
+}
@@ -0,0 +1,188 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSerializeRouteMonitoring(t *testing.T) {
+	hdr := PerPeerHeader{
+		PeerType:        GlobalInstancePeer,
+		Flags:           PeerFlagPostPolicy | PeerFlagLegacyASN,
+		PeerAS:          65201,
+		PeerBGPID:       3325256705,
+		Timestamp:       1500000000,
+		TimestampMicros: 123456,
+	}
+	hdr.SetPeerIP(net.IP{169, 254, 0, 2})
+
+	origin := &packet.PathAttribute{
+		TypeCode:   packet.OriginAttr,
+		Transitive: true,
+		Length:     1,
+		Value:      uint8(0),
+		Next: &packet.PathAttribute{
+			TypeCode:   packet.ASPathAttr,
+			Transitive: true,
+			Length:     4,
+			Value: packet.ASPath{
+				{Type: packet.ASSequence, Count: 1, ASNs: []uint32{65201}},
+			},
+			Next: &packet.PathAttribute{
+				TypeCode:   packet.NextHopAttr,
+				Transitive: true,
+				Length:     4,
+				Value:      net.IP{169, 254, 0, 2},
+			},
+		},
+	}
+
+	msg := &Message{
+		Header: &CommonHeader{Version: Version, Type: RouteMonitoringType},
+		Body: &RouteMonitoring{
+			PerPeerHeader: hdr,
+			Update: &packet.BGPUpdate{
+				PathAttributes: origin,
+				NLRI: &packet.NLRI{
+					IP:     [4]byte{192, 0, 2, 0},
+					Pfxlen: 24,
+				},
+			},
+		},
+	}
+
+	buf, err := Serialize(msg)
+	if err != nil {
+		t.Fatalf("Unable to serialize: %v", err)
+	}
+
+	// Common header: version, length and type
+	updateLen := packet.HeaderLen + 4 + 18 + 4
+	assert.Equal(t, uint8(Version), buf[0])
+	assert.Equal(t, uint32(CommonHeaderLen+PerPeerHeaderLen+updateLen), binary.BigEndian.Uint32(buf[1:5]))
+	assert.Equal(t, uint8(RouteMonitoringType), buf[5])
+	assert.Len(t, buf, CommonHeaderLen+PerPeerHeaderLen+updateLen)
+
+	res, err := Decode(bytes.NewBuffer(buf))
+	if err != nil {
+		t.Fatalf("Unable to decode: %v", err)
+	}
+
+	assert.Equal(t, &CommonHeader{
+		Version: Version,
+		Length:  uint32(len(buf)),
+		Type:    RouteMonitoringType,
+	}, res.Header)
+
+	rm := res.Body.(*RouteMonitoring)
+	assert.Equal(t, hdr, rm.PerPeerHeader)
+	assert.Equal(t, net.IP{169, 254, 0, 2}, rm.PeerIP())
+	assert.Equal(t, [4]byte{192, 0, 2, 0}, rm.Update.NLRI.IP)
+	assert.Equal(t, uint8(24), rm.Update.NLRI.Pfxlen)
+	assert.Equal(t, "65201", rm.Update.PathAttributes.Next.ASPathString())
+}
+
+func TestSerializePeerUpNotification(t *testing.T) {
+	hdr := PerPeerHeader{
+		PeerType:  GlobalInstancePeer,
+		PeerAS:    65201,
+		PeerBGPID: 3325256705,
+		Timestamp: 1500000000,
+	}
+	hdr.SetPeerIP(net.ParseIP("2001:db8::2"))
+
+	received := &packet.BGPOpen{
+		Version:       4,
+		AS:            65201,
+		HoldTime:      90,
+		BGPIdentifier: 3325256705,
+	}
+	received.AddCapability(packet.Capability{
+		Code:  packet.ASN4CapabilityCode,
+		Value: packet.ASN4Capability{ASN4: 65201},
+	})
+
+	msg := &Message{
+		Header: &CommonHeader{Version: Version, Type: PeerUpNotificationType},
+		Body: &PeerUpNotification{
+			PerPeerHeader: hdr,
+			LocalAddress:  address(net.ParseIP("2001:db8::1")),
+			LocalPort:     179,
+			RemotePort:    51234,
+			SentOpen: &packet.BGPOpen{
+				Version:       4,
+				AS:            65200,
+				HoldTime:      90,
+				BGPIdentifier: 2851995649,
+			},
+			ReceivedOpen: received,
+		},
+	}
+
+	buf, err := Serialize(msg)
+	if err != nil {
+		t.Fatalf("Unable to serialize: %v", err)
+	}
+
+	assert.Equal(t, uint8(Version), buf[0])
+	assert.Equal(t, uint32(len(buf)), binary.BigEndian.Uint32(buf[1:5]))
+	assert.Equal(t, uint8(PeerUpNotificationType), buf[5])
+	assert.Equal(t, uint8(PeerFlagIPv6), buf[7])
+
+	res, err := Decode(bytes.NewBuffer(buf))
+	if err != nil {
+		t.Fatalf("Unable to decode: %v", err)
+	}
+
+	up := res.Body.(*PeerUpNotification)
+	assert.Equal(t, hdr, up.PerPeerHeader)
+	assert.Equal(t, net.ParseIP("2001:db8::2"), up.PeerIP())
+	assert.Equal(t, address(net.ParseIP("2001:db8::1")), up.LocalAddress)
+	assert.Equal(t, uint16(179), up.LocalPort)
+	assert.Equal(t, uint16(51234), up.RemotePort)
+
+	assert.Equal(t, uint16(65200), up.SentOpen.AS)
+	assert.Equal(t, uint32(2851995649), up.SentOpen.BGPIdentifier)
+	assert.Equal(t, uint16(65201), up.ReceivedOpen.AS)
+	assert.Equal(t, uint16(90), up.ReceivedOpen.HoldTime)
+	assert.Equal(t, uint32(3325256705), up.ReceivedOpen.BGPIdentifier)
+	assert.Len(t, up.ReceivedOpen.OptParams, 1)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{
+			name:  "Truncated header",
+			input: []byte{3, 0, 0},
+		},
+		{
+			name:  "Unsupported version",
+			input: []byte{1, 0, 0, 0, 6, InitiationMessageType},
+		},
+		{
+			name:  "Length exceeds buffer",
+			input: []byte{3, 0, 0, 0, 10, InitiationMessageType},
+		},
+		{
+			name:  "Unknown type",
+			input: []byte{3, 0, 0, 0, 6, 42},
+		},
+		{
+			name:  "Truncated per peer header",
+			input: []byte{3, 0, 0, 0, 8, PeerDownNotificationType, 0, 0},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := Decode(bytes.NewBuffer(test.input))
+		assert.Error(t, err, test.name)
+	}
+}
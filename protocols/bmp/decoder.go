@@ -0,0 +1,240 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+)
+
+// Decode decodes a BMP message
+func Decode(buf *bytes.Buffer) (*Message, error) {
+	hdr := &CommonHeader{}
+	err := decode(buf, []interface{}{&hdr.Version, &hdr.Length, &hdr.Type})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode common header: %w", err)
+	}
+
+	if hdr.Version != Version {
+		return nil, fmt.Errorf("Unsupported version: %d", hdr.Version)
+	}
+
+	if hdr.Length < CommonHeaderLen || int(hdr.Length-CommonHeaderLen) > buf.Len() {
+		return nil, fmt.Errorf("Invalid length: %d", hdr.Length)
+	}
+
+	body := bytes.NewBuffer(buf.Next(int(hdr.Length - CommonHeaderLen)))
+
+	var b interface{}
+	switch hdr.Type {
+	case RouteMonitoringType:
+		b, err = decodeRouteMonitoring(body)
+	case StatisticsReportType:
+		b, err = decodeStatisticsReport(body)
+	case PeerDownNotificationType:
+		b, err = decodePeerDownNotification(body)
+	case PeerUpNotificationType:
+		b, err = decodePeerUpNotification(body)
+	case InitiationMessageType:
+		b, err = decodeInitiationMessage(body)
+	default:
+		return nil, fmt.Errorf("Unknown message type: %d", hdr.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Header: hdr,
+		Body:   b,
+	}, nil
+}
+
+func decodePerPeerHeader(buf *bytes.Buffer) (PerPeerHeader, error) {
+	h := PerPeerHeader{}
+	err := decode(buf, []interface{}{
+		&h.PeerType,
+		&h.Flags,
+		&h.PeerDistinguisher,
+		&h.PeerAddress,
+		&h.PeerAS,
+		&h.PeerBGPID,
+		&h.Timestamp,
+		&h.TimestampMicros,
+	})
+	if err != nil {
+		return h, fmt.Errorf("Unable to decode per peer header: %w", err)
+	}
+
+	return h, nil
+}
+
+// decodeOptions returns the options to decode BGP messages of the peer with
+func (h *PerPeerHeader) decodeOptions() *packet.DecodeOptions {
+	return &packet.DecodeOptions{
+		Use32BitASN: h.Flags&PeerFlagLegacyASN == 0,
+	}
+}
+
+func decodeRouteMonitoring(buf *bytes.Buffer) (*RouteMonitoring, error) {
+	h, err := decodePerPeerHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := packet.Decode(buf, h.decodeOptions())
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode UPDATE: %w", err)
+	}
+
+	u, ok := msg.Body.(*packet.BGPUpdate)
+	if !ok {
+		return nil, fmt.Errorf("Route monitoring carries message type %d", msg.Header.Type)
+	}
+
+	return &RouteMonitoring{
+		PerPeerHeader: h,
+		Update:        u,
+	}, nil
+}
+
+func decodeStatisticsReport(buf *bytes.Buffer) (*StatisticsReport, error) {
+	h, err := decodePerPeerHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &StatisticsReport{
+		PerPeerHeader: h,
+	}
+
+	count := uint32(0)
+	err = decode(buf, []interface{}{&count})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode statistics count: %w", err)
+	}
+
+	for i := uint32(0); i < count; i++ {
+		s := Stat{}
+		l := uint16(0)
+		err = decode(buf, []interface{}{&s.Type, &l})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode statistic: %w", err)
+		}
+
+		if int(l) > buf.Len() {
+			return nil, fmt.Errorf("Statistic %d exceeds message", s.Type)
+		}
+		s.Value = buf.Next(int(l))
+		m.Stats = append(m.Stats, s)
+	}
+
+	return m, nil
+}
+
+func decodePeerDownNotification(buf *bytes.Buffer) (*PeerDownNotification, error) {
+	h, err := decodePerPeerHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &PeerDownNotification{
+		PerPeerHeader: h,
+	}
+
+	err = decode(buf, []interface{}{&m.Reason})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode reason: %w", err)
+	}
+
+	if buf.Len() > 0 {
+		m.Data = buf.Next(buf.Len())
+	}
+
+	return m, nil
+}
+
+func decodePeerUpNotification(buf *bytes.Buffer) (*PeerUpNotification, error) {
+	h, err := decodePerPeerHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &PeerUpNotification{
+		PerPeerHeader: h,
+	}
+
+	err = decode(buf, []interface{}{&m.LocalAddress, &m.LocalPort, &m.RemotePort})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode addresses: %w", err)
+	}
+
+	m.SentOpen, err = decodeOpen(buf)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode sent OPEN: %w", err)
+	}
+
+	m.ReceivedOpen, err = decodeOpen(buf)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode received OPEN: %w", err)
+	}
+
+	return m, nil
+}
+
+// decodeOpen decodes the OPEN message at the start of buf
+func decodeOpen(buf *bytes.Buffer) (*packet.BGPOpen, error) {
+	if buf.Len() < packet.MinLen {
+		return nil, fmt.Errorf("Message too short: %d", buf.Len())
+	}
+
+	l := int(binary.BigEndian.Uint16(buf.Bytes()[packet.MarkerLen:]))
+	if l < packet.MinLen || l > buf.Len() {
+		return nil, fmt.Errorf("Invalid message length: %d", l)
+	}
+
+	msg, err := packet.Decode(bytes.NewBuffer(buf.Next(l)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	o, ok := msg.Body.(*packet.BGPOpen)
+	if !ok {
+		return nil, fmt.Errorf("Unexpected message type %d", msg.Header.Type)
+	}
+
+	return o, nil
+}
+
+func decodeInitiationMessage(buf *bytes.Buffer) (*InitiationMessage, error) {
+	m := &InitiationMessage{}
+	for buf.Len() > 0 {
+		tlv := InformationTLV{}
+		l := uint16(0)
+		err := decode(buf, []interface{}{&tlv.Type, &l})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode information TLV: %w", err)
+		}
+
+		if int(l) > buf.Len() {
+			return nil, fmt.Errorf("Information TLV %d exceeds message", tlv.Type)
+		}
+		tlv.Value = string(buf.Next(int(l)))
+		m.TLVs = append(m.TLVs, tlv)
+	}
+
+	return m, nil
+}
+
+func decode(buf *bytes.Buffer, fields []interface{}) error {
+	for _, field := range fields {
+		err := binary.Read(buf, binary.BigEndian, field)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
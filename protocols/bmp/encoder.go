@@ -0,0 +1,129 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+)
+
+// Serialize serializes a BMP message including its common header. The length
+// is calculated from the body.
+func Serialize(msg *Message) ([]byte, error) {
+	body := bytes.NewBuffer(nil)
+
+	var err error
+	switch msg.Header.Type {
+	case RouteMonitoringType:
+		m, ok := msg.Body.(*RouteMonitoring)
+		if !ok {
+			return nil, fmt.Errorf("Invalid route monitoring body: %T", msg.Body)
+		}
+		err = m.serialize(body)
+	case StatisticsReportType:
+		m, ok := msg.Body.(*StatisticsReport)
+		if !ok {
+			return nil, fmt.Errorf("Invalid statistics report body: %T", msg.Body)
+		}
+		m.serialize(body)
+	case PeerDownNotificationType:
+		m, ok := msg.Body.(*PeerDownNotification)
+		if !ok {
+			return nil, fmt.Errorf("Invalid peer down notification body: %T", msg.Body)
+		}
+		m.serialize(body)
+	case PeerUpNotificationType:
+		m, ok := msg.Body.(*PeerUpNotification)
+		if !ok {
+			return nil, fmt.Errorf("Invalid peer up notification body: %T", msg.Body)
+		}
+		err = m.serialize(body)
+	case InitiationMessageType:
+		m, ok := msg.Body.(*InitiationMessage)
+		if !ok {
+			return nil, fmt.Errorf("Invalid initiation message body: %T", msg.Body)
+		}
+		m.serialize(body)
+	default:
+		return nil, fmt.Errorf("Unable to serialize message type %d", msg.Header.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, CommonHeaderLen+body.Len()))
+	buf.WriteByte(Version)
+	binary.Write(buf, binary.BigEndian, uint32(CommonHeaderLen+body.Len()))
+	buf.WriteByte(msg.Header.Type)
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+func (h *PerPeerHeader) serialize(buf *bytes.Buffer) {
+	buf.WriteByte(h.PeerType)
+	buf.WriteByte(h.Flags)
+	binary.Write(buf, binary.BigEndian, h.PeerDistinguisher)
+	buf.Write(h.PeerAddress[:])
+	binary.Write(buf, binary.BigEndian, h.PeerAS)
+	binary.Write(buf, binary.BigEndian, h.PeerBGPID)
+	binary.Write(buf, binary.BigEndian, h.Timestamp)
+	binary.Write(buf, binary.BigEndian, h.TimestampMicros)
+}
+
+func (m *RouteMonitoring) serialize(buf *bytes.Buffer) error {
+	update, err := packet.SerializeUpdateMsg(m.Update)
+	if err != nil {
+		return fmt.Errorf("Unable to serialize UPDATE: %w", err)
+	}
+
+	m.PerPeerHeader.serialize(buf)
+	buf.Write(update)
+	return nil
+}
+
+func (m *StatisticsReport) serialize(buf *bytes.Buffer) {
+	m.PerPeerHeader.serialize(buf)
+	binary.Write(buf, binary.BigEndian, uint32(len(m.Stats)))
+	for _, s := range m.Stats {
+		binary.Write(buf, binary.BigEndian, s.Type)
+		binary.Write(buf, binary.BigEndian, uint16(len(s.Value)))
+		buf.Write(s.Value)
+	}
+}
+
+func (m *PeerDownNotification) serialize(buf *bytes.Buffer) {
+	m.PerPeerHeader.serialize(buf)
+	buf.WriteByte(m.Reason)
+	buf.Write(m.Data)
+}
+
+func (m *PeerUpNotification) serialize(buf *bytes.Buffer) error {
+	sent, err := packet.SerializeOpenMsg(m.SentOpen)
+	if err != nil {
+		return fmt.Errorf("Unable to serialize sent OPEN: %w", err)
+	}
+
+	received, err := packet.SerializeOpenMsg(m.ReceivedOpen)
+	if err != nil {
+		return fmt.Errorf("Unable to serialize received OPEN: %w", err)
+	}
+
+	m.PerPeerHeader.serialize(buf)
+	buf.Write(m.LocalAddress[:])
+	binary.Write(buf, binary.BigEndian, m.LocalPort)
+	binary.Write(buf, binary.BigEndian, m.RemotePort)
+	buf.Write(sent)
+	buf.Write(received)
+	return nil
+}
+
+func (m *InitiationMessage) serialize(buf *bytes.Buffer) {
+	for _, tlv := range m.TLVs {
+		binary.Write(buf, binary.BigEndian, tlv.Type)
+		binary.Write(buf, binary.BigEndian, uint16(len(tlv.Value)))
+		buf.WriteString(tlv.Value)
+	}
+}
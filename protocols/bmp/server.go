@@ -0,0 +1,446 @@
+package bmp
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	bgpserver "github.com/bio-routing/bio-rd/protocols/bgp/server"
+	"github.com/bio-routing/bio-rd/rt"
+	log "github.com/sirupsen/logrus"
+	"github.com/taktv6/tflow2/convert"
+)
+
+const (
+	defaultStatsInterval = time.Minute
+	connectRetryInterval = 30 * time.Second
+	dialTimeout          = 10 * time.Second
+)
+
+// Server reports the routes of a RIB and the state of BGP sessions to a BMP
+// station. Routes are reported as post-policy Route Monitoring messages of the
+// peer the best path was received from. Only IPv4 routes are reported.
+// Messages are dropped while there is no connection to the station, the
+// complete state is sent again after reconnecting. Messages are queued and
+// written to the station by a separate goroutine, so neither the RIB nor the
+// BGP sessions wait for the station.
+type Server struct {
+	rib           *rt.RIB
+	station       string
+	statsInterval time.Duration
+
+	mu        sync.Mutex
+	connected bool
+	queue     []*Message
+	peers     []*bgpserver.Peer
+	monitored map[string]PerPeerHeader
+	pending   chan struct{}
+	stop      chan struct{}
+}
+
+var _ rt.RIBClient = &Server{}
+
+// NewServer creates a server reporting rib to the BMP station at address
+// station (host:port)
+func NewServer(rib *rt.RIB, station string) *Server {
+	return &Server{
+		rib:           rib,
+		station:       station,
+		statsInterval: defaultStatsInterval,
+		monitored:     make(map[string]PerPeerHeader),
+		pending:       make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+	}
+}
+
+// Start connects to the station and starts reporting
+func (s *Server) Start() {
+	s.rib.Register(s)
+	go s.run()
+}
+
+// Stop stops reporting and closes the connection to the station
+func (s *Server) Stop() {
+	s.rib.Unregister(s)
+	close(s.stop)
+}
+
+// AddPeer reports state changes of the session to p as Peer Up and Peer Down
+// notifications and includes it in the statistics reports
+func (s *Server) AddPeer(p *bgpserver.Peer) {
+	changes := p.Subscribe()
+
+	s.mu.Lock()
+	s.peers = append(s.peers, p)
+	s.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case c := <-changes:
+				if c.New == bgpserver.Established {
+					s.send(peerUp(p.Info()))
+				} else if c.Old == bgpserver.Established {
+					s.send(peerDown(p.Info(), c.Down))
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// UpdateActivePaths reports the best path of pfx. If the best path was
+// received from another peer before, its route is reported as withdrawn.
+func (s *Server) UpdateActivePaths(pfx *tnet.Prefix, paths []*rt.Path) {
+	if pfx.AFI() != packet.IPv4AFI {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.routeMonitoring(pfx, paths) {
+		s.enqueue(m)
+	}
+}
+
+// routeMonitoring returns the messages reporting the change of pfx to paths
+func (s *Server) routeMonitoring(pfx *tnet.Prefix, paths []*rt.Path) []*Message {
+	key := pfx.String()
+	old, wasMonitored := s.monitored[key]
+
+	var b *rt.BGPPath
+	if len(paths) > 0 && paths[0].Type == rt.BGPPathType {
+		b = paths[0].BGPPath
+	}
+
+	res := make([]*Message, 0, 2)
+	if b == nil {
+		delete(s.monitored, key)
+		if wasMonitored {
+			res = append(res, withdrawal(old, pfx))
+		}
+		return res
+	}
+
+	hdr := routeMonitoringHeader(b)
+	if wasMonitored && old.PeerAddress != hdr.PeerAddress {
+		res = append(res, withdrawal(old, pfx))
+	}
+
+	s.monitored[key] = hdr
+	return append(res, &Message{
+		Header: &CommonHeader{Version: Version, Type: RouteMonitoringType},
+		Body: &RouteMonitoring{
+			PerPeerHeader: hdr,
+			Update: &packet.BGPUpdate{
+				PathAttributes: b.PathAttributes(!b.EBGP),
+				NLRI:           nlri(pfx),
+			},
+		},
+	})
+}
+
+func withdrawal(hdr PerPeerHeader, pfx *tnet.Prefix) *Message {
+	hdr.Timestamp, hdr.TimestampMicros = timestamp(time.Now())
+	return &Message{
+		Header: &CommonHeader{Version: Version, Type: RouteMonitoringType},
+		Body: &RouteMonitoring{
+			PerPeerHeader: hdr,
+			Update: &packet.BGPUpdate{
+				WithdrawnRoutes: nlri(pfx),
+			},
+		},
+	}
+}
+
+func routeMonitoringHeader(b *rt.BGPPath) PerPeerHeader {
+	hdr := PerPeerHeader{
+		PeerType:  GlobalInstancePeer,
+		Flags:     PeerFlagPostPolicy | PeerFlagLegacyASN,
+		PeerAS:    b.NeighborAS,
+		PeerBGPID: b.RouterID,
+	}
+	hdr.SetPeerIP(net.IP(convert.Uint32Byte(b.Source)))
+	hdr.Timestamp, hdr.TimestampMicros = timestamp(time.Now())
+
+	return hdr
+}
+
+func peerHeader(info bgpserver.NeighborInfo) PerPeerHeader {
+	hdr := PerPeerHeader{
+		PeerType:  GlobalInstancePeer,
+		PeerAS:    info.PeerASN,
+		PeerBGPID: info.NeighborID,
+	}
+	hdr.SetPeerIP(info.PeerAddress)
+	hdr.Timestamp, hdr.TimestampMicros = timestamp(time.Now())
+
+	return hdr
+}
+
+// peerUp builds a Peer Up notification from the OPEN messages exchanged
+func peerUp(info bgpserver.NeighborInfo) *Message {
+	return &Message{
+		Header: &CommonHeader{Version: Version, Type: PeerUpNotificationType},
+		Body: &PeerUpNotification{
+			PerPeerHeader: peerHeader(info),
+			LocalAddress:  address(info.LocalAddress),
+			LocalPort:     info.LocalPort,
+			RemotePort:    info.PeerPort,
+			SentOpen:      info.SentOpen,
+			ReceivedOpen:  info.ReceivedOpen,
+		},
+	}
+}
+
+// peerDown builds a Peer Down notification reporting the NOTIFICATION or FSM
+// event which closed the session
+func peerDown(info bgpserver.NeighborInfo, down bgpserver.SessionDown) *Message {
+	m := &PeerDownNotification{
+		PerPeerHeader: peerHeader(info),
+		Reason:        PeerDownRemoteNoNotification,
+	}
+
+	var notification []byte
+	if down.Notification != nil {
+		var err error
+		notification, err = packet.SerializeNotificationMsg(down.Notification)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"peer":  info.PeerAddress,
+				"error": err,
+			}).Warning("Unable to serialize NOTIFICATION for Peer Down notification")
+		}
+	}
+
+	switch {
+	case notification != nil && down.Local:
+		m.Reason = PeerDownLocalNotification
+		m.Data = notification
+	case notification != nil:
+		m.Reason = PeerDownRemoteNotification
+		m.Data = notification
+	case down.Local:
+		m.Reason = PeerDownLocalNoNotification
+		m.Data = []byte{0, 0}
+		binary.BigEndian.PutUint16(m.Data, uint16(down.Event))
+	}
+
+	return &Message{
+		Header: &CommonHeader{Version: Version, Type: PeerDownNotificationType},
+		Body:   m,
+	}
+}
+
+func statisticsReport(info bgpserver.NeighborInfo) *Message {
+	return &Message{
+		Header: &CommonHeader{Version: Version, Type: StatisticsReportType},
+		Body: &StatisticsReport{
+			PerPeerHeader: peerHeader(info),
+			Stats: []Stat{
+				Gauge64Stat(StatAdjRIBInRoutes, info.PrefixesReceived),
+			},
+		},
+	}
+}
+
+func initiation() *Message {
+	sysName, err := os.Hostname()
+	if err != nil {
+		sysName = "bio-rd"
+	}
+
+	return &Message{
+		Header: &CommonHeader{Version: Version, Type: InitiationMessageType},
+		Body: &InitiationMessage{
+			TLVs: []InformationTLV{
+				{Type: InfoSysName, Value: sysName},
+				{Type: InfoSysDescr, Value: "bio-rd"},
+			},
+		},
+	}
+}
+
+func (s *Server) run() {
+	for {
+		c, err := net.DialTimeout("tcp", s.station, dialTimeout)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"station": s.station,
+				"error":   err,
+			}).Warning("Unable to connect to BMP station")
+		} else {
+			s.serve(c)
+			c.Close()
+		}
+
+		select {
+		case <-time.After(connectRetryInterval):
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// serve writes the queued messages and periodic statistics reports to the
+// station until the connection fails or the server is stopped
+func (s *Server) serve(c net.Conn) {
+	defer s.disconnect()
+	s.sync()
+
+	t := time.NewTicker(s.statsInterval)
+	defer t.Stop()
+
+	for {
+		s.mu.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, m := range queue {
+			if !s.write(c, m) {
+				return
+			}
+		}
+
+		select {
+		case <-s.pending:
+		case <-t.C:
+			s.queueStatistics()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// sync queues the initiation message, the sessions up and all routes of the
+// RIB. Changes reported while dumping the RIB are queued behind them.
+func (s *Server) sync() {
+	s.mu.Lock()
+	s.connected = true
+	msgs := []*Message{initiation()}
+	for _, p := range s.peers {
+		info := p.Info()
+		if info.State == "Established" {
+			msgs = append(msgs, peerUp(info))
+		}
+	}
+	s.monitored = make(map[string]PerPeerHeader)
+	s.mu.Unlock()
+
+	// The RIB notifies its clients holding its lock, so it must not be
+	// dumped holding ours
+	routes := s.rib.Dump()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range routes {
+		if r.Prefix().AFI() != packet.IPv4AFI {
+			continue
+		}
+		if _, ok := s.monitored[r.Prefix().String()]; ok {
+			continue
+		}
+
+		msgs = append(msgs, s.routeMonitoring(r.Prefix(), r.ActivePaths())...)
+	}
+
+	s.queue = append(msgs, s.queue...)
+}
+
+// queueStatistics queues statistics reports of all established sessions
+func (s *Server) queueStatistics() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.peers {
+		info := p.Info()
+		if info.State == "Established" {
+			s.enqueue(statisticsReport(info))
+		}
+	}
+}
+
+func (s *Server) send(m *Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enqueue(m)
+}
+
+// enqueue queues m to be written to the station. m is dropped if there is no
+// connection.
+func (s *Server) enqueue(m *Message) {
+	if !s.connected {
+		return
+	}
+
+	s.queue = append(s.queue, m)
+	select {
+	case s.pending <- struct{}{}:
+	default:
+	}
+}
+
+// write sends m to the station. It returns false if the connection failed.
+func (s *Server) write(c net.Conn, m *Message) bool {
+	buf, err := Serialize(m)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"type":  m.Header.Type,
+			"error": err,
+		}).Warning("Unable to serialize BMP message")
+		return true
+	}
+
+	_, err = c.Write(buf)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"station": s.station,
+			"error":   err,
+		}).Warning("Unable to send BMP message")
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connected = false
+	s.queue = nil
+}
+
+func nlri(pfx *tnet.Prefix) *packet.NLRI {
+	addr := convert.Uint32Byte(pfx.Addr())
+	return &packet.NLRI{
+		IP:     [4]byte{addr[0], addr[1], addr[2], addr[3]},
+		Pfxlen: pfx.Pfxlen(),
+	}
+}
+
+// address returns addr in the 16 byte encoding of BMP. IPv4 addresses are
+// stored in the last 4 bytes.
+func address(addr net.IP) [16]byte {
+	res := [16]byte{}
+	if x := addr.To4(); x != nil {
+		copy(res[12:], x)
+		return res
+	}
+
+	copy(res[:], addr.To16())
+	return res
+}
+
+func timestamp(t time.Time) (sec uint32, usec uint32) {
+	return uint32(t.Unix()), uint32(t.Nanosecond() / 1000)
+}
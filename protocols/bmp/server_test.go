@@ -0,0 +1,152 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	bgpserver "github.com/bio-routing/bio-rd/protocols/bgp/server"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+func readMessage(t *testing.T, c net.Conn) *Message {
+	c.SetReadDeadline(time.Now().Add(time.Second))
+
+	hdr := make([]byte, CommonHeaderLen)
+	_, err := io.ReadFull(c, hdr)
+	if err != nil {
+		t.Fatalf("Unable to read common header: %v", err)
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint32(hdr[1:5]))
+	copy(buf, hdr)
+	_, err = io.ReadFull(c, buf[CommonHeaderLen:])
+	if err != nil {
+		t.Fatalf("Unable to read message: %v", err)
+	}
+
+	msg, err := Decode(bytes.NewBuffer(buf))
+	if err != nil {
+		t.Fatalf("Unable to decode message: %v", err)
+	}
+
+	return msg
+}
+
+func TestServerRouteMonitoring(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer l.Close()
+
+	pfx := tnet.NewPfx(3221225984, 24) // 192.0.2.0/24
+	p := &rt.Path{
+		Type: rt.BGPPathType,
+		BGPPath: &rt.BGPPath{
+			NextHop:    2851995650, // 169.254.0.2
+			LocalPref:  100,
+			EBGP:       true,
+			Source:     2851995650,
+			RouterID:   3325256705,
+			NeighborAS: 65201,
+		},
+	}
+
+	rib := rt.NewRIB(nil)
+	rib.AddPath(pfx, p)
+
+	s := NewServer(rib, l.Addr().String())
+	s.Start()
+	defer s.Stop()
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Unable to accept: %v", err)
+	}
+	defer c.Close()
+
+	msg := readMessage(t, c)
+	assert.Equal(t, uint8(InitiationMessageType), msg.Header.Type)
+
+	msg = readMessage(t, c)
+	assert.Equal(t, uint8(RouteMonitoringType), msg.Header.Type)
+	rm := msg.Body.(*RouteMonitoring)
+	assert.Equal(t, net.IP{169, 254, 0, 2}, rm.PeerIP())
+	assert.Equal(t, uint32(65201), rm.PeerAS)
+	assert.Equal(t, uint32(3325256705), rm.PeerBGPID)
+	assert.Equal(t, uint8(PeerFlagPostPolicy|PeerFlagLegacyASN), rm.Flags)
+	assert.Equal(t, [4]byte{192, 0, 2, 0}, rm.Update.NLRI.IP)
+	assert.Nil(t, rm.Update.WithdrawnRoutes)
+
+	rib.RemovePath(pfx, p)
+
+	msg = readMessage(t, c)
+	assert.Equal(t, uint8(RouteMonitoringType), msg.Header.Type)
+	rm = msg.Body.(*RouteMonitoring)
+	assert.Equal(t, net.IP{169, 254, 0, 2}, rm.PeerIP())
+	assert.Nil(t, rm.Update.NLRI)
+	assert.Equal(t, [4]byte{192, 0, 2, 0}, rm.Update.WithdrawnRoutes.IP)
+}
+
+func TestPeerDown(t *testing.T) {
+	notification := []byte{
+		255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255,
+		0, 21, packet.NotificationMsg,
+		packet.Cease, packet.AdminShut,
+	}
+
+	tests := []struct {
+		name           string
+		down           bgpserver.SessionDown
+		expectedReason uint8
+		expectedData   []byte
+	}{
+		{
+			name: "NOTIFICATION sent",
+			down: bgpserver.SessionDown{
+				Notification: &packet.BGPNotification{ErrorCode: packet.Cease, ErrorSubcode: packet.AdminShut},
+				Local:        true,
+				Event:        bgpserver.ManualStop,
+			},
+			expectedReason: PeerDownLocalNotification,
+			expectedData:   notification,
+		},
+		{
+			name: "NOTIFICATION received",
+			down: bgpserver.SessionDown{
+				Notification: &packet.BGPNotification{ErrorCode: packet.Cease, ErrorSubcode: packet.AdminShut},
+			},
+			expectedReason: PeerDownRemoteNotification,
+			expectedData:   notification,
+		},
+		{
+			name: "Closed on FSM event",
+			down: bgpserver.SessionDown{
+				Local: true,
+				Event: bgpserver.HoldTimerExpires,
+			},
+			expectedReason: PeerDownLocalNoNotification,
+			expectedData:   []byte{0, 10},
+		},
+		{
+			name:           "Connection failed",
+			expectedReason: PeerDownRemoteNoNotification,
+		},
+	}
+
+	for _, test := range tests {
+		m := peerDown(bgpserver.NeighborInfo{PeerAddress: net.IP{169, 254, 0, 2}}, test.down)
+		assert.Equal(t, uint8(PeerDownNotificationType), m.Header.Type, test.name)
+
+		pd := m.Body.(*PeerDownNotification)
+		assert.Equal(t, test.expectedReason, pd.Reason, test.name)
+		assert.Equal(t, test.expectedData, pd.Data, test.name)
+	}
+}
@@ -0,0 +1,313 @@
+// Package mrt writes routing information in the MRT format (RFC 6396)
+package mrt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	bgpserver "github.com/bio-routing/bio-rd/protocols/bgp/server"
+	"github.com/bio-routing/bio-rd/rt"
+	log "github.com/sirupsen/logrus"
+	"github.com/taktv6/tflow2/convert"
+)
+
+const (
+	// CommonHeaderLen is the length of the header of all MRT records
+	CommonHeaderLen = 12
+
+	// Record types
+	TableDumpV2 = 13
+	BGP4MP      = 16
+
+	// TABLE_DUMP_V2 subtypes
+	PeerIndexTable = 1
+	RIBIPv4Unicast = 2
+
+	// BGP4MP subtypes
+	BGP4MPMessage    = 1
+	BGP4MPMessageAS4 = 4
+
+	// peerTypeAS4 marks PEER_INDEX_TABLE entries with a 4 octet AS number
+	peerTypeAS4 = 0x02
+
+	// recordBufferSize is the number of UPDATEs RecordUpdates buffers before
+	// it drops them
+	recordBufferSize = 1024
+)
+
+// Writer writes MRT records to an io.Writer
+type Writer struct {
+	w   io.Writer
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// NewWriter creates a writer writing to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:   w,
+		now: time.Now,
+	}
+}
+
+// writeRecord writes a record with its common header
+func (w *Writer) writeRecord(ts time.Time, typ uint16, subtype uint16, body []byte) error {
+	if len(body) > math.MaxUint32 {
+		return fmt.Errorf("Record too long: %d", len(body))
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, CommonHeaderLen+len(body)))
+	binary.Write(buf, binary.BigEndian, uint32(ts.Unix()))
+	binary.Write(buf, binary.BigEndian, typ)
+	binary.Write(buf, binary.BigEndian, subtype)
+	binary.Write(buf, binary.BigEndian, uint32(len(body)))
+	buf.Write(body)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err := w.w.Write(buf.Bytes())
+	return err
+}
+
+// peer identifies a peer in the PEER_INDEX_TABLE
+type peer struct {
+	bgpID   uint32
+	address uint32
+	asn     uint32
+}
+
+// WriteTableDump writes all IPv4 BGP paths of rib as a TABLE_DUMP_V2 dump. A
+// PEER_INDEX_TABLE record describing the peers the paths were received from
+// is followed by one RIB_IPV4_UNICAST record per prefix. The time the paths
+// were received is not known, the time of the dump is used instead.
+func (w *Writer) WriteTableDump(rib *rt.RIB, collectorID uint32, viewName string) error {
+	ts := w.now()
+	peers := make([]peer, 0)
+	peerIndex := make(map[peer]uint16)
+
+	routes := make([]*rt.Route, 0)
+	for _, r := range rib.Dump() {
+		if r.Prefix().AFI() != packet.IPv4AFI {
+			continue
+		}
+
+		routes = append(routes, r)
+		for _, p := range r.Paths() {
+			if p.Type != rt.BGPPathType {
+				continue
+			}
+
+			x := pathPeer(p.BGPPath)
+			if _, ok := peerIndex[x]; ok {
+				continue
+			}
+			if len(peers) > math.MaxUint16 {
+				return fmt.Errorf("Too many peers")
+			}
+
+			peerIndex[x] = uint16(len(peers))
+			peers = append(peers, x)
+		}
+	}
+
+	err := w.writeRecord(ts, TableDumpV2, PeerIndexTable, peerIndexTable(collectorID, viewName, peers))
+	if err != nil {
+		return err
+	}
+
+	seq := uint32(0)
+	for _, r := range routes {
+		body, n, err := ribEntries(seq, r, peerIndex, ts)
+		if err != nil {
+			return fmt.Errorf("Unable to serialize %s: %w", r.Prefix(), err)
+		}
+		if n == 0 {
+			continue
+		}
+
+		err = w.writeRecord(ts, TableDumpV2, RIBIPv4Unicast, body)
+		if err != nil {
+			return err
+		}
+		seq++
+	}
+
+	return nil
+}
+
+func pathPeer(b *rt.BGPPath) peer {
+	return peer{
+		bgpID:   b.RouterID,
+		address: b.Source,
+		asn:     b.NeighborAS,
+	}
+}
+
+func peerIndexTable(collectorID uint32, viewName string, peers []peer) []byte {
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.BigEndian, collectorID)
+	binary.Write(buf, binary.BigEndian, uint16(len(viewName)))
+	buf.WriteString(viewName)
+	binary.Write(buf, binary.BigEndian, uint16(len(peers)))
+	for _, p := range peers {
+		buf.WriteByte(peerTypeAS4)
+		binary.Write(buf, binary.BigEndian, p.bgpID)
+		binary.Write(buf, binary.BigEndian, p.address)
+		binary.Write(buf, binary.BigEndian, p.asn)
+	}
+
+	return buf.Bytes()
+}
+
+// ribEntries serializes the RIB_IPV4_UNICAST record of r. n is the number of
+// RIB entries in the record.
+func ribEntries(seq uint32, r *rt.Route, peerIndex map[peer]uint16, ts time.Time) (body []byte, n int, err error) {
+	pfx := r.Prefix()
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.BigEndian, seq)
+	buf.Write(prefix(pfx))
+
+	entries := bytes.NewBuffer(nil)
+	for _, p := range r.Paths() {
+		if p.Type != rt.BGPPathType {
+			continue
+		}
+
+		attrs, err := packet.SerializePathAttributes(p.BGPPath.PathAttributes(!p.BGPPath.EBGP), true)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(attrs) > math.MaxUint16 {
+			return nil, 0, fmt.Errorf("Path attributes too long: %d", len(attrs))
+		}
+
+		binary.Write(entries, binary.BigEndian, peerIndex[pathPeer(p.BGPPath)])
+		binary.Write(entries, binary.BigEndian, uint32(ts.Unix()))
+		binary.Write(entries, binary.BigEndian, uint16(len(attrs)))
+		entries.Write(attrs)
+		n++
+	}
+
+	binary.Write(buf, binary.BigEndian, uint16(n))
+	buf.Write(entries.Bytes())
+
+	return buf.Bytes(), n, nil
+}
+
+// prefix encodes pfx as prefix length followed by the significant octets
+func prefix(pfx *tnet.Prefix) []byte {
+	addr := convert.Uint32Byte(pfx.Addr())
+	return append([]byte{pfx.Pfxlen()}, addr[:(int(pfx.Pfxlen())+7)/8]...)
+}
+
+// BGP4MPMsg is a BGP message exchanged with a peer
+type BGP4MPMsg struct {
+	PeerAS         uint32
+	LocalAS        uint32
+	InterfaceIndex uint16
+	PeerAddress    net.IP
+	LocalAddress   net.IP
+
+	// AS4 is set if the session uses 4 octet AS numbers
+	AS4 bool
+
+	// Message is the BGP message including its header
+	Message []byte
+}
+
+// WriteBGP4MP writes m as BGP4MP_MESSAGE_AS4 record, or as BGP4MP_MESSAGE
+// record with 2 octet AS numbers if the session does not use 4 octet ones
+func (w *Writer) WriteBGP4MP(m *BGP4MPMsg) error {
+	afi := uint16(packet.IPv4AFI)
+	peerAddr, localAddr := m.PeerAddress.To4(), m.LocalAddress.To4()
+	if peerAddr == nil || localAddr == nil {
+		afi = packet.IPv6AFI
+		peerAddr, localAddr = m.PeerAddress.To16(), m.LocalAddress.To16()
+	}
+
+	if peerAddr == nil || localAddr == nil {
+		return fmt.Errorf("Invalid addresses: %v %v", m.PeerAddress, m.LocalAddress)
+	}
+
+	subtype := uint16(BGP4MPMessageAS4)
+	buf := bytes.NewBuffer(nil)
+	if m.AS4 {
+		binary.Write(buf, binary.BigEndian, m.PeerAS)
+		binary.Write(buf, binary.BigEndian, m.LocalAS)
+	} else {
+		subtype = BGP4MPMessage
+		binary.Write(buf, binary.BigEndian, asn2(m.PeerAS))
+		binary.Write(buf, binary.BigEndian, asn2(m.LocalAS))
+	}
+	binary.Write(buf, binary.BigEndian, m.InterfaceIndex)
+	binary.Write(buf, binary.BigEndian, afi)
+	buf.Write(peerAddr)
+	buf.Write(localAddr)
+	buf.Write(m.Message)
+
+	return w.writeRecord(w.now(), BGP4MP, subtype, buf.Bytes())
+}
+
+// asn2 returns asn as 2 octet AS number, AS_TRANS if it does not fit
+func asn2(asn uint32) uint16 {
+	if asn > math.MaxUint16 {
+		return packet.ASTrans
+	}
+
+	return uint16(asn)
+}
+
+// RecordUpdates writes all UPDATEs received from p as BGP4MP messages. The
+// records are written by a separate goroutine so that the FSM of p never
+// waits for w. UPDATEs are dropped if w falls too far behind.
+func (w *Writer) RecordUpdates(p *bgpserver.Peer) {
+	ch := make(chan *BGP4MPMsg, recordBufferSize)
+	go w.writeBGP4MPs(ch)
+
+	p.OnUpdate(func(msg []byte) {
+		info := p.Info()
+		localAddr := info.LocalAddress
+		if localAddr == nil {
+			localAddr = net.IPv4zero
+			if info.PeerAddress.To4() == nil {
+				localAddr = net.IPv6zero
+			}
+		}
+
+		m := &BGP4MPMsg{
+			PeerAS:       info.PeerASN,
+			LocalAS:      info.LocalASN,
+			PeerAddress:  info.PeerAddress,
+			LocalAddress: localAddr,
+			AS4:          info.Capabilities.Has(packet.ASN4CapabilityCode),
+			Message:      append([]byte(nil), msg...),
+		}
+
+		select {
+		case ch <- m:
+		default:
+			log.WithField("peer", info.PeerAddress).Warning("MRT writer too slow, dropping UPDATE")
+		}
+	})
+}
+
+func (w *Writer) writeBGP4MPs(ch <-chan *BGP4MPMsg) {
+	for m := range ch {
+		err := w.WriteBGP4MP(m)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"peer":  m.PeerAddress,
+				"error": err,
+			}).Warning("Unable to write MRT record")
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package mrt
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	tnet "github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/bio-routing/bio-rd/rt"
+	"github.com/stretchr/testify/assert"
+)
+
+func testWriter(buf *bytes.Buffer) *Writer {
+	w := NewWriter(buf)
+	w.now = func() time.Time {
+		return time.Unix(1500000000, 0)
+	}
+
+	return w
+}
+
+func TestWriteTableDump(t *testing.T) {
+	rib := rt.NewRIB(nil)
+	b := &rt.BGPPath{
+		NextHop:    2851995650, // 169.254.0.2
+		EBGP:       true,
+		Source:     2851995650,
+		RouterID:   3325256705, // 198.51.100.1
+		NeighborAS: 65201,
+	}
+	b.SetASPath(packet.ASPath{
+		{Type: packet.ASSequence, Count: 1, ASNs: []uint32{65201}},
+	})
+	rib.AddPath(tnet.NewPfx(3221225984, 24), &rt.Path{ // 192.0.2.0/24
+		Type:    rt.BGPPathType,
+		BGPPath: b,
+	})
+
+	buf := bytes.NewBuffer(nil)
+	err := testWriter(buf).WriteTableDump(rib, 3405803777, "") // 203.0.113.1
+	if err != nil {
+		t.Fatalf("Unable to write table dump: %v", err)
+	}
+
+	expected := []byte{
+		// PEER_INDEX_TABLE
+		0x59, 0x68, 0x2f, 0x00, // Timestamp
+		0, 13, // Type
+		0, 1, // Subtype
+		0, 0, 0, 21, // Length
+		203, 0, 113, 1, // Collector BGP ID
+		0, 0, // View name length
+		0, 1, // Peer count
+		2,               // Peer type
+		198, 51, 100, 1, // Peer BGP ID
+		169, 254, 0, 2, // Peer address
+		0, 0, 0xfe, 0xb1, // Peer AS
+
+		// RIB_IPV4_UNICAST
+		0x59, 0x68, 0x2f, 0x00, // Timestamp
+		0, 13, // Type
+		0, 2, // Subtype
+		0, 0, 0, 38, // Length
+		0, 0, 0, 0, // Sequence number
+		24, 192, 0, 2, // Prefix
+		0, 1, // Entry count
+		0, 0, // Peer index
+		0x59, 0x68, 0x2f, 0x00, // Originated time
+		0, 20, // Attribute length
+		64, 1, 1, 0, // ORIGIN
+		64, 2, 6, 2, 1, 0, 0, 0xfe, 0xb1, // AS_PATH
+		64, 3, 4, 169, 254, 0, 2, // NEXT_HOP
+	}
+
+	assert.Equal(t, expected, buf.Bytes())
+}
+
+func TestWriteBGP4MP(t *testing.T) {
+	msg := []byte{
+		255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255,
+		0, 23, packet.UpdateMsg,
+		0, 0, 0, 0,
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err := testWriter(buf).WriteBGP4MP(&BGP4MPMsg{
+		PeerAS:       65201,
+		LocalAS:      65200,
+		PeerAddress:  net.IP{169, 254, 0, 2},
+		LocalAddress: net.IP{169, 254, 0, 1},
+		AS4:          true,
+		Message:      msg,
+	})
+	if err != nil {
+		t.Fatalf("Unable to write BGP4MP message: %v", err)
+	}
+
+	expected := append([]byte{
+		0x59, 0x68, 0x2f, 0x00, // Timestamp
+		0, 16, // Type
+		0, 4, // Subtype
+		0, 0, 0, 43, // Length
+		0, 0, 0xfe, 0xb1, // Peer AS
+		0, 0, 0xfe, 0xb0, // Local AS
+		0, 0, // Interface index
+		0, 1, // AFI
+		169, 254, 0, 2, // Peer address
+		169, 254, 0, 1, // Local address
+	}, msg...)
+
+	assert.Equal(t, expected, buf.Bytes())
+}
+
+func TestWriteBGP4MPAS2(t *testing.T) {
+	msg := []byte{
+		255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255,
+		0, 19, packet.KeepaliveMsg,
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err := testWriter(buf).WriteBGP4MP(&BGP4MPMsg{
+		PeerAS:       65201,
+		LocalAS:      4200000000,
+		PeerAddress:  net.IP{169, 254, 0, 2},
+		LocalAddress: net.IP{169, 254, 0, 1},
+		Message:      msg,
+	})
+	if err != nil {
+		t.Fatalf("Unable to write BGP4MP message: %v", err)
+	}
+
+	expected := append([]byte{
+		0x59, 0x68, 0x2f, 0x00, // Timestamp
+		0, 16, // Type
+		0, 1, // Subtype
+		0, 0, 0, 35, // Length
+		0xfe, 0xb1, // Peer AS
+		0x5b, 0xa0, // Local AS (AS_TRANS)
+		0, 0, // Interface index
+		0, 1, // AFI
+		169, 254, 0, 2, // Peer address
+		169, 254, 0, 1, // Local address
+	}, msg...)
+
+	assert.Equal(t, expected, buf.Bytes())
+}
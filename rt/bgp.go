@@ -1,25 +1,360 @@
 package rt
 
 import (
+	"bytes"
+	"fmt"
+	"net"
 	"sync"
 
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
 	log "github.com/sirupsen/logrus"
+	"github.com/taktv6/tflow2/convert"
 )
 
 type BGPPath struct {
 	PathIdentifier uint32
 	NextHop        uint32
+	NextHop6       [16]byte
 	LocalPref      uint32
 	ASPath         string
 	ASPathLen      uint16
+	// ASPathSegments is the AS_PATH ASPath and ASPathLen are derived from. It
+	// is shared between copies and must not be modified in place.
+	ASPathSegments packet.ASPath
 	Origin         uint8
 	MED            uint32
 	EBGP           bool
-	Source         uint32
+	IGPMetric      uint32
+	Communities    []uint32
+
+	// ExtendedCommunities are the EXTENDED_COMMUNITIES of the path, e.g.
+	// route targets
+	ExtendedCommunities []packet.ExtendedCommunity
+
+	// ASPathRaw is the AS_PATH as received from a route server client. It is
+	// advertised byte for byte until the AS_PATH is modified, which drops it.
+	ASPathRaw *packet.RawASPath
+
+	// HasMED is set if the path carries a MULTI_EXIT_DISC, so a MED of 0 can
+	// be told apart from none
+	HasMED bool
+
+	// Source is the address of the peer the path was received from
+	Source uint32
+
+	// RouterID is the BGP identifier of the peer the path was received from
+	RouterID uint32
+
+	// NeighborAS is the leftmost AS of the AS path or the local AS for paths
+	// received via iBGP with an empty AS path. MEDs are only compared between
+	// paths of the same neighbor AS.
+	NeighborAS uint32
+
+	// OriginatorID and ClusterList are set by route reflectors (RFC 4456).
+	// ClusterList holds the cluster IDs of all reflectors the path passed,
+	// the most recent first.
+	OriginatorID uint32
+	ClusterList  []uint32
+
+	// ReflectorClient is set for paths received from a route reflector client
+	ReflectorClient bool
+
+	// Confederation is set for paths received from a peer of another member
+	// AS of the confederation
+	Confederation bool
+
+	// AtomicAggregate is set if the path is an aggregate whose AS_PATH lacks
+	// ASes of the routes it was formed from (RFC 4271, 5.1.6)
+	AtomicAggregate bool
+
+	// AggregatorAS and AggregatorAddr name the speaker that formed the
+	// aggregate. AggregatorAddr is zero if there is no AGGREGATOR.
+	AggregatorAS   uint32
+	AggregatorAddr uint32
+
+	// UnknownAttributes are unrecognized optional transitive attributes passed
+	// on to other peers
+	UnknownAttributes []packet.PathAttribute
+
+	// Received holds the attributes as received from the peer before any
+	// import policy was applied. It is nil for locally originated paths.
+	Received *BGPPath
+}
+
+// Copy returns a deep copy of b without its received attributes
+func (b *BGPPath) Copy() *BGPPath {
+	if b == nil {
+		return nil
+	}
+
+	c := *b
+	c.Received = nil
+	if b.Communities != nil {
+		c.Communities = make([]uint32, len(b.Communities))
+		copy(c.Communities, b.Communities)
+	}
+	if b.ExtendedCommunities != nil {
+		c.ExtendedCommunities = make([]packet.ExtendedCommunity, len(b.ExtendedCommunities))
+		copy(c.ExtendedCommunities, b.ExtendedCommunities)
+	}
+	if b.ClusterList != nil {
+		c.ClusterList = make([]uint32, len(b.ClusterList))
+		copy(c.ClusterList, b.ClusterList)
+	}
+	if b.UnknownAttributes != nil {
+		c.UnknownAttributes = make([]packet.PathAttribute, len(b.UnknownAttributes))
+		copy(c.UnknownAttributes, b.UnknownAttributes)
+	}
+	return &c
+}
+
+// SetASPath sets the AS_PATH of b. The neighbor AS is updated if path has one.
+func (b *BGPPath) SetASPath(path packet.ASPath) {
+	b.ASPathSegments = path
+	b.ASPathRaw = nil
+	b.ASPath = path.String()
+	b.ASPathLen = path.Length()
+	if asn, ok := path.NeighborAS(); ok {
+		b.NeighborAS = asn
+	}
+}
+
+// SetReceived retains a copy of the current attributes of b as received attributes
+func (b *BGPPath) SetReceived() {
+	b.Received = b.Copy()
+}
+
+// ReceivedAttributes returns the attributes of b as received from the peer
+func (b *BGPPath) ReceivedAttributes() *BGPPath {
+	if b.Received != nil {
+		return b.Received
+	}
+
+	return b
+}
+
+// Equal checks if b and c carry equal attributes. Received attributes are ignored.
+func (b *BGPPath) Equal(c *BGPPath) bool {
+	if b == nil || c == nil {
+		return b == c
+	}
+
+	if len(b.Communities) != len(c.Communities) {
+		return false
+	}
+	for i := range b.Communities {
+		if b.Communities[i] != c.Communities[i] {
+			return false
+		}
+	}
+
+	if len(b.ExtendedCommunities) != len(c.ExtendedCommunities) {
+		return false
+	}
+	for i := range b.ExtendedCommunities {
+		if b.ExtendedCommunities[i] != c.ExtendedCommunities[i] {
+			return false
+		}
+	}
+
+	if len(b.ClusterList) != len(c.ClusterList) {
+		return false
+	}
+	for i := range b.ClusterList {
+		if b.ClusterList[i] != c.ClusterList[i] {
+			return false
+		}
+	}
+
+	if len(b.UnknownAttributes) != len(c.UnknownAttributes) {
+		return false
+	}
+	for i := range b.UnknownAttributes {
+		x, y := b.UnknownAttributes[i], c.UnknownAttributes[i]
+		if x.TypeCode != y.TypeCode || !bytes.Equal(x.Value.(packet.UnknownAttribute), y.Value.(packet.UnknownAttribute)) {
+			return false
+		}
+	}
+
+	return b.PathIdentifier == c.PathIdentifier &&
+		b.NextHop == c.NextHop &&
+		b.NextHop6 == c.NextHop6 &&
+		b.LocalPref == c.LocalPref &&
+		b.ASPath == c.ASPath &&
+		b.ASPathLen == c.ASPathLen &&
+		b.Origin == c.Origin &&
+		b.MED == c.MED &&
+		b.HasMED == c.HasMED &&
+		b.EBGP == c.EBGP &&
+		b.IGPMetric == c.IGPMetric &&
+		b.Source == c.Source &&
+		b.RouterID == c.RouterID &&
+		b.NeighborAS == c.NeighborAS &&
+		b.OriginatorID == c.OriginatorID &&
+		b.AtomicAggregate == c.AtomicAggregate &&
+		b.AggregatorAS == c.AggregatorAS &&
+		b.AggregatorAddr == c.AggregatorAddr &&
+		b.ReflectorClient == c.ReflectorClient &&
+		b.Confederation == c.Confederation
+}
+
+// NextHopIP returns the next hop of b. IPv6 next hops take precedence.
+func (b *BGPPath) NextHopIP() net.IP {
+	if b.NextHop6 != [net.IPv6len]byte{} {
+		return net.IP(b.NextHop6[:])
+	}
+
+	return net.IP(convert.Uint32Byte(b.NextHop))
+}
+
+// SetNextHop sets the IPv4 or IPv6 next hop of b and clears the other one
+func (b *BGPPath) SetNextHop(addr net.IP) {
+	b.NextHop = 0
+	b.NextHop6 = [net.IPv6len]byte{}
+
+	if x := addr.To4(); x != nil {
+		b.NextHop = convert.Uint32b(x)
+		return
+	}
+
+	copy(b.NextHop6[:], addr.To16())
+}
+
+// PathAttributes returns the path attributes to advertise b with. LOCAL_PREF
+// is only included if localPref is set, i.e. for internal peers.
+func (b *BGPPath) PathAttributes(localPref bool) *packet.PathAttribute {
+	attrs := []*packet.PathAttribute{
+		{
+			TypeCode:   packet.OriginAttr,
+			Transitive: true,
+			Value:      b.Origin,
+		},
+		{
+			TypeCode:   packet.ASPathAttr,
+			Transitive: true,
+			Value:      append(packet.ASPath{}, b.ASPathSegments...),
+			RawASPath:  b.ASPathRaw,
+		},
+		{
+			TypeCode:   packet.NextHopAttr,
+			Transitive: true,
+			Value:      net.IP(convert.Uint32Byte(b.NextHop)),
+		},
+	}
+
+	if b.HasMED {
+		attrs = append(attrs, &packet.PathAttribute{
+			TypeCode: packet.MEDAttr,
+			Optional: true,
+			Value:    b.MED,
+		})
+	}
+
+	if localPref {
+		attrs = append(attrs, &packet.PathAttribute{
+			TypeCode:   packet.LocalPrefAttr,
+			Transitive: true,
+			Value:      b.LocalPref,
+		})
+	}
+
+	if b.AtomicAggregate {
+		attrs = append(attrs, &packet.PathAttribute{
+			TypeCode:   packet.AtomicAggrAttr,
+			Transitive: true,
+		})
+	}
+
+	if b.AggregatorAddr != 0 {
+		aggr := packet.Aggretator{ASN: b.AggregatorAS}
+		copy(aggr.Addr[:], convert.Uint32Byte(b.AggregatorAddr))
+		attrs = append(attrs, &packet.PathAttribute{
+			TypeCode:   packet.AggregatorAttr,
+			Optional:   true,
+			Transitive: true,
+			Value:      aggr,
+		})
+	}
+
+	if len(b.Communities) > 0 {
+		attrs = append(attrs, &packet.PathAttribute{
+			TypeCode:   packet.CommunitiesAttr,
+			Optional:   true,
+			Transitive: true,
+			Value:      b.Communities,
+		})
+	}
+
+	if len(b.ExtendedCommunities) > 0 {
+		attrs = append(attrs, &packet.PathAttribute{
+			TypeCode:   packet.ExtendedCommunitiesAttr,
+			Optional:   true,
+			Transitive: true,
+			Value:      b.ExtendedCommunities,
+		})
+	}
+
+	if b.OriginatorID != 0 {
+		attrs = append(attrs, &packet.PathAttribute{
+			TypeCode: packet.OriginatorIDAttr,
+			Optional: true,
+			Value:    b.OriginatorID,
+		})
+	}
+
+	if len(b.ClusterList) > 0 {
+		attrs = append(attrs, &packet.PathAttribute{
+			TypeCode: packet.ClusterListAttr,
+			Optional: true,
+			Value:    b.ClusterList,
+		})
+	}
+
+	for i := range b.UnknownAttributes {
+		pa := b.UnknownAttributes[i]
+		attrs = append(attrs, &pa)
+	}
+
+	for i := 0; i < len(attrs)-1; i++ {
+		attrs[i].Next = attrs[i+1]
+	}
+
+	return attrs[0]
+}
+
+// OriginatorRouterID returns the ORIGINATOR_ID of b if set, the router ID of the peer b was received from otherwise
+func (b *BGPPath) OriginatorRouterID() uint32 {
+	if b.OriginatorID != 0 {
+		return b.OriginatorID
+	}
+
+	return b.RouterID
+}
+
+// HasCommunity checks if b carries the community c
+func (b *BGPPath) HasCommunity(c uint32) bool {
+	for _, x := range b.Communities {
+		if x == c {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasExtendedCommunity checks if b carries the extended community c
+func (b *BGPPath) HasExtendedCommunity(c packet.ExtendedCommunity) bool {
+	for _, x := range b.ExtendedCommunities {
+		if x == c {
+			return true
+		}
+	}
+
+	return false
 }
 
 type BGPPathManager struct {
-	paths map[BGPPath]*BGPPathCounter
+	paths map[string]*BGPPathCounter
 	mu    sync.Mutex
 }
 
@@ -33,8 +368,14 @@ func NewBGPPathManager() *BGPPathManager {
 	return m
 }
 
+// pathKey returns the key of p in the paths map. BGPPath contains slices and
+// can not be used as map key directly.
+func pathKey(p BGPPath) string {
+	return fmt.Sprintf("%v", p)
+}
+
 func (m *BGPPathManager) pathExists(p BGPPath) bool {
-	if _, ok := m.paths[p]; !ok {
+	if _, ok := m.paths[pathKey(p)]; !ok {
 		return false
 	}
 
@@ -45,14 +386,15 @@ func (m *BGPPathManager) AddPath(p BGPPath) *BGPPath {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	k := pathKey(p)
 	if !m.pathExists(p) {
-		m.paths[p] = &BGPPathCounter{
+		m.paths[k] = &BGPPathCounter{
 			path: &p,
 		}
 	}
 
-	m.paths[p].usageCount++
-	return m.paths[p].path
+	m.paths[k].usageCount++
+	return m.paths[k].path
 }
 
 func (m *BGPPathManager) RemovePath(p BGPPath) {
@@ -64,80 +406,41 @@ func (m *BGPPathManager) RemovePath(p BGPPath) {
 		return
 	}
 
-	m.paths[p].usageCount--
-	if m.paths[p].usageCount == 0 {
-		delete(m.paths, p)
+	k := pathKey(p)
+	m.paths[k].usageCount--
+	if m.paths[k].usageCount == 0 {
+		delete(m.paths, k)
 	}
 }
 
-func (r *Route) bgpPathSelection() (res []*Path) {
-	// TODO: Implement next hop lookup and compare IGP metrics
-	if len(r.paths) == 1 {
-		copy(res, r.paths)
-		return res
+// bgpPathSelection returns the best BGP paths of r and the paths that led the
+// selection before a later path beat them
+func (r *Route) bgpPathSelection() (res []*Path, superseded []*Path) {
+	s := r.bgpSelector()
+	r.igpMetrics = nil
+	if s.resolver != nil {
+		r.igpMetrics = make(map[*Path]uint32)
 	}
 
-	for _, p := range r.paths {
+	for _, p := range s.resolveNextHops(r.pfx, r.paths, r.igpMetrics) {
 		if p.Type != BGPPathType {
 			continue
 		}
 
-		if len(res) == 0 {
-			res = append(res, p)
-			continue
+		var x *Path
+		res, x = s.selectPath(res, p, r.igpMetrics)
+		if x != nil {
+			superseded = append(superseded, x)
 		}
-
-		if res[0].BGPPath.ecmp(p.BGPPath) {
-			res = append(res, p)
-			continue
-		}
-
-		if !res[0].BGPPath.better(p.BGPPath) {
-			continue
-		}
-
-		res = []*Path{p}
 	}
 
-	return res
+	return res, superseded
 }
 
-func (b *BGPPath) better(c *BGPPath) bool {
-	if c.LocalPref < b.LocalPref {
-		return false
-	}
-
-	if c.LocalPref > b.LocalPref {
-		return true
+func (r *Route) bgpSelector() *Selector {
+	if r.selector == nil {
+		return defaultSelector
 	}
 
-	if c.ASPathLen > b.ASPathLen {
-		return false
-	}
-
-	if c.ASPathLen < b.ASPathLen {
-		return true
-	}
-
-	if c.Origin > b.Origin {
-		return false
-	}
-
-	if c.Origin < b.Origin {
-		return true
-	}
-
-	if c.MED > b.MED {
-		return false
-	}
-
-	if c.MED < b.MED {
-		return true
-	}
-
-	return false
-}
-
-func (b *BGPPath) ecmp(c *BGPPath) bool {
-	return b.LocalPref == c.LocalPref && b.ASPathLen == c.ASPathLen && b.Origin == c.Origin && b.MED == c.MED
+	return r.selector
 }
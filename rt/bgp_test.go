@@ -0,0 +1,92 @@
+package rt
+
+import (
+	"testing"
+
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBGPPathReceived(t *testing.T) {
+	p := &BGPPath{
+		LocalPref: 100,
+		ASPath:    "65001 65002",
+		ASPathLen: 2,
+		MED:       10,
+	}
+	p.SetReceived()
+
+	// Import policy rewrites LOCAL_PREF
+	p.LocalPref = 200
+
+	assert.Equal(t, uint32(200), p.LocalPref)
+	assert.Equal(t, uint32(100), p.ReceivedAttributes().LocalPref)
+	assert.Equal(t, "65001 65002", p.ReceivedAttributes().ASPath)
+	assert.Nil(t, p.Received.Received)
+
+	local := &BGPPath{
+		LocalPref: 100,
+	}
+	assert.Equal(t, local, local.ReceivedAttributes())
+}
+
+func TestBGPPathSetASPath(t *testing.T) {
+	b := &BGPPath{
+		NeighborAS: 65200,
+	}
+
+	b.SetASPath(packet.ASPath{
+		{Type: packet.ASConfedSequence, ASNs: []uint32{65101, 65102}},
+		{Type: packet.ASSequence, ASNs: []uint32{65001, 65002}},
+		{Type: packet.ASSet, ASNs: []uint32{65003, 65004}},
+	})
+	assert.Equal(t, "[65101 65102] 65001 65002 (65003 65004)", b.ASPath)
+	assert.Equal(t, uint16(3), b.ASPathLen)
+	assert.Equal(t, uint32(65001), b.NeighborAS)
+
+	// Paths only crossing the confederation keep the neighbor AS
+	b.NeighborAS = 65200
+	b.SetASPath(packet.ASPath{
+		{Type: packet.ASConfedSequence, ASNs: []uint32{65101}},
+	})
+	assert.Equal(t, uint16(0), b.ASPathLen)
+	assert.Equal(t, uint32(65200), b.NeighborAS)
+}
+
+func TestBGPPathEqual(t *testing.T) {
+	a := &BGPPath{
+		LocalPref: 100,
+	}
+	a.SetReceived()
+
+	b := &BGPPath{
+		LocalPref: 100,
+	}
+
+	assert.True(t, a.Equal(b))
+
+	b.LocalPref = 200
+	assert.False(t, a.Equal(b))
+}
+
+func TestBGPPathCommunities(t *testing.T) {
+	a := &BGPPath{
+		Communities: []uint32{0xffffff01, 0xfc000064},
+	}
+	a.SetReceived()
+
+	assert.True(t, a.HasCommunity(0xffffff01))
+	assert.True(t, a.HasCommunity(0xfc000064))
+	assert.False(t, a.HasCommunity(0xfc000065))
+
+	a.Communities[0] = 0
+	assert.True(t, a.ReceivedAttributes().HasCommunity(0xffffff01))
+
+	b := &BGPPath{
+		Communities: []uint32{0, 0xfc000064},
+	}
+	assert.True(t, a.Equal(b))
+
+	b.Communities = b.Communities[:1]
+	assert.False(t, a.Equal(b))
+}
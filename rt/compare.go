@@ -0,0 +1,77 @@
+package rt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Compare compares the routes in a and b including the attributes of their best paths.
+// If they differ a human readable list of differences is returned.
+func Compare(a, b Trie) (equal bool, diff string) {
+	routes := make(map[string]*Route)
+	a.Walk(func(r *Route) {
+		routes[r.Prefix().String()] = r
+	})
+
+	d := make([]string, 0)
+	b.Walk(func(r *Route) {
+		pfx := r.Prefix().String()
+		x, ok := routes[pfx]
+		if !ok {
+			d = append(d, fmt.Sprintf("%s: missing in a", pfx))
+			return
+		}
+		delete(routes, pfx)
+
+		pa, pb := x.selectPaths(), r.selectPaths()
+		if !pathsEqual(pa, pb) {
+			d = append(d, fmt.Sprintf("%s: best paths differ: a=%s b=%s", pfx, pathsString(pa), pathsString(pb)))
+		}
+	})
+
+	for pfx := range routes {
+		d = append(d, fmt.Sprintf("%s: missing in b", pfx))
+	}
+
+	if len(d) == 0 {
+		return true, ""
+	}
+
+	sort.Strings(d)
+	return false, strings.Join(d, "\n")
+}
+
+// pathsEqual checks if a and b contain the same paths regardless of their order
+func pathsEqual(a, b []*Path) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	used := make([]bool, len(b))
+	for _, p := range a {
+		found := false
+		for i, q := range b {
+			if !used[i] && p.Equal(q) {
+				used[i] = true
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func pathsString(paths []*Path) string {
+	ret := make([]string, 0, len(paths))
+	for _, p := range paths {
+		ret = append(ret, p.String())
+	}
+
+	return "[" + strings.Join(ret, ", ") + "]"
+}
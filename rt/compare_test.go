@@ -0,0 +1,70 @@
+package rt
+
+import (
+	"testing"
+
+	net "github.com/bio-routing/bio-rd/net"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	routes := func(l Trie, localPref uint32, extra bool) Trie {
+		l.Insert(NewRoute(net.NewPfx(strAddr("10.0.0.0"), 8), []*Path{
+			{Type: BGPPathType, BGPPath: &BGPPath{LocalPref: localPref, NextHop: strAddr("192.0.2.1")}},
+			{Type: BGPPathType, BGPPath: &BGPPath{LocalPref: 50, NextHop: strAddr("192.0.2.2")}},
+		}))
+		l.Insert(NewRoute(net.NewPfx(strAddr("192.168.0.0"), 16), []*Path{
+			{Type: StaticPathType, StaticPath: &StaticPath{NextHop: strAddr("192.0.2.3")}},
+		}))
+		if extra {
+			l.Insert(NewRoute(net.NewPfx(strAddr("172.16.0.0"), 12), []*Path{
+				{Type: StaticPathType, StaticPath: &StaticPath{NextHop: strAddr("192.0.2.3")}},
+			}))
+		}
+
+		return l
+	}
+
+	tests := []struct {
+		name     string
+		a        Trie
+		b        Trie
+		expected bool
+		diff     string
+	}{
+		{
+			name:     "Identical",
+			a:        routes(New(), 100, false),
+			b:        routes(New(), 100, false),
+			expected: true,
+		},
+		{
+			name:     "Identical in different implementations",
+			a:        routes(New(), 100, false),
+			b:        routes(NewPrefixMap(), 100, false),
+			expected: true,
+		},
+		{
+			name:     "Prefix missing",
+			a:        routes(New(), 100, true),
+			b:        routes(New(), 100, false),
+			expected: false,
+			diff:     "172.16.0.0/12: missing in b",
+		},
+		{
+			name:     "Different best path",
+			a:        routes(New(), 100, false),
+			b:        routes(New(), 10, false),
+			expected: false,
+			diff: "10.0.0.0/8: best paths differ: " +
+				`a=[bgp (next hop 192.0.2.1, local pref 100, AS path "", origin 0, MED 0, IGP metric 0, eBGP false)] ` +
+				`b=[bgp (next hop 192.0.2.2, local pref 50, AS path "", origin 0, MED 0, IGP metric 0, eBGP false)]`,
+		},
+	}
+
+	for _, test := range tests {
+		res, diff := Compare(test.a, test.b)
+		assert.Equal(t, test.expected, res, test.name)
+		assert.Equal(t, test.diff, diff, test.name)
+	}
+}
@@ -0,0 +1,168 @@
+package rt
+
+import (
+	"sync"
+
+	"github.com/bio-routing/bio-rd/net"
+)
+
+// Leak copies the active paths of from into to as long as policy accepts
+// them, e.g. to leak routes between the RIBs of VRFs whose import route
+// targets match. nil accepts all paths. policy may adjust the leaked copies,
+// e.g. set another next hop. Leaked copies bypass the import policy of to and
+// are withdrawn from to once their source disappears from from or policy
+// rejects it. Paths leaked into from are not leaked again, so leaking in both
+// directions does not loop. Leaking runs asynchronously as from notifies its
+// clients with its lock held. The returned function stops leaking and
+// withdraws all leaked copies.
+func Leak(from *RIB, to *RIB, policy Policy) (stop func()) {
+	l := &leak{
+		from:    from,
+		to:      to,
+		policy:  policy,
+		changes: make(map[string]*leakChange),
+		trigger: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		leaked:  make(map[string]*leakedRoute),
+	}
+	from.Register(l)
+	from.Refresh(l)
+
+	go l.run()
+
+	return func() {
+		from.Unregister(l)
+		close(l.done)
+		<-l.stopped
+		l.withdrawAll()
+	}
+}
+
+// leak copies the paths of one RIB into another
+type leak struct {
+	from   *RIB
+	to     *RIB
+	policy Policy
+
+	mu      sync.Mutex
+	changes map[string]*leakChange
+	trigger chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+
+	// leaked holds the copies added to to by prefix. It is only accessed by
+	// run and after it stopped.
+	leaked map[string]*leakedRoute
+}
+
+// leakChange is a change of the active paths of a prefix of the source RIB
+type leakChange struct {
+	pfx   *net.Prefix
+	paths []*Path
+}
+
+// leakedRoute holds the copies of the paths of a prefix added to the target RIB
+type leakedRoute struct {
+	pfx   *net.Prefix
+	paths []*Path
+}
+
+// UpdateActivePaths queues the change of the active paths of pfx. A change
+// not leaked yet is replaced.
+func (l *leak) UpdateActivePaths(pfx *net.Prefix, paths []*Path) {
+	l.mu.Lock()
+	l.changes[pfx.String()] = &leakChange{
+		pfx:   pfx,
+		paths: paths,
+	}
+	l.mu.Unlock()
+
+	select {
+	case l.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (l *leak) run() {
+	defer close(l.stopped)
+
+	for {
+		select {
+		case <-l.trigger:
+			l.leakChanges()
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// leakChanges replaces the copies of all prefixes changed since the last call
+func (l *leak) leakChanges() {
+	l.mu.Lock()
+	changes := l.changes
+	l.changes = make(map[string]*leakChange)
+	l.mu.Unlock()
+
+	for key, c := range changes {
+		var old []*Path
+		if r, ok := l.leaked[key]; ok {
+			old = r.paths
+		}
+
+		paths := l.copies(c.pfx, c.paths)
+		l.to.replaceLeaked(c.pfx, old, paths)
+		if len(paths) == 0 {
+			delete(l.leaked, key)
+			continue
+		}
+
+		l.leaked[key] = &leakedRoute{
+			pfx:   c.pfx,
+			paths: paths,
+		}
+	}
+}
+
+// copies returns the copies of paths accepted by the policy. Paths leaked from
+// another RIB are skipped.
+func (l *leak) copies(pfx *net.Prefix, paths []*Path) []*Path {
+	var res []*Path
+	for _, p := range paths {
+		if p.leakedFrom != nil {
+			continue
+		}
+
+		x := p
+		if l.policy != nil {
+			var accept bool
+			x, accept = l.policy.Process(pfx, p)
+			if !accept {
+				continue
+			}
+		}
+
+		c := *x
+		c.leakedFrom = l.from
+		res = append(res, &c)
+	}
+
+	return res
+}
+
+// withdrawAll removes all copies from the target RIB
+func (l *leak) withdrawAll() {
+	for key, r := range l.leaked {
+		l.to.replaceLeaked(r.pfx, r.paths, nil)
+		delete(l.leaked, key)
+	}
+}
+
+// replaceLeaked replaces the leaked paths old of pfx by new. Clients are
+// notified once. The import policy does not apply to leaked paths.
+func (rib *RIB) replaceLeaked(pfx *net.Prefix, old []*Path, new []*Path) {
+	rib.mu.Lock()
+	defer rib.mu.Unlock()
+
+	rib.replacePaths(pfx, old, new)
+}
@@ -0,0 +1,72 @@
+package rt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/net"
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// routeTargetPolicy accepts BGP paths carrying a route target
+type routeTargetPolicy packet.ExtendedCommunity
+
+func (r routeTargetPolicy) Process(pfx *net.Prefix, p *Path) (*Path, bool) {
+	return p, p.Type == BGPPathType && p.BGPPath.HasExtendedCommunity(packet.ExtendedCommunity(r))
+}
+
+func pathWithRouteTarget(source uint32, target packet.ExtendedCommunity) *Path {
+	return &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			NextHop:             3325256705, // 198.51.100.1
+			Source:              source,
+			ExtendedCommunities: []packet.ExtendedCommunity{target},
+		},
+	}
+}
+
+func assertLeaked(t *testing.T, rib *RIB, pfx *net.Prefix, n int, msg string) {
+	assert.Eventually(t, func() bool {
+		r := rib.Get(pfx)
+		if n == 0 {
+			return r == nil
+		}
+
+		return r != nil && len(r.ActivePaths()) == n
+	}, time.Second, time.Millisecond, msg)
+}
+
+func TestLeak(t *testing.T) {
+	vrfA := NewRIB(nil)
+	vrfB := NewRIB(nil)
+	pfx := net.NewPfx(3221225984, 24)    // 192.0.2.0/24
+	other := net.NewPfx(3221226240, 24)  // 192.0.3.0/24
+	native := net.NewPfx(3221226496, 24) // 192.0.4.0/24
+	target := packet.TwoOctetASRouteTarget(65000, 1)
+
+	vrfA.AddPath(other, pathWithRouteTarget(1, packet.TwoOctetASRouteTarget(65000, 2)))
+	stop := Leak(vrfA, vrfB, routeTargetPolicy(target))
+	stopBack := Leak(vrfB, vrfA, nil)
+	defer stopBack()
+
+	p := pathWithRouteTarget(1, target)
+	vrfA.AddPath(pfx, p)
+	assertLeaked(t, vrfB, pfx, 1, "Route with matching route target was not leaked")
+	assert.True(t, vrfB.Get(pfx).ActivePaths()[0].BGPPath.Equal(p.BGPPath))
+
+	// Paths of the target RIB are leaked back, leaked paths are not
+	vrfB.AddPath(native, pathWithRouteTarget(2, target))
+	assertLeaked(t, vrfA, native, 1, "Route was not leaked back")
+	assert.Len(t, vrfA.Get(pfx).Paths(), 1, "Leaked path was leaked back")
+
+	vrfA.RemovePath(pfx, p)
+	assertLeaked(t, vrfB, pfx, 0, "Leaked route was not withdrawn")
+	assert.Nil(t, vrfB.Get(other), "Route without matching route target was leaked")
+
+	vrfA.AddPath(pfx, p)
+	assertLeaked(t, vrfB, pfx, 1, "Route was not leaked again")
+	stop()
+	assert.Nil(t, vrfB.Get(pfx), "Leaked route was not withdrawn once leaking stopped")
+}
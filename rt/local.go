@@ -0,0 +1,43 @@
+package rt
+
+// LocalPath is the path of a locally originated prefix. Local paths are
+// preferred over BGP paths and lose against static paths.
+type LocalPath struct {
+	Origin uint8
+
+	// NextHop is the next hop the prefix is advertised with. Zero advertises
+	// the local address of the session (next hop self).
+	NextHop uint32
+
+	Communities []uint32
+}
+
+// Equal checks if l and m carry equal attributes
+func (l *LocalPath) Equal(m *LocalPath) bool {
+	if l == nil || m == nil {
+		return l == m
+	}
+
+	if l.Origin != m.Origin || l.NextHop != m.NextHop || len(l.Communities) != len(m.Communities) {
+		return false
+	}
+
+	for i := range l.Communities {
+		if l.Communities[i] != m.Communities[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// localPathSelection returns the local paths of r
+func (r *Route) localPathSelection() (res []*Path) {
+	for _, p := range r.paths {
+		if p.Type == LocalPathType {
+			res = append(res, p)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,75 @@
+package rt
+
+// GracefulShutdownCommunity is the well-known GRACEFUL_SHUTDOWN community
+// 65535:0 (RFC 8326)
+const GracefulShutdownCommunity = 0xffff0000
+
+// EnterMaintenance prepares for planned maintenance (RFC 8326). All paths
+// passed to clients are tagged with GRACEFUL_SHUTDOWN and BGP paths get a
+// LOCAL_PREF of 0, so peers move their traffic to other paths before the
+// sessions go down. Clients are notified of all routes.
+func (rib *RIB) EnterMaintenance() {
+	rib.setMaintenance(true)
+}
+
+// ExitMaintenance ends maintenance. Clients are notified of all routes with
+// their original attributes.
+func (rib *RIB) ExitMaintenance() {
+	rib.setMaintenance(false)
+}
+
+// InMaintenance checks if rib is in maintenance
+func (rib *RIB) InMaintenance() bool {
+	rib.mu.RLock()
+	defer rib.mu.RUnlock()
+
+	return rib.maintenance
+}
+
+func (rib *RIB) setMaintenance(m bool) {
+	rib.mu.Lock()
+	defer rib.mu.Unlock()
+
+	if rib.maintenance == m {
+		return
+	}
+	rib.maintenance = m
+
+	for _, routes := range []Trie{rib.routes4, rib.routes6} {
+		routes.Walk(func(r *Route) {
+			paths := rib.exportPaths(r.Prefix(), r.activePaths)
+			for _, c := range rib.clients {
+				c.UpdateActivePaths(r.Prefix(), copyPaths(paths))
+			}
+		})
+	}
+}
+
+// gracefulShutdownPaths returns copies of paths tagged with GRACEFUL_SHUTDOWN
+func gracefulShutdownPaths(paths []*Path) []*Path {
+	res := make([]*Path, 0, len(paths))
+	for _, p := range paths {
+		res = append(res, gracefulShutdownPath(p))
+	}
+
+	return res
+}
+
+func gracefulShutdownPath(p *Path) *Path {
+	c := *p
+	switch p.Type {
+	case BGPPathType:
+		c.BGPPath = p.BGPPath.Copy()
+		c.BGPPath.Received = p.BGPPath.Received
+		c.BGPPath.LocalPref = 0
+		if !c.BGPPath.HasCommunity(GracefulShutdownCommunity) {
+			c.BGPPath.Communities = append(c.BGPPath.Communities, GracefulShutdownCommunity)
+		}
+	case LocalPathType:
+		l := *p.LocalPath
+		l.Communities = append(append([]uint32(nil), p.LocalPath.Communities...), GracefulShutdownCommunity)
+		c.LocalPath = &l
+	}
+
+	return &c
+}
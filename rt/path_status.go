@@ -0,0 +1,123 @@
+package rt
+
+import (
+	"fmt"
+
+	net "github.com/bio-routing/bio-rd/net"
+)
+
+// PathStatus is the outcome of path selection for a path of a route
+type PathStatus uint8
+
+const (
+	// PathBest is the first active path of a route
+	PathBest PathStatus = iota
+
+	// PathActive is an active path equal to the best path in all steps
+	PathActive
+
+	// PathEligible is a path that took part in path selection and lost
+	PathEligible
+
+	// PathIneligible is a path that was not considered by path selection
+	PathIneligible
+)
+
+var pathStatusNames = map[PathStatus]string{
+	PathBest:       "best",
+	PathActive:     "active",
+	PathEligible:   "eligible",
+	PathIneligible: "ineligible",
+}
+
+func (s PathStatus) String() string {
+	return pathStatusNames[s]
+}
+
+var pathTypeNames = map[uint8]string{
+	StaticPathType: "static",
+	BGPPathType:    "BGP",
+	OSPFPathType:   "OSPF",
+	ISISPathType:   "IS-IS",
+	LocalPathType:  "local",
+}
+
+// PathWithStatus is a path of a route along with its selection outcome
+type PathWithStatus struct {
+	Path   *Path
+	Status PathStatus
+
+	// Reason explains why the path is not active. It is empty for active paths.
+	Reason string
+}
+
+// AllPaths returns all paths of the route for pfx with their selection
+// outcome, active paths first. ok is false if there is no route for pfx.
+func (rib *RIB) AllPaths(pfx *net.Prefix) (paths []PathWithStatus, ok bool) {
+	rib.mu.RLock()
+	defer rib.mu.RUnlock()
+
+	r := rib.get(pfx)
+	if r == nil {
+		return nil, false
+	}
+
+	return r.pathStatuses(), true
+}
+
+// pathStatuses returns the paths of r with their selection outcome
+func (r *Route) pathStatuses() []PathWithStatus {
+	res := make([]PathWithStatus, 0, len(r.paths))
+	for i, p := range r.activePaths {
+		status := PathActive
+		if i == 0 {
+			status = PathBest
+		}
+
+		res = append(res, PathWithStatus{Path: p, Status: status})
+	}
+
+	for _, p := range r.paths {
+		if containsPath(r.activePaths, p) {
+			continue
+		}
+
+		res = append(res, r.inactivePathStatus(p))
+	}
+
+	return res
+}
+
+// inactivePathStatus returns the status of the inactive path p of r
+func (r *Route) inactivePathStatus(p *Path) PathWithStatus {
+	if p.Type != r.protocol {
+		return PathWithStatus{
+			Path:   p,
+			Status: PathIneligible,
+			Reason: fmt.Sprintf("%s path preferred", pathTypeNames[r.protocol]),
+		}
+	}
+
+	if p.Type == BGPPathType && r.bgpSelector().resolver != nil {
+		if _, ok := r.igpMetrics[p]; !ok {
+			return PathWithStatus{Path: p, Status: PathIneligible, Reason: "next hop unreachable"}
+		}
+	}
+
+	res := PathWithStatus{Path: p, Status: PathEligible, Reason: "not selected"}
+	if len(r.activePaths) == 0 {
+		return res
+	}
+
+	best := r.activePaths[0]
+	switch p.Type {
+	case StaticPathType:
+		res.Reason = fmt.Sprintf("priority %d worse than %d", p.StaticPath.Priority, best.StaticPath.Priority)
+	case BGPPathType:
+		if step, ok := r.bgpSelector().decidingStep(best, p, r.igpMetrics); ok {
+			res.Reason = fmt.Sprintf("lost on %s", step)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,113 @@
+package rt
+
+import (
+	"sort"
+
+	"github.com/bio-routing/bio-rd/net"
+)
+
+// PrefixMap is a Trie implementation keeping one hash map per prefix length.
+// Exact matches are O(1), LPM costs one lookup per prefix length. It only holds IPv4 routes.
+type PrefixMap struct {
+	routes [33]map[uint32]*Route
+}
+
+// NewPrefixMap creates a new empty PrefixMap
+func NewPrefixMap() *PrefixMap {
+	return &PrefixMap{}
+}
+
+// Insert inserts a route into the PrefixMap
+func (pm *PrefixMap) Insert(route *Route) {
+	l := route.Pfxlen()
+	if pm.routes[l] == nil {
+		pm.routes[l] = make(map[uint32]*Route)
+	}
+
+	addr := route.Prefix().Addr()
+	if r, ok := pm.routes[l][addr]; ok {
+		r.AddPaths(route.paths)
+		return
+	}
+
+	pm.routes[l][addr] = route
+}
+
+// RemovePfx removes the route for pfx from the PrefixMap
+func (pm *PrefixMap) RemovePfx(pfx *net.Prefix) {
+	delete(pm.routes[pfx.Pfxlen()], pfx.Addr())
+}
+
+// Get get's prefix pfx from the PrefixMap
+func (pm *PrefixMap) Get(pfx *net.Prefix, moreSpecifics bool) []*Route {
+	r, ok := pm.routes[pfx.Pfxlen()][pfx.Addr()]
+	if !ok {
+		return nil
+	}
+
+	if !moreSpecifics {
+		return []*Route{r}
+	}
+
+	res := []*Route{r}
+	for l := int(pfx.Pfxlen()) + 1; l <= 32; l++ {
+		for _, x := range pm.routes[l] {
+			if pfx.Contains(x.Prefix()) {
+				res = append(res, x)
+			}
+		}
+	}
+
+	sortRoutes(res)
+	return res
+}
+
+// LPM performs a longest prefix match for pfx on the PrefixMap
+func (pm *PrefixMap) LPM(pfx *net.Prefix) (res []*Route) {
+	for l := uint8(0); l <= pfx.Pfxlen(); l++ {
+		if len(pm.routes[l]) == 0 {
+			continue
+		}
+
+		if r, ok := pm.routes[l][pfx.Addr()&mask(l)]; ok {
+			res = append(res, r)
+		}
+	}
+
+	return res
+}
+
+// Walk calls f for every route in the PrefixMap
+func (pm *PrefixMap) Walk(f func(route *Route)) {
+	res := make([]*Route, 0)
+	for l := range pm.routes {
+		for _, r := range pm.routes[l] {
+			res = append(res, r)
+		}
+	}
+
+	sortRoutes(res)
+	for _, r := range res {
+		f(r)
+	}
+}
+
+// sortRoutes sorts routes by address and prefix length, the order a trie walk yields
+func sortRoutes(routes []*Route) {
+	sort.Slice(routes, func(i, j int) bool {
+		a, b := routes[i].Prefix(), routes[j].Prefix()
+		if a.Addr() != b.Addr() {
+			return a.Addr() < b.Addr()
+		}
+
+		return a.Pfxlen() < b.Pfxlen()
+	})
+}
+
+func mask(pfxlen uint8) uint32 {
+	if pfxlen == 0 {
+		return 0
+	}
+
+	return ^uint32(0) << (32 - pfxlen)
+}
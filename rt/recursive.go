@@ -0,0 +1,92 @@
+package rt
+
+import (
+	gonet "net"
+
+	"github.com/bio-routing/bio-rd/net"
+)
+
+// ResolveRecursively makes rib resolve the next hops of its BGP paths in its
+// own routes. A next hop is reachable if the most specific route covering it
+// that is not a BGP route has active paths, e.g. an IGP or static route. BGP
+// routes never resolve next hops, so resolution can not loop. A next hop
+// within the prefix of its route has to resolve to a more specific route, or
+// the route would be forwarded through itself once installed. The IGP metric
+// is 0 as routes of other protocols carry none. BGP paths are selected again
+// whenever the active paths of a route of another protocol change. The
+// selector rib was created with is given a new next hop resolver, so it must
+// not be shared with other RIBs.
+func (rib *RIB) ResolveRecursively() {
+	rib.mu.Lock()
+	defer rib.mu.Unlock()
+
+	if rib.selector == nil {
+		rib.selector = NewSelector()
+		for _, routes := range []Trie{rib.routes4, rib.routes6} {
+			routes.Walk(func(r *Route) {
+				r.SetSelector(rib.selector)
+			})
+		}
+	}
+
+	rib.selector.SetNextHopResolver(recursiveResolver{rib: rib})
+	rib.recursive = true
+	rib.reselect()
+}
+
+// recursiveResolver resolves next hops in the RIB it belongs to. It is only
+// called during path selection with the RIB locked.
+type recursiveResolver struct {
+	rib *RIB
+}
+
+func (r recursiveResolver) ResolveNextHop(addr gonet.IP) (metric uint32, ok bool) {
+	_, ok = r.resolve(addr)
+	return 0, ok
+}
+
+// resolveRouteNextHop resolves the next hop addr of a path of the route for
+// pfx. A next hop within pfx that resolves to pfx itself or to a route
+// covering pfx is rejected, as the route would be forwarded through itself
+// once installed. A more specific route towards the next hop takes precedence
+// over pfx in forwarding, so it resolves the next hop.
+func (r recursiveResolver) resolveRouteNextHop(pfx *net.Prefix, addr gonet.IP) (metric uint32, ok bool) {
+	via, ok := r.resolve(addr)
+	if !ok {
+		return 0, false
+	}
+
+	if nh := hostPrefix(addr); covers(pfx, nh) && covers(via, pfx) {
+		return 0, false
+	}
+
+	return 0, true
+}
+
+// resolve returns the prefix of the most specific route resolving addr
+func (r recursiveResolver) resolve(addr gonet.IP) (via *net.Prefix, ok bool) {
+	pfx := hostPrefix(addr)
+	if pfx == nil {
+		return nil, false
+	}
+
+	routes := r.rib.routes(pfx).LPM(pfx)
+	for i := len(routes) - 1; i >= 0; i-- {
+		if resolvesNextHops(routes[i].activePaths) {
+			return routes[i].Prefix(), true
+		}
+	}
+
+	return nil, false
+}
+
+// covers checks if pfx equals or contains x
+func covers(pfx *net.Prefix, x *net.Prefix) bool {
+	return pfx.Equal(x) || pfx.Contains(x)
+}
+
+// resolvesNextHops checks if a route with the active paths paths resolves BGP
+// next hops
+func resolvesNextHops(paths []*Path) bool {
+	return len(paths) > 0 && paths[0].Type != BGPPathType
+}
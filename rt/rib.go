@@ -0,0 +1,548 @@
+package rt
+
+import (
+	gonet "net"
+	"sync"
+
+	"github.com/bio-routing/bio-rd/net"
+	"github.com/taktv6/tflow2/convert"
+)
+
+// RIBClient is notified of changes of the active paths of a RIB
+type RIBClient interface {
+	// UpdateActivePaths is called with the new active paths of pfx. paths is
+	// empty if pfx was removed from the RIB.
+	UpdateActivePaths(pfx *net.Prefix, paths []*Path)
+}
+
+// Policy filters and modifies paths imported into or exported from a RIB
+type Policy interface {
+	// Process returns the path to use instead of p. p must not be modified.
+	// accept is false if p is rejected. The result must only depend on pfx
+	// and the attributes of p.
+	Process(pfx *net.Prefix, p *Path) (res *Path, accept bool)
+}
+
+// RIB holds IPv4 and IPv6 routes keyed by prefix and runs path selection on
+// them. It serves as Adj-RIB-In, Loc-RIB or Adj-RIB-Out. A RIB is safe for
+// concurrent use. Clients are called with the RIB locked and must not call back
+// into it.
+type RIB struct {
+	mu           sync.RWMutex
+	routes4      Trie
+	routes6      Trie
+	selector     *Selector
+	clients      []RIBClient
+	importPolicy Policy
+	exportPolicy Policy
+	maintenance  bool
+
+	// recursive is set if the BGP next hops are resolved in the RIB itself
+	recursive bool
+
+	// imported holds the results of the import policy by the path they were
+	// imported from. nil marks a rejected path.
+	imported map[importKey]*Path
+}
+
+// importKey identifies a path passed to AddPath by its source
+type importKey struct {
+	pfx    string
+	typ    uint8
+	source uint32
+	pathID uint32
+}
+
+func newImportKey(pfx *net.Prefix, p *Path) importKey {
+	k := importKey{
+		pfx: pfx.String(),
+		typ: p.Type,
+	}
+
+	switch p.Type {
+	case BGPPathType:
+		k.source = p.BGPPath.Source
+		k.pathID = p.BGPPath.PathIdentifier
+	case StaticPathType:
+		k.source = p.StaticPath.NextHop
+	}
+
+	return k
+}
+
+var _ RIBClient = &RIB{}
+
+// NewRIB creates an empty RIB. s is used for BGP path selection, nil selects
+// the standard decision process.
+func NewRIB(s *Selector) *RIB {
+	return &RIB{
+		routes4:  New(),
+		routes6:  New(),
+		selector: s,
+		imported: make(map[importKey]*Path),
+	}
+}
+
+// routes returns the trie holding routes of the address family of pfx
+func (rib *RIB) routes(pfx *net.Prefix) Trie {
+	if pfx.AFI() == net.IPv6AFI {
+		return rib.routes6
+	}
+
+	return rib.routes4
+}
+
+// SetImportPolicy sets the policy paths run through before they are added to
+// the RIB. Paths already in the RIB are not affected.
+func (rib *RIB) SetImportPolicy(p Policy) {
+	rib.mu.Lock()
+	defer rib.mu.Unlock()
+
+	rib.importPolicy = p
+}
+
+// SetExportPolicy sets the policy active paths run through before clients are
+// notified. Clients see a prefix without paths if all of them are rejected.
+func (rib *RIB) SetExportPolicy(p Policy) {
+	rib.mu.Lock()
+	defer rib.mu.Unlock()
+
+	rib.exportPolicy = p
+}
+
+// Register adds a client notified of active path changes
+func (rib *RIB) Register(c RIBClient) {
+	rib.mu.Lock()
+	defer rib.mu.Unlock()
+
+	rib.clients = append(rib.clients, c)
+}
+
+// Unregister removes a client
+func (rib *RIB) Unregister(c RIBClient) {
+	rib.mu.Lock()
+	defer rib.mu.Unlock()
+
+	for i := range rib.clients {
+		if rib.clients[i] == c {
+			rib.clients = append(rib.clients[:i], rib.clients[i+1:]...)
+			return
+		}
+	}
+}
+
+// AddPath adds p to the route for pfx. Adding a path the route already has is a no-op.
+func (rib *RIB) AddPath(pfx *net.Prefix, p *Path) {
+	rib.ReplacePath(pfx, nil, p)
+}
+
+// ReplacePath removes old from and adds new to the route for pfx in one step.
+// Clients are notified once. old or new may be nil. new is run through the
+// import policy. old is removed as it was imported, so paths are removed as
+// they were passed to AddPath even if the import policy changed since.
+func (rib *RIB) ReplacePath(pfx *net.Prefix, old *Path, new *Path) {
+	rib.mu.Lock()
+	defer rib.mu.Unlock()
+
+	old = rib.forgetImported(pfx, old)
+	new = rib.recordImported(pfx, new)
+	rib.replacePath(pfx, old, new)
+}
+
+// recordImported runs p through the import policy and remembers the result for
+// removing it later. It returns nil if p is nil or rejected.
+func (rib *RIB) recordImported(pfx *net.Prefix, p *Path) *Path {
+	if p == nil {
+		return nil
+	}
+
+	k := newImportKey(pfx, p)
+	if rib.importPolicy == nil {
+		delete(rib.imported, k)
+		return p
+	}
+
+	x := rib.importPath(pfx, p)
+	rib.imported[k] = x
+	return x
+}
+
+// forgetImported returns the path p was imported as and forgets it. p is
+// returned if it was imported without import policy.
+func (rib *RIB) forgetImported(pfx *net.Prefix, p *Path) *Path {
+	if p == nil {
+		return nil
+	}
+
+	k := newImportKey(pfx, p)
+	x, ok := rib.imported[k]
+	if !ok {
+		return p
+	}
+
+	delete(rib.imported, k)
+	return x
+}
+
+func (rib *RIB) replacePath(pfx *net.Prefix, old *Path, new *Path) {
+	var oldPaths, newPaths []*Path
+	if old != nil {
+		oldPaths = []*Path{old}
+	}
+	if new != nil {
+		newPaths = []*Path{new}
+	}
+
+	rib.replacePaths(pfx, oldPaths, newPaths)
+}
+
+// replacePaths removes the paths old from and adds the paths new to the route
+// for pfx. Clients are notified once.
+func (rib *RIB) replacePaths(pfx *net.Prefix, old []*Path, new []*Path) {
+	r := rib.get(pfx)
+	if r == nil {
+		if len(new) == 0 {
+			return
+		}
+
+		r = NewRoute(pfx, nil)
+		r.SetSelector(rib.selector)
+		rib.routes(pfx).Insert(r)
+	}
+
+	before := r.activePaths
+	for _, p := range old {
+		r.RemovePath(p)
+	}
+	for _, p := range new {
+		if !r.hasPath(p) {
+			r.AddPath(p)
+		}
+	}
+
+	if len(r.paths) == 0 {
+		rib.routes(pfx).RemovePfx(pfx)
+	}
+
+	rib.notify(pfx, before, r.activePaths)
+}
+
+// RemovePath removes p from the route for pfx. The route is removed with its last path.
+func (rib *RIB) RemovePath(pfx *net.Prefix, p *Path) {
+	rib.ReplacePath(pfx, p, nil)
+}
+
+// Originate adds a locally originated path for pfx with attributes attrs. It
+// replaces a path previously originated for pfx and bypasses the import
+// policy. The path stays in the RIB until it is withdrawn.
+func (rib *RIB) Originate(pfx *net.Prefix, attrs *LocalPath) {
+	rib.mu.Lock()
+	defer rib.mu.Unlock()
+
+	rib.replacePath(pfx, rib.localPath(pfx), &Path{
+		Type:      LocalPathType,
+		LocalPath: attrs,
+	})
+}
+
+// Withdraw removes the locally originated path for pfx
+func (rib *RIB) Withdraw(pfx *net.Prefix) {
+	rib.mu.Lock()
+	defer rib.mu.Unlock()
+
+	if p := rib.localPath(pfx); p != nil {
+		rib.replacePath(pfx, p, nil)
+	}
+}
+
+// localPath returns the locally originated path for pfx or nil if there is none
+func (rib *RIB) localPath(pfx *net.Prefix) *Path {
+	r := rib.get(pfx)
+	if r == nil {
+		return nil
+	}
+
+	for _, p := range r.paths {
+		if p.Type == LocalPathType {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// UpdateActivePaths replaces all paths of pfx with paths run through the import
+// policy. This lets a RIB be registered as client of another RIB, e.g. an
+// Adj-RIB-Out fed from the Loc-RIB with the import policy applied as export
+// policy towards a peer.
+func (rib *RIB) UpdateActivePaths(pfx *net.Prefix, paths []*Path) {
+	rib.mu.Lock()
+	defer rib.mu.Unlock()
+
+	imported := make([]*Path, 0, len(paths))
+	for _, p := range paths {
+		if x := rib.importPath(pfx, p); x != nil {
+			imported = append(imported, x)
+		}
+	}
+
+	r := rib.get(pfx)
+	if r == nil {
+		if len(imported) == 0 {
+			return
+		}
+
+		r = NewRoute(pfx, nil)
+		r.SetSelector(rib.selector)
+		rib.routes(pfx).Insert(r)
+	}
+
+	before := r.activePaths
+	r.paths = imported
+	r.bestPaths()
+
+	if len(r.paths) == 0 {
+		rib.routes(pfx).RemovePfx(pfx)
+	}
+
+	rib.notify(pfx, before, r.activePaths)
+}
+
+// Get returns a copy of the route for pfx or nil if there is none
+func (rib *RIB) Get(pfx *net.Prefix) *Route {
+	rib.mu.RLock()
+	defer rib.mu.RUnlock()
+
+	r := rib.get(pfx)
+	if r == nil {
+		return nil
+	}
+
+	return r.Copy()
+}
+
+func (rib *RIB) get(pfx *net.Prefix) *Route {
+	routes := rib.routes(pfx).Get(pfx, false)
+	if len(routes) == 0 {
+		return nil
+	}
+
+	return routes[0]
+}
+
+// LPM returns a copy of the most specific route covering addr or nil if there
+// is none. The default route matches all addresses of its address family.
+func (rib *RIB) LPM(addr gonet.IP) *Route {
+	pfx := hostPrefix(addr)
+	if pfx == nil {
+		return nil
+	}
+
+	rib.mu.RLock()
+	defer rib.mu.RUnlock()
+
+	routes := rib.routes(pfx).LPM(pfx)
+	if len(routes) == 0 {
+		return nil
+	}
+
+	return routes[len(routes)-1].Copy()
+}
+
+// hostPrefix returns the host prefix of addr or nil if addr is invalid
+func hostPrefix(addr gonet.IP) *net.Prefix {
+	if x := addr.To4(); x != nil {
+		return net.NewPfx(convert.Uint32b(x), 32)
+	}
+
+	if len(addr) == gonet.IPv6len {
+		x := [gonet.IPv6len]byte{}
+		copy(x[:], addr)
+		return net.NewPfx6(x, 128)
+	}
+
+	return nil
+}
+
+// ResolveNextHop resolves addr to the most specific route covering it. The
+// metric is the IGP metric towards the next hop of the first active BGP path
+// of that route, other path types have a metric of 0. This lets a RIB serve as NextHopResolver for
+// the selector of another RIB. ResolveRecursively resolves the next hops of a
+// RIB in its own routes.
+func (rib *RIB) ResolveNextHop(addr gonet.IP) (metric uint32, ok bool) {
+	r := rib.LPM(addr)
+	if r == nil || len(r.activePaths) == 0 {
+		return 0, false
+	}
+
+	if p := r.activePaths[0]; p.Type == BGPPathType {
+		return r.IGPMetric(p), true
+	}
+
+	return 0, true
+}
+
+// Reselect re-runs path selection for all routes, e.g. after next hops changed
+// their reachability. Clients are notified of all changed routes.
+func (rib *RIB) Reselect() {
+	rib.mu.Lock()
+	defer rib.mu.Unlock()
+
+	rib.reselect()
+}
+
+func (rib *RIB) reselect() {
+	for _, routes := range []Trie{rib.routes4, rib.routes6} {
+		routes.Walk(func(r *Route) {
+			before := r.activePaths
+			r.bestPaths()
+			rib.notify(r.Prefix(), before, r.activePaths)
+		})
+	}
+}
+
+// FollowResolver re-runs path selection on rib whenever the active paths of
+// resolver change. resolver is the RIB the selector of rib resolves next hops
+// in, e.g. an IGP RIB. Selection runs asynchronously as resolver notifies its
+// clients with its lock held. The returned function stops following resolver.
+func (rib *RIB) FollowResolver(resolver *RIB) (stop func()) {
+	w := &resolverWatch{
+		trigger: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	resolver.Register(w)
+
+	go func() {
+		for {
+			select {
+			case <-w.trigger:
+				rib.Reselect()
+			case <-w.done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		resolver.Unregister(w)
+		close(w.done)
+	}
+}
+
+// resolverWatch triggers reselection of a RIB on changes of its resolver.
+// Changes arriving while a reselection is pending are coalesced.
+type resolverWatch struct {
+	trigger chan struct{}
+	done    chan struct{}
+}
+
+func (w *resolverWatch) UpdateActivePaths(pfx *net.Prefix, paths []*Path) {
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Refresh notifies c of the active paths of all routes, e.g. after c lost its
+// state. c does not need to be registered.
+func (rib *RIB) Refresh(c RIBClient) {
+	rib.mu.RLock()
+	defer rib.mu.RUnlock()
+
+	for _, routes := range []Trie{rib.routes4, rib.routes6} {
+		routes.Walk(func(r *Route) {
+			paths := rib.exportPaths(r.Prefix(), r.activePaths)
+			if len(paths) > 0 {
+				c.UpdateActivePaths(r.Prefix(), copyPaths(paths))
+			}
+		})
+	}
+}
+
+// Dump returns copies of all routes of the RIB, IPv4 routes first, ordered by
+// address and prefix length
+func (rib *RIB) Dump() []*Route {
+	rib.mu.RLock()
+	defer rib.mu.RUnlock()
+
+	res := make([]*Route, 0)
+	for _, routes := range []Trie{rib.routes4, rib.routes6} {
+		routes.Walk(func(r *Route) {
+			res = append(res, r.Copy())
+		})
+	}
+
+	return res
+}
+
+// importPath runs p through the import policy. It returns nil if p is nil or rejected.
+func (rib *RIB) importPath(pfx *net.Prefix, p *Path) *Path {
+	if p == nil || rib.importPolicy == nil {
+		return p
+	}
+
+	res, accept := rib.importPolicy.Process(pfx, p)
+	if !accept {
+		return nil
+	}
+
+	return res
+}
+
+func (rib *RIB) notify(pfx *net.Prefix, before []*Path, after []*Path) {
+	if samePaths(before, after) {
+		return
+	}
+
+	paths := rib.exportPaths(pfx, after)
+	for _, c := range rib.clients {
+		c.UpdateActivePaths(pfx, copyPaths(paths))
+	}
+
+	if rib.recursive && (resolvesNextHops(before) || resolvesNextHops(after)) {
+		rib.reselect()
+	}
+}
+
+// exportPaths runs paths through the export policy. They are tagged with
+// GRACEFUL_SHUTDOWN during maintenance.
+func (rib *RIB) exportPaths(pfx *net.Prefix, paths []*Path) []*Path {
+	res := paths
+	if rib.exportPolicy != nil {
+		res = make([]*Path, 0, len(paths))
+		for _, p := range paths {
+			if x, accept := rib.exportPolicy.Process(pfx, p); accept {
+				res = append(res, x)
+			}
+		}
+	}
+
+	if rib.maintenance {
+		return gracefulShutdownPaths(res)
+	}
+
+	return res
+}
+
+// samePaths checks if a and b contain equal paths regardless of their order
+func samePaths(a []*Path, b []*Path) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for _, p := range a {
+		found := false
+		for _, q := range b {
+			if p.Equal(q) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
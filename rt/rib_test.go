@@ -0,0 +1,770 @@
+package rt
+
+import (
+	gonet "net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bio-routing/bio-rd/net"
+	"github.com/stretchr/testify/assert"
+)
+
+type ribUpdate struct {
+	pfx   *net.Prefix
+	paths []*Path
+}
+
+type recordingClient struct {
+	updates []ribUpdate
+}
+
+func (c *recordingClient) UpdateActivePaths(pfx *net.Prefix, paths []*Path) {
+	c.updates = append(c.updates, ribUpdate{pfx: pfx, paths: paths})
+}
+
+func bgpPath(localPref uint32, source uint32) *Path {
+	return &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			LocalPref: localPref,
+			Source:    source,
+		},
+	}
+}
+
+func TestRIBChurn(t *testing.T) {
+	rib := NewRIB(nil)
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	a := bgpPath(100, 1)
+	b := bgpPath(200, 2)
+	c := bgpPath(300, 2)
+
+	rib.AddPath(pfx, a)
+	rib.AddPath(pfx, a)
+	rib.AddPath(pfx, b)
+
+	r := rib.Get(pfx)
+	if !assert.NotNil(t, r) {
+		return
+	}
+	assert.Equal(t, []*Path{a, b}, r.Paths())
+	assert.Equal(t, []*Path{b}, r.ActivePaths())
+
+	rib.ReplacePath(pfx, b, c)
+	r = rib.Get(pfx)
+	assert.Equal(t, []*Path{a, c}, r.Paths())
+	assert.Equal(t, []*Path{c}, r.ActivePaths())
+
+	rib.RemovePath(pfx, c)
+	assert.Equal(t, []*Path{a}, rib.Get(pfx).ActivePaths())
+
+	rib.RemovePath(pfx, a)
+	assert.Nil(t, rib.Get(pfx))
+	assert.Len(t, rib.Dump(), 0)
+
+	// Removing from a missing route is a no-op
+	rib.RemovePath(pfx, a)
+	assert.Nil(t, rib.Get(pfx))
+}
+
+func TestRIBNotifications(t *testing.T) {
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+	a := bgpPath(100, 1)
+	b := bgpPath(200, 2)
+
+	tests := []struct {
+		name     string
+		run      func(rib *RIB)
+		expected []ribUpdate
+	}{
+		{
+			name: "New prefix",
+			run: func(rib *RIB) {
+				rib.AddPath(pfx, a)
+			},
+			expected: []ribUpdate{
+				{pfx: pfx, paths: []*Path{a}},
+			},
+		},
+		{
+			name: "Better path",
+			run: func(rib *RIB) {
+				rib.AddPath(pfx, a)
+				rib.AddPath(pfx, b)
+			},
+			expected: []ribUpdate{
+				{pfx: pfx, paths: []*Path{a}},
+				{pfx: pfx, paths: []*Path{b}},
+			},
+		},
+		{
+			name: "Worse path",
+			run: func(rib *RIB) {
+				rib.AddPath(pfx, b)
+				rib.AddPath(pfx, a)
+				rib.RemovePath(pfx, a)
+			},
+			expected: []ribUpdate{
+				{pfx: pfx, paths: []*Path{b}},
+			},
+		},
+		{
+			name: "Duplicate path",
+			run: func(rib *RIB) {
+				rib.AddPath(pfx, a)
+				rib.AddPath(pfx, bgpPath(100, 1))
+			},
+			expected: []ribUpdate{
+				{pfx: pfx, paths: []*Path{a}},
+			},
+		},
+		{
+			name: "Replace with equal path",
+			run: func(rib *RIB) {
+				rib.AddPath(pfx, a)
+				rib.ReplacePath(pfx, a, bgpPath(100, 1))
+			},
+			expected: []ribUpdate{
+				{pfx: pfx, paths: []*Path{a}},
+			},
+		},
+		{
+			name: "Prefix removed",
+			run: func(rib *RIB) {
+				rib.AddPath(pfx, a)
+				rib.RemovePath(pfx, a)
+			},
+			expected: []ribUpdate{
+				{pfx: pfx, paths: []*Path{a}},
+				{pfx: pfx, paths: []*Path{}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		rib := NewRIB(nil)
+		c := &recordingClient{}
+		rib.Register(c)
+
+		test.run(rib)
+		assert.Equal(t, test.expected, c.updates, test.name)
+	}
+}
+
+func TestRIBUnregister(t *testing.T) {
+	rib := NewRIB(nil)
+	c := &recordingClient{}
+	rib.Register(c)
+	rib.Unregister(c)
+
+	rib.AddPath(net.NewPfx(3221225984, 24), bgpPath(100, 1))
+	assert.Len(t, c.updates, 0)
+}
+
+func TestRIBConcurrentAccess(t *testing.T) {
+	rib := NewRIB(nil)
+
+	wg := sync.WaitGroup{}
+	for i := uint32(0); i < 4; i++ {
+		wg.Add(1)
+		go func(i uint32) {
+			defer wg.Done()
+			for j := uint32(0); j < 100; j++ {
+				pfx := net.NewPfx(167772160+j<<8, 24) // 10.0.j.0/24
+				p := bgpPath(100, i)
+				rib.AddPath(pfx, p)
+				rib.Get(pfx)
+				rib.Dump()
+				rib.RemovePath(pfx, p)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, rib.Dump(), 0)
+}
+
+func TestRIBLPM(t *testing.T) {
+	routes := []*net.Prefix{
+		net.NewPfx(167772160, 8),  // 10.0.0.0/8
+		net.NewPfx(167837696, 16), // 10.1.0.0/16
+		net.NewPfx(167837952, 24), // 10.1.1.0/24
+		net.NewPfx6([16]byte{0x20, 0x01, 0x0d, 0xb8}, 32),
+	}
+
+	tests := []struct {
+		name         string
+		addr         gonet.IP
+		withDefault  bool
+		expected     *net.Prefix
+		expectedNone bool
+	}{
+		{
+			name:     "Most specific",
+			addr:     gonet.ParseIP("10.1.1.1"),
+			expected: routes[2],
+		},
+		{
+			name:     "Covered by /16",
+			addr:     gonet.ParseIP("10.1.2.1"),
+			expected: routes[1],
+		},
+		{
+			name:     "Covered by /8",
+			addr:     gonet.ParseIP("10.2.0.1"),
+			expected: routes[0],
+		},
+		{
+			name:         "No match",
+			addr:         gonet.ParseIP("192.0.2.1"),
+			expectedNone: true,
+		},
+		{
+			name:        "Default route",
+			addr:        gonet.ParseIP("192.0.2.1"),
+			withDefault: true,
+			expected:    net.NewPfx(0, 0),
+		},
+		{
+			name:        "Default route does not win over more specifics",
+			addr:        gonet.ParseIP("10.1.1.255"),
+			withDefault: true,
+			expected:    routes[2],
+		},
+		{
+			name:     "IPv6",
+			addr:     gonet.ParseIP("2001:db8::1"),
+			expected: routes[3],
+		},
+		{
+			name:         "IPv6 not matched by IPv4 default route",
+			addr:         gonet.ParseIP("2001:db9::1"),
+			withDefault:  true,
+			expectedNone: true,
+		},
+	}
+
+	for _, test := range tests {
+		rib := NewRIB(nil)
+		for _, pfx := range routes {
+			rib.AddPath(pfx, bgpPath(100, 1))
+		}
+		if test.withDefault {
+			rib.AddPath(net.NewPfx(0, 0), bgpPath(100, 1))
+		}
+
+		r := rib.LPM(test.addr)
+		if test.expectedNone {
+			assert.Nil(t, r, test.name)
+			continue
+		}
+
+		if assert.NotNil(t, r, test.name) {
+			assert.Equal(t, test.expected, r.Prefix(), test.name)
+		}
+	}
+}
+
+type fakeResolver map[string]uint32
+
+func (r fakeResolver) ResolveNextHop(addr gonet.IP) (uint32, bool) {
+	metric, ok := r[addr.String()]
+	return metric, ok
+}
+
+func TestRIBNextHopResolution(t *testing.T) {
+	resolver := fakeResolver{
+		"10.0.0.2": 20,
+	}
+	s := NewSelector()
+	s.SetNextHopResolver(resolver)
+
+	rib := NewRIB(s)
+	c := &recordingClient{}
+	rib.Register(c)
+
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+	a := &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			NextHop: 167772161, // 10.0.0.1
+			Source:  2,
+		},
+	}
+	b := &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			NextHop: 167772162, // 10.0.0.2
+			Source:  1,
+		},
+	}
+	rib.AddPath(pfx, a)
+	rib.AddPath(pfx, b)
+
+	// a is not eligible as its next hop is unreachable
+	assert.Equal(t, []*Path{b}, rib.Get(pfx).ActivePaths())
+	assert.Equal(t, uint32(20), rib.Get(pfx).IGPMetric(b))
+	assert.Equal(t, uint32(0), b.BGPPath.IGPMetric, "Shared path was modified")
+
+	// a wins on the lower IGP metric once its next hop is reachable
+	resolver["10.0.0.1"] = 10
+	rib.Reselect()
+	assert.Equal(t, []*Path{a}, rib.Get(pfx).ActivePaths())
+
+	// Fail over to b when a becomes unreachable again
+	delete(resolver, "10.0.0.1")
+	rib.Reselect()
+	assert.Equal(t, []*Path{b}, rib.Get(pfx).ActivePaths())
+
+	assert.Equal(t, []ribUpdate{
+		{pfx: pfx, paths: []*Path{b}},
+		{pfx: pfx, paths: []*Path{a}},
+		{pfx: pfx, paths: []*Path{b}},
+	}, c.updates)
+
+	// No next hop reachable
+	delete(resolver, "10.0.0.2")
+	rib.Reselect()
+	assert.Len(t, rib.Get(pfx).ActivePaths(), 0)
+}
+
+func TestRIBInactiveRoute(t *testing.T) {
+	resolver := fakeResolver{
+		"10.0.0.1": 10,
+		"10.0.0.2": 20,
+	}
+	s := NewSelector()
+	s.SetNextHopResolver(resolver)
+
+	rib := NewRIB(s)
+	c := &recordingClient{}
+	rib.Register(c)
+
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+	a := &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			NextHop: 167772161, // 10.0.0.1
+			Source:  1,
+		},
+	}
+	b := &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			NextHop: 167772162, // 10.0.0.2
+			Source:  2,
+		},
+	}
+	rib.AddPath(pfx, a)
+	rib.AddPath(pfx, b)
+	assert.False(t, rib.Get(pfx).Inactive())
+
+	// All paths become ineligible: clients see a withdraw but the route is kept
+	delete(resolver, "10.0.0.1")
+	delete(resolver, "10.0.0.2")
+	rib.Reselect()
+
+	r := rib.Get(pfx)
+	if !assert.NotNil(t, r, "Route was removed from the RIB") {
+		return
+	}
+	assert.True(t, r.Inactive())
+	assert.Equal(t, []*Path{}, r.ActivePaths())
+	assert.ElementsMatch(t, []*Path{a, b}, r.Paths())
+	assert.Equal(t, ribUpdate{pfx: pfx, paths: []*Path{}}, c.updates[len(c.updates)-1])
+
+	// The route becomes active again with one of its next hops
+	resolver["10.0.0.2"] = 20
+	rib.Reselect()
+	assert.False(t, rib.Get(pfx).Inactive())
+	assert.Equal(t, ribUpdate{pfx: pfx, paths: []*Path{b}}, c.updates[len(c.updates)-1])
+}
+
+func TestRIBResolveNextHop(t *testing.T) {
+	igp := NewRIB(nil)
+	igp.AddPath(net.NewPfx(167772160, 8), &Path{ // 10.0.0.0/8
+		Type: StaticPathType,
+		StaticPath: &StaticPath{
+			NextHop: 3232235521, // 192.168.0.1
+		},
+	})
+
+	s := NewSelector()
+	s.SetNextHopResolver(igp)
+	rib := NewRIB(s)
+
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+	rib.AddPath(pfx, &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			NextHop: 3325256705, // 198.51.100.1
+		},
+	})
+	assert.Len(t, rib.Get(pfx).ActivePaths(), 0)
+
+	reachable := &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			NextHop: 167772161, // 10.0.0.1
+		},
+	}
+	rib.AddPath(pfx, reachable)
+	assert.Equal(t, []*Path{reachable}, rib.Get(pfx).ActivePaths())
+}
+
+func TestRIBFollowResolver(t *testing.T) {
+	igp := NewRIB(nil)
+	s := NewSelector()
+	s.SetNextHopResolver(igp)
+	rib := NewRIB(s)
+	stop := rib.FollowResolver(igp)
+	defer stop()
+
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+	p := &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			NextHop: 167772161, // 10.0.0.1
+		},
+	}
+	rib.AddPath(pfx, p)
+	assert.Len(t, rib.Get(pfx).ActivePaths(), 0)
+
+	static := &Path{
+		Type: StaticPathType,
+		StaticPath: &StaticPath{
+			NextHop: 3232235521, // 192.168.0.1
+		},
+	}
+	igp.AddPath(net.NewPfx(167772160, 8), static) // 10.0.0.0/8
+	assert.Eventually(t, func() bool {
+		return len(rib.Get(pfx).ActivePaths()) == 1
+	}, time.Second, time.Millisecond, "Path was not selected once its next hop became reachable")
+
+	igp.RemovePath(net.NewPfx(167772160, 8), static)
+	assert.Eventually(t, func() bool {
+		return len(rib.Get(pfx).ActivePaths()) == 0
+	}, time.Second, time.Millisecond, "Path stayed selected after its next hop became unreachable")
+}
+
+// localPrefPolicy rejects paths with a LOCAL_PREF of 0 and raises all others by 10
+type localPrefPolicy struct{}
+
+func (localPrefPolicy) Process(pfx *net.Prefix, p *Path) (*Path, bool) {
+	if p.BGPPath.LocalPref == 0 {
+		return nil, false
+	}
+
+	b := p.BGPPath.Copy()
+	b.LocalPref += 10
+	return &Path{Type: p.Type, BGPPath: b}, true
+}
+
+func TestRIBImportPolicy(t *testing.T) {
+	rib := NewRIB(nil)
+	rib.SetImportPolicy(localPrefPolicy{})
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	rib.AddPath(pfx, bgpPath(0, 1))
+	assert.Nil(t, rib.Get(pfx))
+
+	p := bgpPath(100, 1)
+	rib.AddPath(pfx, p)
+	assert.Equal(t, []*Path{bgpPath(110, 1)}, rib.Get(pfx).Paths())
+	assert.Equal(t, uint32(100), p.BGPPath.LocalPref)
+
+	rib.RemovePath(pfx, p)
+	assert.Nil(t, rib.Get(pfx))
+}
+
+func TestRIBImportPolicyChanged(t *testing.T) {
+	rib := NewRIB(nil)
+	rib.SetImportPolicy(localPrefPolicy{})
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	p := bgpPath(100, 1)
+	rib.AddPath(pfx, p)
+	rejected := bgpPath(0, 2)
+	rib.AddPath(pfx, rejected)
+
+	// Paths are withdrawn as they were imported
+	rib.SetImportPolicy(nil)
+	rib.RemovePath(pfx, rejected)
+	assert.Equal(t, []*Path{bgpPath(110, 1)}, rib.Get(pfx).Paths())
+	rib.RemovePath(pfx, p)
+	assert.Nil(t, rib.Get(pfx))
+
+	// Paths imported without policy are withdrawn as they are
+	rib.AddPath(pfx, p)
+	rib.SetImportPolicy(localPrefPolicy{})
+	rib.ReplacePath(pfx, p, bgpPath(200, 1))
+	assert.Equal(t, []*Path{bgpPath(210, 1)}, rib.Get(pfx).Paths())
+}
+
+func TestRIBExportPolicy(t *testing.T) {
+	rib := NewRIB(nil)
+	rib.SetExportPolicy(localPrefPolicy{})
+	c := &recordingClient{}
+	rib.Register(c)
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+
+	rib.AddPath(pfx, bgpPath(0, 1))
+	rib.AddPath(pfx, bgpPath(100, 2))
+
+	assert.Equal(t, []ribUpdate{
+		{pfx: pfx, paths: []*Path{}},
+		{pfx: pfx, paths: []*Path{bgpPath(110, 2)}},
+	}, c.updates)
+}
+
+func TestRIBRefresh(t *testing.T) {
+	rib := NewRIB(nil)
+	rib.SetExportPolicy(localPrefPolicy{})
+	pfx := net.NewPfx(3221225984, 24)   // 192.0.2.0/24
+	other := net.NewPfx(3325256704, 24) // 198.51.100.0/24
+	rib.AddPath(pfx, bgpPath(100, 1))
+	rib.AddPath(other, bgpPath(0, 1))
+
+	c := &recordingClient{}
+	rib.Refresh(c)
+	assert.Equal(t, []ribUpdate{
+		{pfx: pfx, paths: []*Path{bgpPath(110, 1)}},
+	}, c.updates)
+}
+
+func TestRIBOriginate(t *testing.T) {
+	locRIB := NewRIB(nil)
+	adjRIBOut := NewRIB(nil)
+	locRIB.Register(adjRIBOut)
+
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+	local := &Path{
+		Type: LocalPathType,
+		LocalPath: &LocalPath{
+			Origin:      0,
+			Communities: []uint32{4259840100},
+		},
+	}
+
+	locRIB.Originate(pfx, local.LocalPath)
+	assert.Equal(t, []*Path{local}, adjRIBOut.Get(pfx).ActivePaths())
+
+	// The local path survives a peer announcing and withdrawing the prefix
+	p := bgpPath(100, 1)
+	locRIB.AddPath(pfx, p)
+	assert.Equal(t, []*Path{local}, adjRIBOut.Get(pfx).ActivePaths())
+	locRIB.RemovePath(pfx, p)
+	assert.Equal(t, []*Path{local}, adjRIBOut.Get(pfx).ActivePaths())
+
+	// Originating again replaces the path
+	local2 := &Path{
+		Type: LocalPathType,
+		LocalPath: &LocalPath{
+			Origin: 2,
+		},
+	}
+	locRIB.Originate(pfx, local2.LocalPath)
+	assert.Equal(t, []*Path{local2}, locRIB.Get(pfx).Paths())
+	assert.Equal(t, []*Path{local2}, adjRIBOut.Get(pfx).ActivePaths())
+
+	// BGP paths take over once the local path is withdrawn
+	locRIB.AddPath(pfx, p)
+	locRIB.Withdraw(pfx)
+	assert.Equal(t, []*Path{p}, adjRIBOut.Get(pfx).ActivePaths())
+
+	locRIB.RemovePath(pfx, p)
+	assert.Nil(t, adjRIBOut.Get(pfx))
+	assert.Len(t, adjRIBOut.Dump(), 0)
+}
+
+func TestRIBAsClientAppliesImportPolicy(t *testing.T) {
+	locRIB := NewRIB(nil)
+	adjRIBOut := NewRIB(nil)
+	adjRIBOut.SetImportPolicy(localPrefPolicy{})
+	locRIB.Register(adjRIBOut)
+
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+	locRIB.AddPath(pfx, bgpPath(0, 1))
+	assert.Nil(t, adjRIBOut.Get(pfx))
+
+	locRIB.AddPath(pfx, bgpPath(100, 2))
+	assert.Equal(t, []*Path{bgpPath(110, 2)}, adjRIBOut.Get(pfx).Paths())
+}
+
+func TestRIBMaintenance(t *testing.T) {
+	rib := NewRIB(nil)
+	c := &recordingClient{}
+	rib.Register(c)
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+	p := bgpPath(100, 1)
+	rib.AddPath(pfx, p)
+
+	rib.EnterMaintenance()
+	assert.True(t, rib.InMaintenance())
+	tagged := bgpPath(0, 1)
+	tagged.BGPPath.Communities = []uint32{GracefulShutdownCommunity}
+	assert.Equal(t, ribUpdate{pfx: pfx, paths: []*Path{tagged}}, c.updates[len(c.updates)-1])
+
+	// Changes during maintenance are tagged as well
+	other := net.NewPfx(3325256704, 24) // 198.51.100.0/24
+	rib.AddPath(other, bgpPath(100, 1))
+	assert.Equal(t, ribUpdate{pfx: other, paths: []*Path{tagged}}, c.updates[len(c.updates)-1])
+
+	// The RIB itself is left alone
+	assert.Equal(t, []*Path{bgpPath(100, 1)}, rib.Get(pfx).ActivePaths())
+	assert.Nil(t, p.BGPPath.Communities)
+
+	n := len(c.updates)
+	rib.ExitMaintenance()
+	assert.False(t, rib.InMaintenance())
+	assert.ElementsMatch(t, []ribUpdate{
+		{pfx: pfx, paths: []*Path{bgpPath(100, 1)}},
+		{pfx: other, paths: []*Path{bgpPath(100, 1)}},
+	}, c.updates[n:])
+}
+
+func TestRIBAllPaths(t *testing.T) {
+	s := NewSelector()
+	s.SetNextHopResolver(fakeResolver{
+		"10.0.0.1": 10,
+		"10.0.0.2": 10,
+	})
+	rib := NewRIB(s)
+
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+	best := &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			LocalPref: 200,
+			NextHop:   167772161, // 10.0.0.1
+			Source:    1,
+		},
+	}
+	loser := &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			LocalPref: 100,
+			NextHop:   167772162, // 10.0.0.2
+			Source:    2,
+		},
+	}
+	unreachable := &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			LocalPref: 300,
+			NextHop:   167772163, // 10.0.0.3
+			Source:    3,
+		},
+	}
+	rib.AddPath(pfx, loser)
+	rib.AddPath(pfx, unreachable)
+	rib.AddPath(pfx, best)
+
+	paths, ok := rib.AllPaths(pfx)
+	assert.True(t, ok)
+	assert.Equal(t, []PathWithStatus{
+		{Path: best, Status: PathBest},
+		{Path: loser, Status: PathEligible, Reason: "lost on LOCAL_PREF"},
+		{Path: unreachable, Status: PathIneligible, Reason: "next hop unreachable"},
+	}, paths)
+
+	_, ok = rib.AllPaths(net.NewPfx(3325256704, 24)) // 198.51.100.0/24
+	assert.False(t, ok)
+}
+
+func TestRIBResolveRecursively(t *testing.T) {
+	rib := NewRIB(nil)
+	rib.ResolveRecursively()
+	c := &recordingClient{}
+	rib.Register(c)
+
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+	p := &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			NextHop: 167837697, // 10.1.0.1
+			Source:  1,
+		},
+	}
+	rib.AddPath(pfx, p)
+	assert.Empty(t, rib.Get(pfx).ActivePaths(), "Path with unreachable next hop is active")
+
+	static := &Path{
+		Type: StaticPathType,
+		StaticPath: &StaticPath{
+			NextHop: 3232235521, // 192.168.0.1
+		},
+	}
+
+	// An active BGP route covering the next hop does not resolve it
+	rib.AddPath(net.NewPfx(3232235520, 24), static) // 192.168.0.0/24
+
+	bgpPfx := net.NewPfx(167772160, 8) // 10.0.0.0/8
+	rib.AddPath(bgpPfx, &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			NextHop: 3232235522, // 192.168.0.2
+			Source:  2,
+		},
+	})
+	assert.Len(t, rib.Get(bgpPfx).ActivePaths(), 1)
+	assert.Empty(t, rib.Get(pfx).ActivePaths(), "Next hop was resolved via a BGP route")
+
+	igp := net.NewPfx(167837696, 16) // 10.1.0.0/16
+	c.updates = nil
+	rib.AddPath(igp, static)
+	assert.Equal(t, []*Path{p}, rib.Get(pfx).ActivePaths(), "Path did not become eligible")
+	assert.Contains(t, c.updates, ribUpdate{pfx: pfx, paths: []*Path{p}})
+
+	c.updates = nil
+	rib.RemovePath(igp, static)
+	assert.Empty(t, rib.Get(pfx).ActivePaths(), "Path stayed eligible")
+	assert.Contains(t, c.updates, ribUpdate{pfx: pfx, paths: []*Path{}})
+
+	paths, _ := rib.AllPaths(pfx)
+	assert.Equal(t, []PathWithStatus{
+		{Path: p, Status: PathIneligible, Reason: "next hop unreachable"},
+	}, paths)
+}
+
+func TestRIBResolveRecursivelyRejectsLoops(t *testing.T) {
+	rib := NewRIB(nil)
+	rib.ResolveRecursively()
+
+	static := &Path{
+		Type: StaticPathType,
+		StaticPath: &StaticPath{
+			NextHop: 3232235521, // 192.168.0.1
+		},
+	}
+	rib.AddPath(net.NewPfx(3221225472, 16), static) // 192.0.0.0/16
+
+	// The next hop is within the route and resolves to a route covering it
+	pfx := net.NewPfx(3221225984, 24) // 192.0.2.0/24
+	p := &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			NextHop: 3221225985, // 192.0.2.1
+			Source:  1,
+		},
+	}
+	rib.AddPath(pfx, p)
+	assert.Empty(t, rib.Get(pfx).ActivePaths(), "Path resolving via itself is active")
+
+	// A next hop outside of the route resolves to the same covering route
+	other := net.NewPfx(3221226240, 24) // 192.0.3.0/24
+	rib.AddPath(other, &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			NextHop: 3221225729, // 192.0.1.1
+			Source:  1,
+		},
+	})
+	assert.Len(t, rib.Get(other).ActivePaths(), 1)
+
+	// A more specific route takes precedence over the route in forwarding
+	rib.AddPath(net.NewPfx(3221225984, 30), static) // 192.0.2.0/30
+	assert.Equal(t, []*Path{p}, rib.Get(pfx).ActivePaths(), "Path did not become eligible")
+}
@@ -1,6 +1,8 @@
 package rt
 
 import (
+	"fmt"
+
 	net "github.com/bio-routing/bio-rd/net"
 )
 
@@ -9,17 +11,53 @@ const StaticPathType = 1
 const BGPPathType = 2
 const OSPFPathType = 3
 const ISISPathType = 4
+const LocalPathType = 5
+
+// protocolPreference ranks path types for getBestProtocol. Lower values are preferred.
+var protocolPreference = map[uint8]uint8{
+	StaticPathType: 1,
+	LocalPathType:  2,
+	BGPPathType:    3,
+	OSPFPathType:   4,
+	ISISPathType:   5,
+}
 
 type Path struct {
 	Type       uint8
 	StaticPath *StaticPath
 	BGPPath    *BGPPath
+	LocalPath  *LocalPath
+
+	// leakedFrom is the RIB a path leaked by Leak was copied from
+	leakedFrom *RIB
 }
 
 type Route struct {
 	pfx         *net.Prefix
 	activePaths []*Path
 	paths       []*Path
+	selector    *Selector
+
+	// selected is set once path selection ran on all paths. Later changes of
+	// the paths update the selection incrementally.
+	selected bool
+
+	// protocol is the path type activePaths were selected from
+	protocol uint8
+
+	// superseded are the BGP paths that led the selection before a later path
+	// beat them. The BGP decision process is not transitive as MEDs are only
+	// compared between paths of the same neighbor AS, so removing one of them
+	// may change the outcome even if it is not active.
+	superseded []*Path
+
+	// igpMetrics holds the IGP metrics towards the next hops of the BGP paths
+	// if the selector resolves them
+	igpMetrics map[*Path]uint32
+
+	// contributors are the more specific prefixes an aggregate route is
+	// formed from
+	contributors []*net.Prefix
 }
 
 func NewRoute(pfx *net.Prefix, paths []*Path) *Route {
@@ -38,23 +76,119 @@ func (r *Route) Prefix() *net.Prefix {
 	return r.pfx
 }
 
+// Paths returns all paths of r
+func (r *Route) Paths() []*Path {
+	return r.paths
+}
+
+// ActivePaths returns the paths of r that won path selection
+func (r *Route) ActivePaths() []*Path {
+	return r.activePaths
+}
+
+// Inactive reports whether r has paths but none of them won path selection,
+// e.g. as none of their next hops can be resolved. An inactive route stays in
+// the RIB but clients see it withdrawn until one of its paths becomes
+// eligible again.
+func (r *Route) Inactive() bool {
+	return len(r.paths) > 0 && len(r.activePaths) == 0
+}
+
+// Contributors returns the more specific prefixes r aggregates, ordered by
+// address and prefix length. It is nil if r is no aggregate.
+func (r *Route) Contributors() []*net.Prefix {
+	return r.contributors
+}
+
+// SetContributors marks r as aggregate of the more specific prefixes pfxs
+func (r *Route) SetContributors(pfxs []*net.Prefix) {
+	r.contributors = pfxs
+}
+
+// Copy returns a copy of r. The paths are shared with r.
+func (r *Route) Copy() *Route {
+	return &Route{
+		pfx:          r.pfx,
+		activePaths:  copyPaths(r.activePaths),
+		paths:        copyPaths(r.paths),
+		selector:     r.selector,
+		selected:     r.selected,
+		protocol:     r.protocol,
+		superseded:   copyPaths(r.superseded),
+		igpMetrics:   copyMetrics(r.igpMetrics),
+		contributors: r.contributors,
+	}
+}
+
+func copyMetrics(metrics map[*Path]uint32) map[*Path]uint32 {
+	if metrics == nil {
+		return nil
+	}
+
+	res := make(map[*Path]uint32, len(metrics))
+	for p, m := range metrics {
+		res[p] = m
+	}
+
+	return res
+}
+
+// IGPMetric returns the IGP metric towards the next hop of the BGP path p of r
+func (r *Route) IGPMetric(p *Path) uint32 {
+	return igpMetric(p, r.igpMetrics)
+}
+
+func copyPaths(paths []*Path) []*Path {
+	res := make([]*Path, len(paths))
+	copy(res, paths)
+	return res
+}
+
+func (r *Route) hasPath(p *Path) bool {
+	for _, x := range r.paths {
+		if x.Equal(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetSelector sets the Selector used for BGP best path selection on r
+func (r *Route) SetSelector(s *Selector) {
+	r.selector = s
+	r.bestPaths()
+}
+
 func (r *Route) Remove(rm *Route) (final bool) {
 	for _, del := range rm.paths {
 		r.paths = removePath(r.paths, del)
 	}
+	r.selected = false
 
 	return len(r.paths) == 0
 }
 
-func removePath(paths []*Path, remove *Path) []*Path {
-	i := -1
-	for j := range paths {
-		if paths[j].Equal(remove) {
-			i = j
-			break
-		}
+// RemovePath removes the path p from r and updates the path selection. It is
+// only re-run on all paths if p was active or led the selection before.
+// Removing a path r does not have is a no-op. final is true if r has no paths
+// left.
+func (r *Route) RemovePath(p *Path) (final bool) {
+	i := indexOfPath(r.paths, p)
+	if i < 0 {
+		return len(r.paths) == 0
 	}
 
+	removed := r.paths[i]
+	r.paths = append(r.paths[:i], r.paths[i+1:]...)
+	delete(r.igpMetrics, removed)
+	r.unselectPath(removed)
+
+	return len(r.paths) == 0
+}
+
+func removePath(paths []*Path, remove *Path) []*Path {
+	i := indexOfPath(paths, remove)
 	if i < 0 {
 		return paths
 	}
@@ -63,51 +197,161 @@ func removePath(paths []*Path, remove *Path) []*Path {
 	return paths[:len(paths)-1]
 }
 
+// indexOfPath returns the index of the first path of paths equal to p or -1
+func indexOfPath(paths []*Path, p *Path) int {
+	for i := range paths {
+		if paths[i].Equal(p) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// containsPath checks if paths contains p itself rather than an equal path
+func containsPath(paths []*Path, p *Path) bool {
+	for _, x := range paths {
+		if x == p {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (p *Path) Equal(q *Path) bool {
 	if p == nil || q == nil {
 		return false
 	}
 
-	if p.Type != q.Type {
+	if p.Type != q.Type || p.leakedFrom != q.leakedFrom {
 		return false
 	}
 
 	switch p.Type {
-	case BGPPathType:
-		if *p.BGPPath != *q.BGPPath {
-			return false
+	case StaticPathType:
+		if p.StaticPath == nil || q.StaticPath == nil {
+			return p.StaticPath == q.StaticPath
 		}
+		return *p.StaticPath == *q.StaticPath
+	case BGPPathType:
+		return p.BGPPath.Equal(q.BGPPath)
+	case LocalPathType:
+		return p.LocalPath.Equal(q.LocalPath)
+	}
+
+	return false
+}
+
+// String returns a human readable representation of p
+func (p *Path) String() string {
+	switch p.Type {
+	case StaticPathType:
+		return fmt.Sprintf("static (next hop %s)", addrString(p.StaticPath.NextHop))
+	case BGPPathType:
+		b := p.BGPPath
+		return fmt.Sprintf("bgp (next hop %s, local pref %d, AS path %q, origin %d, MED %d, IGP metric %d, eBGP %v)",
+			addrString(b.NextHop), b.LocalPref, b.ASPath, b.Origin, b.MED, b.IGPMetric, b.EBGP)
+	case LocalPathType:
+		return fmt.Sprintf("local (next hop %s, origin %d)", addrString(p.LocalPath.NextHop), p.LocalPath.Origin)
 	}
 
-	return true
+	return fmt.Sprintf("unknown (type %d)", p.Type)
+}
+
+func addrString(addr uint32) string {
+	return fmt.Sprintf("%d.%d.%d.%d", addr>>24, addr>>16&0xff, addr>>8&0xff, addr&0xff)
 }
 
+// AddPath adds p to r. p is only compared to the active paths unless it is of
+// a more preferred protocol.
 func (r *Route) AddPath(p *Path) {
 	r.paths = append(r.paths, p)
-	r.bestPaths()
+	r.selectPath(p)
 }
 
 func (r *Route) AddPaths(paths []*Path) {
 	for _, p := range paths {
-		r.paths = append(r.paths, p)
+		r.AddPath(p)
 	}
-	r.bestPaths()
 }
 
+// bestPaths runs path selection on all paths of r
 func (r *Route) bestPaths() {
-	var best []*Path
-	protocol := getBestProtocol(r.paths)
+	r.protocol = getBestProtocol(r.paths)
+	r.activePaths, r.superseded = r.selectProtocol(r.protocol)
+	if r.activePaths == nil && len(r.paths) > 0 {
+		// Keep inactive routes apart from those that lost all their paths
+		r.activePaths = make([]*Path, 0)
+	}
+	r.selected = true
+}
+
+// selectPath updates the selection with p which was just added to r. This
+// yields the same result as running the selection on all paths as long as
+// the next hop resolver of the selector did not change its mind in between.
+func (r *Route) selectPath(p *Path) {
+	if !r.selected || r.protocol == 0 || protocolPreference[p.Type] < protocolPreference[r.protocol] {
+		r.bestPaths()
+		return
+	}
+
+	if p.Type != r.protocol {
+		return
+	}
+
+	switch p.Type {
+	case StaticPathType:
+		r.activePaths = addStaticPath(r.activePaths, p)
+	case LocalPathType:
+		r.activePaths = append(r.activePaths[:len(r.activePaths):len(r.activePaths)], p)
+	case BGPPathType:
+		s := r.bgpSelector()
+		if s.resolver != nil && r.igpMetrics == nil {
+			r.igpMetrics = make(map[*Path]uint32)
+		}
+		if len(s.resolveNextHops(r.pfx, []*Path{p}, r.igpMetrics)) == 0 {
+			return
+		}
+
+		var superseded *Path
+		r.activePaths, superseded = s.selectPath(r.activePaths, p, r.igpMetrics)
+		if superseded != nil {
+			r.superseded = append(r.superseded, superseded)
+		}
+	}
+}
+
+// unselectPath updates the selection after p was removed from r. Selection is
+// re-run on all paths if p was active or led the selection before.
+func (r *Route) unselectPath(p *Path) {
+	if !r.selected || len(r.activePaths) == 0 || containsPath(r.activePaths, p) || containsPath(r.superseded, p) {
+		r.bestPaths()
+	}
+}
+
+// selectPaths returns the paths of the best protocol that win path selection
+func (r *Route) selectPaths() []*Path {
+	res, _ := r.selectProtocol(getBestProtocol(r.paths))
+	return res
+}
 
+// selectProtocol returns the paths of protocol that win path selection and
+// the BGP paths superseded during selection
+func (r *Route) selectProtocol(protocol uint8) (res []*Path, superseded []*Path) {
 	switch protocol {
 	case StaticPathType:
-		best = r.staticPathSelection()
+		return r.staticPathSelection(), nil
+	case LocalPathType:
+		return r.localPathSelection(), nil
 	case BGPPathType:
-		best = r.bgpPathSelection()
+		return r.bgpPathSelection()
 	}
 
-	r.activePaths = best
+	return nil, nil
 }
 
+// getBestProtocol returns the most preferred path type of paths according to protocolPreference
 func getBestProtocol(paths []*Path) uint8 {
 	best := uint8(0)
 	for _, p := range paths {
@@ -116,7 +360,7 @@ func getBestProtocol(paths []*Path) uint8 {
 			continue
 		}
 
-		if p.Type < best {
+		if protocolPreference[p.Type] < protocolPreference[best] {
 			best = p.Type
 		}
 	}
@@ -1,6 +1,8 @@
 package rt
 
 import (
+	"fmt"
+	"math/rand"
 	"testing"
 
 	net "github.com/bio-routing/bio-rd/net"
@@ -173,6 +175,37 @@ func TestRouteRemovePath(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Remove static path",
+			paths: []*Path{
+				{
+					Type: StaticPathType,
+					StaticPath: &StaticPath{
+						NextHop: 1,
+					},
+				},
+				{
+					Type: StaticPathType,
+					StaticPath: &StaticPath{
+						NextHop: 2,
+					},
+				},
+			},
+			remove: &Path{
+				Type: StaticPathType,
+				StaticPath: &StaticPath{
+					NextHop: 2,
+				},
+			},
+			expected: []*Path{
+				{
+					Type: StaticPathType,
+					StaticPath: &StaticPath{
+						NextHop: 1,
+					},
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -181,6 +214,57 @@ func TestRouteRemovePath(t *testing.T) {
 	}
 }
 
+func TestRouteRemovePathReselection(t *testing.T) {
+	a := &Path{Type: BGPPathType, BGPPath: &BGPPath{LocalPref: 100}}
+	b := &Path{Type: BGPPathType, BGPPath: &BGPPath{LocalPref: 200}}
+
+	tests := []struct {
+		name          string
+		remove        *Path
+		expectedFinal bool
+		expectedPaths []*Path
+		expectedBest  []*Path
+	}{
+		{
+			name:          "Remove inactive path",
+			remove:        &Path{Type: BGPPathType, BGPPath: &BGPPath{LocalPref: 100}},
+			expectedFinal: false,
+			expectedPaths: []*Path{b},
+			expectedBest:  []*Path{b},
+		},
+		{
+			name:          "Remove active path",
+			remove:        &Path{Type: BGPPathType, BGPPath: &BGPPath{LocalPref: 200}},
+			expectedFinal: false,
+			expectedPaths: []*Path{a},
+			expectedBest:  []*Path{a},
+		},
+		{
+			name:          "Remove non-existent path",
+			remove:        &Path{Type: BGPPathType, BGPPath: &BGPPath{LocalPref: 300}},
+			expectedFinal: false,
+			expectedPaths: []*Path{a, b},
+			expectedBest:  nil,
+		},
+	}
+
+	for _, test := range tests {
+		r := &Route{
+			paths: []*Path{a, b},
+		}
+
+		final := r.RemovePath(test.remove)
+		assert.Equal(t, test.expectedFinal, final, test.name)
+		assert.Equal(t, test.expectedPaths, r.paths, test.name)
+		// Selection must only be re-run if a path was actually removed
+		assert.Equal(t, test.expectedBest, r.activePaths, test.name)
+	}
+
+	r := NewRoute(nil, []*Path{a})
+	assert.True(t, r.RemovePath(a))
+	assert.Nil(t, r.activePaths)
+}
+
 func TestEqual(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -214,6 +298,48 @@ func TestEqual(t *testing.T) {
 			},
 			expected: false,
 		},
+		{
+			name: "Unequal static paths",
+			pathA: &Path{
+				Type: StaticPathType,
+				StaticPath: &StaticPath{
+					NextHop: 1,
+				},
+			},
+			pathB: &Path{
+				Type: StaticPathType,
+				StaticPath: &StaticPath{
+					NextHop: 2,
+				},
+			},
+			expected: false,
+		},
+		{
+			name: "Equal static paths",
+			pathA: &Path{
+				Type: StaticPathType,
+				StaticPath: &StaticPath{
+					NextHop: 1,
+				},
+			},
+			pathB: &Path{
+				Type: StaticPathType,
+				StaticPath: &StaticPath{
+					NextHop: 1,
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "Unknown type",
+			pathA: &Path{
+				Type: OSPFPathType,
+			},
+			pathB: &Path{
+				Type: OSPFPathType,
+			},
+			expected: false,
+		},
 		{
 			name: "Equal",
 			pathA: &Path{
@@ -272,6 +398,16 @@ func TestAddPath(t *testing.T) {
 						},
 					},
 				},
+				selected: true,
+				protocol: BGPPathType,
+				superseded: []*Path{
+					{
+						Type: 2,
+						BGPPath: &BGPPath{
+							LocalPref: 100,
+						},
+					},
+				},
 				paths: []*Path{
 					{
 						Type: 2,
@@ -338,6 +474,16 @@ func TestAddPaths(t *testing.T) {
 						},
 					},
 				},
+				selected: true,
+				protocol: BGPPathType,
+				superseded: []*Path{
+					{
+						Type: BGPPathType,
+						BGPPath: &BGPPath{
+							LocalPref: 100,
+						},
+					},
+				},
 				paths: []*Path{
 					{
 						Type: BGPPathType,
@@ -386,10 +532,132 @@ func TestGetBestProtocol(t *testing.T) {
 			},
 			expected: StaticPathType,
 		},
+		{
+			name: "Local path wins over BGP",
+			input: []*Path{
+				{
+					Type: BGPPathType,
+				},
+				{
+					Type: LocalPathType,
+				},
+			},
+			expected: LocalPathType,
+		},
+		{
+			name: "Static path wins over local path",
+			input: []*Path{
+				{
+					Type: LocalPathType,
+				},
+				{
+					Type: StaticPathType,
+				},
+			},
+			expected: StaticPathType,
+		},
 	}
 
 	for _, test := range tests {
 		res := getBestProtocol(test.input)
-		assert.Equal(t, test.expected, res)
+		assert.Equal(t, test.expected, res, test.name)
+	}
+}
+
+// randomPath returns a path of a random type with attributes chosen from a
+// small range, so paths often tie in some steps of the decision process
+func randomPath(r *rand.Rand) *Path {
+	switch r.Intn(10) {
+	case 0:
+		return &Path{
+			Type: StaticPathType,
+			StaticPath: &StaticPath{
+				NextHop:  uint32(r.Intn(4)),
+				Priority: uint8(r.Intn(3)),
+			},
+		}
+	case 1:
+		return &Path{
+			Type: LocalPathType,
+			LocalPath: &LocalPath{
+				Origin: uint8(r.Intn(3)),
+			},
+		}
+	}
+
+	return randomBGPPath(r)
+}
+
+func randomBGPPath(r *rand.Rand) *Path {
+	return &Path{
+		Type: BGPPathType,
+		BGPPath: &BGPPath{
+			LocalPref:  uint32(100 + r.Intn(2)*100),
+			ASPathLen:  uint16(1 + r.Intn(3)),
+			Origin:     uint8(r.Intn(2)),
+			MED:        uint32(r.Intn(3)),
+			NeighborAS: uint32(65200 + r.Intn(3)),
+			EBGP:       r.Intn(2) == 0,
+			RouterID:   uint32(r.Intn(4)),
+			Source:     r.Uint32(),
+		},
+	}
+}
+
+func TestRouteIncrementalSelection(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		r := NewRoute(net.NewPfx(3221225984, 24), nil)
+		for j := 0; j < 200; j++ {
+			op := "add"
+			if len(r.paths) > 0 && rnd.Intn(5) < 2 {
+				op = "remove"
+				r.RemovePath(r.paths[rnd.Intn(len(r.paths))])
+			} else {
+				r.AddPath(randomPath(rnd))
+			}
+
+			expected := r.selectPaths()
+			if len(expected) == 0 {
+				assert.Empty(t, r.activePaths, "Route %d, %s #%d", i, op, j)
+				continue
+			}
+
+			if !assert.Equal(t, expected, r.activePaths, "Route %d, %s #%d", i, op, j) {
+				return
+			}
+		}
+	}
+}
+
+func BenchmarkRouteChurn(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, n := range []int{10, 100, 1000} {
+		paths := make([]*Path, n)
+		for i := range paths {
+			paths[i] = randomBGPPath(rnd)
+		}
+
+		b.Run(fmt.Sprintf("incremental/%d", n), func(b *testing.B) {
+			r := NewRoute(nil, nil)
+			r.AddPaths(paths)
+			for i := 0; i < b.N; i++ {
+				p := paths[i%n]
+				r.RemovePath(p)
+				r.AddPath(p)
+			}
+		})
+
+		b.Run(fmt.Sprintf("full/%d", n), func(b *testing.B) {
+			r := NewRoute(nil, nil)
+			r.AddPaths(paths)
+			for i := 0; i < b.N; i++ {
+				p := paths[i%n]
+				r.paths = removePath(r.paths, p)
+				r.bestPaths()
+				r.paths = append(r.paths, p)
+				r.bestPaths()
+			}
+		})
 	}
 }
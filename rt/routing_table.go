@@ -4,6 +4,7 @@ import (
 	"github.com/bio-routing/bio-rd/net"
 )
 
+// LPM is a path compressed binary trie. All routes of an LPM must be of the same address family.
 type LPM struct {
 	root  *node
 	nodes uint64
@@ -85,7 +86,7 @@ func (n *node) removePath(route *Route) {
 		return
 	}
 
-	if *n.route.Prefix() == *route.Prefix() {
+	if n.route.Prefix().Equal(route.Prefix()) {
 		if n.dummy {
 			return
 		}
@@ -98,7 +99,7 @@ func (n *node) removePath(route *Route) {
 		return
 	}
 
-	b := getBitUint32(route.Prefix().Addr(), n.route.Pfxlen()+1)
+	b := getBit(route.Prefix(), n.route.Pfxlen()+1)
 	if !b {
 		n.l.removePath(route)
 		return
@@ -112,7 +113,7 @@ func (n *node) removePfx(pfx *net.Prefix) {
 		return
 	}
 
-	if *n.route.Prefix() == *pfx {
+	if n.route.Prefix().Equal(pfx) {
 		if n.dummy {
 			return
 		}
@@ -122,7 +123,7 @@ func (n *node) removePfx(pfx *net.Prefix) {
 		return
 	}
 
-	b := getBitUint32(pfx.Addr(), n.route.Pfxlen()+1)
+	b := getBit(pfx, n.route.Pfxlen()+1)
 	if !b {
 		n.l.removePfx(pfx)
 		return
@@ -136,7 +137,7 @@ func (n *node) lpm(needle *net.Prefix, res *[]*Route) {
 		return
 	}
 
-	if *n.route.Prefix() == *needle && !n.dummy {
+	if n.route.Prefix().Equal(needle) && !n.dummy {
 		*res = append(*res, n.route)
 		return
 	}
@@ -177,7 +178,7 @@ func (n *node) get(pfx *net.Prefix) *node {
 		return nil
 	}
 
-	if *n.route.Prefix() == *pfx {
+	if n.route.Prefix().Equal(pfx) {
 		if n.dummy {
 			return nil
 		}
@@ -188,7 +189,7 @@ func (n *node) get(pfx *net.Prefix) *node {
 		return nil
 	}
 
-	b := getBitUint32(pfx.Addr(), n.route.Pfxlen()+1)
+	b := getBit(pfx, n.route.Pfxlen()+1)
 	if !b {
 		return n.l.get(pfx)
 	}
@@ -196,23 +197,28 @@ func (n *node) get(pfx *net.Prefix) *node {
 }
 
 func (n *node) insert(route *Route) *node {
-	if *n.route.Prefix() == *route.Prefix() {
+	if n.route.Prefix().Equal(route.Prefix()) {
+		if n.dummy {
+			n.route = route
+			n.dummy = false
+			return n
+		}
+
 		n.route.AddPaths(route.paths)
-		n.dummy = false
 		return n
 	}
 
 	// is pfx NOT a subnet of this node?
 	if !n.route.Prefix().Contains(route.Prefix()) {
 		if route.Prefix().Contains(n.route.Prefix()) {
-			return n.insertBefore(route, n.route.Pfxlen()-n.skip-1)
+			return n.insertBefore(route)
 		}
 
 		return n.newSuperNode(route)
 	}
 
 	// pfx is a subnet of this node
-	b := getBitUint32(route.Prefix().Addr(), n.route.Pfxlen()+1)
+	b := getBit(route.Prefix(), n.route.Pfxlen()+1)
 	if !b {
 		return n.insertLow(route, n.route.Prefix().Pfxlen())
 	}
@@ -250,7 +256,7 @@ func (n *node) newSuperNode(route *Route) *node {
 
 func (n *node) insertChildren(old *node, new *Route) {
 	// Place the old node
-	b := getBitUint32(old.route.Prefix().Addr(), n.route.Pfxlen()+1)
+	b := getBit(old.route.Prefix(), n.route.Pfxlen()+1)
 	if !b {
 		n.l = old
 		n.l.skip = old.route.Pfxlen() - n.route.Pfxlen() - 1
@@ -261,7 +267,7 @@ func (n *node) insertChildren(old *node, new *Route) {
 
 	// Place the new Prefix
 	newNode := newNode(new, new.Pfxlen()-n.route.Pfxlen()-1, false)
-	b = getBitUint32(new.Prefix().Addr(), n.route.Pfxlen()+1)
+	b = getBit(new.Prefix(), n.route.Pfxlen()+1)
 	if !b {
 		n.l = newNode
 	} else {
@@ -269,14 +275,14 @@ func (n *node) insertChildren(old *node, new *Route) {
 	}
 }
 
-func (n *node) insertBefore(route *Route, parentPfxLen uint8) *node {
+func (n *node) insertBefore(route *Route) *node {
 	tmp := n
 
 	pfxLenDiff := n.route.Pfxlen() - route.Pfxlen()
 	skip := n.skip - pfxLenDiff
 	new := newNode(route, skip, false)
 
-	b := getBitUint32(route.Prefix().Addr(), parentPfxLen)
+	b := getBit(tmp.route.Prefix(), route.Pfxlen()+1)
 	if !b {
 		new.l = tmp
 		new.l.skip = tmp.route.Pfxlen() - route.Pfxlen() - 1
@@ -307,6 +313,38 @@ func (n *node) dump(res []*Route) []*Route {
 	return res
 }
 
+// getBit returns bit pos of the address of pfx counting from 1 at the most significant bit
+func getBit(pfx *net.Prefix, pos uint8) bool {
+	if pfx.AFI() == net.IPv6AFI {
+		if pos == 0 || pos > 128 {
+			return false
+		}
+
+		addr := pfx.Addr6()
+		return addr[(pos-1)/8]&(0x80>>((pos-1)%8)) != 0
+	}
+
+	return getBitUint32(pfx.Addr(), pos)
+}
+
 func getBitUint32(x uint32, pos uint8) bool {
 	return ((x) & (1 << (32 - pos))) != 0
 }
+
+// Walk calls f for every route in the LPM in pre-order
+func (lpm *LPM) Walk(f func(route *Route)) {
+	lpm.root.walk(f)
+}
+
+func (n *node) walk(f func(route *Route)) {
+	if n == nil {
+		return
+	}
+
+	if !n.dummy {
+		f(n.route)
+	}
+
+	n.l.walk(f)
+	n.h.walk(f)
+}
@@ -150,3 +150,19 @@ func strAddr(s string) uint32 {
 	ret, _ := net.StrToAddr(s)
 	return ret
 }
+
+func TestLPMIPv6(t *testing.T) {
+	l := New()
+	a := NewRoute(net.NewPfx6([16]byte{0x20, 0x01, 0x0d, 0xb8}, 32), nil)
+	b := NewRoute(net.NewPfx6([16]byte{0x20, 0x01, 0x0d, 0xb8, 0x01}, 40), nil)
+	c := NewRoute(net.NewPfx6([16]byte{0x20, 0x01, 0x0d, 0xb8, 0x80}, 40), nil)
+	l.Insert(a)
+	l.Insert(b)
+	l.Insert(c)
+
+	res := l.LPM(net.NewPfx6([16]byte{0x20, 0x01, 0x0d, 0xb8, 0x01, 0x01}, 48))
+	assert.Equal(t, []*Route{a, b}, res)
+
+	res = l.Get(net.NewPfx6([16]byte{0x20, 0x01, 0x0d, 0xb8, 0x80}, 40), false)
+	assert.Equal(t, []*Route{c}, res)
+}
@@ -0,0 +1,347 @@
+package rt
+
+import (
+	"fmt"
+	"net"
+
+	bnet "github.com/bio-routing/bio-rd/net"
+)
+
+// StepID identifies a step of the BGP decision process
+type StepID int
+
+// Steps of the BGP decision process
+const (
+	// SelectionStart is used to insert a step before all other steps
+	SelectionStart StepID = iota
+	LocalPrefStep
+	ASPathLenStep
+	OriginStep
+	MEDStep
+	EBGPStep
+	IGPMetricStep
+	RouterIDStep
+	ClusterListLenStep
+	PeerAddressStep
+
+	firstCustomStep
+)
+
+var stepNames = map[StepID]string{
+	LocalPrefStep:      "LOCAL_PREF",
+	ASPathLenStep:      "AS_PATH length",
+	OriginStep:         "ORIGIN",
+	MEDStep:            "MED",
+	EBGPStep:           "eBGP over iBGP",
+	IGPMetricStep:      "IGP metric",
+	RouterIDStep:       "router ID",
+	ClusterListLenStep: "CLUSTER_LIST length",
+	PeerAddressStep:    "peer address",
+}
+
+// String returns the name of the step. Custom steps are named by their ID.
+func (id StepID) String() string {
+	if name, ok := stepNames[id]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("custom step %d", id)
+}
+
+// NextHopResolver resolves the next hops of BGP paths, e.g. in an IGP RIB
+type NextHopResolver interface {
+	// ResolveNextHop returns the IGP metric towards addr. ok is false if addr
+	// is unreachable.
+	ResolveNextHop(addr net.IP) (metric uint32, ok bool)
+}
+
+// Selector implements the BGP decision process as an ordered list of comparison steps
+type Selector struct {
+	steps    []selectionStep
+	nextID   StepID
+	resolver NextHopResolver
+}
+
+// selectionStep is a step of the decision process. cmp is nil for the IGP
+// metric step, which depends on the next hops resolved for a route.
+type selectionStep struct {
+	id  StepID
+	cmp func(a, b *Path) int
+}
+
+var defaultSelector = NewSelector()
+
+// NewSelector creates a new Selector implementing the standard decision process
+func NewSelector() *Selector {
+	return &Selector{
+		steps: []selectionStep{
+			{id: LocalPrefStep, cmp: compareLocalPref},
+			{id: ASPathLenStep, cmp: compareASPathLen},
+			{id: OriginStep, cmp: compareOrigin},
+			{id: MEDStep, cmp: compareMED},
+			{id: EBGPStep, cmp: compareEBGP},
+			{id: IGPMetricStep},
+			{id: RouterIDStep, cmp: compareRouterID},
+			{id: ClusterListLenStep, cmp: compareClusterListLen},
+			{id: PeerAddressStep, cmp: comparePeerAddress},
+		},
+		nextID: firstCustomStep,
+	}
+}
+
+// InsertStep inserts a custom comparison step right after step afterStep. cmp must return a
+// negative value if a is preferred over b, a positive value if b is preferred over a and 0 if
+// the step can not decide. The ID of the new step is returned so further steps can be anchored to it.
+func (s *Selector) InsertStep(afterStep StepID, cmp func(a, b *Path) int) (StepID, error) {
+	pos := -1
+	if afterStep == SelectionStart {
+		pos = 0
+	}
+
+	for i := range s.steps {
+		if s.steps[i].id == afterStep {
+			pos = i + 1
+			break
+		}
+	}
+
+	if pos < 0 {
+		return 0, fmt.Errorf("Unknown step: %d", afterStep)
+	}
+
+	id := s.nextID
+	s.nextID++
+
+	s.steps = append(s.steps, selectionStep{})
+	copy(s.steps[pos+1:], s.steps[pos:])
+	s.steps[pos] = selectionStep{
+		id:  id,
+		cmp: cmp,
+	}
+
+	return id, nil
+}
+
+// SwapSteps swaps the positions of steps a and b in the decision process, e.g. to
+// consider the IGP metric before preferring eBGP over iBGP paths.
+func (s *Selector) SwapSteps(a, b StepID) error {
+	posA, posB := -1, -1
+	for i := range s.steps {
+		switch s.steps[i].id {
+		case a:
+			posA = i
+		case b:
+			posB = i
+		}
+	}
+
+	if posA < 0 {
+		return fmt.Errorf("Unknown step: %d", a)
+	}
+
+	if posB < 0 {
+		return fmt.Errorf("Unknown step: %d", b)
+	}
+
+	s.steps[posA], s.steps[posB] = s.steps[posB], s.steps[posA]
+	return nil
+}
+
+// RemoveStep removes step id from the decision process. Removing the router ID
+// and peer address steps lets paths that are equal otherwise be selected
+// together (multipath).
+func (s *Selector) RemoveStep(id StepID) error {
+	for i := range s.steps {
+		if s.steps[i].id == id {
+			s.steps = append(s.steps[:i:i], s.steps[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Unknown step: %d", id)
+}
+
+// SetNextHopResolver sets the resolver BGP next hops are looked up with. Paths
+// with an unresolvable next hop are not eligible for selection. Without a
+// resolver all next hops are considered reachable.
+func (s *Selector) SetNextHopResolver(r NextHopResolver) {
+	s.resolver = r
+}
+
+// routeNextHopResolver is a NextHopResolver that knows the route a next hop is
+// resolved for. It rejects next hops that would be forwarded through the route
+// itself.
+type routeNextHopResolver interface {
+	resolveRouteNextHop(pfx *bnet.Prefix, addr net.IP) (metric uint32, ok bool)
+}
+
+// resolveNextHops returns the paths of the route for pfx with a reachable next
+// hop. The IGP metrics towards the next hops are recorded in metrics rather
+// than in the paths, as paths are shared between routes, RIBs and their
+// readers.
+func (s *Selector) resolveNextHops(pfx *bnet.Prefix, paths []*Path, metrics map[*Path]uint32) []*Path {
+	if s.resolver == nil {
+		return paths
+	}
+
+	res := make([]*Path, 0, len(paths))
+	for _, p := range paths {
+		if p.Type != BGPPathType {
+			continue
+		}
+
+		var metric uint32
+		var ok bool
+		if r, isRouteResolver := s.resolver.(routeNextHopResolver); isRouteResolver && pfx != nil {
+			metric, ok = r.resolveRouteNextHop(pfx, p.BGPPath.NextHopIP())
+		} else {
+			metric, ok = s.resolver.ResolveNextHop(p.BGPPath.NextHopIP())
+		}
+		if !ok {
+			continue
+		}
+
+		metrics[p] = metric
+		res = append(res, p)
+	}
+
+	return res
+}
+
+// Select returns the best BGP paths out of paths. Paths that are equal in all steps are returned together.
+// The default decision process breaks all ties by router ID and peer address, so this only happens
+// once those steps are removed.
+func (s *Selector) Select(paths []*Path) (res []*Path) {
+	for _, p := range paths {
+		if p.Type != BGPPathType {
+			continue
+		}
+
+		res, _ = s.selectPath(res, p, nil)
+	}
+
+	return res
+}
+
+// selectPath compares the BGP path p to the best paths res selected so far and
+// returns the new best paths. superseded is the path that led res if p beat
+// it. res is not modified. metrics holds the resolved IGP metrics.
+func (s *Selector) selectPath(res []*Path, p *Path, metrics map[*Path]uint32) (sel []*Path, superseded *Path) {
+	if len(res) == 0 {
+		return []*Path{p}, nil
+	}
+
+	c := s.compare(res[0], p, metrics)
+	if c == 0 {
+		return append(res[:len(res):len(res)], p), nil
+	}
+
+	if c < 0 {
+		return res, nil
+	}
+
+	return []*Path{p}, res[0]
+}
+
+// decidingStep returns the step of the decision process that prefers a over b
+// or b over a. ok is false if a and b are equal in all steps.
+func (s *Selector) decidingStep(a, b *Path, metrics map[*Path]uint32) (id StepID, ok bool) {
+	for _, step := range s.steps {
+		if s.compareStep(step, a, b, metrics) != 0 {
+			return step.id, true
+		}
+	}
+
+	return 0, false
+}
+
+// compare runs the decision process on a and b. It returns a negative value if a is preferred,
+// a positive value if b is preferred and 0 if both are equal.
+func (s *Selector) compare(a, b *Path, metrics map[*Path]uint32) int {
+	for _, step := range s.steps {
+		c := s.compareStep(step, a, b, metrics)
+		if c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+func (s *Selector) compareStep(step selectionStep, a, b *Path, metrics map[*Path]uint32) int {
+	if step.cmp == nil {
+		return compareUint32(igpMetric(a, metrics), igpMetric(b, metrics))
+	}
+
+	return step.cmp(a, b)
+}
+
+func compareLocalPref(a, b *Path) int {
+	// Higher LOCAL_PREF is preferred
+	return compareUint32(b.BGPPath.LocalPref, a.BGPPath.LocalPref)
+}
+
+func compareASPathLen(a, b *Path) int {
+	return compareUint32(uint32(a.BGPPath.ASPathLen), uint32(b.BGPPath.ASPathLen))
+}
+
+func compareOrigin(a, b *Path) int {
+	return compareUint32(uint32(a.BGPPath.Origin), uint32(b.BGPPath.Origin))
+}
+
+func compareMED(a, b *Path) int {
+	// MEDs are only comparable between paths from the same neighbor AS
+	if a.BGPPath.NeighborAS != b.BGPPath.NeighborAS {
+		return 0
+	}
+
+	return compareUint32(a.BGPPath.MED, b.BGPPath.MED)
+}
+
+func compareEBGP(a, b *Path) int {
+	if a.BGPPath.EBGP == b.BGPPath.EBGP {
+		return 0
+	}
+
+	if a.BGPPath.EBGP {
+		return -1
+	}
+
+	return 1
+}
+
+// igpMetric returns the IGP metric towards the next hop of p. The metric of p
+// itself is used if the next hop was not resolved.
+func igpMetric(p *Path, metrics map[*Path]uint32) uint32 {
+	if m, ok := metrics[p]; ok {
+		return m
+	}
+
+	return p.BGPPath.IGPMetric
+}
+
+// compareRouterID compares the ORIGINATOR_ID of reflected paths instead of the router ID (RFC 4456, 9)
+func compareRouterID(a, b *Path) int {
+	return compareUint32(a.BGPPath.OriginatorRouterID(), b.BGPPath.OriginatorRouterID())
+}
+
+func compareClusterListLen(a, b *Path) int {
+	return compareUint32(uint32(len(a.BGPPath.ClusterList)), uint32(len(b.BGPPath.ClusterList)))
+}
+
+func comparePeerAddress(a, b *Path) int {
+	return compareUint32(a.BGPPath.Source, b.BGPPath.Source)
+}
+
+// compareUint32 prefers the lower value
+func compareUint32(a, b uint32) int {
+	if a < b {
+		return -1
+	}
+
+	if a > b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,341 @@
+package rt
+
+import (
+	"testing"
+
+	"github.com/bio-routing/bio-rd/protocols/bgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectorSelect(t *testing.T) {
+	tests := []struct {
+		name     string
+		paths    []*Path
+		expected []*Path
+	}{
+		{
+			name: "Single path",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{LocalPref: 100}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{LocalPref: 100}},
+			},
+		},
+		{
+			name: "Higher local pref wins over shorter AS path",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{LocalPref: 100, ASPathLen: 1}},
+				{Type: BGPPathType, BGPPath: &BGPPath{LocalPref: 200, ASPathLen: 5}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{LocalPref: 200, ASPathLen: 5}},
+			},
+		},
+		{
+			name: "Lower MED wins",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{MED: 20}},
+				{Type: BGPPathType, BGPPath: &BGPPath{MED: 10}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{MED: 10}},
+			},
+		},
+		{
+			name: "Shorter AS path wins",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{ASPathLen: 3}},
+				{Type: BGPPathType, BGPPath: &BGPPath{ASPathLen: 2}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{ASPathLen: 2}},
+			},
+		},
+		{
+			name: "Lower origin wins",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{Origin: 2}},
+				{Type: BGPPathType, BGPPath: &BGPPath{Origin: 0}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{Origin: 0}},
+			},
+		},
+		{
+			name: "MED is not compared between neighbor ASes",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{MED: 10, NeighborAS: 65001}},
+				{Type: BGPPathType, BGPPath: &BGPPath{MED: 20, NeighborAS: 65002, EBGP: true}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{MED: 20, NeighborAS: 65002, EBGP: true}},
+			},
+		},
+		{
+			name: "eBGP wins over iBGP",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{EBGP: false, IGPMetric: 1}},
+				{Type: BGPPathType, BGPPath: &BGPPath{EBGP: true, IGPMetric: 10}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{EBGP: true, IGPMetric: 10}},
+			},
+		},
+		{
+			name: "Lower IGP metric wins",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{IGPMetric: 10, RouterID: 1}},
+				{Type: BGPPathType, BGPPath: &BGPPath{IGPMetric: 5, RouterID: 2}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{IGPMetric: 5, RouterID: 2}},
+			},
+		},
+		{
+			name: "Lower router ID wins",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{RouterID: 2, Source: 1}},
+				{Type: BGPPathType, BGPPath: &BGPPath{RouterID: 1, Source: 2}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{RouterID: 1, Source: 2}},
+			},
+		},
+		{
+			name: "ORIGINATOR_ID replaces router ID",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{RouterID: 1, OriginatorID: 3}},
+				{Type: BGPPathType, BGPPath: &BGPPath{RouterID: 2}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{RouterID: 2}},
+			},
+		},
+		{
+			name: "Shorter CLUSTER_LIST wins",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{OriginatorID: 1, ClusterList: []uint32{1, 2}, Source: 1}},
+				{Type: BGPPathType, BGPPath: &BGPPath{OriginatorID: 1, ClusterList: []uint32{3}, Source: 2}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{OriginatorID: 1, ClusterList: []uint32{3}, Source: 2}},
+			},
+		},
+		{
+			name: "Lower peer address wins",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{RouterID: 1, Source: 2}},
+				{Type: BGPPathType, BGPPath: &BGPPath{RouterID: 1, Source: 1}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{RouterID: 1, Source: 1}},
+			},
+		},
+		{
+			name: "Equal paths are all selected",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 1}},
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 2}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 1}},
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 2}},
+			},
+		},
+		{
+			name: "Non BGP paths are ignored",
+			paths: []*Path{
+				{Type: StaticPathType, StaticPath: &StaticPath{}},
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 2}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 2}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		res := NewSelector().Select(test.paths)
+		assert.Equal(t, test.expected, res, test.name)
+	}
+}
+
+func TestSelectorInsertStep(t *testing.T) {
+	lowerNextHop := func(a, b *Path) int {
+		return compareUint32(a.BGPPath.NextHop, b.BGPPath.NextHop)
+	}
+
+	tests := []struct {
+		name      string
+		afterStep StepID
+		paths     []*Path
+		wantFail  bool
+		expected  []*Path
+	}{
+		{
+			name:      "Custom step breaks a tie",
+			afterStep: MEDStep,
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 2}},
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 1}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 1}},
+			},
+		},
+		{
+			name:      "Custom step after local pref precedes AS path length",
+			afterStep: LocalPrefStep,
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 2, ASPathLen: 1}},
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 1, ASPathLen: 3}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 1, ASPathLen: 3}},
+			},
+		},
+		{
+			name:      "Custom step at the start precedes local pref",
+			afterStep: SelectionStart,
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 2, LocalPref: 200}},
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 1, LocalPref: 100}},
+			},
+			expected: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{NextHop: 1, LocalPref: 100}},
+			},
+		},
+		{
+			name:      "Unknown step",
+			afterStep: 1000,
+			wantFail:  true,
+		},
+	}
+
+	for _, test := range tests {
+		s := NewSelector()
+		_, err := s.InsertStep(test.afterStep, lowerNextHop)
+		if test.wantFail && err == nil {
+			t.Errorf("Expected error did not happen for test %q", test.name)
+			continue
+		}
+
+		if !test.wantFail && err != nil {
+			t.Errorf("Unexpected failure for test %q: %v", test.name, err)
+			continue
+		}
+
+		if test.wantFail {
+			continue
+		}
+
+		res := s.Select(test.paths)
+		assert.Equal(t, test.expected, res, test.name)
+	}
+}
+
+func TestSelectorInsertStepAfterCustomStep(t *testing.T) {
+	s := NewSelector()
+	first, err := s.InsertStep(MEDStep, func(a, b *Path) int {
+		return compareUint32(a.BGPPath.NextHop, b.BGPPath.NextHop)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected failure: %v", err)
+	}
+
+	second, err := s.InsertStep(first, func(a, b *Path) int {
+		return compareUint32(a.BGPPath.Source, b.BGPPath.Source)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected failure: %v", err)
+	}
+
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, []StepID{LocalPrefStep, ASPathLenStep, OriginStep, MEDStep, first, second, EBGPStep, IGPMetricStep, RouterIDStep, ClusterListLenStep, PeerAddressStep}, stepIDs(s))
+}
+
+func TestSelectorSwapSteps(t *testing.T) {
+	ebgp := &Path{Type: BGPPathType, BGPPath: &BGPPath{EBGP: true, IGPMetric: 20}}
+	ibgp := &Path{Type: BGPPathType, BGPPath: &BGPPath{EBGP: false, IGPMetric: 10}}
+	paths := []*Path{ibgp, ebgp}
+
+	s := NewSelector()
+	assert.Equal(t, []*Path{ebgp}, s.Select(paths), "RFC order")
+
+	err := s.SwapSteps(EBGPStep, IGPMetricStep)
+	if err != nil {
+		t.Fatalf("Unexpected failure: %v", err)
+	}
+
+	assert.Equal(t, []StepID{LocalPrefStep, ASPathLenStep, OriginStep, MEDStep, IGPMetricStep, EBGPStep, RouterIDStep, ClusterListLenStep, PeerAddressStep}, stepIDs(s))
+	assert.Equal(t, []*Path{ibgp}, s.Select(paths), "IGP metric first")
+
+	err = s.SwapSteps(EBGPStep, firstCustomStep)
+	assert.Error(t, err)
+}
+
+func TestSelectorRemoveStep(t *testing.T) {
+	a := &Path{Type: BGPPathType, BGPPath: &BGPPath{RouterID: 2, Source: 2}}
+	b := &Path{Type: BGPPathType, BGPPath: &BGPPath{RouterID: 1, Source: 1}}
+	paths := []*Path{a, b}
+
+	s := NewSelector()
+	assert.Equal(t, []*Path{b}, s.Select(paths), "Tie broken by router ID")
+
+	for _, id := range []StepID{RouterIDStep, PeerAddressStep} {
+		err := s.RemoveStep(id)
+		if err != nil {
+			t.Fatalf("Unexpected failure: %v", err)
+		}
+	}
+
+	assert.Equal(t, []StepID{LocalPrefStep, ASPathLenStep, OriginStep, MEDStep, EBGPStep, IGPMetricStep, ClusterListLenStep}, stepIDs(s))
+	assert.Equal(t, []*Path{a, b}, s.Select(paths), "Multipath")
+
+	err := s.RemoveStep(RouterIDStep)
+	assert.Error(t, err)
+}
+
+func TestRouteSetSelector(t *testing.T) {
+	r := NewRoute(nil, []*Path{
+		{Type: BGPPathType, BGPPath: &BGPPath{Source: 2}},
+		{Type: BGPPathType, BGPPath: &BGPPath{Source: 1}},
+	})
+
+	s := NewSelector()
+	s.InsertStep(MEDStep, func(a, b *Path) int {
+		return compareUint32(a.BGPPath.Source, b.BGPPath.Source)
+	})
+	r.SetSelector(s)
+
+	assert.Equal(t, []*Path{{Type: BGPPathType, BGPPath: &BGPPath{Source: 1}}}, r.activePaths)
+}
+
+func stepIDs(s *Selector) []StepID {
+	res := make([]StepID, 0, len(s.steps))
+	for _, step := range s.steps {
+		res = append(res, step.id)
+	}
+
+	return res
+}
+
+func TestSelectorASPathLenIgnoresConfederation(t *testing.T) {
+	a := &BGPPath{Source: 2}
+	a.SetASPath(packet.ASPath{
+		{Type: packet.ASConfedSequence, ASNs: []uint32{65101, 65102, 65103}},
+		{Type: packet.ASSequence, ASNs: []uint32{64496}},
+	})
+
+	b := &BGPPath{Source: 1}
+	b.SetASPath(packet.ASPath{
+		{Type: packet.ASSequence, ASNs: []uint32{64497, 64496}},
+	})
+
+	paths := []*Path{
+		{Type: BGPPathType, BGPPath: a},
+		{Type: BGPPathType, BGPPath: b},
+	}
+	assert.Equal(t, []*Path{paths[0]}, NewSelector().Select(paths))
+}
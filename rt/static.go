@@ -1,22 +1,62 @@
 package rt
 
+import "sort"
+
+// StaticPath is a statically configured path. If a prefix has both static and
+// BGP paths the static paths always win as getBestProtocol prefers static
+// paths over all others. BGP paths are only considered once all static paths
+// are gone.
 type StaticPath struct {
 	NextHop uint32
+
+	// Priority is the administrative distance of the path. Lower values are preferred.
+	Priority uint8
 }
 
+// staticPathSelection returns all static paths of the lowest priority ordered
+// by next hop address, so equal priority paths are installed together (ECMP)
+// in a stable order.
 func (r *Route) staticPathSelection() (res []*Path) {
-	if len(r.paths) == 1 {
-		copy(res, r.paths)
-		return res
-	}
-
 	for _, p := range r.paths {
 		if p.Type != StaticPathType {
 			continue
 		}
 
-		res = append(res, p)
+		if len(res) == 0 || p.StaticPath.Priority == res[0].StaticPath.Priority {
+			res = append(res, p)
+			continue
+		}
+
+		if p.StaticPath.Priority < res[0].StaticPath.Priority {
+			res = []*Path{p}
+		}
 	}
 
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].StaticPath.NextHop < res[j].StaticPath.NextHop
+	})
+
 	return
 }
+
+// addStaticPath returns the static paths active after p was added to a route
+// with the active static paths active. The order is the one of
+// staticPathSelection.
+func addStaticPath(active []*Path, p *Path) []*Path {
+	if len(active) == 0 || p.StaticPath.Priority < active[0].StaticPath.Priority {
+		return []*Path{p}
+	}
+
+	if p.StaticPath.Priority > active[0].StaticPath.Priority {
+		return active
+	}
+
+	i := sort.Search(len(active), func(i int) bool {
+		return active[i].StaticPath.NextHop > p.StaticPath.NextHop
+	})
+
+	res := make([]*Path, 0, len(active)+1)
+	res = append(res, active[:i]...)
+	res = append(res, p)
+	return append(res, active[i:]...)
+}
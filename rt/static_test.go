@@ -0,0 +1,62 @@
+package rt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticPathSelection(t *testing.T) {
+	tests := []struct {
+		name     string
+		paths    []*Path
+		expected []*Path
+	}{
+		{
+			name: "Single static path",
+			paths: []*Path{
+				{Type: StaticPathType, StaticPath: &StaticPath{NextHop: 1}},
+			},
+			expected: []*Path{
+				{Type: StaticPathType, StaticPath: &StaticPath{NextHop: 1}},
+			},
+		},
+		{
+			name: "Equal priority paths ordered by next hop",
+			paths: []*Path{
+				{Type: StaticPathType, StaticPath: &StaticPath{NextHop: 3, Priority: 10}},
+				{Type: StaticPathType, StaticPath: &StaticPath{NextHop: 1, Priority: 10}},
+				{Type: StaticPathType, StaticPath: &StaticPath{NextHop: 2, Priority: 20}},
+			},
+			expected: []*Path{
+				{Type: StaticPathType, StaticPath: &StaticPath{NextHop: 1, Priority: 10}},
+				{Type: StaticPathType, StaticPath: &StaticPath{NextHop: 3, Priority: 10}},
+			},
+		},
+		{
+			name: "Lower priority wins",
+			paths: []*Path{
+				{Type: StaticPathType, StaticPath: &StaticPath{NextHop: 1, Priority: 20}},
+				{Type: StaticPathType, StaticPath: &StaticPath{NextHop: 2, Priority: 10}},
+			},
+			expected: []*Path{
+				{Type: StaticPathType, StaticPath: &StaticPath{NextHop: 2, Priority: 10}},
+			},
+		},
+		{
+			name: "Static wins over BGP",
+			paths: []*Path{
+				{Type: BGPPathType, BGPPath: &BGPPath{LocalPref: 200}},
+				{Type: StaticPathType, StaticPath: &StaticPath{NextHop: 1, Priority: 200}},
+			},
+			expected: []*Path{
+				{Type: StaticPathType, StaticPath: &StaticPath{NextHop: 1, Priority: 200}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		r := NewRoute(nil, test.paths)
+		assert.Equal(t, test.expected, r.selectPaths(), test.name)
+	}
+}
@@ -0,0 +1,27 @@
+package rt
+
+import (
+	"github.com/bio-routing/bio-rd/net"
+)
+
+// Trie is a lookup structure for routes keyed by prefix. Users of a routing table
+// should depend on Trie so the implementation backing it can be swapped.
+type Trie interface {
+	// Insert inserts a route. Paths of an existing route for the same prefix are merged.
+	Insert(route *Route)
+
+	// RemovePfx removes the route for pfx
+	RemovePfx(pfx *net.Prefix)
+
+	// Get returns the route for pfx and, if moreSpecifics is set, all its more specifics
+	Get(pfx *net.Prefix, moreSpecifics bool) []*Route
+
+	// LPM returns all routes covering pfx, least specific first
+	LPM(pfx *net.Prefix) []*Route
+
+	// Walk calls f for every route ordered by address and prefix length
+	Walk(f func(route *Route))
+}
+
+var _ Trie = &LPM{}
+var _ Trie = &PrefixMap{}
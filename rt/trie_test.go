@@ -0,0 +1,140 @@
+package rt
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	net "github.com/bio-routing/bio-rd/net"
+	"github.com/stretchr/testify/assert"
+)
+
+var trieImplementations = []struct {
+	name string
+	new  func() Trie
+}{
+	{
+		name: "LPM",
+		new:  func() Trie { return New() },
+	},
+	{
+		name: "PrefixMap",
+		new:  func() Trie { return NewPrefixMap() },
+	},
+}
+
+func randomPrefixes(seed int64, n int) []*net.Prefix {
+	r := rand.New(rand.NewSource(seed))
+	res := make([]*net.Prefix, 0, n)
+	for i := 0; i < n; i++ {
+		pfxlen := uint8(8 + r.Intn(25))
+		res = append(res, net.NewPfx(r.Uint32()&mask(pfxlen), pfxlen))
+	}
+
+	return res
+}
+
+func pfxStrings(routes []*Route) []string {
+	res := make([]string, 0, len(routes))
+	for _, r := range routes {
+		res = append(res, r.Prefix().String())
+	}
+
+	return res
+}
+
+func walkStrings(t Trie) []string {
+	res := make([]string, 0)
+	t.Walk(func(r *Route) {
+		res = append(res, r.Prefix().String())
+	})
+
+	return res
+}
+
+func TestTrieImplementationsEqual(t *testing.T) {
+	pfxs := randomPrefixes(42, 5000)
+	lookups := append(randomPrefixes(23, 5000), pfxs...)
+	for _, pfx := range randomPrefixes(5, 1000) {
+		lookups = append(lookups, net.NewPfx(pfx.Addr(), 32))
+	}
+
+	a := New()
+	b := NewPrefixMap()
+	for _, pfx := range pfxs {
+		a.Insert(NewRoute(pfx, nil))
+		b.Insert(NewRoute(pfx, nil))
+	}
+
+	check := func(state string) {
+		assert.Equal(t, walkStrings(a), walkStrings(b), "Walk %s", state)
+		for _, pfx := range lookups {
+			assert.Equal(t, pfxStrings(a.LPM(pfx)), pfxStrings(b.LPM(pfx)), "LPM %s for %s", state, pfx.String())
+			assert.Equal(t, pfxStrings(a.Get(pfx, false)), pfxStrings(b.Get(pfx, false)), "Get %s for %s", state, pfx.String())
+			assert.Equal(t, pfxStrings(a.Get(pfx, true)), pfxStrings(b.Get(pfx, true)), "Get more specifics %s for %s", state, pfx.String())
+		}
+	}
+
+	check("after insert")
+
+	for _, pfx := range pfxs[:len(pfxs)/2] {
+		a.RemovePfx(pfx)
+		b.RemovePfx(pfx)
+	}
+
+	check("after remove")
+}
+
+func TestTrieWalk(t *testing.T) {
+	for _, impl := range trieImplementations {
+		trie := impl.new()
+		trie.Insert(NewRoute(net.NewPfx(strAddr("10.128.0.0"), 9), nil))
+		trie.Insert(NewRoute(net.NewPfx(strAddr("10.0.0.0"), 9), nil))
+		trie.Insert(NewRoute(net.NewPfx(strAddr("10.0.0.0"), 8), nil))
+		trie.Insert(NewRoute(net.NewPfx(strAddr("192.168.0.0"), 16), nil))
+
+		assert.Equal(t, []string{
+			"10.0.0.0/8",
+			"10.0.0.0/9",
+			"10.128.0.0/9",
+			"192.168.0.0/16",
+		}, walkStrings(trie), impl.name)
+	}
+}
+
+const benchmarkPrefixes = 1000000
+
+func BenchmarkTrieInsert(b *testing.B) {
+	pfxs := randomPrefixes(1, benchmarkPrefixes)
+	for _, impl := range trieImplementations {
+		b.Run(impl.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				trie := impl.new()
+				for _, pfx := range pfxs {
+					trie.Insert(NewRoute(pfx, nil))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkTrieLPM(b *testing.B) {
+	pfxs := randomPrefixes(1, benchmarkPrefixes)
+	lookups := randomPrefixes(2, 1024)
+	for i, pfx := range lookups {
+		lookups[i] = net.NewPfx(pfx.Addr(), 32)
+	}
+
+	for _, impl := range trieImplementations {
+		trie := impl.new()
+		for _, pfx := range pfxs {
+			trie.Insert(NewRoute(pfx, nil))
+		}
+
+		b.Run(fmt.Sprintf("%s/%d", impl.name, len(pfxs)), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				trie.LPM(lookups[i%len(lookups)])
+			}
+		})
+	}
+}